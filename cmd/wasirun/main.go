@@ -10,6 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/imports"
@@ -33,7 +35,17 @@ ARGS:
 
 OPTIONS:
    --dir <DIR>
-      Grant access to the specified host directory
+      Grant access to the specified host directory, the directory may
+      be exposed to the module under a different path with the syntax
+      <HOST:GUEST>, and made read-only with a :ro suffix; the rights of
+      the directory and of the files opened from it may be restricted
+      with a :rights=<BASE>[,<INHERITING>] suffix, where the rights are
+      names such as FDReadRight or DirectoryRights separated by |
+
+   --file <FILE>
+      Grant access to the specified host file through a preopened file
+      descriptor, following the directories and sockets; the syntax of
+      the option is the same as --dir
 
    --listen <ADDR:PORT>
       Grant access to a socket listening on the specified address
@@ -49,7 +61,9 @@ OPTIONS:
 
    --env <NAME=VAL>
       Pass an environment variable to the module. Overrides
-      any inherited environment variables from --env-inherit
+      any inherited environment variables from --env-inherit.
+      With the --env <NAME> form, the variable is inherited from
+      the calling process if it is set
 
    --sockets <NAME>
       Enable a sockets extension, either {none, auto, path_open,
@@ -61,6 +75,10 @@ OPTIONS:
    --trace
       Enable logging of system calls (like strace)
 
+   --tracer-timestamps
+      Prefix the system calls logged by --trace with the time of the
+      monotonic clock of the module, in nanoseconds
+
    --non-blocking-stdio
       Enable non-blocking stdio
 
@@ -70,6 +88,21 @@ OPTIONS:
    --max-open-dirs <N>
       Limit the number of directories that may be opened by the module
 
+   --max-sockets <N>
+      Limit the number of sockets that may be opened by the module
+
+   --no-dot-entries
+      Omit the "." and ".." entries when the module reads directories,
+      for modules that do not expect fd_readdir to report them
+
+   --strict-paths
+      Reject the paths which escape the directory they are resolved from,
+      like "a/../../etc/passwd", in all the path functions
+
+   --rand-seed <N>
+      Make random_get return deterministic bytes generated from the seed,
+      for reproducible runs (the values are NOT cryptographically secure)
+
    --http <MODE>
       Optionally enable wasi-http client support and select a
       version {none, auto, v1}
@@ -82,6 +115,11 @@ OPTIONS:
       If present, and --http-server-addr is not empty, serve WebAssembly
 	  on this URL prefix path. Default is '/'	
 
+   --self-test [-- FLAGS...]
+      Run the conformance tests of the WASI implementation on this
+      platform and exit, the flags of "go test" (e.g. -test.run) may
+      be passed after -- to select the tests
+
    -v, --version
       Print the version and exit
 
@@ -94,6 +132,7 @@ var (
 	envInherit       bool
 	envs             stringList
 	dirs             stringList
+	files            stringList
 	listens          stringList
 	dials            stringList
 	dnsServer        string
@@ -104,10 +143,16 @@ var (
 	wasiHttpPath     string
 	trace            bool
 	tracerStringSize int
+	tracerTimestamps bool
 	nonBlockingStdio bool
 	version          bool
+	selfTestMode     bool
 	maxOpenFiles     int
 	maxOpenDirs      int
+	maxSockets       int
+	noDotEntries     bool
+	strictPaths      bool
+	randSeed         *int64
 )
 
 func main() {
@@ -117,6 +162,7 @@ func main() {
 	flagSet.BoolVar(&envInherit, "env-inherit", false, "")
 	flagSet.Var(&envs, "env", "")
 	flagSet.Var(&dirs, "dir", "")
+	flagSet.Var(&files, "file", "")
 	flagSet.Var(&listens, "listen", "")
 	flagSet.Var(&dials, "dial", "")
 	flagSet.StringVar(&dnsServer, "dns-server", "", "")
@@ -127,11 +173,24 @@ func main() {
 	flagSet.StringVar(&wasiHttpPath, "http-server-path", "/", "")
 	flagSet.BoolVar(&trace, "trace", false, "")
 	flagSet.IntVar(&tracerStringSize, "tracer-string-size", 32, "")
+	flagSet.BoolVar(&tracerTimestamps, "tracer-timestamps", false, "")
 	flagSet.BoolVar(&nonBlockingStdio, "non-blocking-stdio", false, "")
 	flagSet.BoolVar(&version, "version", false, "")
 	flagSet.BoolVar(&version, "v", false, "")
+	flagSet.BoolVar(&selfTestMode, "self-test", false, "")
 	flagSet.IntVar(&maxOpenFiles, "max-open-files", 1024, "")
 	flagSet.IntVar(&maxOpenDirs, "max-open-dirs", 1024, "")
+	flagSet.IntVar(&maxSockets, "max-sockets", 0, "")
+	flagSet.BoolVar(&noDotEntries, "no-dot-entries", false, "")
+	flagSet.BoolVar(&strictPaths, "strict-paths", false, "")
+	flagSet.Func("rand-seed", "", func(value string) error {
+		seed, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return err
+		}
+		randSeed = &seed
+		return nil
+	})
 	flagSet.Parse(os.Args[1:])
 
 	if version {
@@ -144,14 +203,15 @@ func main() {
 	}
 
 	args := flagSet.Args()
+	if selfTestMode {
+		selfTest(args)
+	}
 	if len(args) == 0 {
 		printUsage()
 		os.Exit(1)
 	}
 
-	if envInherit {
-		envs = append(append([]string{}, os.Environ()...), envs...)
-	}
+	envs = makeEnv(envInherit, envs, os.Environ, os.LookupEnv)
 
 	if dnsServer != "" {
 		_, dnsServerPort, _ := net.SplitHostPort(dnsServer)
@@ -180,6 +240,28 @@ func main() {
 	}
 }
 
+// makeEnv returns the environment of the module from the --env flags, which are
+// either NAME=VAL pairs or the names of variables inherited from the calling
+// process. When inherit is true, all the variables of the calling process are
+// passed to the module.
+func makeEnv(inherit bool, envs []string, environ func() []string, lookup func(string) (string, bool)) []string {
+	var env []string
+	if inherit {
+		env = append(env, environ()...)
+	}
+	for _, e := range envs {
+		if !strings.Contains(e, "=") {
+			value, ok := lookup(e)
+			if !ok {
+				continue
+			}
+			e += "=" + value
+		}
+		env = append(env, e)
+	}
+	return env
+}
+
 func run(wasmFile string, args []string) error {
 	wasmName := filepath.Base(wasmFile)
 	wasmCode, err := os.ReadFile(wasmFile)
@@ -205,18 +287,41 @@ func run(wasmFile string, args []string) error {
 	}
 	defer wasmModule.Close(ctx)
 
+	// The tracer reads the same monotonic clock as the module, so the
+	// timestamps of the calls match the time observed by the guest.
+	clocks := wasi.SystemClocks()
+	var tracerClock func() wasi.Timestamp
+	if tracerTimestamps {
+		tracerClock = func() wasi.Timestamp {
+			t, _ := clocks.Monotonic(ctx)
+			return wasi.Timestamp(t)
+		}
+	}
+
 	builder := imports.NewBuilder().
 		WithName(wasmName).
+		WithClocks(clocks).
 		WithArgs(args...).
 		WithEnv(envs...).
 		WithDirs(dirs...).
+		WithFiles(files...).
 		WithListens(listens...).
 		WithDials(dials...).
 		WithNonBlockingStdio(nonBlockingStdio).
 		WithSocketsExtension(socketExt, wasmModule).
-		WithTracer(trace, os.Stderr, wasi.WithTracerStringSize(tracerStringSize)).
+		WithTracer(trace, os.Stderr,
+			wasi.WithTracerStringSize(tracerStringSize),
+			wasi.WithTracerTimestamps(tracerClock),
+		).
 		WithMaxOpenFiles(maxOpenFiles).
-		WithMaxOpenDirs(maxOpenDirs)
+		WithMaxOpenDirs(maxOpenDirs).
+		WithMaxSockets(maxSockets).
+		WithDotEntries(!noDotEntries).
+		WithStrictPaths(strictPaths)
+
+	if randSeed != nil {
+		builder = builder.WithRandSeed(*randSeed)
+	}
 
 	var system wasi.System
 	ctx, system, err = builder.Instantiate(ctx, runtime)
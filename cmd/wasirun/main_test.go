@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeEnv(t *testing.T) {
+	environ := func() []string {
+		return []string{"HOME=/home/user", "PATH=/bin"}
+	}
+	lookup := func(name string) (string, bool) {
+		switch name {
+		case "HOME":
+			return "/home/user", true
+		case "EMPTY":
+			return "", true
+		}
+		return "", false
+	}
+
+	for _, test := range []struct {
+		inherit bool
+		envs    []string
+		want    []string
+	}{
+		{
+			envs: nil,
+			want: nil,
+		},
+		{
+			envs: []string{"A=1", "B="},
+			want: []string{"A=1", "B="},
+		},
+		{
+			envs: []string{"HOME", "EMPTY", "MISSING", "A=1"},
+			want: []string{"HOME=/home/user", "EMPTY=", "A=1"},
+		},
+		{
+			inherit: true,
+			envs:    []string{"PATH=/usr/bin"},
+			want:    []string{"HOME=/home/user", "PATH=/bin", "PATH=/usr/bin"},
+		},
+	} {
+		env := makeEnv(test.inherit, test.envs, environ, lookup)
+		if !reflect.DeepEqual(env, test.want) {
+			t.Errorf("makeEnv(%t, %q): want %q, got %q", test.inherit, test.envs, test.want, env)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func selfTest(args []string) {
+	fmt.Fprintf(os.Stderr, "error: wasi-go is not available on GOOS=%s\n", runtime.GOOS)
+	os.Exit(1)
+}
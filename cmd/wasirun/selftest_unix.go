@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"github.com/stealthrocket/wasi-go/wasitest"
+)
+
+func selfTest(args []string) {
+	os.Args = append(os.Args[:1], args...)
+	wasitest.Main(wasitest.MakeUnixSystem)
+}
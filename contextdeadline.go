@@ -0,0 +1,475 @@
+package wasi
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ContextDeadline wraps a System to stop the guest once the context passed to
+// the method calls is done, which gives embedders a way to bound the time a
+// guest runs by calling it with a context that has a deadline.
+//
+// Once the context is done, all calls fail with ETIMEDOUT if the deadline
+// was exceeded, or ECANCELED if the context was canceled, except ProcExit and
+// Close. Calls to PollOneOff wait at most until the deadline of the context,
+// and fail with ETIMEDOUT if it was reached before any of the subscriptions
+// triggered. Other calls are not interrupted when they block on the host.
+func ContextDeadline(s System) System {
+	return &contextDeadline{System: s}
+}
+
+type contextDeadline struct {
+	System
+	subscriptions []Subscription
+	events        []Event
+}
+
+func contextErrno(ctx context.Context) Errno {
+	switch err := ctx.Err(); {
+	case err == nil:
+		return ESUCCESS
+	case errors.Is(err, context.DeadlineExceeded):
+		return ETIMEDOUT
+	default:
+		return ECANCELED
+	}
+}
+
+func (d *contextDeadline) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || len(subscriptions) == 0 {
+		return d.System.PollOneOff(ctx, subscriptions, events)
+	}
+
+	// A clock subscription expiring at the deadline is added to bound the
+	// time spent waiting. Its user data is chosen to be distinct from the
+	// user data of the subscriptions of the guest.
+	userData := UserData(0)
+	for i := range subscriptions {
+		userData = max(userData, subscriptions[i].UserData+1)
+	}
+	d.subscriptions = append(d.subscriptions[:0], subscriptions...)
+	d.subscriptions = append(d.subscriptions, MakeSubscriptionClock(userData, SubscriptionClock{
+		ID:      Monotonic,
+		Timeout: Timestamp(max(time.Until(deadline), 0)),
+	}))
+	if len(d.events) < len(d.subscriptions) {
+		d.events = make([]Event, len(d.subscriptions))
+	}
+
+	n, errno := d.System.PollOneOff(ctx, d.subscriptions, d.events)
+	if errno != ESUCCESS {
+		return n, errno
+	}
+	numEvents := 0
+	for _, e := range d.events[:n] {
+		if e.UserData == userData && e.EventType == ClockEvent {
+			if e.Errno != ESUCCESS {
+				// The system does not support the monotonic clock, the
+				// deadline cannot be enforced while waiting.
+				return d.System.PollOneOff(ctx, subscriptions, events)
+			}
+			continue
+		}
+		if numEvents < len(events) {
+			events[numEvents] = e
+			numEvents++
+		}
+	}
+	if numEvents == 0 {
+		// The poll may return slightly before the deadline, the context is
+		// done shortly after.
+		<-ctx.Done()
+		return 0, contextErrno(ctx)
+	}
+	return numEvents, ESUCCESS
+}
+
+func (d *contextDeadline) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, 0, errno
+	}
+	return d.System.ArgsSizesGet(ctx)
+}
+
+func (d *contextDeadline) ArgsGet(ctx context.Context) ([]string, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.ArgsGet(ctx)
+}
+
+func (d *contextDeadline) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, 0, errno
+	}
+	return d.System.EnvironSizesGet(ctx)
+}
+
+func (d *contextDeadline) EnvironGet(ctx context.Context) ([]string, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.EnvironGet(ctx)
+}
+
+func (d *contextDeadline) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.ClockResGet(ctx, id)
+}
+
+func (d *contextDeadline) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.ClockTimeGet(ctx, id, precision)
+}
+
+func (d *contextDeadline) FDAdvise(ctx context.Context, fd FD, offset FileSize, length FileSize, advice Advice) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (d *contextDeadline) FDAllocate(ctx context.Context, fd FD, offset FileSize, length FileSize) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDAllocate(ctx, fd, offset, length)
+}
+
+func (d *contextDeadline) FDClose(ctx context.Context, fd FD) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDClose(ctx, fd)
+}
+
+func (d *contextDeadline) FDDataSync(ctx context.Context, fd FD) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDDataSync(ctx, fd)
+}
+
+func (d *contextDeadline) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return FDStat{}, errno
+	}
+	return d.System.FDStatGet(ctx, fd)
+}
+
+func (d *contextDeadline) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDStatSetFlags(ctx, fd, flags)
+}
+
+func (d *contextDeadline) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+}
+
+func (d *contextDeadline) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return FileStat{}, errno
+	}
+	return d.System.FDFileStatGet(ctx, fd)
+}
+
+func (d *contextDeadline) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (d *contextDeadline) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+func (d *contextDeadline) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDPread(ctx, fd, iovecs, offset)
+}
+
+func (d *contextDeadline) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return PreStat{}, errno
+	}
+	return d.System.FDPreStatGet(ctx, fd)
+}
+
+func (d *contextDeadline) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return "", errno
+	}
+	return d.System.FDPreStatDirName(ctx, fd)
+}
+
+func (d *contextDeadline) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDPwrite(ctx, fd, iovecs, offset)
+}
+
+func (d *contextDeadline) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDRead(ctx, fd, iovecs)
+}
+
+func (d *contextDeadline) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+}
+
+func (d *contextDeadline) FDRenumber(ctx context.Context, from, to FD) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDRenumber(ctx, from, to)
+}
+
+func (d *contextDeadline) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDSeek(ctx, fd, offset, whence)
+}
+
+func (d *contextDeadline) FDSync(ctx context.Context, fd FD) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.FDSync(ctx, fd)
+}
+
+func (d *contextDeadline) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDTell(ctx, fd)
+}
+
+func (d *contextDeadline) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.FDWrite(ctx, fd, iovecs)
+}
+
+func (d *contextDeadline) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathCreateDirectory(ctx, fd, path)
+}
+
+func (d *contextDeadline) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return FileStat{}, errno
+	}
+	return d.System.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (d *contextDeadline) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (d *contextDeadline) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (d *contextDeadline) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return -1, errno
+	}
+	return d.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (d *contextDeadline) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (d *contextDeadline) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (d *contextDeadline) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (d *contextDeadline) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (d *contextDeadline) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.PathUnlinkFile(ctx, fd, path)
+}
+
+func (d *contextDeadline) ProcRaise(ctx context.Context, signal Signal) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.ProcRaise(ctx, signal)
+}
+
+func (d *contextDeadline) SchedYield(ctx context.Context) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.SchedYield(ctx)
+}
+
+func (d *contextDeadline) RandomGet(ctx context.Context, b []byte) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.RandomGet(ctx, b)
+}
+
+func (d *contextDeadline) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return -1, errno
+	}
+	return d.System.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+}
+
+func (d *contextDeadline) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.SockBind(ctx, fd, addr)
+}
+
+func (d *contextDeadline) SockConnect(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.SockConnect(ctx, fd, addr)
+}
+
+func (d *contextDeadline) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.SockListen(ctx, fd, backlog)
+}
+
+func (d *contextDeadline) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return -1, nil, nil, errno
+	}
+	return d.System.SockAccept(ctx, fd, flags)
+}
+
+func (d *contextDeadline) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, 0, errno
+	}
+	return d.System.SockRecv(ctx, fd, iovecs, flags)
+}
+
+func (d *contextDeadline) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.SockSend(ctx, fd, iovecs, flags)
+}
+
+func (d *contextDeadline) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.SockSendTo(ctx, fd, iovecs, flags, addr)
+}
+
+func (d *contextDeadline) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, 0, nil, errno
+	}
+	return d.System.SockRecvFrom(ctx, fd, iovecs, flags)
+}
+
+func (d *contextDeadline) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.SockGetOpt(ctx, fd, option)
+}
+
+func (d *contextDeadline) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.SockSetOpt(ctx, fd, option, value)
+}
+
+func (d *contextDeadline) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.SockLocalAddress(ctx, fd)
+}
+
+func (d *contextDeadline) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return nil, errno
+	}
+	return d.System.SockRemoteAddress(ctx, fd)
+}
+
+func (d *contextDeadline) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return 0, errno
+	}
+	return d.System.SockAddressInfo(ctx, name, service, hints, results)
+}
+
+func (d *contextDeadline) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	if errno := contextErrno(ctx); errno != ESUCCESS {
+		return errno
+	}
+	return d.System.SockShutdown(ctx, fd, flags)
+}
@@ -0,0 +1,79 @@
+package wasi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+)
+
+func TestContextDeadline(t *testing.T) {
+	host := &unix.System{
+		Monotonic: func(context.Context) (uint64, error) {
+			return uint64(time.Now().UnixNano()), nil
+		},
+	}
+	defer host.Close(context.Background())
+	s := wasi.ContextDeadline(host)
+
+	t.Run("a long running guest is stopped at the deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// The guest sleeps for an hour at a time, forever.
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionClock(1, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: wasi.Timestamp(time.Hour)}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		start := time.Now()
+		var errno wasi.Errno
+		for errno == wasi.ESUCCESS {
+			_, errno = s.PollOneOff(ctx, subscriptions, events)
+		}
+		elapsed := time.Since(start)
+
+		if errno != wasi.ETIMEDOUT {
+			t.Errorf("poll_oneoff: want ETIMEDOUT, got %s", errno)
+		}
+		if elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("guest was not stopped at the deadline: %v", elapsed)
+		}
+		if _, errno := s.ClockTimeGet(ctx, wasi.Monotonic, 1); errno != wasi.ETIMEDOUT {
+			t.Errorf("clock_time_get: want ETIMEDOUT, got %s", errno)
+		}
+	})
+
+	t.Run("events are reported before the deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionClock(1, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: wasi.Timestamp(time.Millisecond)}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		n, errno := s.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n != 1 || events[0].UserData != 1 || events[0].EventType != wasi.ClockEvent {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+
+	t.Run("calls fail once the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, errno := s.ClockTimeGet(ctx, wasi.Monotonic, 1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		cancel()
+		if _, errno := s.ClockTimeGet(ctx, wasi.Monotonic, 1); errno != wasi.ECANCELED {
+			t.Errorf("clock_time_get: want ECANCELED, got %s", errno)
+		}
+		if errno := s.SchedYield(ctx); errno != wasi.ECANCELED {
+			t.Errorf("sched_yield: want ECANCELED, got %s", errno)
+		}
+	})
+}
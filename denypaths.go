@@ -0,0 +1,142 @@
+package wasi
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// DenyPaths wraps a System to forbid access to the paths matching any of the
+// given patterns, regardless of the rights of the file descriptors that the
+// paths are resolved from.
+//
+// The patterns use the syntax of path.Match and are matched against each
+// element of the paths, so ".git" denies access to ".git" and "src/.git/HEAD"
+// alike, and "*.pem" denies access to all files with the .pem extension.
+// Operations looking up a denied path fail with ENOENT, operations creating a
+// denied path fail with EPERM, and FDReadDir omits the denied entries.
+//
+// Paths are not resolved, so the system must not expose symbolic links to the
+// denied files that were created outside of the guest. DenyPaths panics if a
+// pattern is malformed.
+func DenyPaths(s System, patterns ...string) System {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic("DenyPaths: " + err.Error() + ": " + pattern)
+		}
+	}
+	return &pathFilter{System: s, patterns: patterns}
+}
+
+type pathFilter struct {
+	System
+	patterns []string
+}
+
+func (p *pathFilter) denied(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if p.deniedName(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pathFilter) deniedName(name string) bool {
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pathFilter) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	read := func(entries []DirEntry, cookie DirCookie) (int, Errno) {
+		return p.System.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+	}
+	return filterDirEntries(entries, cookie, read, func(name []byte) bool {
+		return !p.deniedName(string(name))
+	})
+}
+
+func (p *pathFilter) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	if p.denied(path) {
+		return EPERM
+	}
+	return p.System.PathCreateDirectory(ctx, fd, path)
+}
+
+func (p *pathFilter) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	if p.denied(path) {
+		return FileStat{}, ENOENT
+	}
+	return p.System.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (p *pathFilter) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	if p.denied(path) {
+		return ENOENT
+	}
+	return p.System.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (p *pathFilter) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	if p.denied(oldPath) {
+		return ENOENT
+	}
+	if p.denied(newPath) {
+		return EPERM
+	}
+	return p.System.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+}
+
+func (p *pathFilter) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	if p.denied(path) {
+		if openFlags.Has(OpenCreate) {
+			return -1, EPERM
+		}
+		return -1, ENOENT
+	}
+	return p.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (p *pathFilter) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	if p.denied(path) {
+		return 0, ENOENT
+	}
+	return p.System.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (p *pathFilter) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	if p.denied(path) {
+		return ENOENT
+	}
+	return p.System.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (p *pathFilter) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	if p.denied(oldPath) {
+		return ENOENT
+	}
+	if p.denied(newPath) {
+		return EPERM
+	}
+	return p.System.PathRename(ctx, fd, oldPath, newFD, newPath)
+}
+
+func (p *pathFilter) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	// The target of the link is checked as well to prevent the guest from
+	// creating links to the denied paths.
+	if p.denied(oldPath) || p.denied(newPath) {
+		return EPERM
+	}
+	return p.System.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (p *pathFilter) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	if p.denied(path) {
+		return ENOENT
+	}
+	return p.System.PathUnlinkFile(ctx, fd, path)
+}
@@ -114,6 +114,16 @@ const (
 	SIGSYS
 )
 
+// Terminates is true if the default action of the signal is to terminate the
+// process.
+func (s Signal) Terminates() bool {
+	switch s {
+	case SIGNONE, SIGPIPE, SIGCHLD, SIGCONT, SIGSTOP, SIGTSTP, SIGTTIN, SIGTTOU, SIGURG, SIGWINCH:
+		return false
+	}
+	return s <= SIGSYS
+}
+
 func (s Signal) String() string {
 	if int(s) < len(signalStrings) {
 		return signalStrings[s]
@@ -24,6 +24,11 @@ type FileDelta int64
 type LinkCount uint64
 
 // FileStat are file attributes.
+//
+// The memory layout of FileStat is the one of the filestat type of the WASI
+// ABI, which guests read directly. The ABI has no fields for the block size
+// (st_blksize) nor the number of allocated blocks (st_blocks) of the file, so
+// these attributes cannot be reported to guests.
 type FileStat struct {
 	// Device is the ID of the device containing the file.
 	Device Device
@@ -117,6 +122,10 @@ const (
 	SymbolicLinkType
 )
 
+func (f FileType) isSocket() bool {
+	return f == SocketDGramType || f == SocketStreamType
+}
+
 func (f FileType) String() string {
 	switch f {
 	case UnknownType:
@@ -155,6 +164,8 @@ const (
 	DSync
 
 	// NonBlock indicates non-blocking mode.
+	//
+	// Reads and writes which would block return EAGAIN when the flag is set.
 	NonBlock
 
 	// RSync indicates synchronized read I/O operations.
@@ -236,6 +247,9 @@ type DirEntry struct {
 	// Name of the directory entry. When the directory entry is retrieved by a
 	// call to FDReadDir, the name may point to an internal buffer and therefore
 	// remains valid only until the next call to FDReadDir.
+	//
+	// The name holds the bytes of the file name on the host, which may not be
+	// valid UTF-8; its length in bytes is the length reported to the guest.
 	Name []byte
 }
 
@@ -426,6 +440,17 @@ const (
 	PreOpenDir PreOpenType = iota
 )
 
+// preOpenTypeOf returns the type of pre-open for files of type t, and false if
+// files of this type cannot be pre-opened capabilities.
+func preOpenTypeOf(t FileType) (PreOpenType, bool) {
+	switch t {
+	case DirectoryType:
+		return PreOpenDir, true
+	default:
+		return 0, false
+	}
+}
+
 func (p PreOpenType) String() string {
 	switch p {
 	case PreOpenDir:
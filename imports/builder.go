@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	mathrand "math/rand"
 	"strings"
 	"time"
 
@@ -18,6 +19,7 @@ type Builder struct {
 	args               []string
 	env                []string
 	mounts             []mount
+	files              []mount
 	listens            []string
 	dials              []string
 	customStdio        bool
@@ -31,6 +33,7 @@ type Builder struct {
 	yield              func(context.Context) error
 	exit               func(context.Context, int) error
 	raise              func(context.Context, int) error
+	onExit             func(context.Context, int)
 	rand               io.Reader
 	socketsExtension   *wasi_snapshot_preview1.Extension
 	pathOpenSockets    bool
@@ -42,6 +45,11 @@ type Builder struct {
 	errors             []error
 	maxOpenFiles       int
 	maxOpenDirs        int
+	maxSockets         int
+	omitDotEntries     bool
+	strictPaths        bool
+	trackOpenFiles     bool
+	workingDirectory   string
 }
 
 // NewBuilder creates a Builder.
@@ -50,8 +58,14 @@ func NewBuilder() *Builder {
 }
 
 type mount struct {
-	dir  string
+	dir  string // host directory or file
+	path string // path in the guest
 	mode int
+	// Rights of the preopen, used instead of the default rights when
+	// hasRights is true.
+	hasRights        bool
+	rightsBase       wasi.Rights
+	rightsInheriting wasi.Rights
 }
 
 // WithName sets the name of the module, which is exposed to the module
@@ -75,34 +89,80 @@ func (b *Builder) WithEnv(env ...string) *Builder {
 
 // WithDirs specifies a set of directories to preopen.
 //
-// The directory can either be a path, or a string of the form "path:path[:ro]"
-// for compatibility with wazero's WASI preview 1 host module. Since virtual
-// file systems are not supported by this implementation, the two paths must
-// be the same when using this syntax. The optional ":ro" prefix means that
-// this directory is read-only.
+// The directory can either be a path, or a string of the form
+// "host:guest[:ro]" for compatibility with wazero's WASI preview 1 host
+// module, which exposes the host directory to the guest under a different
+// path. The optional ":ro" suffix means that this directory is read-only.
+//
+// The rights of the preopen may be restricted with a ":rights=base[,inheriting]"
+// suffix, where base and inheriting are parsed by wasi.ParseRights. The base
+// rights apply to the directory itself, and the inheriting rights to the files
+// and directories opened from it, defaulting to the base rights.
 func (b *Builder) WithDirs(dirs ...string) *Builder {
 	for _, dir := range dirs {
-		mode := int('r' + 'w')
-		prefix, readOnly := strings.CutSuffix(dir, ":ro")
-		if readOnly {
-			mode = 'r'
+		m, err := parseMount("directory", dir)
+		if err != nil {
+			b.errors = append(b.errors, err)
+			continue
 		}
-		parts := strings.Split(prefix, ":")
-		switch {
-		case len(parts) == 1:
-			dir = parts[0]
-		case len(parts) == 2 && parts[0] == parts[1]:
-			dir = parts[0]
-		case len(parts) == 2:
-			b.errors = append(b.errors, fmt.Errorf("virtual filesystems are not supported (cannot mount %q)", dir))
-		default:
-			b.errors = append(b.errors, fmt.Errorf("invalid directory %q", dir))
+		b.mounts = append(b.mounts, m)
+	}
+	return b
+}
+
+// WithFiles specifies a set of files to preopen, using the same syntax as
+// WithDirs. The files are added to the set of preopens after the directories
+// and sockets, and the guest reads and writes them directly through their file
+// descriptor. Since WASI only has directory preopens, FDPreStatGet fails with
+// ENOTDIR on these file descriptors.
+func (b *Builder) WithFiles(files ...string) *Builder {
+	for _, file := range files {
+		m, err := parseMount("file", file)
+		if err != nil {
+			b.errors = append(b.errors, err)
+			continue
 		}
-		b.mounts = append(b.mounts, mount{dir: dir, mode: mode})
+		b.files = append(b.files, m)
 	}
 	return b
 }
 
+func parseMount(kind, dir string) (mount, error) {
+	m := mount{mode: int('r' + 'w')}
+	parts := strings.Split(dir, ":")
+	for len(parts) > 1 {
+		last := parts[len(parts)-1]
+		if last == "ro" {
+			m.mode = 'r'
+		} else if spec, ok := strings.CutPrefix(last, "rights="); ok {
+			base, inheriting, _ := strings.Cut(spec, ",")
+			if inheriting == "" {
+				inheriting = base
+			}
+			var err error
+			m.hasRights = true
+			if m.rightsBase, err = wasi.ParseRights(base); err != nil {
+				return m, fmt.Errorf("invalid %s %q: %w", kind, dir, err)
+			}
+			if m.rightsInheriting, err = wasi.ParseRights(inheriting); err != nil {
+				return m, fmt.Errorf("invalid %s %q: %w", kind, dir, err)
+			}
+		} else {
+			break
+		}
+		parts = parts[:len(parts)-1]
+	}
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		m.dir, m.path = parts[0], parts[0]
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		m.dir, m.path = parts[0], parts[1]
+	default:
+		return m, fmt.Errorf("invalid %s %q", kind, dir)
+	}
+	return m, nil
+}
+
 // WithListens specifies a list of addresses to listen on before starting
 // the module. The listener sockets are added to the set of preopens.
 func (b *Builder) WithListens(listens ...string) *Builder {
@@ -144,6 +204,15 @@ func (b *Builder) WithMonotonicClock(clock func(context.Context) (uint64, error)
 	return b
 }
 
+// WithClocks sets both the realtime and monotonic clocks, keeping their
+// precision. The clocks of wasi.MakeClocks are consistent with each other,
+// which clocks set with WithRealtimeClock and WithMonotonicClock may not be.
+func (b *Builder) WithClocks(clocks wasi.Clocks) *Builder {
+	b.realtime = clocks.Realtime
+	b.monotonic = clocks.Monotonic
+	return b
+}
+
 // WithYield sets the sched_yield function.
 func (b *Builder) WithYield(fn func(context.Context) error) *Builder {
 	b.yield = fn
@@ -156,12 +225,42 @@ func (b *Builder) WithExit(fn func(context.Context, int) error) *Builder {
 	return b
 }
 
+// WithExitHook sets a function called with the exit code when the guest
+// exits with proc_exit, or raises a signal terminating it with the default
+// proc_raise function, before the proc_exit function. See
+// unix.System.OnExit.
+func (b *Builder) WithExitHook(fn func(context.Context, int)) *Builder {
+	b.onExit = fn
+	return b
+}
+
 // WithRaise sets the proc_raise function.
 func (b *Builder) WithRaise(fn func(context.Context, int) error) *Builder {
 	b.raise = fn
 	return b
 }
 
+// WithRand sets the source of random_get, which defaults to crypto/rand.
+//
+// The reader must fill the buffers passed to random_get, reads which return
+// fewer bytes are retried and errors are reported to the guest as EIO.
+func (b *Builder) WithRand(rand io.Reader) *Builder {
+	b.rand = rand
+	return b
+}
+
+// WithRandSeed sets the source of random_get to a deterministic pseudo-random
+// generator initialized with the given seed, so that repeated runs of a module
+// observe the same random bytes (e.g. for golden-file tests or to replay an
+// execution).
+//
+// The generator is NOT cryptographically secure; the option must not be used
+// when the module relies on random_get for security.
+func (b *Builder) WithRandSeed(seed int64) *Builder {
+	b.rand = mathrand.New(mathrand.NewSource(seed))
+	return b
+}
+
 // WithSocketsExtension enables a sockets extension.
 //
 // The name can be one of:
@@ -233,3 +332,45 @@ func (b *Builder) WithMaxOpenDirs(n int) *Builder {
 	b.maxOpenDirs = n
 	return b
 }
+
+// WithMaxSockets sets the limit on the maximum number of sockets that may be
+// opened by the guest module.
+func (b *Builder) WithMaxSockets(n int) *Builder {
+	b.maxSockets = n
+	return b
+}
+
+// WithDotEntries sets whether the "." and ".." entries of directories are
+// reported when the guest module reads directories. They are reported by
+// default.
+func (b *Builder) WithDotEntries(enable bool) *Builder {
+	b.omitDotEntries = !enable
+	return b
+}
+
+// WithStrictPaths sets whether paths which escape the directory they are
+// resolved from, like "a/../../etc/passwd", are rejected by all the path
+// functions with ENOTCAPABLE. See wasi.FileTable.StrictPaths.
+func (b *Builder) WithStrictPaths(enable bool) *Builder {
+	b.strictPaths = enable
+	return b
+}
+
+// WithTrackOpenFiles sets whether the system records where the guest opens
+// files, so closing the system fails with a *wasi.LeakError listing the files
+// which were left open. It is intended for tests, and is disabled by default.
+// See wasi.FileTable.TrackOpenFiles.
+func (b *Builder) WithTrackOpenFiles(enable bool) *Builder {
+	b.trackOpenFiles = enable
+	return b
+}
+
+// WithWorkingDirectory designates the directory mounted at path in the guest
+// as its working directory, which the path functions given
+// wasi.WorkingDirectoryFD resolve paths from. The path must be one of those
+// passed to WithDirs. There is no working directory by default.
+// See wasi.WorkingDirectory.
+func (b *Builder) WithWorkingDirectory(path string) *Builder {
+	b.workingDirectory = path
+	return b
+}
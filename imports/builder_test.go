@@ -0,0 +1,275 @@
+package imports_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/imports"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestBuilderDirs(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := imports.NewBuilder().
+		WithDirs(tmp, tmp+":/data:ro").
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	for fd, want := range map[wasi.FD]string{3: tmp, 4: "/data"} {
+		name, errno := system.FDPreStatDirName(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if name != want {
+			t.Errorf("fd %d: wrong preopen name: want %q, got %q", fd, want, name)
+		}
+	}
+
+	// The remapped directory is opened from the host directory.
+	fd, errno := system.PathOpen(ctx, 4, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	defer system.FDClose(ctx, fd)
+	if _, errno := system.PathOpen(ctx, 4, 0, "file", 0, wasi.FDWriteRight, 0, 0); errno != wasi.ENOTCAPABLE {
+		t.Errorf("read-only directory: want ENOTCAPABLE, got %s", errno)
+	}
+}
+
+func TestBuilderDirRights(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := imports.NewBuilder().
+		WithDirs(tmp+":/data:rights=PathOpenRight|FDReadDirRight,FDReadRight|FDSeekRight").
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	stat, errno := system.FDStatGet(ctx, 3)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.RightsBase != wasi.PathOpenRight|wasi.FDReadDirRight {
+		t.Errorf("wrong base rights: %s", stat.RightsBase)
+	}
+	if stat.RightsInheriting != wasi.FDReadRight|wasi.FDSeekRight {
+		t.Errorf("wrong inheriting rights: %s", stat.RightsInheriting)
+	}
+
+	fd, errno := system.PathOpen(ctx, 3, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	defer system.FDClose(ctx, fd)
+	buf := make([]byte, 32)
+	n, errno := system.FDRead(ctx, fd, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "Hello, World!" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+
+	if _, errno := system.PathOpen(ctx, 3, 0, "file", 0, wasi.FDWriteRight, 0, 0); errno != wasi.ENOTCAPABLE {
+		t.Errorf("opening for writing: want ENOTCAPABLE, got %s", errno)
+	}
+	if errno := system.PathCreateDirectory(ctx, 3, "dir"); errno != wasi.ENOTCAPABLE {
+		t.Errorf("creating a directory: want ENOTCAPABLE, got %s", errno)
+	}
+}
+
+func TestBuilderZeroRights(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config")
+	if err := os.WriteFile(path, []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	// Explicit zero rights are not replaced by the default rights.
+	ctx, system, err := imports.NewBuilder().
+		WithDirs(tmp+":/data:rights=Rights(0)").
+		WithFiles(path+":/etc/config:rights=Rights(0)").
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	for _, fd := range []wasi.FD{3, 4} {
+		stat, errno := system.FDStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.RightsBase != 0 || stat.RightsInheriting != 0 {
+			t.Errorf("fd %d: wrong rights: base=%s inheriting=%s", fd, stat.RightsBase, stat.RightsInheriting)
+		}
+	}
+	if _, errno := system.PathOpen(ctx, 3, 0, "config", 0, wasi.FDReadRight, 0, 0); errno != wasi.ENOTCAPABLE {
+		t.Errorf("opening from the directory: want ENOTCAPABLE, got %s", errno)
+	}
+	buf := make([]byte, 32)
+	if _, errno := system.FDRead(ctx, 4, []wasi.IOVec{buf}); errno != wasi.ENOTCAPABLE {
+		t.Errorf("reading the file: want ENOTCAPABLE, got %s", errno)
+	}
+}
+
+func TestBuilderFiles(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config")
+	if err := os.WriteFile(path, []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := imports.NewBuilder().
+		WithDirs(tmp).
+		WithFiles(path+":/etc/config:ro").
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	// The file is preopened after the directory, and is not reported as a
+	// directory preopen.
+	if _, errno := system.FDPreStatGet(ctx, 3); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := system.FDPreStatGet(ctx, 4); errno != wasi.ENOTDIR {
+		t.Errorf("fd_prestat_get: want ENOTDIR, got %s", errno)
+	}
+
+	stat, errno := system.FDStatGet(ctx, 4)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.FileType != wasi.RegularFileType {
+		t.Errorf("wrong file type: %s", stat.FileType)
+	}
+	buf := make([]byte, 32)
+	n, errno := system.FDRead(ctx, 4, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "Hello, World!" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+	if _, errno := system.FDWrite(ctx, 4, []wasi.IOVec{buf}); errno != wasi.ENOTCAPABLE {
+		t.Errorf("read-only file: want ENOTCAPABLE, got %s", errno)
+	}
+}
+
+func TestBuilderInvalidDirs(t *testing.T) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	for _, dir := range []string{"", "a:b:c", ":/data", "/tmp:", "/tmp:rights=NoSuchRight", ":rights=AllRights"} {
+		if _, _, err := imports.NewBuilder().WithDirs(dir).Instantiate(ctx, runtime); err == nil {
+			t.Errorf("%q: invalid directory was accepted", dir)
+		}
+	}
+}
+
+func TestBuilderWorkingDirectory(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := imports.NewBuilder().
+		WithDirs(t.TempDir()+":/", tmp+":/data").
+		WithWorkingDirectory("/data").
+		Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	fd, errno := system.PathOpen(ctx, wasi.WorkingDirectoryFD, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	defer system.FDClose(ctx, fd)
+
+	if _, _, err := imports.NewBuilder().WithDirs(tmp).WithWorkingDirectory("/data").Instantiate(ctx, runtime); err == nil {
+		t.Error("working directory which is not a preopen was accepted")
+	}
+}
+
+func TestBuilderRandSeed(t *testing.T) {
+	a := randomBytes(t, imports.NewBuilder().WithRandSeed(42), 64)
+	b := randomBytes(t, imports.NewBuilder().WithRandSeed(42), 64)
+	c := randomBytes(t, imports.NewBuilder().WithRandSeed(43), 64)
+	if !bytes.Equal(a, b) {
+		t.Errorf("same seed: random bytes differ:\n%x\n%x", a, b)
+	}
+	if bytes.Equal(a, c) {
+		t.Errorf("different seeds: random bytes are the same: %x", a)
+	}
+}
+
+func TestBuilderRand(t *testing.T) {
+	// Short reads of the source are retried until the buffer is full.
+	want := bytes.Repeat([]byte{0xff}, 64)
+	rand := iotest.OneByteReader(bytes.NewReader(want))
+	got := randomBytes(t, imports.NewBuilder().WithRand(rand), len(want))
+	if !bytes.Equal(got, want) {
+		t.Errorf("buffer was not filled completely: %x", got)
+	}
+}
+
+func randomBytes(t *testing.T, builder *imports.Builder, n int) []byte {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	ctx, system, err := builder.Instantiate(ctx, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Close(ctx)
+
+	b := make([]byte, n)
+	if errno := system.RandomGet(ctx, b); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	return b
+}
@@ -34,7 +34,7 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		stdin, stdout, stderr = b.stdin, b.stdout, b.stderr
 	}
 
-	realtime := defaultRealtime
+	realtime := defaultClocks.Realtime
 	if b.realtime != nil {
 		realtime = b.realtime
 	}
@@ -42,7 +42,7 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 	if b.realtimePrecision > 0 {
 		realtimePrecision = b.realtimePrecision
 	}
-	monotonic := defaultMonotonic
+	monotonic := defaultClocks.Monotonic
 	if b.monotonic != nil {
 		monotonic = b.monotonic
 	}
@@ -79,9 +79,14 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		Raise:              raise,
 		Rand:               rand,
 		Exit:               exit,
+		OnExit:             b.onExit,
 	}
 	unixSystem.MaxOpenFiles = b.maxOpenFiles
 	unixSystem.MaxOpenDirs = b.maxOpenDirs
+	unixSystem.MaxSockets = b.maxSockets
+	unixSystem.OmitDotEntries = b.omitDotEntries
+	unixSystem.StrictPaths = b.strictPaths
+	unixSystem.TrackOpenFiles = b.trackOpenFiles
 
 	system := wasi.System(unixSystem)
 	defer func() {
@@ -111,7 +116,7 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 	} {
 		var err error
 		if stdio.fd < 0 {
-			stdio.fd, err = syscall.Open(stdio.path, stdio.open, 0)
+			stdio.fd, err = syscall.Open(stdio.path, stdio.open|syscall.O_CLOEXEC, 0)
 			// Some systems may not allow opening stdio files on /dev, fallback
 			// duplicating the process file descriptors which comes with the
 			// limitation that setting the file descriptors to non-blocking will
@@ -144,22 +149,35 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		unixSystem.Preopen(unix.FD(stdio.fd), stdio.path, stat)
 	}
 
+	cwd := wasi.FD(-1)
 	for _, m := range b.mounts {
-		fd, err := syscall.Open(m.dir, syscall.O_DIRECTORY, 0)
+		fd, err := syscall.Open(m.dir, syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
 		if err != nil {
 			return ctx, nil, fmt.Errorf("unable to preopen directory %q: %w", m.dir, err)
 		}
 		rightsBase := wasi.DirectoryRights
 		rightsInheriting := wasi.DirectoryRights | wasi.FileRights
+		if m.hasRights {
+			rightsBase, rightsInheriting = m.rightsBase, m.rightsInheriting
+		}
 		if m.mode == 'r' {
 			rightsBase &^= wasi.WriteRights
 			rightsInheriting &^= wasi.WriteRights
 		}
-		unixSystem.Preopen(unix.FD(fd), m.dir, wasi.FDStat{
+		preopen := unixSystem.Preopen(unix.FD(fd), m.path, wasi.FDStat{
 			FileType:         wasi.DirectoryType,
 			RightsBase:       rightsBase,
 			RightsInheriting: rightsInheriting,
 		})
+		if m.path == b.workingDirectory {
+			cwd = preopen
+		}
+	}
+	if b.workingDirectory != "" {
+		if cwd < 0 {
+			return ctx, nil, fmt.Errorf("working directory %q is not a preopened directory", b.workingDirectory)
+		}
+		system = wasi.WorkingDirectory(system, cwd)
 	}
 
 	for _, addr := range b.listens {
@@ -186,6 +204,41 @@ func (b *Builder) Instantiate(ctx context.Context, runtime wazero.Runtime) (ctxr
 		})
 	}
 
+	for _, m := range b.files {
+		rightsBase := wasi.FileRights
+		if m.hasRights {
+			rightsBase = m.rightsBase
+		}
+		flags := syscall.O_RDWR
+		if m.mode == 'r' {
+			rightsBase &^= wasi.WriteRights
+			flags = syscall.O_RDONLY
+		}
+		fd, err := syscall.Open(m.dir, flags|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("unable to preopen file %q: %w", m.dir, err)
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(fd, &stat); err != nil {
+			syscall.Close(fd)
+			return ctx, nil, fmt.Errorf("unable to preopen file %q: %w", m.dir, err)
+		}
+		fileType := wasi.UnknownType
+		switch stat.Mode & syscall.S_IFMT {
+		case syscall.S_IFREG:
+			fileType = wasi.RegularFileType
+		case syscall.S_IFCHR:
+			fileType = wasi.CharacterDeviceType
+		case syscall.S_IFDIR:
+			syscall.Close(fd)
+			return ctx, nil, fmt.Errorf("unable to preopen file %q: is a directory", m.dir)
+		}
+		unixSystem.Preopen(unix.FD(fd), m.path, wasi.FDStat{
+			FileType:   fileType,
+			RightsBase: rightsBase,
+		})
+	}
+
 	var extensions []wasi_snapshot_preview1.Extension
 	if b.socketsExtension != nil {
 		extensions = append(extensions, *b.socketsExtension)
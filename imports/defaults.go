@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/stealthrocket/wasi-go"
 	"github.com/tetratelabs/wazero/sys"
 )
 
@@ -17,15 +18,7 @@ const (
 
 var defaultRand = rand.Reader
 
-var epoch = time.Now()
-
-func defaultRealtime(ctx context.Context) (uint64, error) {
-	return uint64(time.Now().UnixNano()), nil
-}
-
-func defaultMonotonic(ctx context.Context) (uint64, error) {
-	return uint64(time.Since(epoch)), nil
-}
+var defaultClocks = wasi.SystemClocks()
 
 func defaultYield(ctx context.Context) error {
 	runtime.Gosched()
@@ -0,0 +1,285 @@
+package wasi_http
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithCache enables an in-memory cache of the responses to the GET requests
+// of the guest, holding up to maxSize bytes of response bodies. The least
+// recently used responses are evicted when the cache is full.
+//
+// Responses are cached according to their Cache-Control and Expires headers:
+// fresh responses are served without sending the request, and stale responses
+// with an ETag or Last-Modified header are revalidated with a conditional
+// request. Responses carrying a Vary header, and requests with a Range header
+// or conditional headers set by the guest, bypass the cache. The cache is
+// private to the guest, so it may hold responses to authenticated requests.
+func WithCache(maxSize int64) Option {
+	return func(w *WasiHTTP) { w.cacheSize = maxSize }
+}
+
+type cacheTransport struct {
+	transport http.RoundTripper
+	maxSize   int64
+	now       func() time.Time
+
+	mutex   sync.Mutex
+	size    int64
+	lru     list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key        string
+	status     string
+	statusCode int
+	proto      string
+	header     http.Header
+	body       []byte
+	expires    time.Time
+}
+
+func newCacheTransport(transport http.RoundTripper, maxSize int64) *cacheTransport {
+	return &cacheTransport{
+		transport: transport,
+		maxSize:   maxSize,
+		now:       time.Now,
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheableRequest(req) {
+		return t.transport.RoundTrip(req)
+	}
+	key := req.URL.String()
+	reqCacheControl := parseCacheControl(req.Header)
+	_, noCache := reqCacheControl["no-cache"]
+
+	entry, ok := t.lookup(key)
+	if ok && !noCache && t.now().Before(entry.expires) {
+		return entry.response(req), nil
+	}
+
+	send := req
+	if ok {
+		etag := entry.header.Get("Etag")
+		lastModified := entry.header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			send = req.Clone(req.Context())
+			if etag != "" {
+				send.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				send.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	res, err := t.transport.RoundTrip(send)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && send != req && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		// The headers of the 304 response update those of the cached one,
+		// e.g. to extend its freshness.
+		header := entry.header.Clone()
+		for name, values := range res.Header {
+			header[name] = values
+		}
+		updated := *entry
+		updated.header = header
+		updated.expires = t.expires(header)
+		t.store(&updated)
+		return updated.response(req), nil
+	}
+
+	if _, noStore := reqCacheControl["no-store"]; noStore || !t.cacheableResponse(res) {
+		t.remove(key)
+		return res, nil
+	}
+
+	res.Body = &cacheBody{
+		ReadCloser: res.Body,
+		transport:  t,
+		entry: &cacheEntry{
+			key:        key,
+			status:     res.Status,
+			statusCode: res.StatusCode,
+			proto:      res.Proto,
+			header:     res.Header.Clone(),
+			expires:    t.expires(res.Header),
+		},
+	}
+	return res, nil
+}
+
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	for _, name := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *cacheTransport) cacheableResponse(res *http.Response) bool {
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+	if res.ContentLength > t.maxSize {
+		return false
+	}
+	if res.Header.Get("Vary") != "" {
+		return false
+	}
+	cacheControl := parseCacheControl(res.Header)
+	if _, noStore := cacheControl["no-store"]; noStore {
+		return false
+	}
+	// Responses without explicit freshness are only cached if they can be
+	// revalidated, there is no heuristic freshness.
+	if _, ok := cacheControl["max-age"]; ok {
+		return true
+	}
+	if res.Header.Get("Expires") != "" {
+		return true
+	}
+	return res.Header.Get("Etag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+// expires returns the time until which a response with the given headers is
+// fresh. Responses which must be revalidated expire immediately.
+func (t *cacheTransport) expires(header http.Header) time.Time {
+	now := t.now()
+	cacheControl := parseCacheControl(header)
+	if _, noCache := cacheControl["no-cache"]; noCache {
+		return now
+	}
+	if maxAge, ok := cacheControl["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil || seconds <= 0 {
+			return now
+		}
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			// Use the clock of the server to compute the freshness
+			// lifetime, since the clocks may not be synchronized.
+			return now.Add(expires.Sub(date))
+		}
+		return expires
+	}
+	return now
+}
+
+// parseCacheControl parses the directives of the Cache-Control header, the
+// keys of the returned map are the lower-case directive names.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func (t *cacheTransport) lookup(key string) (*cacheEntry, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	elem, ok := t.entries[key]
+	if !ok {
+		return nil, false
+	}
+	t.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry), true
+}
+
+func (t *cacheTransport) store(entry *cacheEntry) {
+	size := int64(len(entry.body))
+	if size > t.maxSize {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.removeLocked(entry.key)
+	for t.size+size > t.maxSize {
+		t.removeLocked(t.lru.Back().Value.(*cacheEntry).key)
+	}
+	t.entries[entry.key] = t.lru.PushFront(entry)
+	t.size += size
+}
+
+func (t *cacheTransport) remove(key string) {
+	t.mutex.Lock()
+	t.removeLocked(key)
+	t.mutex.Unlock()
+}
+
+func (t *cacheTransport) removeLocked(key string) {
+	if elem, ok := t.entries[key]; ok {
+		t.lru.Remove(elem)
+		delete(t.entries, key)
+		t.size -= int64(len(elem.Value.(*cacheEntry).body))
+	}
+}
+
+// response returns a response to req served from the cache entry. The entry
+// is not modified after being stored, so the body can be shared.
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        e.status,
+		StatusCode:    e.statusCode,
+		Proto:         e.proto,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cacheBody buffers the body of a response while the guest reads it, and
+// stores the response in the cache once the whole body was read.
+type cacheBody struct {
+	io.ReadCloser
+	transport *cacheTransport
+	entry     *cacheEntry
+	buffer    bytes.Buffer
+	skip      bool
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		if int64(b.buffer.Len()+n) > b.transport.maxSize {
+			b.skip = true
+			b.buffer = bytes.Buffer{}
+		} else {
+			b.buffer.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.skip {
+		b.skip = true
+		b.entry.body = b.buffer.Bytes()
+		b.transport.store(b.entry)
+	}
+	return n, err
+}
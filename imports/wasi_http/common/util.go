@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"log"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -13,9 +12,9 @@ func Malloc(ctx context.Context, m api.Module, size uint32) (uint32, error) {
 	malloc := m.ExportedFunction("cabi_realloc")
 	result, err := malloc.Call(ctx, 0, 0, 4, uint64(size))
 	if err != nil {
-		log.Fatalf(err.Error())
+		return 0, err
 	}
-	return uint32(result[0]), err
+	return uint32(result[0]), nil
 }
 
 func ReadString(mod api.Module, ptr, len uint32) (string, bool) {
@@ -2,6 +2,8 @@ package default_http
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
 
 	"github.com/stealthrocket/wasi-go/imports/wasi_http/types"
 	"github.com/tetratelabs/wazero"
@@ -9,8 +11,20 @@ import (
 
 const ModuleName = "default-outgoing-HTTP"
 
+// Instantiate instantiates the host module with a handler which sends the
+// requests with http.DefaultClient and logs errors to slog.Default.
 func Instantiate(ctx context.Context, r wazero.Runtime, req *types.Requests, res *types.Responses, f *types.FieldsCollection) error {
-	handler := &Handler{req, res, f}
+	return MakeHandler(http.DefaultClient, req, res, f, slog.Default()).Instantiate(ctx, r)
+}
+
+// MakeHandler creates a handler which sends the requests with client and logs
+// the errors which cannot be reported to the guest to logger.
+func MakeHandler(client *http.Client, req *types.Requests, res *types.Responses, f *types.FieldsCollection, logger *slog.Logger) *Handler {
+	return &Handler{client, req, res, f, logger}
+}
+
+// Instantiate instantiates the host module with the handler.
+func (handler *Handler) Instantiate(ctx context.Context, r wazero.Runtime) error {
 	_, err := r.NewHostModuleBuilder(ModuleName).
 		NewFunctionBuilder().WithFunc(requestFn).Export("request").
 		NewFunctionBuilder().WithFunc(handler.handleFn).Export("handle").
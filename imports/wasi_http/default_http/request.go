@@ -2,16 +2,19 @@ package default_http
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 
 	"github.com/stealthrocket/wasi-go/imports/wasi_http/types"
 	"github.com/tetratelabs/wazero/api"
 )
 
 type Handler struct {
-	req *types.Requests
-	res *types.Responses
-	f   *types.FieldsCollection
+	client *http.Client
+	req    *types.Requests
+	res    *types.Responses
+	f      *types.FieldsCollection
+	logger *slog.Logger
 }
 
 // Request handles HTTP serving. It's currently unimplemented
@@ -24,13 +27,13 @@ func requestFn(_ context.Context, mod api.Module, a, b, c, d, e, f, g, h, j, k,
 func (handler *Handler) handleFn(_ context.Context, mod api.Module, request, b, c, d, e, f, g, h uint32) uint32 {
 	req, ok := handler.req.GetRequest(request)
 	if !ok {
-		log.Printf("Failed to get request: %v\n", request)
+		handler.logger.Warn("unknown request handle", "handle", request)
 		return 0
 	}
-	r, err := req.MakeRequest(handler.f)
+	r, err := req.Send(handler.client, handler.f)
 	if err != nil {
-		log.Println(err.Error())
-		return 0
+		handler.logger.Error("failed to send request", "error", err)
+		return handler.res.MakeErrorResponse(err)
 	}
 	return handler.res.MakeResponse(r)
 }
@@ -0,0 +1,36 @@
+package wasi_http_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+
+	"github.com/stealthrocket/wasi-go/imports/wasi_http"
+	"github.com/tetratelabs/wazero"
+)
+
+func ExampleWithTLSConfig() {
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	// Trust the certificate authority of private services in addition to the
+	// system roots.
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if pem, err := os.ReadFile("internal-ca.pem"); err == nil {
+		rootCAs.AppendCertsFromPEM(pem)
+	}
+
+	w := wasi_http.MakeWasiHTTP()
+	if err := w.Instantiate(ctx, runtime, wasi_http.WithTLSConfig(&tls.Config{
+		RootCAs: rootCAs,
+	})); err != nil {
+		log.Fatal(err)
+	}
+}
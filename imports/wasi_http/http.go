@@ -2,6 +2,8 @@ package wasi_http
 
 import (
 	"context"
+	"crypto/tls"
+	"log/slog"
 	"net/http"
 
 	"github.com/stealthrocket/wasi-go/imports/wasi_http/default_http"
@@ -18,6 +20,15 @@ type WasiHTTP struct {
 	r  *types.Requests
 	rs *types.Responses
 	o  *types.OutResponses
+
+	tlsConfig           *tls.Config
+	checkRedirect       func(*http.Request, []*http.Request) error
+	observer            Observer
+	maxIdleConnsPerHost int
+	logger              *slog.Logger
+	cacheSize           int64
+
+	client *http.Client
 }
 
 func MakeWasiHTTP() *WasiHTTP {
@@ -36,14 +47,27 @@ func MakeWasiHTTP() *WasiHTTP {
 	}
 }
 
-func (w *WasiHTTP) Instantiate(ctx context.Context, rt wazero.Runtime) error {
+func (w *WasiHTTP) Instantiate(ctx context.Context, rt wazero.Runtime, options ...Option) error {
+	for _, opt := range options {
+		opt(w)
+	}
+	w.client = w.newClient()
+	if w.logger == nil {
+		w.logger = slog.Default()
+	}
+	w.s.Logger = w.logger
+	w.f.Logger = w.logger
+	w.r.Logger = w.logger
+	w.rs.Logger = w.logger
+	w.r.Client = w.client
+
 	if err := types.Instantiate(ctx, rt, w.s, w.r, w.rs, w.f, w.o); err != nil {
 		return err
 	}
 	if err := streams.Instantiate(ctx, rt, w.s); err != nil {
 		return err
 	}
-	if err := default_http.Instantiate(ctx, rt, w.r, w.rs, w.f); err != nil {
+	if err := default_http.MakeHandler(w.client, w.r, w.rs, w.f, w.logger).Instantiate(ctx, rt); err != nil {
 		return err
 	}
 	return nil
@@ -1,19 +1,30 @@
 package wasi_http
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/imports"
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/types"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/sys"
 )
@@ -214,3 +225,392 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestHttpClientTLSConfig(t *testing.T) {
+	s := httptest.NewTLSServer(&handler{})
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &types.Request{Method: "GET", Scheme: "https", Authority: u.Host, Path: "/"}
+
+	ctx := context.Background()
+	for _, test := range []struct {
+		name    string
+		options []Option
+		fail    bool
+	}{
+		{name: "system roots", fail: true},
+		{name: "custom roots", options: []Option{WithTLSConfig(&tls.Config{RootCAs: rootCAs(s.Certificate())})}},
+		{name: "insecure", options: []Option{WithTLSConfig(&tls.Config{InsecureSkipVerify: true})}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			runtime := wazero.NewRuntime(ctx)
+			defer runtime.Close(ctx)
+
+			w := MakeWasiHTTP()
+			if err := w.Instantiate(ctx, runtime, test.options...); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := request.Send(w.client, w.f)
+			if test.fail {
+				if err == nil {
+					res.Body.Close()
+					t.Fatal("request succeeded with an untrusted certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != 200 {
+				t.Errorf("Unexpected status: %d", res.StatusCode)
+			}
+		})
+	}
+}
+
+func rootCAs(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+func TestHttpClientRedirects(t *testing.T) {
+	// Requests to /redirect/N are redirected to /redirect/N-1 until N is zero.
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/redirect/"))
+		if n > 0 {
+			http.Redirect(res, req, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+			return
+		}
+		res.Write([]byte("Response"))
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, test := range []struct {
+		name     string
+		option   Option
+		status   int
+		location string
+	}{
+		{name: "default", status: http.StatusOK},
+		{name: "don't follow", option: WithMaxRedirects(0), status: http.StatusFound, location: "/redirect/2"},
+		{name: "follow up to 2", option: WithMaxRedirects(2), status: http.StatusFound, location: "/redirect/0"},
+		{name: "follow up to 3", option: WithMaxRedirects(3), status: http.StatusOK},
+		{name: "callback", status: http.StatusFound, location: "/redirect/1", option: WithCheckRedirect(func(req *http.Request, via []*http.Request) error {
+			if req.URL.Path == "/redirect/1" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			runtime := wazero.NewRuntime(ctx)
+			defer runtime.Close(ctx)
+
+			w := MakeWasiHTTP()
+			var options []Option
+			if test.option != nil {
+				options = append(options, test.option)
+			}
+			if err := w.Instantiate(ctx, runtime, options...); err != nil {
+				t.Fatal(err)
+			}
+
+			request := &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/redirect/3"}
+			res, err := request.Send(w.client, w.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != test.status {
+				t.Errorf("Unexpected status: %d vs %d", res.StatusCode, test.status)
+			}
+			if location := res.Header.Get("Location"); location != test.location {
+				t.Errorf("Unexpected location: %q vs %q", location, test.location)
+			}
+		})
+	}
+}
+
+func TestHttpClientHeaders(t *testing.T) {
+	var headers []http.Header
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		headers = append(headers, req.Header)
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	w := MakeWasiHTTP()
+	if err := w.Instantiate(ctx, runtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range []types.Fields{
+		{"X-Foo": {"bar\r\nX-Injected: yes"}},
+		{"X-Foo": {"bar\nX-Injected: yes"}},
+		{"X-Foo\r\nX-Injected": {"yes"}},
+		{"X Foo": {"bar"}},
+		{"": {"bar"}},
+	} {
+		request := &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/", Headers: w.f.MakeFields(fields)}
+		res, err := request.Send(w.client, w.f)
+		if err == nil {
+			res.Body.Close()
+			t.Errorf("invalid header fields were sent: %q", fields)
+		} else if !errors.Is(err, types.ErrInvalidHeader) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if len(headers) != 0 {
+		t.Fatalf("invalid requests reached the server: %v", headers)
+	}
+
+	request := &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/", Headers: w.f.MakeFields(types.Fields{
+		"x-foo":             {"bar"},
+		"Host":              {"example.com"},
+		"Content-Length":    {"0"},
+		"Transfer-Encoding": {"chunked"},
+		"Connection":        {"X-Hop"},
+		"X-Hop":             {"hop"},
+	})}
+	res, err := request.Send(w.client, w.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if len(headers) != 1 {
+		t.Fatalf("Unexpected requests: %v", headers)
+	}
+	for _, name := range []string{"Content-Length", "Transfer-Encoding", "X-Hop"} {
+		if values, found := headers[0][name]; found {
+			t.Errorf("Unexpected header %s: %q", name, values)
+		}
+	}
+	if value := headers[0].Get("X-Foo"); value != "bar" {
+		t.Errorf("Unexpected X-Foo header: %q", value)
+	}
+}
+
+type observer struct {
+	events []string
+}
+
+func (o *observer) RequestStart(req *http.Request) {
+	o.events = append(o.events, fmt.Sprintf("start %s %s %d", req.Method, req.URL.Path, req.ContentLength))
+}
+
+func (o *observer) RequestFinish(req *http.Request, res *http.Response, elapsed time.Duration) {
+	o.events = append(o.events, fmt.Sprintf("finish %s %d", req.URL.Path, res.StatusCode))
+}
+
+func (o *observer) RequestError(req *http.Request, err error, elapsed time.Duration) {
+	o.events = append(o.events, fmt.Sprintf("error %s", req.URL.Path))
+}
+
+func (o *observer) ResponseBodyFinish(req *http.Request, n int64, err error) {
+	o.events = append(o.events, fmt.Sprintf("body %s %d %v", req.URL.Path, n, err))
+}
+
+func TestHttpClientObserver(t *testing.T) {
+	s := httptest.NewServer(&handler{})
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	o := &observer{}
+	w := MakeWasiHTTP()
+	if err := w.Instantiate(ctx, runtime, WithObserver(o)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := &types.Request{Method: "POST", Scheme: "http", Authority: u.Host, Path: "/post", BodyBuffer: bytes.NewBufferString("Hello")}
+	res, err := request.Send(w.client, w.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// The guest closes the body before reading it to the end.
+	request = &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/early"}
+	res, err = request.Send(w.client, w.f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(res.Body, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	s.Close()
+	request = &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/get"}
+	if _, err := request.Send(w.client, w.f); err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+
+	expected := []string{
+		"start POST /post 5",
+		"finish /post 200",
+		"body /post 8 <nil>",
+		"start GET /early 0",
+		"finish /early 200",
+		"body /early 3 unexpected EOF",
+		"start GET /get 0",
+		"error /get",
+	}
+	if !reflect.DeepEqual(o.events, expected) {
+		t.Errorf("Unexpected events: %q vs %q", o.events, expected)
+	}
+}
+
+func BenchmarkHttpClientKeepAlive(b *testing.B) {
+	var conns int64
+	s := httptest.NewUnstartedServer(&handler{})
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	w := MakeWasiHTTP()
+	if err := w.Instantiate(ctx, runtime, WithMaxIdleConnsPerHost(4)); err != nil {
+		b.Fatal(err)
+	}
+
+	request := &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: "/"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := request.Send(w.client, w.f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// The guest drops the response without reading the body.
+		w.rs.DeleteResponse(w.rs.MakeResponse(res))
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+	if b.N > 1 && atomic.LoadInt64(&conns) != 1 {
+		b.Errorf("connections were not reused: %d connections for %d requests", conns, b.N)
+	}
+}
+
+func TestHttpClientCache(t *testing.T) {
+	var requests atomic.Int32
+	var revalidations atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		switch req.URL.Path {
+		case "/fresh":
+			res.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			res.Header().Set("Cache-Control", "no-cache")
+			res.Header().Set("Etag", `"v1"`)
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				revalidations.Add(1)
+				res.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			res.Header().Set("Cache-Control", "no-store")
+		}
+		res.Write([]byte("Response " + req.URL.Path))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	w := MakeWasiHTTP()
+	if err := w.Instantiate(ctx, runtime, WithCache(1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) string {
+		request := &types.Request{Method: "GET", Scheme: "http", Authority: u.Host, Path: path}
+		res, err := request.Send(w.client, w.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status code: %d", res.StatusCode)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	for _, test := range []struct {
+		path          string
+		requests      int32
+		revalidations int32
+	}{
+		{path: "/fresh", requests: 1},
+		{path: "/etag", requests: 2, revalidations: 1},
+		{path: "/no-store", requests: 2},
+	} {
+		requests.Store(0)
+		revalidations.Store(0)
+		for i := 0; i < 2; i++ {
+			if body := get(test.path); body != "Response "+test.path {
+				t.Errorf("%s: unexpected body: %q", test.path, body)
+			}
+		}
+		if n := requests.Load(); n != test.requests {
+			t.Errorf("%s: unexpected number of requests: %d", test.path, n)
+		}
+		if n := revalidations.Load(); n != test.revalidations {
+			t.Errorf("%s: unexpected number of revalidations: %d", test.path, n)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package wasi_http
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Observer is notified of the outgoing requests of the guest, for example to
+// export metrics. Requests sent to follow redirects are observed individually.
+// The methods may be called concurrently.
+type Observer interface {
+	// RequestStart is called before sending req. The number of bytes of the
+	// request body is req.ContentLength.
+	RequestStart(req *http.Request)
+	// RequestFinish is called when the response headers to req are received,
+	// after the given elapsed time.
+	RequestFinish(req *http.Request, res *http.Response, elapsed time.Duration)
+	// RequestError is called when sending req fails after the given elapsed
+	// time.
+	RequestError(req *http.Request, err error, elapsed time.Duration)
+	// ResponseBodyFinish is called when the guest stops reading the response
+	// body of req after reading n bytes, with a nil error at the end of the
+	// body, or io.ErrUnexpectedEOF if the body was closed before its end.
+	ResponseBodyFinish(req *http.Request, n int64, err error)
+}
+
+// WithObserver sets an observer notified of the outgoing requests of the
+// guest.
+func WithObserver(observer Observer) Option {
+	return func(w *WasiHTTP) { w.observer = observer }
+}
+
+type observerTransport struct {
+	transport http.RoundTripper
+	observer  Observer
+}
+
+func (t *observerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.observer.RequestStart(req)
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		t.observer.RequestError(req, err, time.Since(start))
+		return nil, err
+	}
+	t.observer.RequestFinish(req, res, time.Since(start))
+	res.Body = &observerBody{ReadCloser: res.Body, req: req, observer: t.observer}
+	return res, nil
+}
+
+type observerBody struct {
+	io.ReadCloser
+	req      *http.Request
+	observer Observer
+	once     sync.Once
+	n        int64
+}
+
+func (b *observerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil {
+		if err == io.EOF {
+			b.finish(nil)
+		} else {
+			b.finish(err)
+		}
+	}
+	return n, err
+}
+
+func (b *observerBody) Close() error {
+	// Does nothing if reading the body reached its end or failed.
+	b.finish(io.ErrUnexpectedEOF)
+	return b.ReadCloser.Close()
+}
+
+func (b *observerBody) finish(err error) {
+	b.once.Do(func() { b.observer.ResponseBodyFinish(b.req, b.n, err) })
+}
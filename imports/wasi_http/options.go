@@ -0,0 +1,103 @@
+package wasi_http
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+)
+
+// Option configures the host functions created by WasiHTTP.Instantiate.
+type Option func(*WasiHTTP)
+
+// WithTLSConfig sets the TLS configuration of the transport used to send the
+// outgoing requests of the guest, for example to trust the root certificate
+// authorities of private services, or to present a client certificate when
+// the server requires mutual TLS.
+//
+// Setting InsecureSkipVerify disables the verification of the server
+// certificate chain and host name, which exposes guest requests to
+// man-in-the-middle attacks. It should only be used in development, against
+// servers which cannot be configured with a trusted certificate.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(w *WasiHTTP) { w.tlsConfig = config }
+}
+
+// WithCheckRedirect sets the redirect policy of the outgoing requests of the
+// guest, with the semantics of http.Client.CheckRedirect. When check returns
+// http.ErrUseLastResponse, the redirect response is returned to the guest
+// unchanged, including its Location header.
+//
+// By default, up to 10 consecutive redirects are followed.
+func WithCheckRedirect(check func(req *http.Request, via []*http.Request) error) Option {
+	return func(w *WasiHTTP) { w.checkRedirect = check }
+}
+
+// WithMaxRedirects sets the maximum number of consecutive redirects followed
+// by the outgoing requests of the guest. Once the limit is reached, the last
+// redirect response is returned to the guest. Zero disables following
+// redirects.
+func WithMaxRedirects(n int) Option {
+	return WithCheckRedirect(func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	})
+}
+
+// WithMaxResponseBodySize sets the maximum size of the response bodies that
+// the guest can read. Reading past the limit results in a stream error instead
+// of the end of the body. Zero, the default, means no limit.
+func WithMaxResponseBodySize(n int64) Option {
+	return func(w *WasiHTTP) { w.rs.MaxBodySize = n }
+}
+
+// WithDecompression enables the decompression of response bodies with a gzip
+// or deflate Content-Encoding before they are exposed to the guest, which is
+// otherwise only done by the Go http client when the guest does not set the
+// Accept-Encoding header. The Content-Encoding and Content-Length headers are
+// removed from decompressed responses.
+func WithDecompression() Option {
+	return func(w *WasiHTTP) { w.rs.Decompress = true }
+}
+
+// WithMaxIdleConnsPerHost sets the maximum number of idle keep-alive
+// connections that are kept open to each host, to be reused by the next
+// requests of the guest. The default is http.DefaultMaxIdleConnsPerHost.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(w *WasiHTTP) { w.maxIdleConnsPerHost = n }
+}
+
+// WithLogger sets the logger receiving the errors of the host functions which
+// cannot be reported to the guest, e.g. failures to write to an output stream.
+// By default, the errors are logged with slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(w *WasiHTTP) { w.logger = logger }
+}
+
+func (w *WasiHTTP) newClient() *http.Client {
+	client := &http.Client{CheckRedirect: w.checkRedirect}
+	if w.tlsConfig != nil || w.maxIdleConnsPerHost != 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = w.tlsConfig
+		transport.MaxIdleConnsPerHost = w.maxIdleConnsPerHost
+		client.Transport = transport
+	}
+	if w.observer != nil {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = &observerTransport{transport, w.observer}
+	}
+	if w.cacheSize > 0 {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		// The cache wraps the observer, which only observes the requests
+		// actually sent.
+		client.Transport = newCacheTransport(transport, w.cacheSize)
+	}
+	return client
+}
@@ -3,7 +3,6 @@ package streams
 import (
 	"context"
 	"encoding/binary"
-	"log"
 
 	"github.com/stealthrocket/wasi-go/imports/wasi_http/common"
 	"github.com/tetratelabs/wazero/api"
@@ -12,23 +11,35 @@ import (
 func (s *Streams) streamReadFn(ctx context.Context, mod api.Module, stream_handle uint32, length uint64, out_ptr uint32) {
 	rawData := make([]byte, length)
 	n, done, err := s.Read(stream_handle, rawData)
+	s.writeReadResult(ctx, mod, out_ptr, rawData[:n], done, err)
+}
+
+func (s *Streams) streamPeekFn(ctx context.Context, mod api.Module, stream_handle uint32, length uint64, out_ptr uint32) {
+	data, done, err := s.Peek(stream_handle, int(min(length, MaxPeekSize)))
+	s.writeReadResult(ctx, mod, out_ptr, data, done, err)
+}
 
-	//	data, err := types.ResponseBody()
+func (s *Streams) writeReadResult(ctx context.Context, mod api.Module, out_ptr uint32, data []byte, done bool, err error) {
+	le := binary.LittleEndian
 	if err != nil {
-		log.Fatalf(err.Error())
+		s.Logger.Error("failed to read stream", "error", err)
+		data := []byte{}
+		// 0 == is_ok, 1 == is_err
+		data = le.AppendUint32(data, 1)
+		data = le.AppendUint32(data, 0)
+		mod.Memory().Write(out_ptr, data)
+		return
 	}
 
-	data := rawData[0:n]
 	ptr_len := uint32(len(data))
 	ptr, err := common.Malloc(ctx, mod, ptr_len)
 	if err != nil {
-		log.Fatalf(err.Error())
+		panic(err.Error())
 	}
 	mod.Memory().Write(ptr, data)
 
 	data = []byte{}
 	// 0 == is_ok, 1 == is_err
-	le := binary.LittleEndian
 	data = le.AppendUint32(data, 0)
 	data = le.AppendUint32(data, ptr)
 	data = le.AppendUint32(data, ptr_len)
@@ -1,9 +1,12 @@
 package streams
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 
@@ -12,12 +15,22 @@ import (
 
 const ModuleName = "streams"
 
+// MaxPeekSize is the maximum number of bytes that can be peeked from a stream.
+const MaxPeekSize = 64 * 1024
+
 type Stream struct {
 	reader io.Reader
 	writer io.Writer
 }
 
+// Streams is the registry of the input and output streams exposed to the
+// guest. It is safe for concurrent use, but each stream must only be read or
+// written by one goroutine at a time.
 type Streams struct {
+	// Logger receives the errors of the stream operations which cannot be
+	// reported to the guest.
+	Logger *slog.Logger
+
 	lock             sync.RWMutex
 	streams          map[uint32]Stream
 	streamHandleBase uint32
@@ -25,6 +38,7 @@ type Streams struct {
 
 func MakeStreams() *Streams {
 	return &Streams{
+		Logger:           slog.Default(),
 		streams:          make(map[uint32]Stream),
 		streamHandleBase: 1,
 	}
@@ -33,8 +47,11 @@ func MakeStreams() *Streams {
 func Instantiate(ctx context.Context, r wazero.Runtime, s *Streams) error {
 	_, err := r.NewHostModuleBuilder(ModuleName).
 		NewFunctionBuilder().WithFunc(s.streamReadFn).Export("read").
+		NewFunctionBuilder().WithFunc(s.streamPeekFn).Export("peek").
 		NewFunctionBuilder().WithFunc(s.dropInputStreamFn).Export("drop-input-stream").
 		NewFunctionBuilder().WithFunc(s.writeStreamFn).Export("write").
+		NewFunctionBuilder().WithFunc(s.flushStreamFn).Export("flush").
+		NewFunctionBuilder().WithFunc(s.dropOutputStreamFn).Export("drop-output-stream").
 		Instantiate(ctx)
 	return err
 }
@@ -71,6 +88,11 @@ func (s *Streams) GetStream(handle uint32) (stream Stream, found bool) {
 	return
 }
 
+// Read reads data from the stream, blocking until at least one byte is read or
+// the end of the stream is reached. The boolean is true when the end of the
+// stream was reached, in which case the next reads return no data. If the
+// reader makes no progress after maxEmptyReads attempts, Read returns no data
+// with the stream still open, so the guest retries the read later.
 func (s *Streams) Read(handle uint32, data []byte) (int, bool, error) {
 	stream, found := s.GetStream(handle)
 	if !found {
@@ -80,11 +102,105 @@ func (s *Streams) Read(handle uint32, data []byte) (int, bool, error) {
 		return 0, false, fmt.Errorf("not a readable stream: %d", handle)
 	}
 
-	n, err := stream.reader.Read(data)
+	if len(data) == 0 {
+		return 0, false, nil
+	}
+	// Readers returning no data and no error are retried, so the guest does
+	// not spin on empty reads when data is about to become available.
+	for i := 0; i < maxEmptyReads; i++ {
+		n, err := stream.reader.Read(data)
+		if err == io.EOF {
+			return n, true, nil
+		}
+		if n > 0 || err != nil {
+			return n, false, err
+		}
+	}
+	return 0, false, nil
+}
+
+// maxEmptyReads is the number of consecutive empty reads after which Read
+// returns a pending result, like bufio.Reader bounds its retries.
+const maxEmptyReads = 100
+
+// Peek returns up to n bytes from the stream without consuming them, so they
+// are returned again by the next calls to Read. The data is buffered in the
+// stream, n is limited to MaxPeekSize. The boolean is true if the end of the
+// stream was reached before n bytes could be read.
+func (s *Streams) Peek(handle uint32, n int) ([]byte, bool, error) {
+	s.lock.Lock()
+	stream, found := s.streams[handle]
+	if !found {
+		s.lock.Unlock()
+		return nil, false, fmt.Errorf("stream not found: %d", handle)
+	}
+	if stream.reader == nil {
+		s.lock.Unlock()
+		return nil, false, fmt.Errorf("not a readable stream: %d", handle)
+	}
+	n = min(n, MaxPeekSize)
+	r, ok := stream.reader.(*bufio.Reader)
+	if !ok || r.Size() < n {
+		// Wrapping the buffered reader keeps the data which was already
+		// buffered available to the next reads.
+		r = bufio.NewReaderSize(stream.reader, max(n, 4096))
+		stream.reader = r
+		s.streams[handle] = stream
+	}
+	s.lock.Unlock()
+
+	data, err := r.Peek(n)
 	if err == io.EOF {
-		return n, true, nil
+		return data, true, nil
+	}
+	return data, false, err
+}
+
+// Flush flushes the data written to the stream, if its writer has a
+// Flush method.
+func (s *Streams) Flush(handle uint32) error {
+	stream, found := s.GetStream(handle)
+	if !found {
+		return fmt.Errorf("stream not found: %d", handle)
+	}
+	if stream.writer == nil {
+		return fmt.Errorf("not a writeable stream: %d", handle)
 	}
-	return n, false, err
+	if f, ok := stream.writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Finish marks the end of the data written to the stream, closing its writer
+// if it implements io.Closer; for example it completes the body of outgoing
+// requests. The stream can not be written after being finished.
+func (s *Streams) Finish(handle uint32) error {
+	s.lock.Lock()
+	stream, found := s.streams[handle]
+	if found && stream.writer != nil {
+		s.streams[handle] = Stream{writer: finishedWriter{}}
+	}
+	s.lock.Unlock()
+	if !found {
+		return fmt.Errorf("stream not found: %d", handle)
+	}
+	switch w := stream.writer.(type) {
+	case nil:
+		return fmt.Errorf("not a writeable stream: %d", handle)
+	case finishedWriter:
+		return nil
+	case io.Closer:
+		return w.Close()
+	}
+	return nil
+}
+
+// finishedWriter is the writer of finished streams.
+type finishedWriter struct{}
+
+func (finishedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write to a finished stream")
 }
 
 func (s *Streams) Write(handle uint32, data []byte) (int, error) {
@@ -0,0 +1,173 @@
+package streams
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStreamPeek(t *testing.T) {
+	s := MakeStreams()
+	handle := s.NewInputStream(strings.NewReader("Hello, World!"))
+
+	data, done, err := s.Peek(handle, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello" || done {
+		t.Errorf("wrong peeked data: %q (done=%t)", data, done)
+	}
+
+	// Peeking past the end of the stream returns the remaining data.
+	data, done, err = s.Peek(handle, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello, World!" || !done {
+		t.Errorf("wrong peeked data: %q (done=%t)", data, done)
+	}
+
+	var b strings.Builder
+	buf := make([]byte, 4)
+	for {
+		n, done, err := s.Read(handle, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(buf[:n])
+		if done {
+			break
+		}
+	}
+	if b.String() != "Hello, World!" {
+		t.Errorf("wrong data read after peeking: %q", b.String())
+	}
+}
+
+func TestStreamPeekNotReadable(t *testing.T) {
+	s := MakeStreams()
+	handle := s.NewOutputStream(io.Discard)
+	if _, _, err := s.Peek(handle, 1); err == nil {
+		t.Error("peeking an output stream did not fail")
+	}
+	if _, _, err := s.Peek(handle+1, 1); err == nil {
+		t.Error("peeking an unknown stream did not fail")
+	}
+}
+
+// emptyReader returns no data and no error on the first reads, as readers of
+// streaming sources may do when no data is available yet.
+type emptyReader struct {
+	empty int
+	r     io.Reader
+}
+
+func (r *emptyReader) Read(b []byte) (int, error) {
+	if r.empty > 0 {
+		r.empty--
+		return 0, nil
+	}
+	return r.r.Read(b)
+}
+
+func TestStreamRead(t *testing.T) {
+	s := MakeStreams()
+	handle := s.NewInputStream(&emptyReader{empty: 3, r: strings.NewReader("Hello, World!")})
+	buf := make([]byte, 5)
+
+	// The read blocks until data is available.
+	n, done, err := s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "Hello" || done {
+		t.Errorf("wrong data read: %q (done=%t)", buf[:n], done)
+	}
+
+	// More data is available in the middle of the stream.
+	n, done, err = s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != ", Wor" || done {
+		t.Errorf("wrong data read: %q (done=%t)", buf[:n], done)
+	}
+
+	n, done, err = s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ld!" || done {
+		t.Errorf("wrong data read: %q (done=%t)", buf[:n], done)
+	}
+
+	// The end of the stream is only reported when the reader returns EOF.
+	n, done, err = s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || !done {
+		t.Errorf("wrong read at the end of the stream: %d bytes (done=%t)", n, done)
+	}
+}
+
+func TestStreamReadNoProgress(t *testing.T) {
+	s := MakeStreams()
+	handle := s.NewInputStream(&emptyReader{empty: 3 * maxEmptyReads / 2, r: strings.NewReader("Hello")})
+	buf := make([]byte, 5)
+
+	// The stream is empty but still open, the read is pending.
+	n, done, err := s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || done {
+		t.Errorf("wrong pending read: %d bytes (done=%t)", n, done)
+	}
+
+	// The next read returns the data once it becomes available.
+	n, done, err = s.Read(handle, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "Hello" || done {
+		t.Errorf("wrong data read: %q (done=%t)", buf[:n], done)
+	}
+}
+
+func TestStreamsConcurrency(t *testing.T) {
+	s := MakeStreams()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				in := s.NewInputStream(strings.NewReader("Hello, World!"))
+				out := s.NewOutputStream(io.Discard)
+				if _, _, err := s.Peek(in, 5); err != nil {
+					t.Error(err)
+				}
+				if _, _, err := s.Read(in, make([]byte, 32)); err != nil {
+					t.Error(err)
+				}
+				if _, err := s.Write(out, []byte("Hello, World!")); err != nil {
+					t.Error(err)
+				}
+				if err := s.Finish(out); err != nil {
+					t.Error(err)
+				}
+				s.DeleteStream(in)
+				s.DeleteStream(out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.streams) != 0 {
+		t.Errorf("streams were not deleted: %d", len(s.streams))
+	}
+}
@@ -3,7 +3,6 @@ package streams
 import (
 	"context"
 	"encoding/binary"
-	"log"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -11,12 +10,12 @@ import (
 func (s *Streams) writeStreamFn(_ context.Context, mod api.Module, stream, ptr, l, result_ptr uint32) {
 	data, ok := mod.Memory().Read(ptr, l)
 	if !ok {
-		log.Printf("Body read failed!\n")
+		s.Logger.Error("failed to read the stream data from memory", "stream", stream)
 		return
 	}
 	n, err := s.Write(stream, data)
 	if err != nil {
-		log.Printf("Failed to write: %v\n", err.Error())
+		s.Logger.Error("failed to write stream", "stream", stream, "error", err)
 	}
 
 	data = []byte{}
@@ -27,3 +26,23 @@ func (s *Streams) writeStreamFn(_ context.Context, mod api.Module, stream, ptr,
 	data = le.AppendUint32(data, uint32(n))
 	mod.Memory().Write(result_ptr, data)
 }
+
+func (s *Streams) flushStreamFn(_ context.Context, mod api.Module, stream, result_ptr uint32) {
+	data := []byte{}
+	// 0 == is_ok, 1 == is_err
+	le := binary.LittleEndian
+	if err := s.Flush(stream); err != nil {
+		s.Logger.Error("failed to flush stream", "stream", stream, "error", err)
+		data = le.AppendUint32(data, 1)
+	} else {
+		data = le.AppendUint32(data, 0)
+	}
+	mod.Memory().Write(result_ptr, data)
+}
+
+func (s *Streams) dropOutputStreamFn(_ context.Context, mod api.Module, stream uint32) {
+	if err := s.Finish(stream); err != nil {
+		s.Logger.Error("failed to finish stream", "stream", stream, "error", err)
+	}
+	s.DeleteStream(stream)
+}
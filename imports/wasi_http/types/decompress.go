@@ -0,0 +1,52 @@
+package types
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decompress replaces the body of res with a reader decompressing it if the
+// response has a gzip or deflate Content-Encoding, and updates the headers to
+// describe the decompressed body.
+func decompress(res *http.Response) {
+	var newReader func(io.Reader) (io.ReadCloser, error)
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		newReader = func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+	case "deflate":
+		newReader = zlib.NewReader
+	default:
+		return
+	}
+	res.Body = &decompressReader{body: res.Body, newReader: newReader}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+}
+
+// decompressReader creates the decompressor on the first read so reading the
+// compression header from the network happens when the guest reads the body.
+type decompressReader struct {
+	body      io.ReadCloser
+	newReader func(io.Reader) (io.ReadCloser, error)
+	reader    io.ReadCloser
+	err       error
+}
+
+func (d *decompressReader) Read(b []byte) (int, error) {
+	if d.reader == nil && d.err == nil {
+		d.reader, d.err = d.newReader(d.body)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.reader.Read(b)
+}
+
+func (d *decompressReader) Close() error {
+	return d.body.Close()
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidHeader is returned when the guest sets a header field with an
+// illegal name or value on an outgoing request.
+var ErrInvalidHeader = errors.New("invalid header field")
+
+// hopByHopHeaders are the headers controlling the connection or the framing
+// of the request, which are managed by the Go http client and must not be set
+// by the guest.
+var hopByHopHeaders = [...]string{
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// makeHeader validates the header fields set by the guest and converts them to
+// the headers of an outgoing request. Hop-by-hop headers, and the headers that
+// they list in Connection, are removed.
+func makeHeader(fields Fields) (http.Header, error) {
+	header := make(http.Header, len(fields))
+	for name, values := range fields {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("%w: name %q", ErrInvalidHeader, name)
+		}
+		for _, value := range values {
+			if !validHeaderValue(value) {
+				return nil, fmt.Errorf("%w: value of %s: %q", ErrInvalidHeader, name, value)
+			}
+		}
+		name = http.CanonicalHeaderKey(name)
+		header[name] = append(header[name], values...)
+	}
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	return header, nil
+}
+
+// contentLength returns the value of the Content-Length header set by the
+// guest, which makeHeader removes since the Go http client manages it. The
+// header may be repeated with identical values.
+func contentLength(fields Fields) (length int64, ok bool, err error) {
+	for name, values := range fields {
+		if !strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+		for _, value := range values {
+			for _, v := range strings.Split(value, ",") {
+				n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 63)
+				if err != nil || (ok && int64(n) != length) {
+					return 0, false, fmt.Errorf("%w: value of Content-Length: %q", ErrInvalidHeader, value)
+				}
+				length, ok = int64(n), true
+			}
+		}
+	}
+	return length, ok, nil
+}
+
+// validHeaderName reports whether name is a token, as defined in RFC 7230.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value contains no control characters other
+// than horizontal tabs, which rejects attempts to inject CRLF sequences.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -29,7 +29,7 @@ func Instantiate(ctx context.Context, rt wazero.Runtime, s *streams.Streams, r *
 		NewFunctionBuilder().WithFunc(rs.incomingResponseStatusFn).Export("incoming-response-status").
 		NewFunctionBuilder().WithFunc(rs.incomingResponseHeadersFn).Export("incoming-response-headers").
 		NewFunctionBuilder().WithFunc(rs.incomingResponseConsumeFn).Export("incoming-response-consume").
-		NewFunctionBuilder().WithFunc(futureResponseGetFn).Export("future-incoming-response-get").
+		NewFunctionBuilder().WithFunc(rs.futureResponseGetFn).Export("future-incoming-response-get").
 		NewFunctionBuilder().WithFunc(r.incomingRequestMethodFn).Export("incoming-request-method").
 		NewFunctionBuilder().WithFunc(r.incomingRequestPathFn).Export("incoming-request-path").
 		NewFunctionBuilder().WithFunc(r.incomingRequestAuthorityFn).Export("incoming-request-authority").
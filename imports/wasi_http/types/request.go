@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -24,6 +25,93 @@ type Request struct {
 	Authority  string
 	Headers    uint32
 	BodyBuffer *bytes.Buffer
+	// BodyFinished is set when the guest finishes the output stream of the
+	// body, marking the body as complete.
+	BodyFinished bool
+
+	// The request sent with a streamed body, once the guest wrote more than
+	// MaxBufferedBodySize bytes.
+	streamed *streamedRequest
+}
+
+// MaxBufferedBodySize is the maximum number of bytes of request bodies which
+// are buffered. Requests with larger bodies are sent while the guest writes
+// them, with a chunked transfer encoding unless the guest set the
+// Content-Length header.
+const MaxBufferedBodySize = 64 * 1024
+
+// requestBodyWriter is the writer of the output stream of request bodies.
+//
+// The body is buffered and sent with a Content-Length when the request is
+// handled, closing the writer when the stream is finished marks the body as
+// complete. When the body grows larger than MaxBufferedBodySize, the request
+// is sent and the next writes flow into the request body.
+type requestBodyWriter struct {
+	request *Request
+	client  *http.Client
+	fields  *FieldsCollection
+}
+
+func (w requestBodyWriter) Write(b []byte) (int, error) {
+	if s := w.request.streamed; s != nil {
+		return s.body.Write(b)
+	}
+	n, err := w.request.BodyBuffer.Write(b)
+	if w.client != nil && w.request.BodyBuffer.Len() > MaxBufferedBodySize {
+		if err := w.request.startStreaming(w.client, w.fields); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (w requestBodyWriter) Close() error {
+	w.request.BodyFinished = true
+	if s := w.request.streamed; s != nil {
+		return s.body.Close()
+	}
+	return nil
+}
+
+// streamedRequest is a request sent in the background, its body is written to
+// a pipe.
+type streamedRequest struct {
+	body *io.PipeWriter
+	done chan struct{}
+	res  *http.Response
+	err  error
+}
+
+func (request *Request) startStreaming(client *http.Client, f *FieldsCollection) error {
+	r, w := io.Pipe()
+	buffered := request.BodyBuffer.Bytes()
+	req, err := request.newHTTPRequest(f, io.MultiReader(bytes.NewReader(buffered), r), -1)
+	if err != nil {
+		return err
+	}
+	s := &streamedRequest{body: w, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.res, s.err = client.Do(req)
+		if s.err != nil {
+			// Unblock the guest if the request failed before reading the
+			// whole body.
+			r.CloseWithError(s.err)
+		}
+	}()
+	request.streamed = s
+	request.BodyBuffer = &bytes.Buffer{}
+	return nil
+}
+
+// abort cancels a request sent with a streamed body and waits for it to
+// complete.
+func (s *streamedRequest) abort() {
+	s.body.CloseWithError(errors.New("request dropped"))
+	<-s.done
+	if s.res != nil {
+		s.res.Body.Close()
+	}
 }
 
 func (r Request) Url() string {
@@ -31,6 +119,14 @@ func (r Request) Url() string {
 }
 
 type Requests struct {
+	// Logger receives the errors of the operations on requests which cannot
+	// be reported to the guest.
+	Logger *slog.Logger
+	// Client sends the requests whose bodies are larger than
+	// MaxBufferedBodySize while the guest writes them. If nil, the bodies are
+	// always buffered.
+	Client *http.Client
+
 	lock          sync.RWMutex
 	requests      map[uint32]*Request
 	requestIdBase uint32
@@ -39,7 +135,7 @@ type Requests struct {
 }
 
 func MakeRequests(s *streams.Streams, f *FieldsCollection) *Requests {
-	return &Requests{requests: map[uint32]*Request{}, requestIdBase: 1, streams: s, fields: f}
+	return &Requests{Logger: slog.Default(), requests: map[uint32]*Request{}, requestIdBase: 1, streams: s, fields: f}
 }
 
 func (r *Requests) MakeRequest(req *http.Request) uint32 {
@@ -75,21 +171,68 @@ func (r *Requests) GetRequest(handle uint32) (*Request, bool) {
 	return req, ok
 }
 
+// MakeRequest sends the request with http.DefaultClient, see Send.
 func (request *Request) MakeRequest(f *FieldsCollection) (*http.Response, error) {
+	return request.Send(http.DefaultClient, f)
+}
+
+// Send sends the request with client and returns the response. If the body is
+// being streamed, Send ends the body and waits for the response of the request
+// which is already in flight.
+func (request *Request) Send(client *http.Client, f *FieldsCollection) (*http.Response, error) {
+	if s := request.streamed; s != nil {
+		// Guests may not finish the output stream before handling the
+		// request, the body cannot be written after that.
+		s.body.Close()
+		<-s.done
+		return s.res, s.err
+	}
 	var body io.Reader = nil
+	var bodySize int64
 	if request.BodyBuffer != nil {
 		body = bytes.NewReader(request.BodyBuffer.Bytes())
+		bodySize = int64(request.BodyBuffer.Len())
 	}
+	r, err := request.newHTTPRequest(f, body, bodySize)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(r)
+}
+
+// newHTTPRequest creates the request sent to the server with the given body
+// of bodySize bytes, or -1 if the size is unknown. A Content-Length set by the
+// guest must match the size of the body, otherwise the error wraps
+// ErrInvalidHeader; when the size is unknown, the body is expected to have
+// the length set by the guest, or is sent with a chunked transfer encoding.
+func (request *Request) newHTTPRequest(f *FieldsCollection, body io.Reader, bodySize int64) (*http.Request, error) {
 	r, err := http.NewRequest(request.Method, request.Url(), body)
 	if err != nil {
 		return nil, err
 	}
+	if bodySize < 0 {
+		r.ContentLength = -1
+	}
 
 	if fields, found := f.GetFields(request.Headers); found {
-		r.Header = http.Header(fields)
-	}
+		header, err := makeHeader(fields)
+		if err != nil {
+			return nil, err
+		}
+		r.Header = header
 
-	return http.DefaultClient.Do(r)
+		length, ok, err := contentLength(fields)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if bodySize >= 0 && length != bodySize {
+				return nil, fmt.Errorf("%w: Content-Length is %d but the body has %d bytes", ErrInvalidHeader, length, bodySize)
+			}
+			r.ContentLength = length
+		}
+	}
+	return r, nil
 }
 
 func incomingRequestConsumeFn(ctx context.Context, mod api.Module, request, ptr uint32) {
@@ -157,7 +300,8 @@ func (r *Requests) incomingRequestMethodFn(_ context.Context, mod api.Module, re
 	case "PATCH":
 		method = 8
 	default:
-		log.Fatalf("Unknown method: %s", req.Method)
+		r.Logger.Error("unknown request method", "method", req.Method)
+		return
 	}
 
 	data := []byte{}
@@ -194,7 +338,9 @@ func (r *Requests) newOutgoingRequestFn(_ context.Context, mod api.Module,
 	case 8:
 		request.Method = "PATCH"
 	default:
-		log.Fatalf("Unknown method: %d", method)
+		r.Logger.Error("unknown request method", "method", method)
+		r.deleteRequest(id)
+		return 0
 	}
 
 	path, ok := mod.Memory().Read(uint32(path_ptr), uint32(path_len))
@@ -235,6 +381,9 @@ func (r *Requests) newOutgoingRequestFn(_ context.Context, mod api.Module,
 }
 
 func (r *Requests) dropOutgoingRequestFn(_ context.Context, mod api.Module, handle uint32) {
+	if req, found := r.GetRequest(handle); found && req.streamed != nil && !req.BodyFinished {
+		req.streamed.abort()
+	}
 	r.deleteRequest(handle)
 }
 
@@ -251,11 +400,12 @@ func (r *Requests) dropIncomingRequestFn(_ context.Context, mod api.Module, hand
 func (r *Requests) outgoingRequestWriteFn(_ context.Context, mod api.Module, handle, ptr uint32) {
 	request, found := r.GetRequest(handle)
 	if !found {
-		fmt.Printf("Failed to find request: %d\n", handle)
+		r.Logger.Warn("unknown request handle", "handle", handle)
 		return
 	}
 	request.BodyBuffer = &bytes.Buffer{}
-	stream := r.streams.NewOutputStream(request.BodyBuffer)
+	request.BodyFinished = false
+	stream := r.streams.NewOutputStream(requestBodyWriter{request, r.Client, r.fields})
 
 	data := []byte{}
 	data = binary.LittleEndian.AppendUint32(data, 0)
@@ -0,0 +1,195 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/streams"
+)
+
+func TestRequestBodyFinish(t *testing.T) {
+	type received struct {
+		body          string
+		contentLength int64
+	}
+	requests := make(chan received, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		requests <- received{string(b), req.ContentLength}
+	}))
+	defer s.Close()
+
+	st := streams.MakeStreams()
+	f := MakeFields()
+	r := MakeRequests(st, f)
+	request, _ := r.newRequest()
+	request.Method = "POST"
+	request.Scheme = "http"
+	request.Authority = s.Listener.Addr().String()
+	request.Path = "/"
+	request.BodyBuffer = &bytes.Buffer{}
+	stream := st.NewOutputStream(requestBodyWriter{request: request})
+
+	for _, chunk := range []string{"Hello, ", "World!"} {
+		if _, err := st.Write(stream, []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Flush(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Finish(stream); err != nil {
+		t.Fatal(err)
+	}
+	if !request.BodyFinished {
+		t.Error("the request body was not marked as finished")
+	}
+	if _, err := st.Write(stream, []byte("!")); err == nil {
+		t.Error("writing to a finished stream did not fail")
+	}
+
+	res, err := request.MakeRequest(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	got := <-requests
+	if got.body != "Hello, World!" {
+		t.Errorf("wrong request body: %q", got.body)
+	}
+	if got.contentLength != 13 {
+		t.Errorf("wrong content length: %d", got.contentLength)
+	}
+}
+
+func TestRequestBodyStreaming(t *testing.T) {
+	type received struct {
+		body             string
+		contentLength    int64
+		transferEncoding []string
+	}
+	started := make(chan struct{})
+	requests := make(chan received, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		close(started)
+		b, _ := io.ReadAll(req.Body)
+		requests <- received{string(b), req.ContentLength, req.TransferEncoding}
+	}))
+	defer s.Close()
+
+	st := streams.MakeStreams()
+	f := MakeFields()
+	r := MakeRequests(st, f)
+	r.Client = s.Client()
+	request, _ := r.newRequest()
+	request.Method = "POST"
+	request.Scheme = "http"
+	request.Authority = s.Listener.Addr().String()
+	request.Path = "/"
+	request.BodyBuffer = &bytes.Buffer{}
+	stream := st.NewOutputStream(requestBodyWriter{request, r.Client, f})
+
+	chunk := strings.Repeat("0123456789abcdef", 1024)
+	var body strings.Builder
+	for i := 0; i < 2*MaxBufferedBodySize/len(chunk); i++ {
+		if _, err := st.Write(stream, []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		body.WriteString(chunk)
+	}
+	// The request is in flight before the body is finished.
+	<-started
+
+	for i := 0; i < 4; i++ {
+		if _, err := st.Write(stream, []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		body.WriteString(chunk)
+	}
+	if request.BodyBuffer.Len() > MaxBufferedBodySize {
+		t.Errorf("the request body was buffered: %d bytes", request.BodyBuffer.Len())
+	}
+	if err := st.Finish(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := request.Send(r.Client, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	got := <-requests
+	if got.body != body.String() {
+		t.Errorf("wrong request body: %d bytes instead of %d", len(got.body), body.Len())
+	}
+	if got.contentLength != -1 || len(got.transferEncoding) != 1 || got.transferEncoding[0] != "chunked" {
+		t.Errorf("wrong request encoding: content length %d, transfer encoding %q", got.contentLength, got.transferEncoding)
+	}
+}
+
+func TestRequestContentLength(t *testing.T) {
+	contentLengths := make(chan int64, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		contentLengths <- req.ContentLength
+	}))
+	defer s.Close()
+
+	for _, test := range []struct {
+		scenario      string
+		contentLength []string
+		body          string
+		err           error
+	}{
+		{scenario: "no content length", body: "Hello, World!"},
+		{scenario: "matching content length", contentLength: []string{"13"}, body: "Hello, World!"},
+		{scenario: "repeated content length", contentLength: []string{"13", "13"}, body: "Hello, World!"},
+		{scenario: "zero content length without body", contentLength: []string{"0"}},
+		{scenario: "shorter content length", contentLength: []string{"5"}, body: "Hello, World!", err: ErrInvalidHeader},
+		{scenario: "longer content length", contentLength: []string{"42"}, body: "Hello, World!", err: ErrInvalidHeader},
+		{scenario: "content length without body", contentLength: []string{"13"}, err: ErrInvalidHeader},
+		{scenario: "conflicting content lengths", contentLength: []string{"13", "14"}, body: "Hello, World!", err: ErrInvalidHeader},
+		{scenario: "negative content length", contentLength: []string{"-1"}, body: "Hello, World!", err: ErrInvalidHeader},
+		{scenario: "malformed content length", contentLength: []string{"thirteen"}, body: "Hello, World!", err: ErrInvalidHeader},
+	} {
+		t.Run(test.scenario, func(t *testing.T) {
+			f := MakeFields()
+			fields := Fields{}
+			if test.contentLength != nil {
+				fields["content-length"] = test.contentLength
+			}
+			request := &Request{
+				Method:    "POST",
+				Scheme:    "http",
+				Authority: s.Listener.Addr().String(),
+				Path:      "/",
+				Headers:   f.MakeFields(fields),
+			}
+			if test.body != "" {
+				request.BodyBuffer = bytes.NewBufferString(test.body)
+			}
+
+			res, err := request.MakeRequest(f)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if n := <-contentLengths; n != int64(len(test.body)) {
+				t.Errorf("wrong content length: %d", n)
+			}
+		})
+	}
+}
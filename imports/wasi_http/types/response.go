@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -21,9 +23,23 @@ type Response struct {
 }
 
 type Responses struct {
+	// MaxBodySize is the maximum number of bytes of incoming response bodies
+	// that the guest can read, reading past the limit results in a stream
+	// error. Zero means no limit.
+	MaxBodySize int64
+	// Decompress enables the decompression of incoming response bodies with
+	// a gzip or deflate Content-Encoding. The Content-Encoding and
+	// Content-Length headers are removed from the response exposed to the
+	// guest, and MaxBodySize applies to the decompressed body.
+	Decompress bool
+	// Logger receives the errors of the operations on responses which
+	// cannot be reported to the guest.
+	Logger *slog.Logger
+
 	lock           sync.RWMutex
 	responses      map[uint32]*Response
 	baseResponseId uint32
+	errors         map[uint32]error
 	streams        *streams.Streams
 	fields         *FieldsCollection
 }
@@ -60,10 +76,22 @@ func (r *Responses) GetResponse(handle uint32) (*Response, bool) {
 	return res, ok
 }
 
+// maxDrainSize is the maximum number of bytes of response bodies that are
+// discarded when the guest drops a response, to allow the connection to be
+// reused. Connections with more remaining bytes are closed instead.
+const maxDrainSize = 256 << 10
+
 func (r *Responses) DeleteResponse(handle uint32) {
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	response := r.responses[handle]
 	delete(r.responses, handle)
+	delete(r.errors, handle)
+	r.lock.Unlock()
+
+	if response != nil && response.Response != nil && response.Body != nil {
+		io.CopyN(io.Discard, response.Body, maxDrainSize)
+		response.Body.Close()
+	}
 }
 
 func (r *Responses) dropIncomingResponseFn(_ context.Context, mod api.Module, handle uint32) {
@@ -120,17 +148,20 @@ func (o *OutResponses) setResponseOutparamFn(_ context.Context, mod api.Module,
 func (r *Responses) incomingResponseStatusFn(_ context.Context, mod api.Module, handle uint32) int32 {
 	response, found := r.GetResponse(handle)
 	if !found {
-		log.Printf("Unknown handle: %v", handle)
+		r.Logger.Warn("unknown response handle", "handle", handle)
 		return 0
 	}
 	return int32(response.StatusCode)
 }
 
 func MakeResponses(s *streams.Streams, f *FieldsCollection) *Responses {
-	return &Responses{responses: map[uint32]*Response{}, baseResponseId: 1, streams: s, fields: f}
+	return &Responses{Logger: slog.Default(), responses: map[uint32]*Response{}, baseResponseId: 1, errors: map[uint32]error{}, streams: s, fields: f}
 }
 
 func (r *Responses) MakeResponse(res *http.Response) uint32 {
+	if r.Decompress {
+		decompress(res)
+	}
 	baseResponseId := atomic.AddUint32(&r.baseResponseId, 1)
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -138,10 +169,27 @@ func (r *Responses) MakeResponse(res *http.Response) uint32 {
 	return baseResponseId
 }
 
+// MakeErrorResponse returns the handle of a future response which resolves to
+// the error that occurred sending an outgoing request.
+func (r *Responses) MakeErrorResponse(err error) uint32 {
+	baseResponseId := atomic.AddUint32(&r.baseResponseId, 1)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errors[baseResponseId] = err
+	return baseResponseId
+}
+
+func (r *Responses) getError(handle uint32) (error, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	err, ok := r.errors[handle]
+	return err, ok
+}
+
 func (r *Responses) incomingResponseHeadersFn(_ context.Context, mod api.Module, handle uint32) uint32 {
 	res, found := r.GetResponse(handle)
 	if !found {
-		log.Printf("Unknown handle: %v", handle)
+		r.Logger.Warn("unknown response handle", "handle", handle)
 		return 0
 	}
 	if res.HeaderHandle == 0 {
@@ -151,7 +199,7 @@ func (r *Responses) incomingResponseHeadersFn(_ context.Context, mod api.Module,
 }
 
 func (r *Responses) incomingResponseConsumeFn(_ context.Context, mod api.Module, handle, ptr uint32) {
-	response, found := r.GetResponse(handle)
+	stream, found := r.consume(handle)
 	le := binary.LittleEndian
 	data := []byte{}
 	if !found {
@@ -160,18 +208,79 @@ func (r *Responses) incomingResponseConsumeFn(_ context.Context, mod api.Module,
 	} else {
 		// 0 == ok, 1 == is_err
 		data = le.AppendUint32(data, 0)
-		stream := r.streams.NewInputStream(response.Body)
 		// This is the stream number
 		data = le.AppendUint32(data, stream)
 	}
 	mod.Memory().Write(ptr, data)
 }
 
-func futureResponseGetFn(_ context.Context, mod api.Module, handle, ptr uint32) {
+// consume returns a new input stream reading the body of the response.
+func (r *Responses) consume(handle uint32) (uint32, bool) {
+	response, found := r.GetResponse(handle)
+	if !found {
+		return 0, false
+	}
+	var body io.Reader = response.Body
+	if r.MaxBodySize > 0 {
+		body = &limitedReader{body, r.MaxBodySize}
+	}
+	return r.streams.NewInputStream(body), true
+}
+
+// ErrBodyTooLarge is the error of reads from the body of responses which
+// exceed the MaxBodySize limit.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// limitedReader is like io.LimitedReader but returns ErrBodyTooLarge instead
+// of io.EOF when the underlying reader has more than n bytes.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(b)) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.r.Read(b)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Values of the error variant of the wasi-http types.
+const (
+	invalidURLError = iota
+	timeoutError
+	protocolError
+	unexpectedError
+)
+
+func (r *Responses) futureResponseGetFn(ctx context.Context, mod api.Module, handle, ptr uint32) {
 	le := binary.LittleEndian
 	data := []byte{}
 	// 1 == is_some, 0 == none
 	data = le.AppendUint32(data, 1)
+	if err, found := r.getError(handle); found {
+		kind := uint32(unexpectedError)
+		if errors.Is(err, ErrInvalidHeader) {
+			kind = protocolError
+		}
+		msg := err.Error()
+		data = le.AppendUint32(data, 1)
+		data = le.AppendUint32(data, kind)
+		data = le.AppendUint32(data, allocateWriteString(ctx, mod, msg))
+		data = le.AppendUint32(data, uint32(len(msg)))
+		mod.Memory().Write(ptr, data)
+		return
+	}
 	// 0 == ok, 1 == is_err, consistency ftw!
 	data = le.AppendUint32(data, 0)
 	// Copy the future into the actual
@@ -0,0 +1,160 @@
+package types
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go/imports/wasi_http/streams"
+)
+
+func TestResponseMaxBodySize(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(body))
+	}))
+	defer s.Close()
+
+	for _, test := range []struct {
+		maxBodySize int64
+		body        string
+		err         error
+	}{
+		{maxBodySize: 0, body: body},
+		{maxBodySize: 100, body: body},
+		{maxBodySize: 1000, body: body},
+		{maxBodySize: 42, body: body[:42], err: ErrBodyTooLarge},
+	} {
+		st := streams.MakeStreams()
+		f := MakeFields()
+		rs := MakeResponses(st, f)
+		rs.MaxBodySize = test.maxBodySize
+
+		res, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		stream, ok := rs.consume(rs.MakeResponse(res))
+		if !ok {
+			t.Fatal("response not found")
+		}
+
+		var data []byte
+		for {
+			b := make([]byte, 16)
+			n, done, err := st.Read(stream, b)
+			data = append(data, b[:n]...)
+			if err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("max body size %d: unexpected error: %v", test.maxBodySize, err)
+				}
+				break
+			}
+			if done {
+				if test.err != nil {
+					t.Errorf("max body size %d: reached the end of the body instead of %v", test.maxBodySize, test.err)
+				}
+				break
+			}
+		}
+		if string(data) != test.body {
+			t.Errorf("max body size %d: unexpected body: %q", test.maxBodySize, data)
+		}
+	}
+}
+
+func TestResponseLogger(t *testing.T) {
+	var buf bytes.Buffer
+	rs := MakeResponses(streams.MakeStreams(), MakeFields())
+	rs.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	if status := rs.incomingResponseStatusFn(context.Background(), nil, 42); status != 0 {
+		t.Errorf("unexpected status of unknown response: %d", status)
+	}
+	if log := buf.String(); !strings.Contains(log, `msg="unknown response handle" handle=42`) {
+		t.Errorf("unexpected log output: %q", log)
+	}
+}
+
+func TestResponseDecompress(t *testing.T) {
+	const body = "Hello, World!"
+	var compressed bytes.Buffer
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Encoding", req.URL.Query().Get("encoding"))
+		res.Write(compressed.Bytes())
+	}))
+	defer s.Close()
+
+	for _, test := range []struct {
+		encoding string
+		writer   func(io.Writer) io.WriteCloser
+	}{
+		{encoding: "gzip", writer: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{encoding: "deflate", writer: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+	} {
+		compressed.Reset()
+		w := test.writer(&compressed)
+		io.WriteString(w, body)
+		w.Close()
+
+		for _, enabled := range []bool{false, true} {
+			st := streams.MakeStreams()
+			rs := MakeResponses(st, MakeFields())
+			rs.Decompress = enabled
+
+			// The guest setting Accept-Encoding disables the decompression
+			// of the Go http client.
+			req, err := http.NewRequest("GET", s.URL+"?encoding="+test.encoding, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Encoding", test.encoding)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			handle := rs.MakeResponse(res)
+			response, _ := rs.GetResponse(handle)
+			stream, _ := rs.consume(handle)
+
+			var data []byte
+			for {
+				b := make([]byte, 4)
+				n, done, err := st.Read(stream, b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = append(data, b[:n]...)
+				if done {
+					break
+				}
+			}
+
+			want, encoding := compressed.String(), test.encoding
+			if enabled {
+				want, encoding = body, ""
+			}
+			if string(data) != want {
+				t.Errorf("%s (decompress=%t): unexpected body: %q", test.encoding, enabled, data)
+			}
+			if value := response.Header.Get("Content-Encoding"); value != encoding {
+				t.Errorf("%s (decompress=%t): unexpected Content-Encoding: %q", test.encoding, enabled, value)
+			}
+			if enabled && response.Header.Get("Content-Length") != "" {
+				t.Errorf("%s: Content-Length of the compressed body was not removed", test.encoding)
+			}
+		}
+	}
+}
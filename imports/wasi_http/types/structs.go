@@ -3,8 +3,7 @@ package types
 import (
 	"context"
 	"encoding/binary"
-	"fmt"
-	"log"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 
@@ -14,13 +13,17 @@ import (
 
 type Fields map[string][]string
 type FieldsCollection struct {
+	// Logger receives the errors of the operations on fields which cannot be
+	// reported to the guest.
+	Logger *slog.Logger
+
 	lock         sync.RWMutex
 	fields       map[uint32]Fields
 	baseFieldsId uint32
 }
 
 func MakeFields() *FieldsCollection {
-	return &FieldsCollection{fields: map[uint32]Fields{}, baseFieldsId: 1}
+	return &FieldsCollection{Logger: slog.Default(), fields: map[uint32]Fields{}, baseFieldsId: 1}
 }
 
 func (f *FieldsCollection) MakeFields(fields Fields) uint32 {
@@ -51,7 +54,7 @@ func (f *FieldsCollection) dropFieldsFn(_ context.Context, handle uint32) {
 func (f *FieldsCollection) newFieldsFn(_ context.Context, mod api.Module, ptr, len uint32) uint32 {
 	data, ok := mod.Memory().Read(ptr, len*16)
 	if !ok {
-		fmt.Println("Error reading fields.")
+		f.Logger.Error("failed to read fields from memory")
 		return 0
 	}
 	fields := make(Fields)
@@ -60,14 +63,14 @@ func (f *FieldsCollection) newFieldsFn(_ context.Context, mod api.Module, ptr, l
 		key_len := binary.LittleEndian.Uint32(data[i*16+4 : i*16+8])
 		key, ok := common.ReadString(mod, key_ptr, key_len)
 		if !ok {
-			fmt.Println("Error reading key")
+			f.Logger.Error("failed to read field key from memory")
 			return 0
 		}
 		val_ptr := binary.LittleEndian.Uint32(data[i*16+8 : i*16+12])
 		val_len := binary.LittleEndian.Uint32(data[i*16+12 : i*16+16])
 		val, ok := common.ReadString(mod, val_ptr, val_len)
 		if !ok {
-			fmt.Println("Error reading value")
+			f.Logger.Error("failed to read field value from memory", "key", key)
 			return 0
 		}
 		if _, found := fields[key]; !found {
@@ -81,7 +84,7 @@ func (f *FieldsCollection) newFieldsFn(_ context.Context, mod api.Module, ptr, l
 func allocateWriteString(ctx context.Context, m api.Module, s string) uint32 {
 	ptr, err := common.Malloc(ctx, m, uint32(len(s)))
 	if err != nil {
-		log.Fatalf(err.Error())
+		panic(err.Error())
 	}
 	m.Memory().Write(ptr, []byte(s))
 	return ptr
@@ -96,7 +99,7 @@ func (f *FieldsCollection) fieldsEntriesFn(ctx context.Context, mod api.Module,
 	// 8 bytes per string/string
 	ptr, err := common.Malloc(ctx, mod, l*16)
 	if err != nil {
-		log.Fatalf(err.Error())
+		panic(err.Error())
 	}
 
 	le := binary.LittleEndian
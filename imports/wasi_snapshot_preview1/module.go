@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"math"
 
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wazergo"
@@ -210,6 +211,11 @@ func (m *Module) ClockTimeGet(ctx context.Context, clockID Int32, precision Uint
 }
 
 func (m *Module) FDAdvise(ctx context.Context, fd Int32, offset, length Uint64, advice Int32) Errno {
+	// The advice is a u8 in the WASI ABI, values which do not fit are not
+	// truncated to valid advice.
+	if advice < 0 || advice > math.MaxUint8 {
+		return Errno(wasi.EINVAL)
+	}
 	return Errno(m.WASI.FDAdvise(ctx, wasi.FD(fd), wasi.FileSize(offset), wasi.FileSize(length), wasi.Advice(advice)))
 }
 
@@ -259,8 +265,40 @@ func (m *Module) FDFileStatSetTimes(ctx context.Context, fd Int32, accessTime, m
 	return Errno(m.WASI.FDFileStatSetTimes(ctx, wasi.FD(fd), wasi.Timestamp(accessTime), wasi.Timestamp(modifyTime), wasi.FSTFlags(flags)))
 }
 
+// appendIOVecs appends the i/o vectors of the list to buffer, returning false
+// if the list or any of the i/o vectors is not within the memory of the module,
+// so the functions fail with EFAULT instead of trapping.
+func appendIOVecs(buffer []wasi.IOVec, iovecs List[wasi.IOVec]) ([]wasi.IOVec, bool) {
+	if iovecs.Len() == 0 {
+		return buffer, true
+	}
+	ptr := iovecs.Index(0)
+	mem := ptr.Memory()
+	size := uint64(iovecs.Len()) * uint64(wasi.IOVec(nil).ObjectSize())
+	if size > math.MaxUint32 {
+		return buffer, false
+	}
+	b, ok := mem.Read(ptr.Offset(), uint32(size))
+	if !ok {
+		return buffer, false
+	}
+	for ; len(b) >= 8; b = b[8:] {
+		offset := binary.LittleEndian.Uint32(b[0:])
+		length := binary.LittleEndian.Uint32(b[4:])
+		iov, ok := mem.Read(offset, length)
+		if !ok {
+			return buffer, false
+		}
+		buffer = append(buffer, iov)
+	}
+	return buffer, true
+}
+
 func (m *Module) FDPread(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], offset Uint64, nread Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	result, errno := m.WASI.FDPread(ctx, wasi.FD(fd), m.iovecs, wasi.FileSize(offset))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -291,7 +329,10 @@ func (m *Module) FDPreStatDirName(ctx context.Context, fd Int32, dirName Bytes)
 }
 
 func (m *Module) FDPwrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], offset Uint64, nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	result, errno := m.WASI.FDPwrite(ctx, wasi.FD(fd), m.iovecs, wasi.FileSize(offset))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -301,7 +342,10 @@ func (m *Module) FDPwrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec]
 }
 
 func (m *Module) FDRead(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], nread Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	result, errno := m.WASI.FDRead(ctx, wasi.FD(fd), m.iovecs)
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -370,7 +414,10 @@ func (m *Module) FDTell(ctx context.Context, fd Int32, size Pointer[Uint64]) Err
 }
 
 func (m *Module) FDWrite(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	result, errno := m.WASI.FDWrite(ctx, wasi.FD(fd), m.iovecs)
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -484,7 +531,10 @@ func (m *Module) SockAccept(ctx context.Context, fd Int32, flags Int32, connfd P
 }
 
 func (m *Module) SockRecv(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], iflags Int32, nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	size, roflags, errno := m.WASI.SockRecv(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -495,7 +545,10 @@ func (m *Module) SockRecv(ctx context.Context, fd Int32, iovecs List[wasi.IOVec]
 }
 
 func (m *Module) SockSend(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], flags Int32, nwritten Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	size, errno := m.WASI.SockSend(ctx, wasi.FD(fd), m.iovecs, wasi.SIFlags(flags))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
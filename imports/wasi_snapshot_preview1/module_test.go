@@ -0,0 +1,129 @@
+package wasi_snapshot_preview1
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	. "github.com/stealthrocket/wazergo/types"
+	"github.com/stealthrocket/wazergo/wasm"
+)
+
+type writeSystem struct {
+	wasi.System
+	calls int
+}
+
+func (s *writeSystem) FDWrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	s.calls++
+	n := 0
+	for _, iov := range iovecs {
+		n += len(iov)
+	}
+	return wasi.Size(n), wasi.ESUCCESS
+}
+
+func TestFDWriteIOVecBounds(t *testing.T) {
+	ctx := context.Background()
+	mem := wasm.NewFixedSizeMemory(wasm.PageSize)
+	size := mem.Size()
+
+	nwritten := Ptr[Int32](mem, 1024)
+
+	// The i/o vectors are pairs of offset and length, they are written at the
+	// start of the memory unless the list is set.
+	for _, test := range []struct {
+		scenario string
+		iovecs   [][2]uint32
+		list     List[wasi.IOVec]
+		errno    wasi.Errno
+	}{
+		{
+			scenario: "i/o vectors within memory",
+			iovecs:   [][2]uint32{{100, 10}, {size - 10, 10}},
+		},
+		{
+			scenario: "i/o vector past the end of memory",
+			iovecs:   [][2]uint32{{100, 10}, {size - 10, 11}},
+			errno:    wasi.EFAULT,
+		},
+		{
+			scenario: "i/o vector with an offset past the end of memory",
+			iovecs:   [][2]uint32{{size + 1, 0}},
+			errno:    wasi.EFAULT,
+		},
+		{
+			scenario: "i/o vector wrapping around the address space",
+			iovecs:   [][2]uint32{{100, ^uint32(0)}},
+			errno:    wasi.EFAULT,
+		},
+		{
+			scenario: "list of i/o vectors past the end of memory",
+			list:     MakeList(Ptr[wasi.IOVec](mem, size-4), 1),
+			errno:    wasi.EFAULT,
+		},
+	} {
+		t.Run(test.scenario, func(t *testing.T) {
+			list := test.list
+			if test.iovecs != nil {
+				b, _ := mem.Read(0, uint32(8*len(test.iovecs)))
+				for i, iov := range test.iovecs {
+					binary.LittleEndian.PutUint32(b[i*8:], iov[0])
+					binary.LittleEndian.PutUint32(b[i*8+4:], iov[1])
+				}
+				list = MakeList(Ptr[wasi.IOVec](mem, 0), len(test.iovecs))
+			}
+			system := &writeSystem{}
+			m := &Module{WASI: system}
+
+			if errno := m.FDWrite(ctx, 1, list, nwritten); errno != Errno(test.errno) {
+				t.Fatalf("fd_write: want %s, got %s", test.errno, wasi.Errno(errno))
+			}
+			if test.errno != wasi.ESUCCESS {
+				if system.calls != 0 {
+					t.Error("fd_write: the system was called with invalid i/o vectors")
+				}
+			} else if n := nwritten.Load(); n != 20 {
+				t.Errorf("fd_write: wrong number of bytes written: %d", n)
+			}
+		})
+	}
+}
+
+type readDirSystem struct {
+	wasi.System
+	entries []wasi.DirEntry
+}
+
+func (s *readDirSystem) FDReadDir(ctx context.Context, fd wasi.FD, entries []wasi.DirEntry, cookie wasi.DirCookie, bufferSizeBytes int) (int, wasi.Errno) {
+	return copy(entries, s.entries[cookie:]), wasi.ESUCCESS
+}
+
+func TestFDReadDirNonUTF8(t *testing.T) {
+	ctx := context.Background()
+	mem := wasm.NewFixedSizeMemory(wasm.PageSize)
+
+	// The name is "café" encoded in latin1 followed by a byte which is never
+	// valid in UTF-8, it must be written to the guest memory unchanged.
+	name := []byte("caf\xe9\xff")
+	system := &readDirSystem{
+		entries: []wasi.DirEntry{{Next: 1, INode: 42, Type: wasi.RegularFileType, Name: name}},
+	}
+	m := &Module{WASI: system}
+
+	buf, _ := mem.Read(0, 256)
+	nwritten := Ptr[Int32](mem, 1024)
+	if errno := m.FDReadDir(ctx, 3, buf, 0, nwritten); errno != Errno(wasi.ESUCCESS) {
+		t.Fatalf("fd_readdir: %s", wasi.Errno(errno))
+	}
+	if n := nwritten.Load(); n != Int32(wasi.SizeOfDirent+len(name)) {
+		t.Fatalf("fd_readdir: wrong number of bytes written: %d", n)
+	}
+	if n := binary.LittleEndian.Uint32(buf[16:]); n != uint32(len(name)) {
+		t.Errorf("fd_readdir: wrong name length: want %d, got %d", len(name), n)
+	}
+	if b := buf[wasi.SizeOfDirent : wasi.SizeOfDirent+len(name)]; string(b) != string(name) {
+		t.Errorf("fd_readdir: wrong name: want %q, got %q", name, b)
+	}
+}
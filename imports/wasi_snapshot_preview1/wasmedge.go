@@ -92,7 +92,9 @@ func (m *Module) WasmEdgeSockSendTo(ctx context.Context, fd Int32, iovecs List[w
 	if !ok {
 		return Errno(wasi.EINVAL)
 	}
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	size, errno := m.WASI.SockSendTo(ctx, wasi.FD(fd), m.iovecs, wasi.SIFlags(flags), socketAddr)
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -102,7 +104,10 @@ func (m *Module) WasmEdgeSockSendTo(ctx context.Context, fd Int32, iovecs List[w
 }
 
 func (m *Module) WasmEdgeV1SockRecvFrom(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], addr Pointer[wasmEdgeAddress], iflags Uint32, nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	size, roflags, sa, errno := m.WASI.SockRecvFrom(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -116,7 +121,10 @@ func (m *Module) WasmEdgeV1SockRecvFrom(ctx context.Context, fd Int32, iovecs Li
 }
 
 func (m *Module) WasmEdgeV2SockRecvFrom(ctx context.Context, fd Int32, iovecs List[wasi.IOVec], addr Pointer[wasmEdgeAddress], iflags Uint32, port Pointer[Uint32], nread Pointer[Int32], oflags Pointer[Int32]) Errno {
-	m.iovecs = iovecs.Append(m.iovecs[:0])
+	var ok bool
+	if m.iovecs, ok = appendIOVecs(m.iovecs[:0], iovecs); !ok {
+		return Errno(wasi.EFAULT)
+	}
 	size, roflags, sa, errno := m.WASI.SockRecvFrom(ctx, wasi.FD(fd), m.iovecs, wasi.RIFlags(iflags))
 	if errno != wasi.ESUCCESS {
 		return Errno(errno)
@@ -149,8 +157,16 @@ func (m *Module) WasmEdgeSockSetOpt(ctx context.Context, fd Int32, level Int32,
 			return Errno(wasi.EINVAL)
 		}
 		val = wasi.IntValue(binary.LittleEndian.Uint32(value))
-	case wasi.Linger,
-		wasi.RecvTimeout,
+	case wasi.Linger:
+		// struct linger { int l_onoff; int l_linger; }
+		if len(value) != 8 {
+			return Errno(wasi.EINVAL)
+		}
+		val = wasi.LingerValue{
+			Enabled: binary.LittleEndian.Uint32(value[0:]) != 0,
+			Timeout: int(int32(binary.LittleEndian.Uint32(value[4:]))),
+		}
+	case wasi.RecvTimeout,
 		wasi.SendTimeout,
 		wasi.BindToDevice:
 		return Errno(wasi.ENOTSUP)
@@ -158,6 +174,10 @@ func (m *Module) WasmEdgeSockSetOpt(ctx context.Context, fd Int32, level Int32,
 		wasi.QuerySocketError,
 		wasi.QueryAcceptConnections:
 		return Errno(wasi.ENOTSUP)
+	case wasi.IpTypeOfService,
+		wasi.Ipv6TrafficClass:
+		// These options are not part of the WasmEdge ABI.
+		return Errno(wasi.ENOTSUP)
 
 	default:
 		val = wasi.BytesValue(value)
@@ -169,11 +189,33 @@ func (m *Module) WasmEdgeSockSetOpt(ctx context.Context, fd Int32, level Int32,
 func (m *Module) WasmEdgeSockGetOpt(ctx context.Context, fd Int32, level Int32, option Int32, value Pointer[Int32], valueLen Int32) Errno {
 	opt := wasi.MakeSocketOption(wasi.SocketOptionLevel(level), int32(option))
 
-	// Only int options are supported for now.
+	// Only int and linger options are supported for now.
 	switch opt {
-	case wasi.Linger, wasi.RecvTimeout, wasi.SendTimeout, wasi.BindToDevice:
-		// These accept struct linger / struct timeval / string.
+	case wasi.RecvTimeout, wasi.SendTimeout, wasi.BindToDevice:
+		// These accept struct timeval / string.
+		return Errno(wasi.ENOTSUP)
+	case wasi.IpTypeOfService, wasi.Ipv6TrafficClass:
+		// These options are not part of the WasmEdge ABI.
 		return Errno(wasi.ENOTSUP)
+	case wasi.Linger:
+		if valueLen != 8 {
+			return Errno(wasi.EINVAL)
+		}
+		result, errno := m.WASI.SockGetOpt(ctx, wasi.FD(fd), opt)
+		if errno != wasi.ESUCCESS {
+			return Errno(errno)
+		}
+		linger, ok := result.(wasi.LingerValue)
+		if !ok {
+			return Errno(wasi.EINVAL)
+		}
+		onoff := Int32(0)
+		if linger.Enabled {
+			onoff = 1
+		}
+		value.Store(onoff)
+		value.Index(1).Store(Int32(linger.Timeout))
+		return Errno(wasi.ESUCCESS)
 	}
 	if valueLen != 4 {
 		return Errno(wasi.EINVAL)
@@ -51,6 +51,10 @@ func (t *Table[Descriptor, Object]) Grow(n int) {
 // Insert inserts the given object to the table, returning the descriptor that
 // it is mapped to.
 //
+// The object is mapped to the lowest descriptor that is not in use, like the
+// file descriptors allocated by open(2), so descriptors are reused after being
+// deleted and the table remains compact.
+//
 // The method does not perform deduplication, it is possible for the same object
 // to be inserted multiple times, each insertion will return a different
 // descriptor.
@@ -135,6 +139,10 @@ func (t *Table[Descriptor, Object]) Delete(desc Descriptor) {
 
 // Range calls f for each object and its associated descriptor in the table.
 // The function f might return false to interupt the iteration.
+//
+// The table must not be modified by f, the entries inserted, assigned or
+// deleted during the iteration may or may not be observed. Snapshot should be
+// used instead to iterate over the table while modifying it.
 func (t *Table[Descriptor, Object]) Range(f func(Descriptor, Object) bool) {
 	for i, mask := range t.masks {
 		if mask == 0 {
@@ -151,6 +159,30 @@ func (t *Table[Descriptor, Object]) Range(f func(Descriptor, Object) bool) {
 	}
 }
 
+// Entry is an object and its associated descriptor in a Table.
+type Entry[Descriptor ~int32 | ~uint32, Object any] struct {
+	Desc   Descriptor
+	Object Object
+}
+
+// Snapshot returns the objects of the table and their associated descriptors,
+// ordered by descriptor number.
+//
+// The returned slice is a copy of the table at the time of the call: the
+// table may be modified while iterating over the snapshot, and the changes
+// are not reflected in the snapshot. The objects are copied by value, which
+// means that changes made through pointers that they hold remain visible.
+// Like the other methods, Snapshot must be synchronized with the methods
+// modifying the table when those are called from other goroutines.
+func (t *Table[Descriptor, Object]) Snapshot() []Entry[Descriptor, Object] {
+	entries := make([]Entry[Descriptor, Object], 0, t.Len())
+	t.Range(func(desc Descriptor, object Object) bool {
+		entries = append(entries, Entry[Descriptor, Object]{Desc: desc, Object: object})
+		return true
+	})
+	return entries
+}
+
 // Reset clears the content of the table.
 func (t *Table[Descriptor, Object]) Reset() {
 	for i := range t.masks {
@@ -1,6 +1,8 @@
 package descriptor_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stealthrocket/wasi-go/internal/descriptor"
@@ -94,6 +96,68 @@ func TestTable(t *testing.T) {
 	}
 }
 
+func TestTableSnapshot(t *testing.T) {
+	table := new(descriptor.Table[fd, file])
+	for _, name := range []string{"0", "1", "2"} {
+		table.Insert(file{name: name})
+	}
+
+	snapshot := table.Snapshot()
+	for i, entry := range snapshot {
+		// Modifications of the table during the iteration are not observed
+		// by the snapshot.
+		table.Delete(entry.Desc)
+		table.Insert(file{name: "new"})
+		table.Insert(file{name: "new"})
+
+		if entry.Desc != fd(i) || entry.Object.name != fmt.Sprint(i) {
+			t.Errorf("wrong snapshot entry at index %d: %+v", i, entry)
+		}
+	}
+	if len(snapshot) != 3 {
+		t.Errorf("wrong snapshot length: want=3 got=%d", len(snapshot))
+	}
+	if n := table.Len(); n != 6 {
+		t.Errorf("wrong table length: want=6 got=%d", n)
+	}
+
+	// Snapshots can be iterated over while another goroutine inserts into the
+	// table, as long as the calls to the table are synchronized.
+	var mutex sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			mutex.Lock()
+			table.Insert(file{name: "concurrent"})
+			mutex.Unlock()
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		mutex.Lock()
+		snapshot := table.Snapshot()
+		mutex.Unlock()
+
+		for i, entry := range snapshot {
+			if i > 0 && entry.Desc <= snapshot[i-1].Desc {
+				t.Fatalf("snapshot not ordered by descriptor: %v after %v", entry.Desc, snapshot[i-1].Desc)
+			}
+			if entry.Object.name == "" {
+				t.Fatalf("snapshot entry %v has no object", entry.Desc)
+			}
+		}
+	}
+	if n := len(table.Snapshot()); n != 1006 {
+		t.Errorf("wrong snapshot length: want=1006 got=%d", n)
+	}
+}
+
 func BenchmarkTableInsert(b *testing.B) {
 	table := new(descriptor.Table[fd, *file])
 	entry := new(file)
@@ -24,7 +24,15 @@ func Socket(rawAddr string) (u *url.URL, sa syscall.Sockaddr, fd int, err error)
 		return nil, nil, -1, err
 	}
 	opt := u.Query()
+	// Like the net package, hold the fork lock while the socket does not
+	// have the close-on-exec flag, so it is not inherited by the processes
+	// spawned concurrently.
+	syscall.ForkLock.RLock()
 	fd, err = syscall.Socket(family, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
 	if err != nil {
 		return
 	}
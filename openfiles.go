@@ -0,0 +1,105 @@
+package wasi
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// OpenFile describes a file opened through a FileTable which tracks open
+// files. See FileTable.TrackOpenFiles.
+type OpenFile struct {
+	// FD is the file descriptor of the file.
+	FD FD
+	// Path is the path of the file in the guest, for files opened with
+	// PathOpen from a directory with a known path, and empty otherwise.
+	Path string
+	// Stack holds the program counters of the call stack where the file was
+	// opened, as returned by runtime.Callers.
+	Stack []uintptr
+}
+
+// CallSite returns the function, file, and line of the first frame of the
+// stack where the file was opened which is outside of the wasi package and
+// the systems implementing it, or of the innermost frame if there are none.
+func (f *OpenFile) CallSite() string {
+	frames := runtime.CallersFrames(f.Stack)
+	var site runtime.Frame
+	for i := 0; ; i++ {
+		frame, more := frames.Next()
+		if i == 0 {
+			site = frame
+		}
+		if !isWASIPackage(funcPackage(frame.Function)) {
+			site = frame
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	if site.Function == "" {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s (%s:%d)", site.Function, site.File, site.Line)
+}
+
+// funcPackage returns the import path of the package of the function named fn
+// in a stack frame. The type parameters of generic functions are elided as
+// "[...]", so the package is delimited by the first dot after the last slash.
+func funcPackage(fn string) string {
+	i := strings.LastIndexByte(fn, '/') + 1
+	if j := strings.IndexByte(fn[i:], '.'); j >= 0 {
+		return fn[:i+j]
+	}
+	return fn
+}
+
+func isWASIPackage(pkg string) bool {
+	const module = "github.com/stealthrocket/wasi-go"
+	if strings.HasSuffix(pkg, "_test") {
+		return false
+	}
+	return pkg == module || strings.HasPrefix(pkg, module+"/systems/")
+}
+
+// LeakError is returned by FileTable.Close when files which were tracked were
+// still open.
+type LeakError struct {
+	Files []OpenFile
+}
+
+func (e *LeakError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d file descriptor(s) left open:", len(e.Files))
+	for i := range e.Files {
+		f := &e.Files[i]
+		fmt.Fprintf(&b, "\n\tfd %d", f.FD)
+		if f.Path != "" {
+			fmt.Fprintf(&b, " %q", f.Path)
+		}
+		fmt.Fprintf(&b, " opened by %s", f.CallSite())
+	}
+	return b.String()
+}
+
+// OpenFiles returns the files currently open on the table which were opened
+// while the table was tracking open files, in increasing order of their file
+// descriptors.
+func (t *FileTable[T]) OpenFiles() (files []OpenFile) {
+	t.files.Range(func(fd FD, f fileEntry[T]) bool {
+		if !f.preopen && f.stack != nil {
+			files = append(files, OpenFile{FD: fd, Path: f.path, Stack: f.stack})
+		}
+		return true
+	})
+	return files
+}
+
+// callers returns the call stack of the caller of FileTable.insert.
+func callers() []uintptr {
+	pc := make([]uintptr, 32)
+	// Skip runtime.Callers, callers, and FileTable.insert.
+	n := runtime.Callers(3, pc)
+	return pc[:n:n]
+}
@@ -0,0 +1,221 @@
+package wasi
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// CallGroup is a group of System methods sharing a rate limit.
+type CallGroup int
+
+const (
+	// OpenCalls are the calls to PathOpen and SockOpen.
+	OpenCalls CallGroup = iota
+	// AcceptCalls are the calls to SockAccept.
+	AcceptCalls
+	// ConnectCalls are the calls to SockConnect.
+	ConnectCalls
+	// ReadCalls are the calls to FDRead, FDPread, SockRecv and SockRecvFrom.
+	ReadCalls
+	// WriteCalls are the calls to FDWrite, FDPwrite, SockSend and SockSendTo.
+	WriteCalls
+
+	numCallGroups
+)
+
+// RateLimit wraps a System to limit the rate at which the guest calls groups
+// of methods, using a token bucket for each group configured with
+// WithRateLimit. The methods of the groups which have no limit configured, and
+// the methods which are not part of a group, are not limited.
+//
+// By default, calls exceeding the rate limit block until the rate allows them
+// to proceed, or fail with ECANCELED if the context is canceled while waiting.
+func RateLimit(s System, options ...RateLimiterOption) System {
+	r := &rateLimiter{System: s}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// RateLimiterOption configures a rate limiter.
+type RateLimiterOption func(*rateLimiter)
+
+// WithRateLimit limits the calls to the methods of group to the given rate
+// per second, with bursts of up to burst calls. A rate of zero or less only
+// allows the burst: the calls past it block until the context is canceled, or
+// fail with EAGAIN if the rate limiter is non-blocking.
+func WithRateLimit(group CallGroup, rate float64, burst int) RateLimiterOption {
+	return func(r *rateLimiter) {
+		r.buckets[group] = &tokenBucket{
+			rate:   max(rate, 0),
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+// WithRateLimitNonBlocking configures the rate limiter to fail the calls
+// exceeding the rate limit with EAGAIN instead of blocking.
+func WithRateLimitNonBlocking() RateLimiterOption {
+	return func(r *rateLimiter) { r.nonBlocking = true }
+}
+
+type rateLimiter struct {
+	System
+	buckets     [numCallGroups]*tokenBucket
+	nonBlocking bool
+}
+
+func (r *rateLimiter) wait(ctx context.Context, group CallGroup) Errno {
+	b := r.buckets[group]
+	if b == nil {
+		return ESUCCESS
+	}
+	delay, ok := b.take(time.Now(), !r.nonBlocking)
+	if !ok {
+		return EAGAIN
+	}
+	if delay <= 0 {
+		return ESUCCESS
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return ESUCCESS
+	case <-ctx.Done():
+		b.cancel()
+		return ECANCELED
+	}
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second, holding up
+// to burst tokens.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket and returns how long the caller must wait
+// for the token to be available. If reserve is false and there are no tokens
+// available, take returns false instead. Otherwise the token is reserved, so
+// the number of tokens may become negative.
+func (b *tokenBucket) take(now time.Time, reserve bool) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 && !reserve {
+		return 0, false
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	if b.rate == 0 {
+		// The bucket is never refilled.
+		return math.MaxInt64, true
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// cancel returns a token reserved by take to the bucket.
+func (b *tokenBucket) cancel() {
+	b.mutex.Lock()
+	b.tokens = min(b.burst, b.tokens+1)
+	b.mutex.Unlock()
+}
+
+func (r *rateLimiter) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	if errno := r.wait(ctx, ReadCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.FDPread(ctx, fd, iovecs, offset)
+}
+
+func (r *rateLimiter) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	if errno := r.wait(ctx, WriteCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.FDPwrite(ctx, fd, iovecs, offset)
+}
+
+func (r *rateLimiter) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := r.wait(ctx, ReadCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.FDRead(ctx, fd, iovecs)
+}
+
+func (r *rateLimiter) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	if errno := r.wait(ctx, WriteCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.FDWrite(ctx, fd, iovecs)
+}
+
+func (r *rateLimiter) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	if errno := r.wait(ctx, OpenCalls); errno != ESUCCESS {
+		return -1, errno
+	}
+	return r.System.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (r *rateLimiter) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	if errno := r.wait(ctx, OpenCalls); errno != ESUCCESS {
+		return -1, errno
+	}
+	return r.System.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+}
+
+func (r *rateLimiter) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	if errno := r.wait(ctx, AcceptCalls); errno != ESUCCESS {
+		return -1, nil, nil, errno
+	}
+	return r.System.SockAccept(ctx, fd, flags)
+}
+
+func (r *rateLimiter) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
+	if errno := r.wait(ctx, ConnectCalls); errno != ESUCCESS {
+		return nil, errno
+	}
+	return r.System.SockConnect(ctx, fd, peer)
+}
+
+func (r *rateLimiter) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	if errno := r.wait(ctx, ReadCalls); errno != ESUCCESS {
+		return 0, 0, errno
+	}
+	return r.System.SockRecv(ctx, fd, iovecs, flags)
+}
+
+func (r *rateLimiter) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	if errno := r.wait(ctx, ReadCalls); errno != ESUCCESS {
+		return 0, 0, nil, errno
+	}
+	return r.System.SockRecvFrom(ctx, fd, iovecs, flags)
+}
+
+func (r *rateLimiter) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	if errno := r.wait(ctx, WriteCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.SockSend(ctx, fd, iovecs, flags)
+}
+
+func (r *rateLimiter) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	if errno := r.wait(ctx, WriteCalls); errno != ESUCCESS {
+		return 0, errno
+	}
+	return r.System.SockSendTo(ctx, fd, iovecs, flags, addr)
+}
@@ -0,0 +1,108 @@
+package wasi_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+type writeCounter struct {
+	wasi.System
+	writes int
+}
+
+func (w *writeCounter) FDWrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	w.writes++
+	return 0, wasi.ESUCCESS
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("calls are throttled to the configured rate", func(t *testing.T) {
+		w := &writeCounter{}
+		s := wasi.Trace(io.Discard, wasi.RateLimit(w, wasi.WithRateLimit(wasi.WriteCalls, 100, 5)))
+
+		start := time.Now()
+		for i := 0; i < 15; i++ {
+			if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+		// The first 5 calls consume the burst, the next 10 are spaced by 10ms.
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Errorf("calls were not throttled: 15 calls in %v", elapsed)
+		}
+		if w.writes != 15 {
+			t.Errorf("wrong number of writes: %d", w.writes)
+		}
+	})
+
+	t.Run("non-blocking calls fail with EAGAIN", func(t *testing.T) {
+		w := &writeCounter{}
+		s := wasi.RateLimit(w, wasi.WithRateLimit(wasi.WriteCalls, 1, 3), wasi.WithRateLimitNonBlocking())
+
+		var errnos []wasi.Errno
+		for i := 0; i < 5; i++ {
+			_, errno := s.FDWrite(ctx, 1, nil)
+			errnos = append(errnos, errno)
+		}
+		want := []wasi.Errno{wasi.ESUCCESS, wasi.ESUCCESS, wasi.ESUCCESS, wasi.EAGAIN, wasi.EAGAIN}
+		for i := range want {
+			if errnos[i] != want[i] {
+				t.Fatalf("wrong errors: want %v, got %v", want, errnos)
+			}
+		}
+		if w.writes != 3 {
+			t.Errorf("wrong number of writes: %d", w.writes)
+		}
+	})
+
+	t.Run("a zero rate only allows the burst", func(t *testing.T) {
+		w := &writeCounter{}
+		s := wasi.RateLimit(w, wasi.WithRateLimit(wasi.WriteCalls, 0, 2))
+
+		for i := 0; i < 2; i++ {
+			if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ECANCELED {
+			t.Errorf("want ECANCELED, got %s", errno)
+		}
+		if w.writes != 2 {
+			t.Errorf("wrong number of writes: %d", w.writes)
+		}
+
+		s = wasi.RateLimit(w, wasi.WithRateLimit(wasi.WriteCalls, 0, 1), wasi.WithRateLimitNonBlocking())
+		if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		time.Sleep(time.Millisecond)
+		if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.EAGAIN {
+			t.Errorf("non-blocking: want EAGAIN, got %s", errno)
+		}
+	})
+
+	t.Run("waiting is interrupted by the context", func(t *testing.T) {
+		w := &writeCounter{}
+		s := wasi.RateLimit(w, wasi.WithRateLimit(wasi.WriteCalls, 0.001, 1))
+
+		if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, errno := s.FDWrite(ctx, 1, nil); errno != wasi.ECANCELED {
+			t.Errorf("want ECANCELED, got %s", errno)
+		}
+		if w.writes != 1 {
+			t.Errorf("wrong number of writes: %d", w.writes)
+		}
+	})
+}
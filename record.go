@@ -0,0 +1,753 @@
+package wasi
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+func init() {
+	gob.Register(&Inet4Address{})
+	gob.Register(&Inet6Address{})
+	gob.Register(&UnixAddress{})
+	gob.Register(IntValue(0))
+	gob.Register(TimeValue(0))
+	gob.Register(LingerValue{})
+	gob.Register(BytesValue(nil))
+}
+
+// Record wraps a System to record the results of all calls to its methods to
+// the given io.Writer, including the data written to the buffers passed by
+// the caller, such as the bytes read from files or returned by RandomGet.
+//
+// The recording can be served by the System returned by Replay to run the
+// same program again deterministically. The recording is encoded with the
+// encoding/gob package. Close returns the first error that occurred writing
+// to w, if any.
+func Record(w io.Writer, s System) System {
+	return &recorder{system: s, enc: gob.NewEncoder(w)}
+}
+
+// Replay returns a System which serves the results of the calls recorded by
+// Record from the given io.Reader, without calling into the host.
+//
+// The calls must be made in the order they were recorded, which is the case
+// when the program is deterministic given the results of the calls, and the
+// arguments are not verified. If a call diverges from the recording, it fails
+// with ENOTRECOVERABLE, and so do all the calls which follow it. Close returns
+// the error that caused the divergence, if any.
+func Replay(r io.Reader) System {
+	return &replayer{dec: gob.NewDecoder(r)}
+}
+
+type callRecord struct {
+	Call  string
+	Errno Errno
+}
+
+// Interface values are wrapped in structs to be encoded with their type.
+type addressRecord struct{ Addr SocketAddress }
+
+type optionRecord struct{ Value SocketOptionValue }
+
+type recorder struct {
+	system System
+	enc    *gob.Encoder
+	err    error
+}
+
+func (r *recorder) record(call string, errno Errno, values ...any) {
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(callRecord{call, errno}); r.err != nil {
+		return
+	}
+	for _, v := range values {
+		if r.err = r.enc.Encode(v); r.err != nil {
+			return
+		}
+	}
+}
+
+// iovecsData returns the first n bytes of iovecs.
+func iovecsData(iovecs []IOVec, n int) []byte {
+	data := make([]byte, 0, n)
+	for _, iov := range iovecs {
+		if len(data) == n {
+			break
+		}
+		data = append(data, iov[:min(len(iov), n-len(data))]...)
+	}
+	return data
+}
+
+func (r *recorder) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
+	argCount, stringBytes, errno := r.system.ArgsSizesGet(ctx)
+	r.record("ArgsSizesGet", errno, argCount, stringBytes)
+	return argCount, stringBytes, errno
+}
+
+func (r *recorder) ArgsGet(ctx context.Context) ([]string, Errno) {
+	args, errno := r.system.ArgsGet(ctx)
+	r.record("ArgsGet", errno, args)
+	return args, errno
+}
+
+func (r *recorder) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
+	envCount, stringBytes, errno := r.system.EnvironSizesGet(ctx)
+	r.record("EnvironSizesGet", errno, envCount, stringBytes)
+	return envCount, stringBytes, errno
+}
+
+func (r *recorder) EnvironGet(ctx context.Context) ([]string, Errno) {
+	env, errno := r.system.EnvironGet(ctx)
+	r.record("EnvironGet", errno, env)
+	return env, errno
+}
+
+func (r *recorder) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
+	t, errno := r.system.ClockResGet(ctx, id)
+	r.record("ClockResGet", errno, t)
+	return t, errno
+}
+
+func (r *recorder) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
+	t, errno := r.system.ClockTimeGet(ctx, id, precision)
+	r.record("ClockTimeGet", errno, t)
+	return t, errno
+}
+
+func (r *recorder) FDAdvise(ctx context.Context, fd FD, offset, length FileSize, advice Advice) Errno {
+	errno := r.system.FDAdvise(ctx, fd, offset, length, advice)
+	r.record("FDAdvise", errno)
+	return errno
+}
+
+func (r *recorder) FDAllocate(ctx context.Context, fd FD, offset, length FileSize) Errno {
+	errno := r.system.FDAllocate(ctx, fd, offset, length)
+	r.record("FDAllocate", errno)
+	return errno
+}
+
+func (r *recorder) FDClose(ctx context.Context, fd FD) Errno {
+	errno := r.system.FDClose(ctx, fd)
+	r.record("FDClose", errno)
+	return errno
+}
+
+func (r *recorder) FDDataSync(ctx context.Context, fd FD) Errno {
+	errno := r.system.FDDataSync(ctx, fd)
+	r.record("FDDataSync", errno)
+	return errno
+}
+
+func (r *recorder) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
+	stat, errno := r.system.FDStatGet(ctx, fd)
+	r.record("FDStatGet", errno, stat)
+	return stat, errno
+}
+
+func (r *recorder) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	errno := r.system.FDStatSetFlags(ctx, fd, flags)
+	r.record("FDStatSetFlags", errno)
+	return errno
+}
+
+func (r *recorder) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	errno := r.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
+	r.record("FDStatSetRights", errno)
+	return errno
+}
+
+func (r *recorder) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
+	stat, errno := r.system.FDFileStatGet(ctx, fd)
+	r.record("FDFileStatGet", errno, stat)
+	return stat, errno
+}
+
+func (r *recorder) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	errno := r.system.FDFileStatSetSize(ctx, fd, size)
+	r.record("FDFileStatSetSize", errno)
+	return errno
+}
+
+func (r *recorder) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	errno := r.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+	r.record("FDFileStatSetTimes", errno)
+	return errno
+}
+
+func (r *recorder) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	n, errno := r.system.FDPread(ctx, fd, iovecs, offset)
+	r.record("FDPread", errno, n, iovecsData(iovecs, int(n)))
+	return n, errno
+}
+
+func (r *recorder) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
+	stat, errno := r.system.FDPreStatGet(ctx, fd)
+	r.record("FDPreStatGet", errno, stat)
+	return stat, errno
+}
+
+func (r *recorder) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
+	name, errno := r.system.FDPreStatDirName(ctx, fd)
+	r.record("FDPreStatDirName", errno, name)
+	return name, errno
+}
+
+func (r *recorder) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
+	n, errno := r.system.FDPwrite(ctx, fd, iovecs, offset)
+	r.record("FDPwrite", errno, n)
+	return n, errno
+}
+
+func (r *recorder) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	n, errno := r.system.FDRead(ctx, fd, iovecs)
+	r.record("FDRead", errno, n, iovecsData(iovecs, int(n)))
+	return n, errno
+}
+
+func (r *recorder) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	n, errno := r.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
+	r.record("FDReadDir", errno, entries[:n])
+	return n, errno
+}
+
+func (r *recorder) FDRenumber(ctx context.Context, from, to FD) Errno {
+	errno := r.system.FDRenumber(ctx, from, to)
+	r.record("FDRenumber", errno)
+	return errno
+}
+
+func (r *recorder) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
+	offs, errno := r.system.FDSeek(ctx, fd, offset, whence)
+	r.record("FDSeek", errno, offs)
+	return offs, errno
+}
+
+func (r *recorder) FDSync(ctx context.Context, fd FD) Errno {
+	errno := r.system.FDSync(ctx, fd)
+	r.record("FDSync", errno)
+	return errno
+}
+
+func (r *recorder) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
+	offs, errno := r.system.FDTell(ctx, fd)
+	r.record("FDTell", errno, offs)
+	return offs, errno
+}
+
+func (r *recorder) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
+	n, errno := r.system.FDWrite(ctx, fd, iovecs)
+	r.record("FDWrite", errno, n)
+	return n, errno
+}
+
+func (r *recorder) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	errno := r.system.PathCreateDirectory(ctx, fd, path)
+	r.record("PathCreateDirectory", errno)
+	return errno
+}
+
+func (r *recorder) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	stat, errno := r.system.PathFileStatGet(ctx, fd, lookupFlags, path)
+	r.record("PathFileStatGet", errno, stat)
+	return stat, errno
+}
+
+func (r *recorder) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	errno := r.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
+	r.record("PathFileStatSetTimes", errno)
+	return errno
+}
+
+func (r *recorder) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	errno := r.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
+	r.record("PathLink", errno)
+	return errno
+}
+
+func (r *recorder) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	newfd, errno := r.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	r.record("PathOpen", errno, newfd)
+	return newfd, errno
+}
+
+func (r *recorder) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	n, errno := r.system.PathReadLink(ctx, fd, path, buffer)
+	r.record("PathReadLink", errno, buffer[:n])
+	return n, errno
+}
+
+func (r *recorder) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	errno := r.system.PathRemoveDirectory(ctx, fd, path)
+	r.record("PathRemoveDirectory", errno)
+	return errno
+}
+
+func (r *recorder) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	errno := r.system.PathRename(ctx, fd, oldPath, newFD, newPath)
+	r.record("PathRename", errno)
+	return errno
+}
+
+func (r *recorder) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	errno := r.system.PathSymlink(ctx, oldPath, fd, newPath)
+	r.record("PathSymlink", errno)
+	return errno
+}
+
+func (r *recorder) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	errno := r.system.PathUnlinkFile(ctx, fd, path)
+	r.record("PathUnlinkFile", errno)
+	return errno
+}
+
+func (r *recorder) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	n, errno := r.system.PollOneOff(ctx, subscriptions, events)
+	r.record("PollOneOff", errno, events[:max(n, 0)])
+	return n, errno
+}
+
+func (r *recorder) ProcExit(ctx context.Context, exitCode ExitCode) (errno Errno) {
+	// The system may not return from ProcExit, the call is recorded when
+	// unwinding the stack in this case.
+	defer func() { r.record("ProcExit", errno) }()
+	return r.system.ProcExit(ctx, exitCode)
+}
+
+func (r *recorder) ProcRaise(ctx context.Context, signal Signal) Errno {
+	errno := r.system.ProcRaise(ctx, signal)
+	r.record("ProcRaise", errno)
+	return errno
+}
+
+func (r *recorder) SchedYield(ctx context.Context) Errno {
+	errno := r.system.SchedYield(ctx)
+	r.record("SchedYield", errno)
+	return errno
+}
+
+func (r *recorder) RandomGet(ctx context.Context, b []byte) Errno {
+	errno := r.system.RandomGet(ctx, b)
+	r.record("RandomGet", errno, b)
+	return errno
+}
+
+func (r *recorder) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
+	newfd, peer, addr, errno := r.system.SockAccept(ctx, fd, flags)
+	r.record("SockAccept", errno, newfd, addressRecord{peer}, addressRecord{addr})
+	return newfd, peer, addr, errno
+}
+
+func (r *recorder) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	errno := r.system.SockShutdown(ctx, fd, flags)
+	r.record("SockShutdown", errno)
+	return errno
+}
+
+func (r *recorder) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, Errno) {
+	n, oflags, errno := r.system.SockRecv(ctx, fd, iovecs, flags)
+	r.record("SockRecv", errno, n, oflags, iovecsData(iovecs, int(n)))
+	return n, oflags, errno
+}
+
+func (r *recorder) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (Size, Errno) {
+	n, errno := r.system.SockSend(ctx, fd, iovecs, flags)
+	r.record("SockSend", errno, n)
+	return n, errno
+}
+
+func (r *recorder) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
+	fd, errno := r.system.SockOpen(ctx, family, socketType, protocol, rightsBase, rightsInheriting)
+	r.record("SockOpen", errno, fd)
+	return fd, errno
+}
+
+func (r *recorder) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	addr, errno := r.system.SockBind(ctx, fd, addr)
+	r.record("SockBind", errno, addressRecord{addr})
+	return addr, errno
+}
+
+func (r *recorder) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
+	addr, errno := r.system.SockConnect(ctx, fd, peer)
+	r.record("SockConnect", errno, addressRecord{addr})
+	return addr, errno
+}
+
+func (r *recorder) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	errno := r.system.SockListen(ctx, fd, backlog)
+	r.record("SockListen", errno)
+	return errno
+}
+
+func (r *recorder) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (Size, Errno) {
+	n, errno := r.system.SockSendTo(ctx, fd, iovecs, flags, addr)
+	r.record("SockSendTo", errno, n)
+	return n, errno
+}
+
+func (r *recorder) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
+	n, oflags, addr, errno := r.system.SockRecvFrom(ctx, fd, iovecs, flags)
+	r.record("SockRecvFrom", errno, n, oflags, addressRecord{addr}, iovecsData(iovecs, int(n)))
+	return n, oflags, addr, errno
+}
+
+func (r *recorder) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	value, errno := r.system.SockGetOpt(ctx, fd, option)
+	r.record("SockGetOpt", errno, optionRecord{value})
+	return value, errno
+}
+
+func (r *recorder) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	errno := r.system.SockSetOpt(ctx, fd, option, value)
+	r.record("SockSetOpt", errno)
+	return errno
+}
+
+func (r *recorder) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	addr, errno := r.system.SockLocalAddress(ctx, fd)
+	r.record("SockLocalAddress", errno, addressRecord{addr})
+	return addr, errno
+}
+
+func (r *recorder) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	addr, errno := r.system.SockRemoteAddress(ctx, fd)
+	r.record("SockRemoteAddress", errno, addressRecord{addr})
+	return addr, errno
+}
+
+func (r *recorder) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	n, errno := r.system.SockAddressInfo(ctx, name, service, hints, results)
+	r.record("SockAddressInfo", errno, results[:max(n, 0)])
+	return n, errno
+}
+
+func (r *recorder) Close(ctx context.Context) error {
+	return errors.Join(r.system.Close(ctx), r.err)
+}
+
+type replayer struct {
+	dec *gob.Decoder
+	err error
+}
+
+func (r *replayer) replay(call string, values ...any) Errno {
+	if r.err != nil {
+		return ENOTRECOVERABLE
+	}
+	var rec callRecord
+	if err := r.dec.Decode(&rec); err != nil {
+		r.err = fmt.Errorf("replaying %s: %w", call, err)
+		return ENOTRECOVERABLE
+	}
+	if rec.Call != call {
+		r.err = fmt.Errorf("replaying %s: the recorded call is %s", call, rec.Call)
+		return ENOTRECOVERABLE
+	}
+	for _, v := range values {
+		if err := r.dec.Decode(v); err != nil {
+			r.err = fmt.Errorf("replaying %s: %w", call, err)
+			return ENOTRECOVERABLE
+		}
+	}
+	return rec.Errno
+}
+
+// replayData copies the recorded bytes to iovecs.
+func replayData(iovecs []IOVec, data []byte) {
+	for _, iov := range iovecs {
+		data = data[copy(iov, data):]
+	}
+}
+
+func (r *replayer) ArgsSizesGet(ctx context.Context) (argCount, stringBytes int, errno Errno) {
+	errno = r.replay("ArgsSizesGet", &argCount, &stringBytes)
+	return
+}
+
+func (r *replayer) ArgsGet(ctx context.Context) (args []string, errno Errno) {
+	errno = r.replay("ArgsGet", &args)
+	return
+}
+
+func (r *replayer) EnvironSizesGet(ctx context.Context) (envCount, stringBytes int, errno Errno) {
+	errno = r.replay("EnvironSizesGet", &envCount, &stringBytes)
+	return
+}
+
+func (r *replayer) EnvironGet(ctx context.Context) (env []string, errno Errno) {
+	errno = r.replay("EnvironGet", &env)
+	return
+}
+
+func (r *replayer) ClockResGet(ctx context.Context, id ClockID) (t Timestamp, errno Errno) {
+	errno = r.replay("ClockResGet", &t)
+	return
+}
+
+func (r *replayer) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (t Timestamp, errno Errno) {
+	errno = r.replay("ClockTimeGet", &t)
+	return
+}
+
+func (r *replayer) FDAdvise(ctx context.Context, fd FD, offset, length FileSize, advice Advice) Errno {
+	return r.replay("FDAdvise")
+}
+
+func (r *replayer) FDAllocate(ctx context.Context, fd FD, offset, length FileSize) Errno {
+	return r.replay("FDAllocate")
+}
+
+func (r *replayer) FDClose(ctx context.Context, fd FD) Errno {
+	return r.replay("FDClose")
+}
+
+func (r *replayer) FDDataSync(ctx context.Context, fd FD) Errno {
+	return r.replay("FDDataSync")
+}
+
+func (r *replayer) FDStatGet(ctx context.Context, fd FD) (stat FDStat, errno Errno) {
+	errno = r.replay("FDStatGet", &stat)
+	return
+}
+
+func (r *replayer) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
+	return r.replay("FDStatSetFlags")
+}
+
+func (r *replayer) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
+	return r.replay("FDStatSetRights")
+}
+
+func (r *replayer) FDFileStatGet(ctx context.Context, fd FD) (stat FileStat, errno Errno) {
+	errno = r.replay("FDFileStatGet", &stat)
+	return
+}
+
+func (r *replayer) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
+	return r.replay("FDFileStatSetSize")
+}
+
+func (r *replayer) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	return r.replay("FDFileStatSetTimes")
+}
+
+func (r *replayer) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (n Size, errno Errno) {
+	var data []byte
+	errno = r.replay("FDPread", &n, &data)
+	replayData(iovecs, data)
+	return
+}
+
+func (r *replayer) FDPreStatGet(ctx context.Context, fd FD) (stat PreStat, errno Errno) {
+	errno = r.replay("FDPreStatGet", &stat)
+	return
+}
+
+func (r *replayer) FDPreStatDirName(ctx context.Context, fd FD) (name string, errno Errno) {
+	errno = r.replay("FDPreStatDirName", &name)
+	return
+}
+
+func (r *replayer) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (n Size, errno Errno) {
+	errno = r.replay("FDPwrite", &n)
+	return
+}
+
+func (r *replayer) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (n Size, errno Errno) {
+	var data []byte
+	errno = r.replay("FDRead", &n, &data)
+	replayData(iovecs, data)
+	return
+}
+
+func (r *replayer) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
+	var recorded []DirEntry
+	errno := r.replay("FDReadDir", &recorded)
+	return copy(entries, recorded), errno
+}
+
+func (r *replayer) FDRenumber(ctx context.Context, from, to FD) Errno {
+	return r.replay("FDRenumber")
+}
+
+func (r *replayer) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (offs FileSize, errno Errno) {
+	errno = r.replay("FDSeek", &offs)
+	return
+}
+
+func (r *replayer) FDSync(ctx context.Context, fd FD) Errno {
+	return r.replay("FDSync")
+}
+
+func (r *replayer) FDTell(ctx context.Context, fd FD) (offs FileSize, errno Errno) {
+	errno = r.replay("FDTell", &offs)
+	return
+}
+
+func (r *replayer) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (n Size, errno Errno) {
+	errno = r.replay("FDWrite", &n)
+	return
+}
+
+func (r *replayer) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	return r.replay("PathCreateDirectory")
+}
+
+func (r *replayer) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (stat FileStat, errno Errno) {
+	errno = r.replay("PathFileStatGet", &stat)
+	return
+}
+
+func (r *replayer) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	return r.replay("PathFileStatSetTimes")
+}
+
+func (r *replayer) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	return r.replay("PathLink")
+}
+
+func (r *replayer) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (newfd FD, errno Errno) {
+	errno = r.replay("PathOpen", &newfd)
+	return
+}
+
+func (r *replayer) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	var data []byte
+	errno := r.replay("PathReadLink", &data)
+	return copy(buffer, data), errno
+}
+
+func (r *replayer) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	return r.replay("PathRemoveDirectory")
+}
+
+func (r *replayer) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	return r.replay("PathRename")
+}
+
+func (r *replayer) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	return r.replay("PathSymlink")
+}
+
+func (r *replayer) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	return r.replay("PathUnlinkFile")
+}
+
+func (r *replayer) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
+	var recorded []Event
+	errno := r.replay("PollOneOff", &recorded)
+	return copy(events, recorded), errno
+}
+
+func (r *replayer) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
+	return r.replay("ProcExit")
+}
+
+func (r *replayer) ProcRaise(ctx context.Context, signal Signal) Errno {
+	return r.replay("ProcRaise")
+}
+
+func (r *replayer) SchedYield(ctx context.Context) Errno {
+	return r.replay("SchedYield")
+}
+
+func (r *replayer) RandomGet(ctx context.Context, b []byte) Errno {
+	var data []byte
+	errno := r.replay("RandomGet", &data)
+	copy(b, data)
+	return errno
+}
+
+func (r *replayer) SockAccept(ctx context.Context, fd FD, flags FDFlags) (newfd FD, peer, addr SocketAddress, errno Errno) {
+	var peerRecord, addrRecord addressRecord
+	errno = r.replay("SockAccept", &newfd, &peerRecord, &addrRecord)
+	return newfd, peerRecord.Addr, addrRecord.Addr, errno
+}
+
+func (r *replayer) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
+	return r.replay("SockShutdown")
+}
+
+func (r *replayer) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (n Size, oflags ROFlags, errno Errno) {
+	var data []byte
+	errno = r.replay("SockRecv", &n, &oflags, &data)
+	replayData(iovecs, data)
+	return
+}
+
+func (r *replayer) SockSend(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags) (n Size, errno Errno) {
+	errno = r.replay("SockSend", &n)
+	return
+}
+
+func (r *replayer) SockOpen(ctx context.Context, family ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (fd FD, errno Errno) {
+	errno = r.replay("SockOpen", &fd)
+	return
+}
+
+func (r *replayer) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
+	var rec addressRecord
+	errno := r.replay("SockBind", &rec)
+	return rec.Addr, errno
+}
+
+func (r *replayer) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
+	var rec addressRecord
+	errno := r.replay("SockConnect", &rec)
+	return rec.Addr, errno
+}
+
+func (r *replayer) SockListen(ctx context.Context, fd FD, backlog int) Errno {
+	return r.replay("SockListen")
+}
+
+func (r *replayer) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, flags SIFlags, addr SocketAddress) (n Size, errno Errno) {
+	errno = r.replay("SockSendTo", &n)
+	return
+}
+
+func (r *replayer) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, flags RIFlags) (n Size, oflags ROFlags, addr SocketAddress, errno Errno) {
+	var rec addressRecord
+	var data []byte
+	errno = r.replay("SockRecvFrom", &n, &oflags, &rec, &data)
+	replayData(iovecs, data)
+	return n, oflags, rec.Addr, errno
+}
+
+func (r *replayer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
+	var rec optionRecord
+	errno := r.replay("SockGetOpt", &rec)
+	return rec.Value, errno
+}
+
+func (r *replayer) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
+	return r.replay("SockSetOpt")
+}
+
+func (r *replayer) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	var rec addressRecord
+	errno := r.replay("SockLocalAddress", &rec)
+	return rec.Addr, errno
+}
+
+func (r *replayer) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
+	var rec addressRecord
+	errno := r.replay("SockRemoteAddress", &rec)
+	return rec.Addr, errno
+}
+
+func (r *replayer) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
+	var recorded []AddressInfo
+	errno := r.replay("SockAddressInfo", &recorded)
+	return copy(results, recorded), errno
+}
+
+func (r *replayer) Close(ctx context.Context) error {
+	return r.err
+}
@@ -0,0 +1,134 @@
+package wasi_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func ExampleReplay() {
+	ctx := context.Background()
+
+	tmp, _ := os.MkdirTemp("", "replay")
+	defer os.RemoveAll(tmp)
+	os.WriteFile(filepath.Join(tmp, "hello.txt"), []byte("Hello, World!"), 0644)
+	dirfd, _ := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+
+	host := &unix.System{
+		Realtime: func(context.Context) (uint64, error) {
+			return uint64(time.Now().UnixNano()), nil
+		},
+		Rand: rand.Reader,
+	}
+	root := host.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsInheriting: wasi.AllRights,
+		RightsBase:       wasi.AllRights,
+	})
+
+	// program reads the clock, random bytes and a file, and returns what it
+	// observed.
+	program := func(s wasi.System) string {
+		now, _ := s.ClockTimeGet(ctx, wasi.Realtime, 1)
+		b := make([]byte, 8)
+		s.RandomGet(ctx, b)
+		fd, _ := s.PathOpen(ctx, root, 0, "hello.txt", 0, wasi.FDReadRight, 0, 0)
+		buf := make([]byte, 32)
+		n, _ := s.FDRead(ctx, fd, []wasi.IOVec{buf})
+		s.FDClose(ctx, fd)
+		return fmt.Sprintf("%d %x %q", now, b, buf[:n])
+	}
+
+	// Record a run of the program on the host...
+	var recording bytes.Buffer
+	recorder := wasi.Record(&recording, host)
+	recorded := program(recorder)
+	recorder.Close(ctx)
+
+	// ...and replay it without touching the host or the file system.
+	replayer := wasi.Replay(&recording)
+	replayed := program(replayer)
+	if err := replayer.Close(ctx); err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Println(recorded == replayed)
+	// Output: true
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+
+	host := &unix.System{
+		Monotonic: func(context.Context) (uint64, error) {
+			return uint64(time.Now().UnixNano()), nil
+		},
+	}
+
+	type results struct {
+		FD     wasi.FD
+		Addr   wasi.SocketAddress
+		Value  wasi.SocketOptionValue
+		Events []wasi.Event
+		Errnos []wasi.Errno
+	}
+	program := func(s wasi.System) (r results) {
+		var errno wasi.Errno
+		var n int
+		r.FD, errno = s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockListenRights, wasi.SockConnectionRights)
+		r.Errnos = append(r.Errnos, errno)
+		_, errno = s.SockBind(ctx, r.FD, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+		r.Errnos = append(r.Errnos, errno)
+		r.Addr, errno = s.SockLocalAddress(ctx, r.FD)
+		r.Errnos = append(r.Errnos, errno)
+		r.Value, errno = s.SockGetOpt(ctx, r.FD, wasi.ReuseAddress)
+		r.Errnos = append(r.Errnos, errno)
+		r.Events = make([]wasi.Event, 1)
+		n, errno = s.PollOneOff(ctx, []wasi.Subscription{
+			wasi.MakeSubscriptionClock(42, wasi.SubscriptionClock{ID: wasi.Monotonic, Timeout: 1000}),
+		}, r.Events)
+		r.Events = r.Events[:n]
+		r.Errnos = append(r.Errnos, errno)
+		r.Errnos = append(r.Errnos, s.FDClose(ctx, r.FD))
+		r.Errnos = append(r.Errnos, s.FDClose(ctx, r.FD))
+		return r
+	}
+
+	var recording bytes.Buffer
+	recorder := wasi.Record(&recording, host)
+	recorded := program(recorder)
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer := wasi.Replay(bytes.NewReader(recording.Bytes()))
+	replayed := program(replayer)
+	if err := replayer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replay diverged from the recording:\nrecorded: %+v\nreplayed: %+v", recorded, replayed)
+	}
+
+	// Calls which do not match the recording fail.
+	replayer = wasi.Replay(bytes.NewReader(recording.Bytes()))
+	if _, errno := replayer.PathOpen(ctx, 3, 0, "file", 0, 0, 0, 0); errno != wasi.ENOTRECOVERABLE {
+		t.Errorf("path_open: want ENOTRECOVERABLE, got %s", errno)
+	}
+	if _, errno := replayer.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, 0, 0); errno != wasi.ENOTRECOVERABLE {
+		t.Errorf("sock_open: want ENOTRECOVERABLE, got %s", errno)
+	}
+	if err := replayer.Close(ctx); err == nil {
+		t.Error("close: the divergence was not reported")
+	}
+}
@@ -1,6 +1,10 @@
 package wasi
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // Rights are file descriptor rights, determining which actions may be performed.
 type Rights uint64
@@ -238,3 +242,50 @@ func (flags Rights) String() (s string) {
 	}
 	return
 }
+
+var rightsSets = map[string]Rights{
+	"AllRights":            AllRights,
+	"ReadRights":           ReadRights,
+	"WriteRights":          WriteRights,
+	"FileRights":           FileRights,
+	"DirectoryRights":      DirectoryRights,
+	"TTYRights":            TTYRights,
+	"SockListenRights":     SockListenRights,
+	"SockConnectionRights": SockConnectionRights,
+}
+
+// ParseRights parses a set of rights from their string representation.
+//
+// The string is a list of rights separated by "|", where each element is
+// either the name of a right (e.g. "FDReadRight"), the name of a set of rights
+// (e.g. "DirectoryRights"), or a numeric value of the form "Rights(N)", so
+// ParseRights is the inverse of Rights.String.
+func ParseRights(s string) (Rights, error) {
+	var rights Rights
+	for _, name := range strings.Split(s, "|") {
+		r, ok := parseRight(strings.TrimSpace(name))
+		if !ok {
+			return 0, fmt.Errorf("invalid rights %q: unknown right %q", s, name)
+		}
+		rights |= r
+	}
+	return rights, nil
+}
+
+func parseRight(name string) (Rights, bool) {
+	if r, ok := rightsSets[name]; ok {
+		return r, true
+	}
+	for i, n := range rightsStrings {
+		if n == name {
+			return 1 << i, true
+		}
+	}
+	if n, ok := strings.CutPrefix(name, "Rights("); ok {
+		if n, ok := strings.CutSuffix(n, ")"); ok {
+			r, err := strconv.ParseUint(n, 0, 64)
+			return Rights(r), err == nil
+		}
+	}
+	return 0, false
+}
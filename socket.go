@@ -318,8 +318,16 @@ func (st SocketType) String() string {
 type SocketOptionLevel int32
 
 const (
-	SocketLevel SocketOptionLevel = 0 // SOL_SOCKET
-	TcpLevel    SocketOptionLevel = 6 // IPPROTO_TCP
+	SocketLevel SocketOptionLevel = 0  // SOL_SOCKET
+	TcpLevel    SocketOptionLevel = 6  // IPPROTO_TCP
+	Ipv6Level   SocketOptionLevel = 41 // IPPROTO_IPV6
+
+	// IpLevel is the IPPROTO_IP level, which cannot have the value zero of
+	// IPPROTO_IP since it is the value of SocketLevel. The level and its
+	// options are specific to wasi-go: they are exposed to the hosts through
+	// the System interface, but are not part of the ABI of the sockets
+	// extensions, which reject them.
+	IpLevel SocketOptionLevel = 0x100
 )
 
 func (sl SocketOptionLevel) String() string {
@@ -328,6 +336,10 @@ func (sl SocketOptionLevel) String() string {
 		return "SocketLevel"
 	case TcpLevel:
 		return "TcpLevel"
+	case Ipv6Level:
+		return "Ipv6Level"
+	case IpLevel:
+		return "IpLevel"
 	default:
 		return fmt.Sprintf("SocketOptionLevel(%d)", sl)
 	}
@@ -368,6 +380,23 @@ const (
 	TcpNoDelay SocketOption = (SocketOption(TcpLevel) << 32) | (15)
 )
 
+// IPPROTO_IP level options. The numbers of IpTypeOfService and
+// Ipv6TrafficClass are specific to wasi-go, see IpLevel.
+const (
+	// IpTypeOfService is the type of service byte of the IPv4 packets sent
+	// by the socket (IP_TOS), which carries the DSCP and ECN bits. The value
+	// is an integer between 0 and 255.
+	IpTypeOfService SocketOption = (SocketOption(IpLevel) << 32) | (16)
+)
+
+// IPPROTO_IPV6 level options
+const (
+	// Ipv6TrafficClass is the traffic class of the IPv6 packets sent by the
+	// socket (IPV6_TCLASS), the IPv6 equivalent of IpTypeOfService. The value
+	// is an integer between 0 and 255.
+	Ipv6TrafficClass SocketOption = (SocketOption(Ipv6Level) << 32) | (17)
+)
+
 func (so SocketOption) String() string {
 	switch so {
 	case ReuseAddress:
@@ -402,6 +431,10 @@ func (so SocketOption) String() string {
 		return "BindToDevice"
 	case TcpNoDelay:
 		return "TcpNoDelay"
+	case IpTypeOfService:
+		return "IpTypeOfService"
+	case Ipv6TrafficClass:
+		return "Ipv6TrafficClass"
 	default:
 		return fmt.Sprintf("SocketOption(%d|%d)", so.Level(), int32(so))
 	}
@@ -492,6 +525,25 @@ func (tv TimeValue) String() string {
 	return time.Duration(tv).String()
 }
 
+// LingerValue is used to represent the value of the Linger socket option.
+type LingerValue struct {
+	// Enabled is true if closing the socket waits for the data not yet sent
+	// to be transmitted.
+	Enabled bool
+	// Timeout is how long closing the socket waits for the data to be sent,
+	// in seconds.
+	Timeout int
+}
+
+func (LingerValue) sockopt() {}
+
+func (l LingerValue) String() string {
+	if !l.Enabled {
+		return "off"
+	}
+	return (time.Duration(l.Timeout) * time.Second).String()
+}
+
 // BytesValue is used to represent an arbitrary socket option value.
 type BytesValue []byte
 
@@ -130,7 +130,9 @@ type System interface {
 	// FDRead reads from a file descriptor.
 	//
 	// On success, it returns the number of bytes read. On failure, it returns
-	// an Errno.
+	// an Errno. If the file descriptor has the NonBlock flag and no data is
+	// available, the method returns EAGAIN instead of blocking; PollOneOff
+	// may then be used to wait until the file descriptor becomes readable.
 	//
 	// Note: This is similar to readv in POSIX.
 	FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno)
@@ -175,6 +177,10 @@ type System interface {
 
 	// FDWrite write to a file descriptor.
 	//
+	// If the file descriptor has the NonBlock flag and the data cannot be
+	// written without blocking, the method returns EAGAIN; PollOneOff may then
+	// be used to wait until the file descriptor becomes writable.
+	//
 	// Note: This is similar to writev in POSIX.
 	//
 	// Like POSIX, any calls of write (and other functions to read or write)
@@ -0,0 +1,84 @@
+package unix
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+)
+
+// maxCopySize bounds the number of bytes transferred by a single call to
+// FDCopy, like the host bounds the size of reads and writes.
+const maxCopySize = 1 << 30
+
+// FDCopy is an extension to WASI preview 1 which copies up to size bytes from
+// the current offset of srcFD to the current offset of dstFD, and returns the
+// number of bytes copied. It is intended to back a host function for guests
+// which move data between file descriptors, like proxies, so the data does
+// not have to be copied to the guest memory and back.
+//
+// The copy behaves like a read from srcFD followed by a write of the data to
+// dstFD: it may complete partially, returning zero means that srcFD reached
+// the end of file, and it fails with EAGAIN if srcFD is non-blocking and has
+// no data available. Copying requires FDReadRight on srcFD and FDWriteRight
+// on dstFD.
+//
+// On Linux, the data is copied within the kernel with copy_file_range(2)
+// between regular files, or sendfile(2) from regular files to other types of
+// file descriptors. Other file descriptors and platforms fall back to copying
+// through a buffer, in which case data read from srcFD may be lost if writing
+// to dstFD fails.
+func (s *System) FDCopy(ctx context.Context, dstFD, srcFD wasi.FD, size wasi.FileSize) (wasi.FileSize, wasi.Errno) {
+	size = min(size, maxCopySize)
+	_, srcDevice := s.devices[srcFD]
+	_, dstDevice := s.devices[dstFD]
+	if srcDevice || dstDevice {
+		return s.copyDevice(ctx, dstFD, srcFD, int(size))
+	}
+	src, _, errno := s.LookupFD(srcFD, wasi.FDReadRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	dst, stat, errno := s.LookupFD(dstFD, wasi.FDWriteRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if size == 0 {
+		return 0, wasi.ESUCCESS
+	}
+	if errno := s.checkWriteLimit(stat.FileType, int64(size)); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	n, err := copyData(ctx, int(dst), int(src), int(size))
+	if n > 0 {
+		s.invalidateFileStats()
+		s.countBytesWritten(stat.FileType, int64(n))
+		return wasi.FileSize(n), s.syncWrite(ctx, dst, dstFD)
+	}
+	return 0, makeErrno(err)
+}
+
+// copyDevice implements FDCopy when either file descriptor is a device, which
+// the host cannot copy from or to, by reading and writing through the System.
+func (s *System) copyDevice(ctx context.Context, dstFD, srcFD wasi.FD, size int) (wasi.FileSize, wasi.Errno) {
+	// The rights of dstFD are checked before consuming data from srcFD.
+	if _, _, errno := s.LookupFD(dstFD, wasi.FDWriteRight); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	buf := make([]byte, min(size, copyBufferSize))
+	r, errno := s.FDRead(ctx, srcFD, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS || r == 0 {
+		return 0, errno
+	}
+	n := 0
+	for n < int(r) {
+		w, errno := s.FDWrite(ctx, dstFD, []wasi.IOVec{buf[n:r]})
+		if errno != wasi.ESUCCESS || w == 0 {
+			if n > 0 {
+				errno = wasi.ESUCCESS
+			}
+			return wasi.FileSize(n), errno
+		}
+		n += int(w)
+	}
+	return wasi.FileSize(n), wasi.ESUCCESS
+}
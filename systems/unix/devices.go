@@ -0,0 +1,562 @@
+package unix
+
+import (
+	"context"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/stealthrocket/wasi-go"
+	"golang.org/x/sys/unix"
+)
+
+// devices is the set of virtual devices that System exposes when the
+// VirtualDevices option is enabled, indexed by absolute path.
+//
+// Virtual devices are implemented in memory and do not require the host to
+// have an equivalent file. They are registered in the file table with an
+// invalid host file descriptor so they get a guest file descriptor number, and
+// the System methods dispatch operations on those numbers to the device.
+var devices = map[string]func(*System) device{
+	"/dev/null":          newNull,
+	"/dev/random":        newRandom,
+	"/dev/urandom":       newRandom,
+	"/dev/zero":          newZero,
+	"/proc/self/cmdline": newCmdline,
+}
+
+type device interface {
+	// Returns information about the device.
+	stat() wasi.FileStat
+	// Rights that the device supports, the rights requested when opening
+	// the device are masked with these.
+	rights() wasi.Rights
+	// Reads from, or writes to the device at the given offset.
+	readAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno)
+	writeAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno)
+}
+
+type deviceFile struct {
+	device
+	offset int64
+	flags  wasi.FDFlags
+}
+
+func (d *deviceFile) read(ctx context.Context, iovecs []wasi.IOVec, offset int64) (int, wasi.Errno) {
+	n := 0
+	for _, iov := range iovecs {
+		rn, errno := d.readAt(ctx, iov, offset+int64(n))
+		n += rn
+		if errno != wasi.ESUCCESS {
+			return n, errno
+		}
+		if rn < len(iov) {
+			break
+		}
+	}
+	return n, wasi.ESUCCESS
+}
+
+func (d *deviceFile) write(ctx context.Context, iovecs []wasi.IOVec, offset int64) (int, wasi.Errno) {
+	n := 0
+	for _, iov := range iovecs {
+		wn, errno := d.writeAt(ctx, iov, offset+int64(n))
+		n += wn
+		if errno != wasi.ESUCCESS {
+			return n, errno
+		}
+	}
+	return n, wasi.ESUCCESS
+}
+
+// charDeviceRights are the rights of the emulated character devices.
+const charDeviceRights = wasi.FDReadRight | wasi.FDWriteRight | wasi.FDSeekRight | wasi.FDTellRight |
+	wasi.FDFileStatGetRight | wasi.PollFDReadWriteRight | wasi.FDAdviseRight | wasi.FDDataSyncRight |
+	wasi.FDSyncRight | wasi.FDStatSetFlagsRight
+
+func charDeviceStat() wasi.FileStat {
+	return wasi.FileStat{FileType: wasi.CharacterDeviceType, NLink: 1}
+}
+
+// null discards writes and is always at end of file, like /dev/null.
+type null struct{}
+
+func newNull(*System) device { return null{} }
+
+func (null) stat() wasi.FileStat { return charDeviceStat() }
+
+func (null) rights() wasi.Rights { return charDeviceRights }
+
+func (null) readAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	return 0, wasi.ESUCCESS
+}
+
+func (null) writeAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	return len(b), wasi.ESUCCESS
+}
+
+// zero discards writes and reads as an infinite sequence of zero bytes, like
+// /dev/zero.
+type zero struct{}
+
+func newZero(*System) device { return zero{} }
+
+func (zero) stat() wasi.FileStat { return charDeviceStat() }
+
+func (zero) rights() wasi.Rights { return charDeviceRights }
+
+func (zero) readAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	clear(b)
+	return len(b), wasi.ESUCCESS
+}
+
+func (zero) writeAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	return len(b), wasi.ESUCCESS
+}
+
+// random reads from the source of RandomGet, like /dev/random and
+// /dev/urandom. Writes are discarded.
+type random struct{ rand io.Reader }
+
+func newRandom(s *System) device { return random{s.Rand} }
+
+func (random) stat() wasi.FileStat { return charDeviceStat() }
+
+func (random) rights() wasi.Rights { return charDeviceRights }
+
+func (r random) readAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	if r.rand == nil {
+		return 0, wasi.EIO
+	}
+	n, err := io.ReadFull(r.rand, b)
+	if err != nil {
+		return n, wasi.EIO
+	}
+	return n, wasi.ESUCCESS
+}
+
+func (random) writeAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	return len(b), wasi.ESUCCESS
+}
+
+// memFile is a read-only regular file with its content held in memory.
+type memFile []byte
+
+// newCmdline exposes the program arguments separated by null bytes, like
+// /proc/self/cmdline on Linux.
+func newCmdline(s *System) device {
+	var b []byte
+	for _, arg := range s.args() {
+		b = append(b, arg...)
+		b = append(b, 0)
+	}
+	return memFile(b)
+}
+
+func (f memFile) stat() wasi.FileStat {
+	return wasi.FileStat{
+		FileType: wasi.RegularFileType,
+		NLink:    1,
+		Size:     wasi.FileSize(len(f)),
+	}
+}
+
+func (f memFile) rights() wasi.Rights {
+	return wasi.FDReadRight | wasi.FDSeekRight | wasi.FDTellRight | wasi.FDFileStatGetRight | wasi.PollFDReadWriteRight |
+		wasi.FDAdviseRight | wasi.FDDataSyncRight | wasi.FDSyncRight | wasi.FDStatSetFlagsRight
+}
+
+func (f memFile) readAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	if offset >= int64(len(f)) {
+		return 0, wasi.ESUCCESS
+	}
+	return copy(b, f[offset:]), wasi.ESUCCESS
+}
+
+func (f memFile) writeAt(ctx context.Context, b []byte, offset int64) (int, wasi.Errno) {
+	return 0, wasi.EBADF
+}
+
+// lookupDevicePath returns the constructor of the virtual device that the
+// path resolves to when opened relative to the preopen fd. The files claimed
+// by VirtualFile take precedence over the devices of VirtualDevices.
+func (s *System) lookupDevicePath(ctx context.Context, fd wasi.FD, name string) (func(*System) device, bool) {
+	if !s.VirtualDevices && s.VirtualFile == nil {
+		return nil, false
+	}
+	name, ok := s.virtualPath(ctx, fd, name)
+	if !ok {
+		return nil, false
+	}
+	if s.VirtualFile != nil {
+		if content, ok := s.VirtualFile(name); ok {
+			return func(*System) device { return memFile(content) }, true
+		}
+	}
+	if !s.VirtualDevices {
+		return nil, false
+	}
+	newDevice, ok := devices[name]
+	return newDevice, ok
+}
+
+// virtualPath returns the absolute path in the guest file system of name when
+// resolved relative to the preopen fd. Paths which escape the preopen, and
+// paths relative to file descriptors that are not preopens, are not resolved.
+func (s *System) virtualPath(ctx context.Context, fd wasi.FD, name string) (string, bool) {
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") || strings.HasPrefix(clean, "/") {
+		return "", false
+	}
+	dir, errno := s.FDPreStatDirName(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		return "", false
+	}
+	return path.Join("/", dir, clean), true
+}
+
+// lookupFDLink returns the target of the /proc/self/fd symbolic link that the
+// path resolves to when the ProcSelfFD option is enabled. The boolean is false
+// if the path is not in /proc/self/fd, and the link must be resolved on the
+// host.
+func (s *System) lookupFDLink(ctx context.Context, fd wasi.FD, name string) (string, wasi.Errno, bool) {
+	if !s.ProcSelfFD {
+		return "", wasi.ESUCCESS, false
+	}
+	name, ok := s.virtualPath(ctx, fd, name)
+	if !ok {
+		return "", wasi.ESUCCESS, false
+	}
+	num, ok := strings.CutPrefix(name, "/proc/self/fd/")
+	if !ok {
+		return "", wasi.ESUCCESS, false
+	}
+	if _, _, errno := s.LookupFD(fd, wasi.PathReadLinkRight); errno != wasi.ESUCCESS {
+		return "", errno, true
+	}
+	// Like procfs, only accept the canonical representation of the numbers.
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 || strconv.Itoa(n) != num {
+		return "", wasi.ENOENT, true
+	}
+	target, errno := s.FDPath(wasi.FD(n))
+	if errno != wasi.ESUCCESS || target == "" {
+		return "", wasi.ENOENT, true
+	}
+	return target, wasi.ESUCCESS, true
+}
+
+func (s *System) openDevice(ctx context.Context, fd wasi.FD, newDevice func(*System) device, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (wasi.FD, wasi.Errno) {
+	_, stat, errno := s.LookupFD(fd, wasi.PathOpenRight)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	switch {
+	case openFlags.Has(wasi.OpenCreate | wasi.OpenExclusive):
+		return -1, wasi.EEXIST
+	case openFlags.Has(wasi.OpenDirectory):
+		return -1, wasi.ENOTDIR
+	}
+	if s.MaxOpenFiles > 0 && s.NumOpenFiles() >= s.MaxOpenFiles {
+		return -1, wasi.ENFILE
+	}
+	d := newDevice(s)
+	// Programs request the write right when opening files in write mode,
+	// which must be rejected for read-only devices. Other rights are masked.
+	if !d.rights().Has(wasi.FDWriteRight) {
+		if rightsBase.Has(wasi.FDWriteRight) || openFlags.Has(wasi.OpenTruncate) {
+			return -1, wasi.EACCES
+		}
+	}
+	newfd := s.Register(-1, wasi.FDStat{
+		FileType:   d.stat().FileType,
+		Flags:      fdFlags,
+		RightsBase: rightsBase & stat.RightsInheriting & d.rights(),
+	})
+	if s.devices == nil {
+		s.devices = make(map[wasi.FD]*deviceFile)
+	}
+	s.devices[newfd] = &deviceFile{device: d, flags: fdFlags}
+	return newfd, wasi.ESUCCESS
+}
+
+func (s *System) lookupDevice(fd wasi.FD, rights wasi.Rights) (*deviceFile, wasi.Errno, bool) {
+	d := s.devices[fd]
+	if d == nil {
+		return nil, wasi.ESUCCESS, false
+	}
+	_, _, errno := s.LookupFD(fd, rights)
+	return d, errno, true
+}
+
+// lookupUnsupportedDevice reports the error of operations which modify the
+// size or times of a file if fd is a device: EBADF for read-only devices,
+// like files opened without write access on procfs, and EINVAL for the
+// others, like character devices.
+func (s *System) lookupUnsupportedDevice(fd wasi.FD) (wasi.Errno, bool) {
+	d, errno, ok := s.lookupDevice(fd, 0)
+	if ok && errno == wasi.ESUCCESS {
+		if d.rights().Has(wasi.FDWriteRight) {
+			errno = wasi.EINVAL
+		} else {
+			errno = wasi.EBADF
+		}
+	}
+	return errno, ok
+}
+
+func (s *System) FDClose(ctx context.Context, fd wasi.FD) wasi.Errno {
+	delete(s.filestats, fd)
+	delete(s.shutdowns, fd)
+	delete(s.syncs, fd)
+	delete(s.readOnlyDirs, fd)
+	if _, errno, ok := s.lookupDevice(fd, 0); ok {
+		if errno != wasi.ESUCCESS {
+			return errno
+		}
+		delete(s.devices, fd)
+		// The file table holds an invalid host file descriptor for devices,
+		// closing it always reports EBADF.
+		s.FileTable.FDClose(ctx, fd)
+		return wasi.ESUCCESS
+	}
+	if f, stat, errno := s.LookupFD(fd, 0); errno == wasi.ESUCCESS && stat.FileType == wasi.SocketStreamType {
+		lingerOnClose(int(f))
+	}
+	return s.FileTable.FDClose(ctx, fd)
+}
+
+func (s *System) FDFileStatGet(ctx context.Context, fd wasi.FD) (wasi.FileStat, wasi.Errno) {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDFileStatGetRight); ok {
+		if errno != wasi.ESUCCESS {
+			return wasi.FileStat{}, errno
+		}
+		return d.stat(), wasi.ESUCCESS
+	}
+	if !s.CacheFileStat {
+		return s.FileTable.FDFileStatGet(ctx, fd)
+	}
+	if stat, ok := s.filestats[fd]; ok {
+		_, _, errno := s.LookupFD(fd, wasi.FDFileStatGetRight)
+		return stat, errno
+	}
+	stat, errno := s.FileTable.FDFileStatGet(ctx, fd)
+	if errno == wasi.ESUCCESS {
+		switch stat.FileType {
+		case wasi.RegularFileType, wasi.CharacterDeviceType:
+			if s.filestats == nil {
+				s.filestats = make(map[wasi.FD]wasi.FileStat)
+			}
+			s.filestats[fd] = stat
+		}
+	}
+	return stat, errno
+}
+
+func (s *System) FDPread(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDReadRight|wasi.FDSeekRight); ok {
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		n, errno := d.read(ctx, iovecs, int64(offset))
+		return wasi.Size(n), errno
+	}
+	f, stat, errno := s.LookupFD(fd, wasi.FDReadRight|wasi.FDSeekRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if errno := f.checkSeekable(stat.FileType); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	n, errno := f.preadv(s.makeIovecs(iovecs), offset)
+	s.clearIovecs()
+	return n, errno
+}
+
+func (s *System) FDPwrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDWriteRight|wasi.FDSeekRight); ok {
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		n, errno := d.write(ctx, iovecs, int64(offset))
+		return wasi.Size(n), errno
+	}
+	f, stat, errno := s.LookupFD(fd, wasi.FDWriteRight|wasi.FDSeekRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if errno := f.checkSeekable(stat.FileType); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if errno := s.checkWriteLimit(stat.FileType, iovecsLen(iovecs)); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	n, errno := f.pwritev(s.makeIovecs(iovecs), offset)
+	s.clearIovecs()
+	s.invalidateFileStats()
+	if errno == wasi.ESUCCESS {
+		s.countBytesWritten(stat.FileType, int64(n))
+		errno = s.syncWrite(ctx, f, fd)
+	}
+	return n, errno
+}
+
+func (s *System) FDRead(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDReadRight); ok {
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		n, errno := d.read(ctx, iovecs, d.offset)
+		d.offset += int64(n)
+		return wasi.Size(n), errno
+	}
+	f, stat, errno := s.LookupFD(fd, wasi.FDReadRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if emptyIO(stat.FileType, iovecs) {
+		return 0, wasi.ESUCCESS
+	}
+	n, errno := f.readv(s.makeIovecs(iovecs))
+	s.clearIovecs()
+	return n, errno
+}
+
+// emptyIO reports whether a read or write of iovecs transfers no data, in
+// which case it completes immediately with zero bytes and no syscall, even if
+// the file descriptor is not ready. Zero-length reads and writes succeed
+// without blocking, signaling the end of file, or consuming data from
+// sockets, so guests may use them to probe file descriptors after the rights
+// are checked. Datagram sockets are excluded since empty datagrams are valid
+// messages.
+func emptyIO(fileType wasi.FileType, iovecs []wasi.IOVec) bool {
+	return fileType != wasi.SocketDGramType && iovecsLen(iovecs) == 0
+}
+
+func (s *System) FDWrite(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDWriteRight); ok {
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		n, errno := d.write(ctx, iovecs, d.offset)
+		d.offset += int64(n)
+		return wasi.Size(n), errno
+	}
+	f, stat, errno := s.LookupFD(fd, wasi.FDWriteRight)
+	if errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	if emptyIO(stat.FileType, iovecs) {
+		return 0, wasi.ESUCCESS
+	}
+	if errno := s.checkWriteLimit(stat.FileType, iovecsLen(iovecs)); errno != wasi.ESUCCESS {
+		return 0, errno
+	}
+	iovs := s.makeIovecs(iovecs)
+	n, errno := f.writev(iovs)
+	if s.FullWrites && errno == wasi.ESUCCESS {
+		n = writeFull(ctx, f, iovs, n)
+	}
+	s.clearIovecs()
+	s.invalidateFileStats()
+	if errno == wasi.ESUCCESS {
+		s.countBytesWritten(stat.FileType, int64(n))
+		errno = s.syncWrite(ctx, f, fd)
+	}
+	return n, errno
+}
+
+// writeFull writes the remaining data of iovs after a write of n bytes, until
+// all the data is written or an error occurs. On non-blocking file descriptors
+// it waits for the file descriptor to be writable. The errors are not reported
+// since some data was written; they occur again on the next call.
+func writeFull(ctx context.Context, f FD, iovs []unix.Iovec, n wasi.Size) wasi.Size {
+	for w := n; ; {
+		if iovs = advanceIovecs(iovs, int(w)); len(iovs) == 0 {
+			return n
+		}
+		var errno wasi.Errno
+		w, errno = f.writev(iovs)
+		switch errno {
+		case wasi.ESUCCESS:
+			if w == 0 {
+				return n
+			}
+			n += w
+		case wasi.EAGAIN:
+			if !waitWritable(ctx, int(f)) {
+				return n
+			}
+			w = 0
+		default:
+			return n
+		}
+	}
+}
+
+func (s *System) FDRenumber(ctx context.Context, from, to wasi.FD) wasi.Errno {
+	s.invalidateFileStats()
+	if errno := s.FileTable.FDRenumber(ctx, from, to); errno != wasi.ESUCCESS {
+		return errno
+	}
+	if d := s.devices[from]; d != nil {
+		delete(s.devices, from)
+		s.devices[to] = d
+	} else {
+		delete(s.devices, to)
+	}
+	if sd, ok := s.shutdowns[from]; ok {
+		delete(s.shutdowns, from)
+		s.shutdowns[to] = sd
+	} else {
+		delete(s.shutdowns, to)
+	}
+	if sync, ok := s.syncs[from]; ok {
+		delete(s.syncs, from)
+		s.syncs[to] = sync
+	} else {
+		delete(s.syncs, to)
+	}
+	if _, ok := s.readOnlyDirs[from]; ok {
+		delete(s.readOnlyDirs, from)
+		s.readOnlyDirs[to] = struct{}{}
+	} else {
+		delete(s.readOnlyDirs, to)
+	}
+	return wasi.ESUCCESS
+}
+
+func (s *System) FDSeek(ctx context.Context, fd wasi.FD, delta wasi.FileDelta, whence wasi.Whence) (wasi.FileSize, wasi.Errno) {
+	if d, _, ok := s.lookupDevice(fd, 0); ok {
+		// Same rights semantic as wasi.FileTable.FDSeek: FDTellRight only
+		// allows calls which do not alter the file offset.
+		_, _, errno := s.LookupFD(fd, wasi.FDSeekRight)
+		if errno == wasi.ENOTCAPABLE && delta == 0 && whence == wasi.SeekCurrent {
+			_, _, errno = s.LookupFD(fd, wasi.FDTellRight)
+		}
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		offset := int64(delta)
+		switch whence {
+		case wasi.SeekStart:
+		case wasi.SeekCurrent:
+			offset += d.offset
+		case wasi.SeekEnd:
+			offset += int64(d.stat().Size)
+		default:
+			return 0, wasi.EINVAL
+		}
+		if offset < 0 {
+			return 0, wasi.EINVAL
+		}
+		d.offset = offset
+		return wasi.FileSize(offset), wasi.ESUCCESS
+	}
+	return s.FileTable.FDSeek(ctx, fd, delta, whence)
+}
+
+func (s *System) FDTell(ctx context.Context, fd wasi.FD) (wasi.FileSize, wasi.Errno) {
+	return s.FDSeek(ctx, fd, 0, wasi.SeekCurrent)
+}
@@ -2,6 +2,7 @@ package unix
 
 import (
 	"context"
+	"math"
 
 	"github.com/stealthrocket/wasi-go"
 	"golang.org/x/sys/unix"
@@ -9,29 +10,62 @@ import (
 
 type FD int
 
+// File sizes and offsets are unsigned in WASI but signed on the host. The
+// values which do not fit in an int64 are rejected instead of being passed to
+// the host as negative offsets.
+func validFileRange(offset, length wasi.FileSize) bool {
+	return offset <= math.MaxInt64 && length <= math.MaxInt64-offset
+}
+
+// Paths which do not fit in PATH_MAX bytes, with the null byte terminating
+// them, are rejected with ENAMETOOLONG before making syscalls so the error is
+// reported consistently, whether or not the host would have to resolve the
+// whole path to detect it.
+func validPathLength(path string) bool {
+	return len(path) < unix.PathMax
+}
+
 func (fd FD) FDAdvise(ctx context.Context, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	if !validFileRange(offset, length) {
+		return wasi.EINVAL
+	}
 	err := ignoreEINTR(func() error { return fdadvise(int(fd), int64(offset), int64(length), advice) })
 	return makeErrno(err)
 }
 
 func (fd FD) FDAllocate(ctx context.Context, offset, length wasi.FileSize) wasi.Errno {
+	if !validFileRange(offset, length) {
+		return wasi.EFBIG
+	}
 	err := ignoreEINTR(func() error { return fallocate(int(fd), int64(offset), int64(length)) })
 	return makeErrno(err)
 }
 
 func (fd FD) FDClose(ctx context.Context) wasi.Errno {
-	// It's unclear what to do for EINTR on Linux, so do nothing and assume the
-	// file descriptor has been closed.
+	// Linux releases the file descriptor even when close(2) is interrupted,
+	// and POSIX leaves its state unspecified. The call must not be retried on
+	// EINTR: the descriptor number may already have been reused by another
+	// thread, and closing it again would close an unrelated file. The error
+	// is dropped instead since the guest can do nothing about it.
 	//
 	// See:
 	// - https://man7.org/linux/man-pages/man2/close.2.html
 	// - https://lwn.net/Articles/576478/
 	err := closeTraceEBADF(int(fd))
+	if err == unix.EINTR {
+		err = nil
+	}
 	return makeErrno(err)
 }
 
+// FDDataSync synchronizes the data of the file with fdatasync(2). On darwin,
+// where fdatasync is not part of the public API, it may fall back to FDSync.
 func (fd FD) FDDataSync(ctx context.Context) wasi.Errno {
-	err := ignoreEINTR(func() error { return fdatasync(int(fd)) })
+	return fd.dataSync(true)
+}
+
+func (fd FD) dataSync(full bool) wasi.Errno {
+	err := ignoreEINTR(func() error { return fdatasync(int(fd), full) })
 	return makeErrno(err)
 }
 
@@ -52,12 +86,47 @@ func (fd FD) FDStatSetFlags(ctx context.Context, flags wasi.FDFlags) wasi.Errno
 	} else {
 		fl &^= unix.O_NONBLOCK
 	}
+	// The synchronization flags are only changed by F_SETFL on some
+	// platforms (e.g. O_SYNC on darwin), and silently ignored on others like
+	// Linux; System emulates the flags which were not applied.
+	if flags.Has(wasi.DSync) {
+		fl |= unix.O_DSYNC
+	} else {
+		fl &^= unix.O_DSYNC
+	}
+	if flags.Has(wasi.Sync) || flags.Has(wasi.RSync) {
+		fl |= unix.O_SYNC
+	} else {
+		fl &^= unix.O_SYNC
+	}
 	_, err = ignoreEINTR2(func() (int, error) {
 		return unix.FcntlInt(uintptr(fd), unix.F_SETFL, fl)
 	})
 	return makeErrno(err)
 }
 
+// syncFlags returns the synchronization flags applied by the host to the
+// file descriptor.
+func (fd FD) syncFlags() (wasi.FDFlags, wasi.Errno) {
+	fl, err := ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	})
+	if err != nil {
+		return 0, makeErrno(err)
+	}
+	var flags wasi.FDFlags
+	if (fl & unix.O_DSYNC) == unix.O_DSYNC {
+		flags |= wasi.DSync
+	}
+	if (fl & unix.O_SYNC) == unix.O_SYNC {
+		flags |= wasi.Sync | wasi.DSync
+	}
+	if __O_RSYNC != 0 && (fl&__O_RSYNC) == __O_RSYNC {
+		flags |= wasi.RSync
+	}
+	return flags, wasi.ESUCCESS
+}
+
 func (fd FD) FDFileStatGet(ctx context.Context) (wasi.FileStat, wasi.Errno) {
 	var sysStat unix.Stat_t
 	if err := ignoreEINTR(func() error { return unix.Fstat(int(fd), &sysStat) }); err != nil {
@@ -68,6 +137,9 @@ func (fd FD) FDFileStatGet(ctx context.Context) (wasi.FileStat, wasi.Errno) {
 }
 
 func (fd FD) FDFileStatSetSize(ctx context.Context, size wasi.FileSize) wasi.Errno {
+	if !validFileRange(size, 0) {
+		return wasi.EFBIG
+	}
 	err := ignoreEINTR(func() error { return unix.Ftruncate(int(fd), int64(size)) })
 	return makeErrno(err)
 }
@@ -96,25 +168,98 @@ func (fd FD) FDFileStatSetTimes(ctx context.Context, accessTime, modifyTime wasi
 }
 
 func (fd FD) FDPread(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return preadv(int(fd), makeIOVecs(iovecs), int64(offset)) })
-	return wasi.Size(n), makeErrno(err)
+	var buf [minIovec]unix.Iovec
+	return fd.preadv(appendIovecs(buf[:0], iovecs), offset)
 }
 
 func (fd FD) FDPwrite(ctx context.Context, iovecs []wasi.IOVec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return pwritev(int(fd), makeIOVecs(iovecs), int64(offset)) })
-	return wasi.Size(n), makeErrno(err)
+	var buf [minIovec]unix.Iovec
+	return fd.pwritev(appendIovecs(buf[:0], iovecs), offset)
 }
 
 func (fd FD) FDRead(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return readv(int(fd), makeIOVecs(iovecs)) })
-	return wasi.Size(n), makeErrno(err)
+	var buf [minIovec]unix.Iovec
+	return fd.readv(appendIovecs(buf[:0], iovecs))
 }
 
 func (fd FD) FDWrite(ctx context.Context, iovecs []wasi.IOVec) (wasi.Size, wasi.Errno) {
-	n, err := handleEINTR(func() (int, error) { return writev(int(fd), makeIOVecs(iovecs)) })
+	var buf [minIovec]unix.Iovec
+	return fd.writev(appendIovecs(buf[:0], iovecs))
+}
+
+func (fd FD) preadv(iovs []unix.Iovec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if !validFileRange(offset, 0) {
+		return 0, wasi.EINVAL
+	}
+	n, err := handleEINTR(func() (int, error) { return preadv(int(fd), iovs, int64(offset)) })
+	return wasi.Size(n), makeErrno(err)
+}
+
+func (fd FD) pwritev(iovs []unix.Iovec, offset wasi.FileSize) (wasi.Size, wasi.Errno) {
+	if !validFileRange(offset, 0) {
+		return 0, wasi.EINVAL
+	}
+	n, err := handleEINTR(func() (int, error) { return pwritev(int(fd), iovs, int64(offset)) })
+	return wasi.Size(n), makeErrno(err)
+}
+
+func (fd FD) readv(iovs []unix.Iovec) (wasi.Size, wasi.Errno) {
+	// Guests commonly read into a single buffer, which does not need the
+	// vectored syscall.
+	if len(iovs) == 1 {
+		b := iovecBytes(iovs[0])
+		n, err := handleEINTR(func() (int, error) { return unix.Read(int(fd), b) })
+		return wasi.Size(n), makeErrno(err)
+	}
+	n, err := handleEINTR(func() (int, error) { return readv(int(fd), iovs) })
+	return wasi.Size(n), makeErrno(err)
+}
+
+// checkSeekable returns ESPIPE if fd, which is of type t, does not support
+// positioned reads and writes. The check is made before calling preadv(2) or
+// pwritev(2) so guests get the same error on all platforms, regardless of how
+// the host reports it.
+func (fd FD) checkSeekable(t wasi.FileType) wasi.Errno {
+	switch t {
+	case wasi.SocketStreamType, wasi.SocketDGramType:
+		return wasi.ESPIPE
+	case wasi.CharacterDeviceType, wasi.UnknownType:
+		// Pipes and terminals are reported as character devices or files of
+		// unknown type, lseek(2) fails with ESPIPE on them.
+		_, err := ignoreEINTR2(func() (int64, error) {
+			return lseek(int(fd), 0, unix.SEEK_CUR)
+		})
+		if err == unix.ESPIPE {
+			return wasi.ESPIPE
+		}
+	}
+	return wasi.ESUCCESS
+}
+
+func (fd FD) writev(iovs []unix.Iovec) (wasi.Size, wasi.Errno) {
+	if len(iovs) == 1 {
+		b := iovecBytes(iovs[0])
+		n, err := handleEINTR(func() (int, error) { return unix.Write(int(fd), b) })
+		return wasi.Size(n), makeErrno(err)
+	}
+	n, err := handleEINTR(func() (int, error) { return writev(int(fd), iovs) })
 	return wasi.Size(n), makeErrno(err)
 }
 
+// FDOpenDir returns a directory reader for fd.
+//
+// Directory entries are read from the host in batches. A read from cookie
+// zero always restarts from the beginning of the directory and observes all
+// the changes made before the call.
+//
+// On Linux, the cookies are the positions of the entries in the directory
+// stream reported by the host (d_off), which reads from other cookies seek
+// to. The cookies remain valid when the directory is modified and across
+// file descriptors, so a guest can persist a cookie and resume reading after
+// a restart; entries added or removed concurrently may or may not be
+// reported. On other platforms, the cookies are the indexes of the entries in
+// the directory stream, and the cookies of the entries following those that
+// were added or removed may shift.
 func (fd FD) FDOpenDir(ctx context.Context) (wasi.Dir, wasi.Errno) {
 	if _, err := ignoreEINTR2(func() (int64, error) {
 		return lseek(int(fd), 0, 0)
@@ -124,8 +269,17 @@ func (fd FD) FDOpenDir(ctx context.Context) (wasi.Dir, wasi.Errno) {
 	return &dirbuf{fd: int(fd)}, wasi.ESUCCESS
 }
 
+// FDSync synchronizes the data and metadata of the file with fsync(2). On
+// darwin, fcntl(F_FULLFSYNC) is used instead so the data is written to
+// permanent storage rather than to the volatile cache of the drive, unless the
+// file system does not support it.
 func (fd FD) FDSync(ctx context.Context) wasi.Errno {
-	err := ignoreEINTR(func() error { return fsync(int(fd)) })
+	return fd.sync(true)
+}
+
+// sync is like FDSync, but only uses F_FULLFSYNC on darwin if full is true.
+func (fd FD) sync(full bool) wasi.Errno {
+	err := ignoreEINTR(func() error { return fsync(int(fd), full) })
 	return makeErrno(err)
 }
 
@@ -146,11 +300,24 @@ func (fd FD) FDSeek(ctx context.Context, delta wasi.FileDelta, whence wasi.Whenc
 }
 
 func (fd FD) PathCreateDirectory(ctx context.Context, path string) wasi.Errno {
-	err := ignoreEINTR(func() error { return unix.Mkdirat(int(fd), path, 0755) })
+	return fd.createDirectory(path, 0)
+}
+
+// createDirectory is like PathCreateDirectory, but removes the permission bits
+// of umask from the mode of the directory.
+func (fd FD) createDirectory(path string, umask uint32) wasi.Errno {
+	if !validPathLength(path) {
+		return wasi.ENAMETOOLONG
+	}
+	mode := 0755 &^ umask
+	err := ignoreEINTR(func() error { return unix.Mkdirat(int(fd), path, mode) })
 	return makeErrno(err)
 }
 
 func (fd FD) PathFileStatGet(ctx context.Context, flags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+	if !validPathLength(path) {
+		return wasi.FileStat{}, wasi.ENAMETOOLONG
+	}
 	var sysStat unix.Stat_t
 	var sysFlags int
 	if !flags.Has(wasi.SymlinkFollow) {
@@ -161,6 +328,9 @@ func (fd FD) PathFileStatGet(ctx context.Context, flags wasi.LookupFlags, path s
 }
 
 func (fd FD) PathFileStatSetTimes(ctx context.Context, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, fstFlags wasi.FSTFlags) wasi.Errno {
+	if !validPathLength(path) {
+		return wasi.ENAMETOOLONG
+	}
 	var sysFlags int
 	if !lookupFlags.Has(wasi.SymlinkFollow) {
 		sysFlags |= unix.AT_SYMLINK_NOFOLLOW
@@ -188,6 +358,9 @@ func (fd FD) PathFileStatSetTimes(ctx context.Context, lookupFlags wasi.LookupFl
 }
 
 func (fd FD) PathLink(ctx context.Context, flags wasi.LookupFlags, oldPath string, newDir FD, newPath string) wasi.Errno {
+	if !validPathLength(oldPath) || !validPathLength(newPath) {
+		return wasi.ENAMETOOLONG
+	}
 	var sysFlags int
 	if flags.Has(wasi.SymlinkFollow) {
 		sysFlags |= unix.AT_SYMLINK_FOLLOW
@@ -196,7 +369,27 @@ func (fd FD) PathLink(ctx context.Context, flags wasi.LookupFlags, oldPath strin
 	return makeErrno(err)
 }
 
+// pathOnlyRights are the directory rights which remain usable on a file
+// descriptor opened with O_PATH: the descriptor can be the base of path
+// operations and be passed to fstat, but its entries cannot be listed nor its
+// metadata changed.
+const pathOnlyRights = wasi.DirectoryRights &^ (wasi.FDReadDirRight |
+	wasi.FDSyncRight |
+	wasi.FDDataSyncRight |
+	wasi.FDStatSetFlagsRight |
+	wasi.FDFileStatSetSizeRight |
+	wasi.FDFileStatSetTimesRight)
+
 func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (FD, wasi.Errno) {
+	return fd.pathOpen(lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags, 0)
+}
+
+// pathOpen is like PathOpen, but removes the permission bits of umask from the
+// mode of the files that it creates.
+func (fd FD) pathOpen(lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags, umask uint32) (FD, wasi.Errno) {
+	if !validPathLength(path) {
+		return -1, wasi.ENAMETOOLONG
+	}
 	oflags := unix.O_CLOEXEC
 	if openFlags.Has(wasi.OpenDirectory) {
 		oflags |= unix.O_DIRECTORY
@@ -206,6 +399,10 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_CREAT
 	}
 	if openFlags.Has(wasi.OpenExclusive) {
+		// With O_CREAT, O_EXCL also prevents following a symbolic link at
+		// the last component of the path, even if the flags did not have
+		// O_NOFOLLOW, so the open fails with EEXIST instead of creating the
+		// target of a dangling link.
 		oflags |= unix.O_EXCL
 	}
 	if openFlags.Has(wasi.OpenTruncate) {
@@ -221,9 +418,12 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_SYNC
 	}
 	if fdFlags.Has(wasi.RSync) {
-		// O_RSYNC is not widely supported, and in many cases is an
-		// alias for O_SYNC.
-		oflags |= unix.O_SYNC
+		// O_RSYNC is not supported on all platforms, the file is not opened
+		// rather than silently providing weaker guarantees than requested.
+		if __O_RSYNC == 0 {
+			return -1, wasi.ENOTSUP
+		}
+		oflags |= __O_RSYNC
 	}
 	if fdFlags.Has(wasi.NonBlock) {
 		oflags |= unix.O_NONBLOCK
@@ -232,6 +432,12 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_NOFOLLOW
 	}
 	switch {
+	case openFlags.Has(wasi.OpenDirectory) && (rightsBase&^pathOnlyRights) == 0 && __O_PATH != 0:
+		// The guest only asked for rights to use the directory as the base
+		// of path operations or to stat it, which do not require access to
+		// its content. Opening it with O_PATH allows traversing directories
+		// that the host process has no permission to read.
+		oflags |= __O_PATH
 	case openFlags.Has(wasi.OpenDirectory):
 		oflags |= unix.O_RDONLY
 	case rightsBase.Has(wasi.FDReadRight) && rightsBase.Has(wasi.FDWriteRight):
@@ -244,7 +450,7 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 		oflags |= unix.O_RDONLY
 	}
 
-	mode := uint32(0644)
+	mode := 0644 &^ umask
 	if (oflags & unix.O_DIRECTORY) != 0 {
 		mode = 0
 	}
@@ -254,7 +460,17 @@ func (fd FD) PathOpen(ctx context.Context, lookupFlags wasi.LookupFlags, path st
 	return FD(hostfd), makeErrno(err)
 }
 
+// openTemp creates an anonymous file in the directory at path, relative to fd,
+// removing the permission bits of umask from its mode. See System.PathOpenTemp.
+func (fd FD) openTemp(path string, umask uint32) (FD, wasi.Errno) {
+	newfd, err := openTemp(int(fd), path, 0600&^umask)
+	return FD(newfd), makeErrno(err)
+}
+
 func (fd FD) PathReadLink(ctx context.Context, path string, buffer []byte) (int, wasi.Errno) {
+	if !validPathLength(path) {
+		return 0, wasi.ENAMETOOLONG
+	}
 	n, err := ignoreEINTR2(func() (int, error) {
 		return unix.Readlinkat(int(fd), path, buffer)
 	})
@@ -268,21 +484,33 @@ func (fd FD) PathReadLink(ctx context.Context, path string, buffer []byte) (int,
 }
 
 func (fd FD) PathRemoveDirectory(ctx context.Context, path string) wasi.Errno {
+	if !validPathLength(path) {
+		return wasi.ENAMETOOLONG
+	}
 	err := ignoreEINTR(func() error { return unix.Unlinkat(int(fd), path, unix.AT_REMOVEDIR) })
 	return makeErrno(err)
 }
 
 func (fd FD) PathRename(ctx context.Context, oldPath string, newDir FD, newPath string) wasi.Errno {
+	if !validPathLength(oldPath) || !validPathLength(newPath) {
+		return wasi.ENAMETOOLONG
+	}
 	err := ignoreEINTR(func() error { return unix.Renameat(int(fd), oldPath, int(newDir), newPath) })
 	return makeErrno(err)
 }
 
 func (fd FD) PathSymlink(ctx context.Context, oldPath string, newPath string) wasi.Errno {
+	if !validPathLength(oldPath) || !validPathLength(newPath) {
+		return wasi.ENAMETOOLONG
+	}
 	err := ignoreEINTR(func() error { return unix.Symlinkat(oldPath, int(fd), newPath) })
 	return makeErrno(err)
 }
 
 func (fd FD) PathUnlinkFile(ctx context.Context, path string) wasi.Errno {
+	if !validPathLength(path) {
+		return wasi.ENAMETOOLONG
+	}
 	err := ignoreEINTR(func() error { return unix.Unlinkat(int(fd), path, 0) })
 	return makeErrno(err)
 }
@@ -33,6 +33,9 @@ func (p *PathOpenSockets) PathOpen(ctx context.Context, fd wasi.FD, lookupFlags
 	if !ok || fd >= 0 {
 		return p.System.PathOpen(ctx, fd, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
 	}
+	if errno := p.CheckMaxSockets(); errno != wasi.ESUCCESS {
+		return -1, errno
+	}
 	var sockfd int
 	var err error
 	switch op {
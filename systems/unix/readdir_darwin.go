@@ -34,7 +34,10 @@ func (d *dirbuf) readDirEntries(entries []wasi.DirEntry, cookie wasi.DirCookie,
 		d.buffer = new([bufferSize]byte)
 	}
 
-	if cookie < d.cookie {
+	// Reading from cookie zero starts a new pass over the directory, the
+	// buffered entries are discarded so changes made to the directory since
+	// the previous pass are observed.
+	if cookie < d.cookie || (cookie == 0 && d.length != 0) {
 		if _, err := ignoreEINTR2(func() (int64, error) {
 			return syscall.Seek(d.fd, 0, 0)
 		}); err != nil {
@@ -107,6 +110,9 @@ func (d *dirbuf) readDirEntries(entries []wasi.DirEntry, cookie wasi.DirCookie,
 			i := d.offset + sizeOfDirent
 			j := d.offset + sizeOfDirent + int(dirent.namlen)
 			dirEntry.Name = d.buffer[i:j:j]
+			if dirent.typ == syscall.DT_UNKNOWN {
+				dirEntry.Type = lstatFileType(d.fd, dirEntry.Name)
+			}
 
 			entries[numEntries] = dirEntry
 			numEntries++
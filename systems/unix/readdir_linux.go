@@ -25,6 +25,8 @@ type dirbuf struct {
 	offset int
 	length int
 	fd     int
+	// cookie is the position in the directory stream of the next buffered
+	// entry.
 	cookie wasi.DirCookie
 }
 
@@ -33,15 +35,21 @@ func (d *dirbuf) readDirEntries(entries []wasi.DirEntry, cookie wasi.DirCookie,
 		d.buffer = new([bufferSize]byte)
 	}
 
-	if cookie < d.cookie {
+	// The cookies are the d_off positions of the entries in the directory
+	// stream, so reading from any cookie other than the position of the
+	// buffered entries is done by seeking the directory to the cookie.
+	// Reading from cookie zero starts a new pass over the directory, the
+	// buffered entries are discarded so changes made to the directory since
+	// the previous pass are observed.
+	if cookie != d.cookie || (cookie == 0 && d.length != 0) {
 		if _, err := ignoreEINTR2(func() (int64, error) {
-			return unix.Seek(d.fd, 0, unix.SEEK_SET)
+			return unix.Seek(d.fd, int64(cookie), unix.SEEK_SET)
 		}); err != nil {
 			return 0, err
 		}
 		d.offset = 0
 		d.length = 0
-		d.cookie = 0
+		d.cookie = cookie
 	}
 
 	numEntries := 0
@@ -76,53 +84,55 @@ func (d *dirbuf) readDirEntries(entries []wasi.DirEntry, cookie wasi.DirCookie,
 
 		if dirent.ino == 0 {
 			d.offset += int(dirent.reclen)
+			d.cookie = wasi.DirCookie(dirent.off)
 			continue
 		}
 
-		if d.cookie >= cookie {
-			dirEntry := wasi.DirEntry{
-				Next:  d.cookie + 1,
-				INode: wasi.INode(dirent.ino),
-			}
-
-			switch dirent.typ {
-			case unix.DT_BLK:
-				dirEntry.Type = wasi.BlockDeviceType
-			case unix.DT_CHR:
-				dirEntry.Type = wasi.CharacterDeviceType
-			case unix.DT_DIR:
-				dirEntry.Type = wasi.DirectoryType
-			case unix.DT_LNK:
-				dirEntry.Type = wasi.SymbolicLinkType
-			case unix.DT_REG:
-				dirEntry.Type = wasi.RegularFileType
-			case unix.DT_SOCK:
-				dirEntry.Type = wasi.SocketStreamType
-			default: // DT_FIFO, DT_UNKNOWN
-				dirEntry.Type = wasi.UnknownType
-			}
+		dirEntry := wasi.DirEntry{
+			Next:  wasi.DirCookie(dirent.off),
+			INode: wasi.INode(dirent.ino),
+		}
 
-			i := d.offset + sizeOfDirent
-			j := d.offset + int(dirent.reclen)
-			dirEntry.Name = d.buffer[i:j:j]
+		switch dirent.typ {
+		case unix.DT_BLK:
+			dirEntry.Type = wasi.BlockDeviceType
+		case unix.DT_CHR:
+			dirEntry.Type = wasi.CharacterDeviceType
+		case unix.DT_DIR:
+			dirEntry.Type = wasi.DirectoryType
+		case unix.DT_LNK:
+			dirEntry.Type = wasi.SymbolicLinkType
+		case unix.DT_REG:
+			dirEntry.Type = wasi.RegularFileType
+		case unix.DT_SOCK:
+			dirEntry.Type = wasi.SocketStreamType
+		default: // DT_FIFO, DT_UNKNOWN
+			dirEntry.Type = wasi.UnknownType
+		}
 
-			n := bytes.IndexByte(dirEntry.Name, 0)
-			if n >= 0 {
-				dirEntry.Name = dirEntry.Name[:n:n]
-			}
+		i := d.offset + sizeOfDirent
+		j := d.offset + int(dirent.reclen)
+		dirEntry.Name = d.buffer[i:j:j]
 
-			entries[numEntries] = dirEntry
-			numEntries++
+		n := bytes.IndexByte(dirEntry.Name, 0)
+		if n >= 0 {
+			dirEntry.Name = dirEntry.Name[:n:n]
+		}
+		if dirent.typ == unix.DT_UNKNOWN {
+			dirEntry.Type = lstatFileType(d.fd, dirEntry.Name)
+		}
 
-			bufferSizeBytes -= wasi.SizeOfDirent
-			bufferSizeBytes -= len(dirEntry.Name)
+		entries[numEntries] = dirEntry
+		numEntries++
 
-			if bufferSizeBytes <= 0 {
-				return numEntries, nil
-			}
-		}
+		bufferSizeBytes -= wasi.SizeOfDirent
+		bufferSizeBytes -= len(dirEntry.Name)
 
 		d.offset += int(dirent.reclen)
-		d.cookie += 1
+		d.cookie = dirEntry.Next
+
+		if bufferSizeBytes <= 0 {
+			return numEntries, nil
+		}
 	}
 }
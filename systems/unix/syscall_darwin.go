@@ -1,13 +1,30 @@
 package unix
 
 import (
+	"context"
+	"math"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
 	"golang.org/x/sys/unix"
 )
 
+// pollPrecision is the granularity of the timeout passed to poll.
+const pollPrecision = time.Millisecond
+
+// poll waits for events on the file descriptors, or until the timeout expires
+// if it is not negative. There is no ppoll(2) on darwin, the timeout is
+// truncated to milliseconds, and capped to the maximum timeout of poll(2).
+func poll(fds []unix.PollFd, timeout time.Duration) (int, error) {
+	timeoutMillis := -1
+	if timeout >= 0 {
+		timeoutMillis = int(min(timeout.Milliseconds(), math.MaxInt32))
+	}
+	return unix.Poll(fds, timeoutMillis)
+}
+
 func accept(socket, flags int) (int, unix.Sockaddr, error) {
 	conn, addr, err := acceptCloseOnExec(socket)
 	if err != nil {
@@ -22,6 +39,18 @@ func accept(socket, flags int) (int, unix.Sockaddr, error) {
 	return conn, addr, nil
 }
 
+func socket(domain, typ, proto int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+
+	fd, err := unix.Socket(domain, typ, proto)
+	if err != nil {
+		return -1, err
+	}
+	unix.CloseOnExec(fd)
+	return fd, nil
+}
+
 func acceptCloseOnExec(socket int) (int, unix.Sockaddr, error) {
 	syscall.ForkLock.Lock()
 	defer syscall.ForkLock.Unlock()
@@ -76,6 +105,17 @@ const (
 	__UTIME_OMIT = -2
 )
 
+// Darwin has no O_RSYNC flag.
+const __O_RSYNC = 0
+
+// Darwin has no O_PATH flag, directories are always opened for reading.
+const __O_PATH = 0
+
+// Darwin has no SIGPWR signal.
+const __SIGPWR = 0
+
+const __ENOATTR = unix.ENOATTR
+
 func prepareTimesAndAttrs(ts *[2]unix.Timespec) (attrs, size int, times [2]unix.Timespec) {
 	const sizeOfTimespec = int(unsafe.Sizeof(times[0]))
 	i := 0
@@ -92,6 +132,12 @@ func prepareTimesAndAttrs(ts *[2]unix.Timespec) (attrs, size int, times [2]unix.
 	return attrs, i * sizeOfTimespec, times
 }
 
+// copyData copies up to size bytes from src to dst through a buffer, the
+// sendfile(2) of darwin only copies from files to sockets.
+func copyData(ctx context.Context, dst, src, size int) (int, error) {
+	return copyBuffer(ctx, dst, src, size)
+}
+
 func futimens(fd int, ts *[2]unix.Timespec) error {
 	attrs, size, times := prepareTimesAndAttrs(ts)
 	attrlist := unix.Attrlist{
@@ -117,55 +163,131 @@ func fsetattrlist(fd int, attrlist *unix.Attrlist, attrbuf unsafe.Pointer, attrb
 	return nil
 }
 
-const minIovec = 8
-
-func appendBytes(vecs []unix.Iovec, bs [][]byte) []unix.Iovec {
-	for _, b := range bs {
-		vecs = append(vecs, unix.Iovec{
-			Base: unsafe.SliceData(b),
-			Len:  uint64(len(b)),
-		})
-	}
-	return vecs
-}
-
 func fdadvise(fd int, offset, length int64, advice wasi.Advice) error {
-	// Since posix_fadvise is not available, just ignore the hint.
+	// posix_fadvise is not available on darwin, so the advice is translated
+	// to the closest fcntl(2) hints. Errors reported by the hints are ignored
+	// since the advice is not required to have any effect; the call only
+	// fails if the file descriptor is invalid.
+	var err error
+	switch advice {
+	case wasi.Normal, wasi.Sequential:
+		_, err = unix.FcntlInt(uintptr(fd), unix.F_RDAHEAD, 1)
+	case wasi.Random:
+		_, err = unix.FcntlInt(uintptr(fd), unix.F_RDAHEAD, 0)
+	case wasi.WillNeed:
+		count := length
+		if count == 0 || count > math.MaxInt32 {
+			count = math.MaxInt32
+		}
+		ra := unix.Radvisory_t{Offset: offset, Count: int32(count)}
+		_, _, e := unix.Syscall(
+			uintptr(unix.SYS_FCNTL),
+			uintptr(fd),
+			uintptr(unix.F_RDADVISE),
+			uintptr(unsafe.Pointer(&ra)),
+		)
+		if e != 0 {
+			err = e
+		}
+	case wasi.DontNeed, wasi.NoReuse:
+		_, err = unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	default:
+		return wasi.EINVAL
+	}
+	if err == unix.EBADF {
+		return err
+	}
 	return nil
 }
 
 func fallocate(fd int, offset, length int64) error {
+	// There is no fallocate(2) on darwin, the equivalent is to preallocate
+	// the blocks past the end of file with F_PREALLOCATE, then extend the
+	// file size with ftruncate(2), since fd_allocate requires the file size
+	// to grow to offset+length when it is smaller.
 	var sysStat unix.Stat_t
 	if err := unix.Fstat(fd, &sysStat); err != nil {
 		return err
 	}
-	if offset != sysStat.Size {
-		return wasi.ENOSYS
+	if offset < 0 || length < 0 {
+		return unix.EINVAL
 	}
-	err := unix.FcntlFstore(uintptr(fd), unix.F_PREALLOCATE, &unix.Fstore_t{
-		Flags:   unix.F_ALLOCATEALL | unix.F_ALLOCATECONTIG,
+	size := offset + length
+	if size < 0 {
+		return unix.EFBIG
+	}
+	if size <= sysStat.Size {
+		return nil
+	}
+	fstore := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
 		Posmode: unix.F_PEOFPOSMODE,
 		Offset:  0,
-		Length:  length,
-	})
+		Length:  size - sysStat.Size,
+	}
+	err := unix.FcntlFstore(uintptr(fd), unix.F_PREALLOCATE, &fstore)
 	if err != nil {
+		// The space could not be allocated contiguously, retry with blocks
+		// anywhere on the device.
+		fstore.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(uintptr(fd), unix.F_PREALLOCATE, &fstore)
+	}
+	switch err {
+	case nil:
+	case unix.ENOTSUP:
+		// The file system does not support preallocation, the blocks will
+		// be allocated lazily when written.
+	default:
 		return err
 	}
-	return unix.Ftruncate(fd, sysStat.Size+length)
+	return unix.Ftruncate(fd, size)
 }
 
-func fdatasync(fd int) error {
+func fdatasync(fd int, full bool) error {
+	// fdatasync(2) is not part of the public API on darwin; the system call
+	// exists on recent versions but may be missing, in which case the file
+	// is synchronized with fsync, which also flushes the file metadata.
 	_, _, err := unix.Syscall(unix.SYS_FDATASYNC, uintptr(fd), 0, 0)
-	if err != 0 {
-		return err
+	switch err {
+	case 0:
+		return nil
+	case unix.ENOSYS:
+		return fsync(fd, full)
 	}
-	return nil
+	return err
 }
 
-func fsync(fd int) error {
+func fsync(fd int, full bool) error {
+	// fsync(2) on darwin only moves the data to the drive, which may keep it
+	// in a volatile cache; F_FULLFSYNC asks the drive to flush the data to
+	// permanent storage, providing the durability that guests expect from
+	// fd_sync on other platforms.
 	// See https://twitter.com/TigerBeetleDB/status/1422854887113732097
-	_, err := unix.FcntlInt(uintptr(fd), unix.F_FULLFSYNC, 0)
-	return err
+	//
+	// Some file systems (e.g. network or FUSE file systems) do not support
+	// F_FULLFSYNC, the data is then synchronized with fsync.
+	if full {
+		_, err := unix.FcntlInt(uintptr(fd), unix.F_FULLFSYNC, 0)
+		if !fullFsyncUnsupported(err) {
+			return err
+		}
+	}
+	return unix.Fsync(fd)
+}
+
+// fullFsyncUnsupported reports whether err, returned by fcntl(F_FULLFSYNC),
+// means that the file system does not support the operation.
+func fullFsyncUnsupported(err error) bool {
+	switch err {
+	case unix.ENOTSUP, unix.ENOTTY, unix.EINVAL:
+		return true
+	}
+	return false
+}
+
+func openTemp(dirfd int, dir string, mode uint32) (int, error) {
+	// O_TMPFILE is not available on darwin.
+	return openUnlinkedTemp(dirfd, dir, mode)
 }
 
 func lseek(fd int, offset int64, whence int) (int64, error) {
@@ -187,14 +309,12 @@ func lseek(fd int, offset int64, whence int) (int64, error) {
 	return syscall.Seek(fd, offset, whence)
 }
 
-func readv(fd int, iovs [][]byte) (int, error) {
-	iovecs := make([]unix.Iovec, 0, minIovec)
-	iovecs = appendBytes(iovecs, iovs)
+func readv(fd int, iovs []unix.Iovec) (int, error) {
 	n, _, err := unix.Syscall(
 		uintptr(unix.SYS_READV),
 		uintptr(fd),
-		uintptr(unsafe.Pointer(unsafe.SliceData(iovecs))),
-		uintptr(len(iovecs)),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
 	)
 	if err != 0 {
 		return int(n), err
@@ -202,14 +322,12 @@ func readv(fd int, iovs [][]byte) (int, error) {
 	return int(n), nil
 }
 
-func writev(fd int, iovs [][]byte) (int, error) {
-	iovecs := make([]unix.Iovec, 0, minIovec)
-	iovecs = appendBytes(iovecs, iovs)
+func writev(fd int, iovs []unix.Iovec) (int, error) {
 	n, _, err := unix.Syscall(
 		uintptr(unix.SYS_WRITEV),
 		uintptr(fd),
-		uintptr(unsafe.Pointer(unsafe.SliceData(iovecs))),
-		uintptr(len(iovecs)),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
 	)
 	if err != 0 {
 		return int(n), err
@@ -217,10 +335,10 @@ func writev(fd int, iovs [][]byte) (int, error) {
 	return int(n), nil
 }
 
-func preadv(fd int, iovs [][]byte, offset int64) (int, error) {
+func preadv(fd int, iovs []unix.Iovec, offset int64) (int, error) {
 	read := 0
 	for _, iov := range iovs {
-		n, err := unix.Pread(fd, iov, offset)
+		n, err := unix.Pread(fd, unsafe.Slice(iov.Base, iov.Len), offset)
 		offset += int64(n)
 		read += n
 		if err != nil {
@@ -230,10 +348,10 @@ func preadv(fd int, iovs [][]byte, offset int64) (int, error) {
 	return read, nil
 }
 
-func pwritev(fd int, iovs [][]byte, offset int64) (int, error) {
+func pwritev(fd int, iovs []unix.Iovec, offset int64) (int, error) {
 	written := 0
 	for _, iov := range iovs {
-		n, err := unix.Pwrite(fd, iov, offset)
+		n, err := unix.Pwrite(fd, unsafe.Slice(iov.Base, iov.Len), offset)
 		offset += int64(n)
 		written += n
 		if err != nil {
@@ -243,6 +361,17 @@ func pwritev(fd int, iovs [][]byte, offset int64) (int, error) {
 	return written, nil
 }
 
+func setNoAtime(fd int) error {
+	// O_NOATIME does not exist on darwin, the option is ignored.
+	return nil
+}
+
+func isReadOnlyFS(fd int) bool {
+	var stat unix.Statfs_t
+	err := ignoreEINTR(func() error { return unix.Fstatfs(fd, &stat) })
+	return err == nil && (stat.Flags&unix.MNT_RDONLY) != 0
+}
+
 func getsocketdomain(fd int) (int, error) {
 	return 0, unix.ENOSYS
 }
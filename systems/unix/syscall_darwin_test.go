@@ -0,0 +1,37 @@
+package unix
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFullFsyncFallback(t *testing.T) {
+	// The file systems which do not support F_FULLFSYNC report one of these
+	// errors, the data is then synchronized with fsync(2).
+	for _, err := range []error{unix.ENOTSUP, unix.ENOTTY, unix.EINVAL} {
+		if !fullFsyncUnsupported(err) {
+			t.Errorf("%v: F_FULLFSYNC was not reported as unsupported", err)
+		}
+	}
+	for _, err := range []error{nil, unix.EIO, unix.EBADF} {
+		if fullFsyncUnsupported(err) {
+			t.Errorf("%v: F_FULLFSYNC was reported as unsupported", err)
+		}
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, full := range []bool{false, true} {
+		if err := fsync(int(f.Fd()), full); err != nil {
+			t.Errorf("fsync(full=%t): %v", full, err)
+		}
+		if err := fdatasync(int(f.Fd()), full); err != nil {
+			t.Errorf("fdatasync(full=%t): %v", full, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package unix
 
 import (
+	"context"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
@@ -12,6 +14,36 @@ const (
 	__UTIME_OMIT = unix.UTIME_OMIT
 )
 
+// Linux defines O_RSYNC as an alias of O_SYNC, which synchronizes both reads
+// and writes.
+const __O_RSYNC = unix.O_RSYNC
+
+const __O_PATH = unix.O_PATH
+
+const __SIGPWR = unix.SIGPWR
+
+// Linux reports missing extended attributes with ENODATA.
+const __ENOATTR = unix.ENODATA
+
+// pollPrecision is the granularity of the timeout passed to poll.
+const pollPrecision = 0
+
+// poll waits for events on the file descriptors, or until the timeout expires
+// if it is not negative. ppoll(2) is used instead of poll(2) because it takes
+// the timeout as a timespec, which retains sub-millisecond precision.
+func poll(fds []unix.PollFd, timeout time.Duration) (int, error) {
+	var ts *unix.Timespec
+	if timeout >= 0 {
+		t := unix.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+	return unix.Ppoll(fds, ts, nil)
+}
+
+func socket(domain, typ, proto int) (int, error) {
+	return unix.Socket(domain, typ|unix.SOCK_CLOEXEC, proto)
+}
+
 func accept(socket, flags int) (int, unix.Sockaddr, error) {
 	return unix.Accept4(socket, flags|unix.O_CLOEXEC)
 }
@@ -20,6 +52,42 @@ func pipe(fds []int, flags int) error {
 	return unix.Pipe2(fds, flags|unix.O_CLOEXEC)
 }
 
+// copyData copies up to size bytes from src to dst within the kernel when it
+// can: copy_file_range(2) copies between regular files, sharing their blocks on
+// file systems which support it, and sendfile(2) copies from regular files to
+// other types of file descriptors. The other file descriptors are copied
+// through a buffer.
+func copyData(ctx context.Context, dst, src, size int) (int, error) {
+	n, err := handleEINTR(func() (int, error) {
+		return unix.CopyFileRange(src, nil, dst, nil, size, 0)
+	})
+	switch err {
+	case nil:
+		// Some file systems (e.g. procfs) report files as empty, in which
+		// case copy_file_range(2) copies nothing; the end of file is
+		// confirmed by reading src.
+		if n > 0 {
+			return n, nil
+		}
+	case unix.EINVAL, unix.EXDEV, unix.ENOSYS, unix.EOPNOTSUPP, unix.EBADF:
+		// The file descriptors are not both regular files, are on different
+		// file systems on kernels which do not support it, or dst was opened
+		// with O_APPEND.
+	default:
+		return n, err
+	}
+	n, err = handleEINTR(func() (int, error) {
+		return unix.Sendfile(dst, src, nil, size)
+	})
+	switch err {
+	case unix.EINVAL, unix.ENOSYS:
+		// src cannot be mapped in memory (e.g. sockets), or dst was opened
+		// with O_APPEND.
+		return copyBuffer(ctx, dst, src, size)
+	}
+	return n, err
+}
+
 func futimens(fd int, ts *[2]unix.Timespec) error {
 	// https://github.com/bminor/glibc/blob/master/sysdeps/unix/sysv/linux/futimens.c
 	_, _, err := unix.Syscall6(
@@ -38,6 +106,9 @@ func futimens(fd int, ts *[2]unix.Timespec) error {
 }
 
 func fdadvise(fd int, offset, length int64, advice wasi.Advice) error {
+	// Each advice maps to the POSIX_FADV_* constant of the same name. The
+	// values of WASI and Linux match on most architectures but not all of
+	// them (e.g. s390x), so they are always translated.
 	var sysAdvice int
 	switch advice {
 	case wasi.Normal:
@@ -62,32 +133,115 @@ func fallocate(fd int, offset, length int64) error {
 	return unix.Fallocate(fd, 0, offset, length)
 }
 
-func fdatasync(fd int) error {
+func fdatasync(fd int, full bool) error {
 	return unix.Fdatasync(fd)
 }
 
-func fsync(fd int) error {
+func fsync(fd int, full bool) error {
 	return unix.Fsync(fd)
 }
 
+func openTemp(dirfd int, dir string, mode uint32) (int, error) {
+	fd, err := ignoreEINTR2(func() (int, error) {
+		return unix.Openat(dirfd, dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, mode)
+	})
+	switch err {
+	case unix.EOPNOTSUPP, unix.EISDIR:
+		// The file system does not support O_TMPFILE, or the kernel predates
+		// it and attempted to open the directory.
+		return openUnlinkedTemp(dirfd, dir, mode)
+	}
+	return fd, err
+}
+
 func lseek(fd int, offset int64, whence int) (int64, error) {
 	return unix.Seek(fd, offset, whence)
 }
 
-func readv(fd int, iovs [][]byte) (int, error) {
-	return unix.Readv(fd, iovs)
+func readv(fd int, iovs []unix.Iovec) (int, error) {
+	n, _, err := unix.Syscall(
+		uintptr(unix.SYS_READV),
+		uintptr(fd),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
+	)
+	if err != 0 {
+		return int(n), err
+	}
+	return int(n), nil
+}
+
+func writev(fd int, iovs []unix.Iovec) (int, error) {
+	n, _, err := unix.Syscall(
+		uintptr(unix.SYS_WRITEV),
+		uintptr(fd),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
+	)
+	if err != 0 {
+		return int(n), err
+	}
+	return int(n), nil
+}
+
+func preadv(fd int, iovs []unix.Iovec, offset int64) (int, error) {
+	lo, hi := offs2lohi(offset)
+	n, _, err := unix.Syscall6(
+		uintptr(unix.SYS_PREADV),
+		uintptr(fd),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
+		lo,
+		hi,
+		uintptr(0),
+	)
+	if err != 0 {
+		return int(n), err
+	}
+	return int(n), nil
+}
+
+func pwritev(fd int, iovs []unix.Iovec, offset int64) (int, error) {
+	lo, hi := offs2lohi(offset)
+	n, _, err := unix.Syscall6(
+		uintptr(unix.SYS_PWRITEV),
+		uintptr(fd),
+		uintptr(unsafe.Pointer(unsafe.SliceData(iovs))),
+		uintptr(len(iovs)),
+		lo,
+		hi,
+		uintptr(0),
+	)
+	if err != 0 {
+		return int(n), err
+	}
+	return int(n), nil
 }
 
-func writev(fd int, iovs [][]byte) (int, error) {
-	return unix.Writev(fd, iovs)
+// The offset of preadv(2) and pwritev(2) is passed as two long values.
+// See https://github.com/golang/sys/blob/master/unix/syscall_linux.go
+func offs2lohi(offset int64) (lo, hi uintptr) {
+	const longBits = unix.SizeofLong * 8
+	return uintptr(offset), uintptr(uint64(offset) >> (longBits - 1) >> 1)
 }
 
-func preadv(fd int, iovs [][]byte, offset int64) (int, error) {
-	return unix.Preadv(fd, iovs, offset)
+func setNoAtime(fd int) error {
+	fl, err := ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(fd), unix.F_SETFL, fl|unix.O_NOATIME)
+	})
+	return err
 }
 
-func pwritev(fd int, iovs [][]byte, offset int64) (int, error) {
-	return unix.Pwritev(fd, iovs, offset)
+func isReadOnlyFS(fd int) bool {
+	var stat unix.Statfs_t
+	err := ignoreEINTR(func() error { return unix.Fstatfs(fd, &stat) })
+	return err == nil && (stat.Flags&unix.ST_RDONLY) != 0
 }
 
 func getsocketdomain(fd int) (int, error) {
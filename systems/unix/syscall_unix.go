@@ -1,7 +1,12 @@
 package unix
 
 import (
+	"context"
+	"path"
 	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/stealthrocket/wasi-go"
@@ -65,6 +70,8 @@ func makeErrno(err error) wasi.Errno {
 	return wasi.MakeErrno(err)
 }
 
+// makeFileStat converts the stat of a host file. Blksize and Blocks are dropped
+// because wasi.FileStat has no room for them, see its documentation.
 func makeFileStat(s *unix.Stat_t) wasi.FileStat {
 	return wasi.FileStat{
 		FileType:   makeFileType(uint32(s.Mode)),
@@ -78,6 +85,54 @@ func makeFileStat(s *unix.Stat_t) wasi.FileStat {
 	}
 }
 
+// signals maps the WASI signals to the signals of the host. The numbers of
+// WASI follow the Linux numbering up to SIGTERM, but diverge after it, and
+// differ from other platforms like Darwin for some of the preceding signals.
+// Signals mapped to zero have no equivalent on the host.
+var signals = [...]unix.Signal{
+	wasi.SIGNONE:   0,
+	wasi.SIGHUP:    unix.SIGHUP,
+	wasi.SIGINT:    unix.SIGINT,
+	wasi.SIGQUIT:   unix.SIGQUIT,
+	wasi.SIGILL:    unix.SIGILL,
+	wasi.SIGTRAP:   unix.SIGTRAP,
+	wasi.SIGABRT:   unix.SIGABRT,
+	wasi.SIGBUS:    unix.SIGBUS,
+	wasi.SIGFPE:    unix.SIGFPE,
+	wasi.SIGKILL:   unix.SIGKILL,
+	wasi.SIGUSR1:   unix.SIGUSR1,
+	wasi.SIGSEGV:   unix.SIGSEGV,
+	wasi.SIGUSR2:   unix.SIGUSR2,
+	wasi.SIGPIPE:   unix.SIGPIPE,
+	wasi.SIGALRM:   unix.SIGALRM,
+	wasi.SIGTERM:   unix.SIGTERM,
+	wasi.SIGCHLD:   unix.SIGCHLD,
+	wasi.SIGCONT:   unix.SIGCONT,
+	wasi.SIGSTOP:   unix.SIGSTOP,
+	wasi.SIGTSTP:   unix.SIGTSTP,
+	wasi.SIGTTIN:   unix.SIGTTIN,
+	wasi.SIGTTOU:   unix.SIGTTOU,
+	wasi.SIGURG:    unix.SIGURG,
+	wasi.SIGXCPU:   unix.SIGXCPU,
+	wasi.SIGXFSZ:   unix.SIGXFSZ,
+	wasi.SIGVTALRM: unix.SIGVTALRM,
+	wasi.SIGPROF:   unix.SIGPROF,
+	wasi.SIGWINCH:  unix.SIGWINCH,
+	wasi.SIGPOLL:   unix.SIGIO,
+	wasi.SIGPWR:    __SIGPWR,
+	wasi.SIGSYS:    unix.SIGSYS,
+}
+
+// makeSignal returns the host signal of a WASI signal, and false if the host
+// has no equivalent signal.
+func makeSignal(signal wasi.Signal) (unix.Signal, bool) {
+	if int(signal) >= len(signals) {
+		return 0, false
+	}
+	sig := signals[signal]
+	return sig, sig != 0 || signal == wasi.SIGNONE
+}
+
 func makeFileType(mode uint32) wasi.FileType {
 	switch mode & unix.S_IFMT { // see stat(2)
 	case unix.S_IFCHR: // character special
@@ -98,8 +153,219 @@ func makeFileType(mode uint32) wasi.FileType {
 	}
 }
 
+// lstatFileType returns the type of the entry name of the directory dirfd,
+// without following symbolic links. It is used to determine the type of the
+// directory entries on file systems which do not report it (DT_UNKNOWN).
+func lstatFileType(dirfd int, name []byte) wasi.FileType {
+	var sysStat unix.Stat_t
+	err := ignoreEINTR(func() error {
+		return unix.Fstatat(dirfd, string(name), &sysStat, unix.AT_SYMLINK_NOFOLLOW)
+	})
+	if err != nil {
+		return wasi.UnknownType
+	}
+	return makeFileType(uint32(sysStat.Mode))
+}
+
+var tempFileCount atomic.Uint64
+
+// createTemp creates a file with a unique name in the directory dir relative
+// to dirfd, returning the file descriptor and the path of the file.
+func createTemp(dirfd int, dir string, flags int, mode uint32) (int, string, error) {
+	for {
+		name := path.Join(dir, ".wasi-tmp-"+strconv.FormatUint(tempFileCount.Add(1), 36))
+		fd, err := ignoreEINTR2(func() (int, error) {
+			return unix.Openat(dirfd, name, flags|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC|unix.O_NOFOLLOW, mode)
+		})
+		if err != unix.EEXIST {
+			return fd, name, err
+		}
+	}
+}
+
+// openUnlinkedTemp creates a file with a unique name in the directory dir
+// relative to dirfd and removes it right away, emulating O_TMPFILE on the
+// platforms or file systems which do not support it.
+func openUnlinkedTemp(dirfd int, dir string, mode uint32) (int, error) {
+	fd, name, err := createTemp(dirfd, dir, unix.O_RDWR, mode)
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.Unlinkat(dirfd, name, 0); err != nil {
+		closeTraceEBADF(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// copyRename moves the regular file at oldPath relative to olddirfd to newPath
+// relative to newdirfd by copying it, for renames across file systems which
+// fail with EXDEV. The copy is made to a temporary file next to newPath, which
+// is then renamed to newPath, so newPath is replaced atomically, but the file
+// is visible at both paths until the source is unlinked. The mode and times of
+// the file are preserved. Other types of files fail with EXDEV.
+func copyRename(olddirfd int, oldPath string, newdirfd int, newPath string) error {
+	var stat unix.Stat_t
+	if err := ignoreEINTR(func() error {
+		return unix.Fstatat(olddirfd, oldPath, &stat, unix.AT_SYMLINK_NOFOLLOW)
+	}); err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		return unix.EXDEV
+	}
+	src, err := ignoreEINTR2(func() (int, error) {
+		return unix.Openat(olddirfd, oldPath, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+	})
+	if err != nil {
+		return err
+	}
+	defer closeTraceEBADF(src)
+
+	mode := uint32(stat.Mode) & 07777
+	dst, tmpPath, err := createTemp(newdirfd, path.Dir(newPath), unix.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	err = copyFileData(dst, src)
+	if err == nil {
+		// The mode passed to open is subject to the umask of the process.
+		err = ignoreEINTR(func() error { return unix.Fchmod(dst, mode) })
+	}
+	if err == nil {
+		ts := [2]unix.Timespec{stat.Atim, stat.Mtim}
+		err = ignoreEINTR(func() error { return futimens(dst, &ts) })
+	}
+	closeTraceEBADF(dst)
+	if err == nil {
+		err = ignoreEINTR(func() error { return unix.Renameat(newdirfd, tmpPath, newdirfd, newPath) })
+	}
+	if err != nil {
+		unix.Unlinkat(newdirfd, tmpPath, 0)
+		return err
+	}
+	return ignoreEINTR(func() error { return unix.Unlinkat(olddirfd, oldPath, 0) })
+}
+
+func copyFileData(dst, src int) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := ignoreEINTR2(func() (int, error) { return unix.Read(src, buf) })
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		for b := buf[:n]; len(b) > 0; {
+			w, err := ignoreEINTR2(func() (int, error) { return unix.Write(dst, b) })
+			if err != nil {
+				return err
+			}
+			b = b[w:]
+		}
+	}
+}
+
+// copyBufferSize is the size of the buffer used to copy data between file
+// descriptors which the host cannot copy within the kernel.
+const copyBufferSize = 64 * 1024
+
+// copyBuffer copies up to size bytes from src to dst through a buffer. The data
+// is read with a single call, so it only blocks once on src like read(2), and
+// is then written entirely, waiting for dst to be writable if it is
+// non-blocking. If writing fails after some data was written, the number of
+// bytes written is returned and the rest of the data is lost.
+func copyBuffer(ctx context.Context, dst, src, size int) (int, error) {
+	buf := make([]byte, min(size, copyBufferSize))
+	r, err := handleEINTR(func() (int, error) { return unix.Read(src, buf) })
+	if err != nil || r == 0 {
+		return 0, err
+	}
+	n := 0
+	for b := buf[:r]; len(b) > 0; {
+		w, err := handleEINTR(func() (int, error) { return unix.Write(dst, b) })
+		switch {
+		case err == unix.EAGAIN:
+			if waitWritable(ctx, dst) {
+				continue
+			}
+		case err != nil:
+		default:
+			n += w
+			b = b[w:]
+			continue
+		}
+		if n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// advanceIovecs removes the first n bytes from iovs, modifying the iovecs in
+// place.
+func advanceIovecs(iovs []unix.Iovec, n int) []unix.Iovec {
+	for len(iovs) > 0 && n >= int(iovs[0].Len) {
+		n -= int(iovs[0].Len)
+		iovs = iovs[1:]
+	}
+	if len(iovs) > 0 && n > 0 {
+		iovs[0].Base = (*byte)(unsafe.Add(unsafe.Pointer(iovs[0].Base), n))
+		iovs[0].SetLen(int(iovs[0].Len) - n)
+	}
+	return iovs
+}
+
+// lingerOnClose prepares the socket fd to be closed so its SO_LINGER option is
+// honored. Some platforms (e.g. darwin) do not wait for the data to be sent
+// when closing non-blocking sockets, so the socket is switched to blocking
+// mode if it lingers.
+func lingerOnClose(fd int) {
+	l, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	if err == nil && l.Onoff != 0 && l.Linger > 0 {
+		unix.SetNonblock(fd, false)
+	}
+}
+
+// waitWritable waits until fd is writable, returning false if an error occurs
+// or the context is canceled.
+func waitWritable(ctx context.Context, fd int) bool {
+	for ctx.Err() == nil {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+		n, err := poll(fds, 100*time.Millisecond)
+		switch {
+		case err == unix.EINTR:
+		case err != nil:
+			return false
+		case n > 0:
+			return fds[0].Revents&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) == 0
+		}
+	}
+	return false
+}
+
 var _ []byte = (wasi.IOVec)(nil)
 
 func makeIOVecs(iovecs []wasi.IOVec) [][]byte {
 	return *(*[][]byte)(unsafe.Pointer(&iovecs))
 }
+
+// minIovec is the number of iovecs that can be converted to the host
+// representation using a buffer allocated on the stack.
+const minIovec = 8
+
+func appendIovecs(vecs []unix.Iovec, iovecs []wasi.IOVec) []unix.Iovec {
+	for _, iov := range iovecs {
+		vec := unix.Iovec{Base: unsafe.SliceData(iov)}
+		vec.SetLen(len(iov))
+		vecs = append(vecs, vec)
+	}
+	return vecs
+}
+
+// iovecBytes returns the buffer referenced by iov.
+func iovecBytes(iov unix.Iovec) []byte {
+	return unsafe.Slice(iov.Base, iov.Len)
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"io"
+	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync"
@@ -18,44 +20,202 @@ import (
 
 // System is a WASI preview 1 implementation for Unix.
 //
+// The host file descriptors that System creates for the guest are opened with
+// the close-on-exec flag, and Preopen sets the flag on the file descriptors
+// passed by the application, so they do not leak to the child processes that
+// the host may spawn.
+//
 // An instance of System is not safe for concurrent use.
 type System struct {
-	// Args are the environment variables accessible via ArgsGet.
+	// Args are the command line arguments accessible via ArgsGet.
+	//
+	// The field must not be modified once the guest is running, use SetArgs
+	// to change the arguments of a running system instead.
 	Args []string
 
 	// Environ is the environment variables accessible via EnvironGet.
+	//
+	// The field must not be modified once the guest is running, use
+	// SetEnviron to change the environment of a running system instead.
 	Environ []string
 
 	// Realtime returns the realtime clock value.
 	Realtime          func(context.Context) (uint64, error)
 	RealtimePrecision time.Duration
 
-	// Monotonic returns the monotonic clock value.
+	// Monotonic returns the monotonic clock value, which must never
+	// decrease. It should advance at the same rate as Realtime so deadlines
+	// computed from either clock agree; wasi.MakeClocks returns such a pair.
 	Monotonic          func(context.Context) (uint64, error)
 	MonotonicPrecision time.Duration
 
 	// Yield is called when SchedYield is called. If Yield is nil,
-	// SchedYield is a noop.
+	// SchedYield yields the processor to other goroutines.
 	Yield func(context.Context) error
 
 	// Exit is called with an exit code when ProcExit is called.
 	// If Exit is nil, ProcExit is a noop.
 	Exit func(context.Context, int) error
 
-	// Raise is called with a signal when ProcRaise is called.
-	// If Raise is nil, ProcRaise is a noop.
+	// Raise is called with a signal when ProcRaise is called. The WASI signal
+	// is translated to the number of the equivalent signal on the host, and
+	// ProcRaise fails with EINVAL if there is no such signal.
+	// If Raise is nil, ProcRaise applies the default action of the signal:
+	// signals terminating the process call Exit with the exit code 128+signal,
+	// and the other signals are ignored.
 	Raise func(context.Context, int) error
 
+	// OnExit, if set, is called with the exit code when the guest calls
+	// ProcExit, including the exits caused by ProcRaise, before calling Exit.
+	// It is intended to capture diagnostics, e.g. to log the nonzero exit
+	// codes, and cannot prevent the exit. The traps of the guest are not
+	// observed by the system, the runtime reports them as errors to the
+	// application which called the guest.
+	OnExit func(ctx context.Context, code int)
+
 	// Rand is the source for RandomGet.
 	Rand io.Reader
 
+	// NoAtime instructs PathOpen to open files with O_NOATIME, preventing
+	// reads from updating their access time. The flag is only honored on
+	// Linux, and is silently dropped when the process is not permitted to
+	// set it (e.g. it does not own the file).
+	NoAtime bool
+
+	// CrossDeviceRename instructs PathRename to move regular files across
+	// file systems by copying them and unlinking the source when rename(2)
+	// fails with EXDEV, e.g. when the source and target directories are
+	// preopens on different devices. Such renames are not atomic: the file
+	// may exist at both paths if the host fails during the copy.
+	CrossDeviceRename bool
+
+	// Umask is the set of permission bits removed from the mode of files and
+	// directories created by the guest, e.g. 077 to make them only accessible
+	// to the owner. WASI preview 1 has no mode argument when creating files,
+	// so this host policy determines their permissions: files are created
+	// with 0644 and directories with 0755, minus the bits in Umask. The umask
+	// of the host process still applies.
+	Umask uint32
+
+	// FullWrites instructs FDWrite to retry short writes until all the data
+	// is written or an error occurs, waiting for non-blocking file descriptors
+	// to be writable once some data was written. By default, FDWrite performs
+	// a single writev(2) and returns the number of bytes written, which may be
+	// less than requested on pipes and sockets; guests are expected to handle
+	// short writes like POSIX programs do.
+	FullWrites bool
+
+	// FastSync makes FDSync, FDDataSync, and the writes to files with the
+	// synchronization flags use fsync(2) on darwin instead of
+	// fcntl(F_FULLFSYNC). It is faster, but the data may remain in the
+	// volatile cache of the drive and be lost on power failure. The option is
+	// disabled by default, so the data is written to permanent storage when
+	// the file system supports it. It has no effect on other platforms.
+	FastSync bool
+
+	// AcceptKeepAlive and AcceptNoDelay enable SO_KEEPALIVE and TCP_NODELAY
+	// on the TCP connections accepted by SockAccept, for example so dead
+	// peers of long-lived connections are detected. The guest may still
+	// change the options of accepted sockets with SockSetOpt.
+	AcceptKeepAlive bool
+	AcceptNoDelay   bool
+
+	// MaxListenBacklog caps the backlog of the sockets that the guest
+	// listens on with SockListen. Zero means no limit, the host still caps
+	// the backlog (e.g. to SOMAXCONN on Linux).
+	MaxListenBacklog int
+
+	// WriteByteLimit caps the number of bytes that the guest may write to
+	// regular files with FDWrite and FDPwrite, or reserve with FDAllocate.
+	// Calls which would exceed the limit fail with EDQUOT and do not modify
+	// the file. Writes to other file types (e.g. pipes or sockets) are not
+	// limited. The count is reported by BytesWritten, and may be reset with
+	// ResetBytesWritten. Zero means no limit.
+	WriteByteLimit int64
+
+	// VirtualDevices enables the emulation of well-known files that programs
+	// may expect to find on the file system, without exposing the host files
+	// to the guest. The devices are visible to PathOpen when resolving paths
+	// relative to a preopened directory. The emulated files are:
+	//
+	//	/dev/null           discards writes, reads return end of file
+	//	/dev/zero           discards writes, reads return zero bytes
+	//	/dev/random         reads from Rand, writes are discarded
+	//	/dev/urandom        same as /dev/random
+	//	/proc/self/cmdline  the null-separated list of Args (read-only)
+	VirtualDevices bool
+
+	// VirtualFile, if set, intercepts the paths opened with PathOpen or
+	// passed to PathFileStatGet relative to a preopened directory. It is
+	// called with the absolute path of the file in the guest file system,
+	// and claims the path by returning true, in which case the guest sees a
+	// read-only regular file with the returned content instead of the host
+	// file. The content must not be modified after being returned. Paths
+	// which are not claimed are resolved on the host.
+	VirtualFile func(path string) (content []byte, ok bool)
+
+	// ProcSelfFD enables the emulation of the symbolic links of the
+	// /proc/self/fd directory, which some programs read to find the path of
+	// an open file descriptor. PathReadLink of /proc/self/fd/N, relative to a
+	// preopened directory, returns the path of the file descriptor N as
+	// reported by FDs. These are paths of the guest file system, the host
+	// paths of the preopened directories are never exposed. The links of file
+	// descriptors with no known path, like sockets, do not exist.
+	ProcSelfFD bool
+
+	// CacheFileStat enables caching the results of FDFileStatGet for regular
+	// files and character devices, saving a syscall on repeated calls. The
+	// cache is invalidated when the guest modifies any file, but changes made
+	// by other processes are not observed; the access time also remains
+	// unchanged by reads. The option should not be enabled if the guest needs
+	// to observe files modified concurrently.
+	CacheFileStat bool
+
+	// DetectReadOnlyFS enables detecting the directories preopened on host
+	// file systems mounted read-only. The path functions which modify the
+	// entries of these directories then fail with EROFS without making
+	// syscalls, so the guest gets the error even when the host would have
+	// reported another one first. PathOpen is always left to the host, since
+	// some files like devices may still be opened for writing. The option must
+	// be set before preopening the directories.
+	//
+	// Only the file system of the preopened directories is checked, so the
+	// option must not be enabled if writable file systems are mounted under
+	// them, e.g. a writable /tmp in the read-only root of a container.
+	DetectReadOnlyFS bool
+
 	wasi.FileTable[FD]
 
+	devices   map[wasi.FD]*deviceFile
+	filestats map[wasi.FD]wasi.FileStat
+	// Number of bytes counted against WriteByteLimit.
+	bytesWritten int64
+	// Directions of the sockets that were shut down by SockShutdown, used to
+	// recognize the ENOTCONN errors reported by repeated calls.
+	shutdowns map[wasi.FD]wasi.SDFlags
+	// Synchronization flags set by FDStatSetFlags that the host did not
+	// apply to the file descriptors, emulated by syncing after each write.
+	syncs map[wasi.FD]wasi.FDFlags
+	// Preopened directories detected on read-only file systems when
+	// DetectReadOnlyFS is enabled.
+	readOnlyDirs map[wasi.FD]struct{}
+
+	// Scratch buffer used to convert the iovecs passed to the I/O functions
+	// to the host representation. Reusing the buffer is safe because System
+	// is not safe for concurrent use.
+	iovecs []unix.Iovec
+
+	// The subscriptions of the pollfds following the wake fd in PollOneOff,
+	// by index.
+	pollsubs []int
+
 	pollfds []unix.PollFd
 	inet4   unix.SockaddrInet4
 	inet6   unix.SockaddrInet6
 	unix    unix.SockaddrUnix
 
+	// The mutex guards wake, and Args and Environ when they are replaced by
+	// SetArgs and SetEnviron.
 	mutex sync.Mutex
 	wake  [2]*os.File
 	shut  atomic.Bool
@@ -63,22 +223,61 @@ type System struct {
 
 var _ wasi.System = (*System)(nil)
 
+// SetArgs replaces the command line arguments of the system, allowing
+// embedders to reconfigure a system between runs of guests without creating
+// a new file table. The arguments are copied, and the change takes effect on
+// the next call to ArgsSizesGet or ArgsGet.
+//
+// Unlike the other methods, SetArgs may be called concurrently with the
+// guest.
+func (s *System) SetArgs(args []string) {
+	args = append([]string(nil), args...)
+	s.mutex.Lock()
+	s.Args = args
+	s.mutex.Unlock()
+}
+
+// SetEnviron replaces the environment variables of the system. The variables
+// are copied, and the change takes effect on the next call to EnvironSizesGet
+// or EnvironGet.
+//
+// Unlike the other methods, SetEnviron may be called concurrently with the
+// guest.
+func (s *System) SetEnviron(environ []string) {
+	environ = append([]string(nil), environ...)
+	s.mutex.Lock()
+	s.Environ = environ
+	s.mutex.Unlock()
+}
+
+func (s *System) args() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Args
+}
+
+func (s *System) environ() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Environ
+}
+
 func (s *System) ArgsSizesGet(ctx context.Context) (argCount, stringBytes int, errno wasi.Errno) {
-	argCount, stringBytes = wasi.SizesGet(s.Args)
+	argCount, stringBytes = wasi.SizesGet(s.args())
 	return
 }
 
 func (s *System) ArgsGet(ctx context.Context) ([]string, wasi.Errno) {
-	return s.Args, wasi.ESUCCESS
+	return s.args(), wasi.ESUCCESS
 }
 
 func (s *System) EnvironSizesGet(ctx context.Context) (envCount, stringBytes int, errno wasi.Errno) {
-	envCount, stringBytes = wasi.SizesGet(s.Environ)
+	envCount, stringBytes = wasi.SizesGet(s.environ())
 	return
 }
 
 func (s *System) EnvironGet(ctx context.Context) ([]string, wasi.Errno) {
-	return s.Environ, wasi.ESUCCESS
+	return s.environ(), wasi.ESUCCESS
 }
 
 func (s *System) ClockResGet(ctx context.Context, id wasi.ClockID) (wasi.Timestamp, wasi.Errno) {
@@ -115,6 +314,9 @@ func (s *System) ClockTimeGet(ctx context.Context, id wasi.ClockID, precision wa
 	}
 }
 
+// PollOneOff waits for at least one of the subscriptions to complete. The
+// events are written in the order of the subscriptions they complete, each
+// carrying the user data of its subscription.
 func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscription, events []wasi.Event) (int, wasi.Errno) {
 	if len(subscriptions) == 0 || len(events) < len(subscriptions) {
 		return 0, wasi.EINVAL
@@ -127,6 +329,7 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 		Fd:     int32(r.Fd()),
 		Events: unix.POLLIN | unix.POLLHUP,
 	})
+	s.pollsubs = s.pollsubs[:0]
 
 	realtimeEpoch := time.Duration(0)
 	monotonicEpoch := time.Duration(0)
@@ -149,16 +352,33 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 			pollEvent = unix.POLLOUT
 			fallthrough
 		case wasi.FDReadEvent:
-			fd, _, errno := s.LookupFD(sub.GetFDReadWrite().FD, wasi.PollFDReadWriteRight)
+			fd, stat, errno := s.LookupFD(sub.GetFDReadWrite().FD, wasi.PollFDReadWriteRight)
 			if errno != wasi.ESUCCESS {
 				events[i] = errorEvent(sub, errno)
 				numEvents++
 				continue
 			}
+			if s.devices[sub.GetFDReadWrite().FD] != nil {
+				// Virtual devices never block.
+				events[i] = errorEvent(sub, wasi.ESUCCESS)
+				numEvents++
+				continue
+			}
+			switch stat.FileType {
+			case wasi.RegularFileType, wasi.DirectoryType:
+				// Reads and writes on files never block, they are always
+				// ready like poll(2) reports on Linux. They are not passed
+				// to poll(2) since not all platforms support polling them
+				// (e.g. some file systems on darwin).
+				events[i] = errorEvent(sub, wasi.ESUCCESS)
+				numEvents++
+				continue
+			}
 			s.pollfds = append(s.pollfds, unix.PollFd{
 				Fd:     int32(fd),
 				Events: pollEvent,
 			})
+			s.pollsubs = append(s.pollsubs, i)
 
 		case wasi.ClockEvent:
 			c := sub.GetClock()
@@ -170,6 +390,15 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 				epoch, gettime = &realtimeEpoch, s.Realtime
 			case wasi.Monotonic:
 				epoch, gettime = &monotonicEpoch, s.Monotonic
+			case wasi.ProcessCPUTimeID, wasi.ThreadCPUTimeID:
+			default:
+				// Like ClockTimeGet, unknown clocks are invalid while known
+				// clocks which are not available are not supported. The
+				// error is reported on the event of the subscription so the
+				// other subscriptions still resolve.
+				events[i] = errorEvent(sub, wasi.EINVAL)
+				numEvents++
+				continue
 			}
 			if gettime == nil {
 				events[i] = errorEvent(sub, wasi.ENOTSUP)
@@ -209,16 +438,16 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 				timeout = t
 				timeoutEventIndex = i
 			}
+
+		default:
+			// The subscriptions of unknown types could never complete, and
+			// their events could not be told apart from the events of the
+			// subscriptions which did not complete yet.
+			return 0, wasi.EINVAL
 		}
 	}
 
-	// We set the timeout to zero when we already produced events due to
-	// invalid subscriptions; this is useful to still make progress on I/O
-	// completion.
 	var deadline time.Time
-	if numEvents > 0 {
-		timeout = 0
-	}
 	if timeout > 0 {
 		deadline = time.Now().Add(timeout)
 	}
@@ -226,15 +455,23 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 	// This loops until either the deadline is reached or at least one event is
 	// reported.
 	for {
-		timeoutMillis := 0
+		pollTimeout := time.Duration(0)
 		switch {
+		case numEvents > 0:
+			// We set the timeout to zero when we already produced events
+			// due to invalid subscriptions; this is useful to still make
+			// progress on I/O completion. The deadline is kept so the clock
+			// subscriptions are not reported before they expire.
 		case timeout < 0:
-			timeoutMillis = -1
+			pollTimeout = -1
 		case !deadline.IsZero():
-			timeoutMillis = int(time.Until(deadline).Milliseconds())
+			pollTimeout = max(time.Until(deadline), 0)
 		}
 
-		n, err := unix.Poll(s.pollfds, timeoutMillis)
+		// When interrupted by a signal, poll(2) returns EINTR without
+		// reporting events; the loop resumes waiting with the time left
+		// before the deadline.
+		n, err := poll(s.pollfds, pollTimeout)
 		if err != nil && err != unix.EINTR {
 			return 0, makeErrno(err)
 		}
@@ -261,34 +498,49 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 			return len(subscriptions), wasi.ESUCCESS
 		}
 
-		if timeoutEventIndex >= 0 && deadline.Before(time.Now().Add(time.Millisecond)) {
+		if timeoutEventIndex >= 0 && deadline.Before(time.Now().Add(pollPrecision)) {
 			events[timeoutEventIndex] = wasi.Event{
 				UserData:  subscriptions[timeoutEventIndex].UserData,
 				EventType: subscriptions[timeoutEventIndex].EventType + 1,
 			}
 		}
 
-		j := 1
-		for i := range subscriptions {
-			if events[i].EventType != 0 {
+		// Each pollfd after the wake fd was added for the subscription at
+		// the same position in pollsubs, so the events are reported to the
+		// subscriptions that they were produced for regardless of how many
+		// subscriptions were completed without calling poll(2).
+		for k, i := range s.pollsubs {
+			pf := &s.pollfds[k+1]
+			if pf.Revents == 0 || events[i].EventType != 0 {
 				continue
 			}
-			switch sub := &subscriptions[i]; sub.EventType {
-			case wasi.FDReadEvent, wasi.FDWriteEvent:
-				pf := &s.pollfds[j]
-				j++
-				if pf.Revents == 0 {
-					continue
-				}
-				// Linux never reports POLLHUP for disconnected sockets,
-				// so there is no reliable mechanism to set wasi.Hanghup.
-				// We optimize for portability here and just report that
-				// the file descriptor is ready for reading or writing,
-				// and let the application deal with the conditions it
-				// sees from the following calles to read/write/etc...
-				events[i] = wasi.Event{
-					UserData:  sub.UserData,
-					EventType: sub.EventType + 1,
+			sub := &subscriptions[i]
+			// Linux never reports POLLHUP for disconnected sockets,
+			// so there is no reliable mechanism to set wasi.Hanghup.
+			// We optimize for portability here and just report that
+			// the file descriptor is ready for reading or writing,
+			// and let the application deal with the conditions it
+			// sees from the following calles to read/write/etc...
+			//
+			// The exceptions are POLLNVAL, which indicates that the
+			// host file descriptor was closed, and POLLHUP on files
+			// other than sockets when there is no data left to read
+			// (e.g. the write end of a pipe was closed). When POLLIN
+			// is also set, the hangup is not reported so the
+			// application drains the buffered data.
+			events[i] = wasi.Event{
+				UserData:  sub.UserData,
+				EventType: sub.EventType + 1,
+			}
+			switch {
+			case (pf.Revents & unix.POLLNVAL) != 0:
+				events[i].Errno = wasi.EBADF
+			case (pf.Revents&(unix.POLLHUP|unix.POLLIN)) == unix.POLLHUP && sub.EventType == wasi.FDReadEvent:
+				_, stat, _ := s.LookupFD(sub.GetFDReadWrite().FD, 0)
+				switch stat.FileType {
+				case wasi.SocketStreamType, wasi.SocketDGramType:
+				default:
+					events[i].FDReadWrite.Flags |= wasi.Hangup
 				}
 			}
 		}
@@ -321,6 +573,16 @@ func (s *System) PollOneOff(ctx context.Context, subscriptions []wasi.Subscripti
 	}
 }
 
+func (s *System) makeIovecs(iovecs []wasi.IOVec) []unix.Iovec {
+	s.iovecs = appendIovecs(s.iovecs[:0], iovecs)
+	return s.iovecs
+}
+
+func (s *System) clearIovecs() {
+	// Don't retain pointers to the guest memory after the call.
+	clear(s.iovecs)
+}
+
 func errorEvent(s *wasi.Subscription, err wasi.Errno) wasi.Event {
 	return wasi.Event{
 		UserData:  s.UserData,
@@ -330,6 +592,9 @@ func errorEvent(s *wasi.Subscription, err wasi.Errno) wasi.Event {
 }
 
 func (s *System) ProcExit(ctx context.Context, code wasi.ExitCode) wasi.Errno {
+	if s.OnExit != nil {
+		s.OnExit(ctx, int(code))
+	}
 	if s.Exit != nil {
 		return makeErrno(s.Exit(ctx, int(code)))
 	}
@@ -337,17 +602,25 @@ func (s *System) ProcExit(ctx context.Context, code wasi.ExitCode) wasi.Errno {
 }
 
 func (s *System) ProcRaise(ctx context.Context, signal wasi.Signal) wasi.Errno {
+	sig, ok := makeSignal(signal)
+	if !ok {
+		return wasi.EINVAL
+	}
 	if s.Raise != nil {
-		return makeErrno(s.Raise(ctx, int(signal)))
+		return makeErrno(s.Raise(ctx, int(sig)))
 	}
-	return wasi.ENOSYS
+	if signal.Terminates() {
+		return s.ProcExit(ctx, wasi.ExitCode(128+int(sig)))
+	}
+	return wasi.ESUCCESS
 }
 
 func (s *System) SchedYield(ctx context.Context) wasi.Errno {
 	if s.Yield != nil {
 		return makeErrno(s.Yield(ctx))
 	}
-	return wasi.ENOSYS
+	runtime.Gosched()
+	return wasi.ESUCCESS
 }
 
 func (s *System) RandomGet(ctx context.Context, b []byte) wasi.Errno {
@@ -357,6 +630,431 @@ func (s *System) RandomGet(ctx context.Context, b []byte) wasi.Errno {
 	return wasi.ESUCCESS
 }
 
+func (s *System) FDAdvise(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize, advice wasi.Advice) wasi.Errno {
+	if _, errno, ok := s.lookupDevice(fd, wasi.FDAdviseRight); ok {
+		return errno
+	}
+	return s.FileTable.FDAdvise(ctx, fd, offset, length, advice)
+}
+
+func (s *System) FDAllocate(ctx context.Context, fd wasi.FD, offset, length wasi.FileSize) wasi.Errno {
+	if errno, ok := s.lookupUnsupportedDevice(fd); ok {
+		return errno
+	}
+	_, stat, errno := s.LookupFD(fd, wasi.FDAllocateRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if errno := s.checkWriteLimit(stat.FileType, int64(length)); errno != wasi.ESUCCESS {
+		return errno
+	}
+	s.invalidateFileStats()
+	errno = s.FileTable.FDAllocate(ctx, fd, offset, length)
+	if errno == wasi.ESUCCESS {
+		s.countBytesWritten(stat.FileType, int64(length))
+	}
+	return errno
+}
+
+// BytesWritten returns the number of bytes counted against WriteByteLimit.
+func (s *System) BytesWritten() int64 {
+	return s.bytesWritten
+}
+
+// ResetBytesWritten resets the count of bytes returned by BytesWritten,
+// allowing the guest to write WriteByteLimit more bytes.
+func (s *System) ResetBytesWritten() {
+	s.bytesWritten = 0
+}
+
+func (s *System) checkWriteLimit(fileType wasi.FileType, size int64) wasi.Errno {
+	if s.WriteByteLimit > 0 && fileType == wasi.RegularFileType {
+		if size < 0 || size > s.WriteByteLimit-s.bytesWritten {
+			return wasi.EDQUOT
+		}
+	}
+	return wasi.ESUCCESS
+}
+
+func (s *System) countBytesWritten(fileType wasi.FileType, size int64) {
+	if fileType == wasi.RegularFileType {
+		s.bytesWritten += size
+	}
+}
+
+func iovecsLen(iovecs []wasi.IOVec) (n int64) {
+	for _, iov := range iovecs {
+		n += int64(len(iov))
+	}
+	return n
+}
+
+// FDDup duplicates the file descriptor, returning a new file descriptor which
+// refers to the same open file. WASI preview 1 has no equivalent function,
+// the method is intended to be used by host functions implementing dup(2).
+//
+// The new file descriptor is backed by a distinct host file descriptor, so
+// either of them can be closed without affecting the other. As with dup(2),
+// the file offset and status flags are shared, but the rights of the two file
+// descriptors can be changed independently.
+func (s *System) FDDup(ctx context.Context, fd wasi.FD) (wasi.FD, wasi.Errno) {
+	f, stat, errno := s.LookupFD(fd, 0)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	if s.MaxOpenFiles > 0 && s.NumOpenFiles() >= s.MaxOpenFiles {
+		return -1, wasi.ENFILE
+	}
+	if d := s.devices[fd]; d != nil {
+		newfd := s.Register(-1, stat)
+		s.devices[newfd] = d
+		return newfd, wasi.ESUCCESS
+	}
+	hostfd, err := ignoreEINTR2(func() (int, error) {
+		return unix.FcntlInt(uintptr(f), unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if err != nil {
+		return -1, makeErrno(err)
+	}
+	newfd := s.Register(FD(hostfd), stat)
+	if sync, ok := s.syncs[fd]; ok {
+		s.syncs[newfd] = sync
+	}
+	return newfd, wasi.ESUCCESS
+}
+
+func (s *System) FDDataSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if _, errno, ok := s.lookupDevice(fd, wasi.FDDataSyncRight); ok {
+		return errno
+	}
+	f, _, errno := s.LookupFD(fd, wasi.FDDataSyncRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	return f.dataSync(!s.FastSync)
+}
+
+func (s *System) FDStatGet(ctx context.Context, fd wasi.FD) (wasi.FDStat, wasi.Errno) {
+	stat, errno := s.FileTable.FDStatGet(ctx, fd)
+	if d := s.devices[fd]; d != nil && errno == wasi.ESUCCESS {
+		stat.Flags = d.flags
+	}
+	return stat, errno
+}
+
+func (s *System) FDs() []wasi.FDInfo {
+	fds := s.FileTable.FDs()
+	for i := range fds {
+		if d := s.devices[fds[i].FD]; d != nil {
+			fds[i].Flags = d.flags
+		}
+	}
+	return fds
+}
+
+func (s *System) FDStatSetFlags(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) wasi.Errno {
+	if d, errno, ok := s.lookupDevice(fd, wasi.FDStatSetFlagsRight); ok {
+		if errno != wasi.ESUCCESS {
+			return errno
+		}
+		// Devices never block and have no data to synchronize, the flags
+		// are only recorded so FDStatGet reports them.
+		d.flags = flags
+		return wasi.ESUCCESS
+	}
+	f, stat, errno := s.LookupFD(fd, wasi.FDStatSetFlagsRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	// Like PathOpen, RSync is rejected on platforms which do not support it
+	// rather than providing weaker guarantees than requested.
+	if __O_RSYNC == 0 && flags.Has(wasi.RSync) {
+		return wasi.ENOSYS
+	}
+	if errno := s.FileTable.FDStatSetFlags(ctx, fd, flags); errno != wasi.ESUCCESS {
+		return errno
+	}
+	const syncFlags = wasi.Sync | wasi.DSync | wasi.RSync
+	if ((flags ^ stat.Flags) & syncFlags) == 0 {
+		return wasi.ESUCCESS
+	}
+	hostFlags, errno := f.syncFlags()
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	// The host may keep synchronizing writes after the guest cleared the
+	// flags (e.g. O_DSYNC cannot be removed on Linux), which is slower but
+	// still correct.
+	if emulated := flags & syncFlags &^ hostFlags; emulated != 0 {
+		if s.syncs == nil {
+			s.syncs = make(map[wasi.FD]wasi.FDFlags)
+		}
+		s.syncs[fd] = emulated
+	} else {
+		delete(s.syncs, fd)
+	}
+	return wasi.ESUCCESS
+}
+
+// syncWrite synchronizes the data written to fd if the guest enabled the
+// synchronization flags and the host does not apply them.
+func (s *System) syncWrite(ctx context.Context, f FD, fd wasi.FD) wasi.Errno {
+	switch flags := s.syncs[fd]; {
+	case flags.Has(wasi.Sync), flags.Has(wasi.RSync):
+		return f.sync(!s.FastSync)
+	case flags.Has(wasi.DSync):
+		return f.dataSync(!s.FastSync)
+	default:
+		return wasi.ESUCCESS
+	}
+}
+
+// invalidateFileStats clears the FDFileStatGet cache. File descriptors may
+// share the underlying file (and the same file may be modified by path), so
+// all entries are dropped when any file is modified.
+func (s *System) invalidateFileStats() {
+	clear(s.filestats)
+}
+
+func (s *System) FDFileStatSetSize(ctx context.Context, fd wasi.FD, size wasi.FileSize) wasi.Errno {
+	if errno, ok := s.lookupUnsupportedDevice(fd); ok {
+		return errno
+	}
+	s.invalidateFileStats()
+	return s.FileTable.FDFileStatSetSize(ctx, fd, size)
+}
+
+func (s *System) FDFileStatSetTimes(ctx context.Context, fd wasi.FD, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) wasi.Errno {
+	if errno, ok := s.lookupUnsupportedDevice(fd); ok {
+		return errno
+	}
+	accessTime, modifyTime, flags, errno := s.resolveTimeNow(ctx, accessTime, modifyTime, flags)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	s.invalidateFileStats()
+	return s.FileTable.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
+}
+
+// resolveTimeNow replaces the AccessTimeNow and ModifyTimeNow flags with the
+// time of the Realtime clock, so the timestamps set by the guest agree with
+// the values it observes from ClockTimeGet, including when the clock is not
+// the host clock (e.g. to replay an execution deterministically).
+func (s *System) resolveTimeNow(ctx context.Context, accessTime, modifyTime wasi.Timestamp, flags wasi.FSTFlags) (wasi.Timestamp, wasi.Timestamp, wasi.FSTFlags, wasi.Errno) {
+	if !flags.Has(wasi.AccessTimeNow) && !flags.Has(wasi.ModifyTimeNow) {
+		return accessTime, modifyTime, flags, wasi.ESUCCESS
+	}
+	if s.Realtime == nil {
+		return 0, 0, 0, wasi.ENOSYS
+	}
+	t, err := s.Realtime(ctx)
+	if err != nil {
+		return 0, 0, 0, makeErrno(err)
+	}
+	now := wasi.Timestamp(t)
+	if flags.Has(wasi.AccessTimeNow) {
+		accessTime, flags = now, (flags&^wasi.AccessTimeNow)|wasi.AccessTime
+	}
+	if flags.Has(wasi.ModifyTimeNow) {
+		modifyTime, flags = now, (flags&^wasi.ModifyTimeNow)|wasi.ModifyTime
+	}
+	return accessTime, modifyTime, flags, wasi.ESUCCESS
+}
+
+func (s *System) FDSync(ctx context.Context, fd wasi.FD) wasi.Errno {
+	if _, errno, ok := s.lookupDevice(fd, wasi.FDSyncRight); ok {
+		return errno
+	}
+	f, _, errno := s.LookupFD(fd, wasi.FDSyncRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	return f.sync(!s.FastSync)
+}
+
+// Preopen adds a pre-opened file descriptor to the table, see
+// wasi.FileTable.Preopen. The close-on-exec flag is set on the file
+// descriptor, and directories on read-only file systems are recorded if
+// DetectReadOnlyFS is enabled.
+func (s *System) Preopen(file FD, path string, stat wasi.FDStat) wasi.FD {
+	if file >= 0 {
+		unix.CloseOnExec(int(file))
+	}
+	fd := s.FileTable.Preopen(file, path, stat)
+	if s.DetectReadOnlyFS && stat.FileType == wasi.DirectoryType && isReadOnlyFS(int(file)) {
+		if s.readOnlyDirs == nil {
+			s.readOnlyDirs = make(map[wasi.FD]struct{})
+		}
+		s.readOnlyDirs[fd] = struct{}{}
+	}
+	return fd
+}
+
+func (s *System) readOnlyDir(fd wasi.FD) bool {
+	_, ok := s.readOnlyDirs[fd]
+	return ok
+}
+
+func (s *System) PathCreateDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if s.readOnlyDir(fd) {
+		return wasi.EROFS
+	}
+	d, _, errno := s.LookupFD(fd, wasi.PathCreateDirectoryRight)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if errno := s.CheckPath(path); errno != wasi.ESUCCESS {
+		return errno
+	}
+	return d.createDirectory(path, s.Umask)
+}
+
+func (s *System) PathFileStatGet(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string) (wasi.FileStat, wasi.Errno) {
+	if newDevice, ok := s.lookupDevicePath(ctx, fd, path); ok {
+		if _, _, errno := s.LookupFD(fd, wasi.PathFileStatGetRight); errno != wasi.ESUCCESS {
+			return wasi.FileStat{}, errno
+		}
+		return newDevice(s).stat(), wasi.ESUCCESS
+	}
+	return s.FileTable.PathFileStatGet(ctx, fd, lookupFlags, path)
+}
+
+func (s *System) PathFileStatSetTimes(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, accessTime, modifyTime wasi.Timestamp, fstFlags wasi.FSTFlags) wasi.Errno {
+	if s.readOnlyDir(fd) {
+		return wasi.EROFS
+	}
+	accessTime, modifyTime, fstFlags, errno := s.resolveTimeNow(ctx, accessTime, modifyTime, fstFlags)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	s.invalidateFileStats()
+	return s.FileTable.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, fstFlags)
+}
+
+func (s *System) PathLink(ctx context.Context, fd wasi.FD, flags wasi.LookupFlags, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	if s.readOnlyDir(newFD) {
+		return wasi.EROFS
+	}
+	s.invalidateFileStats()
+	return s.FileTable.PathLink(ctx, fd, flags, oldPath, newFD, newPath)
+}
+
+func (s *System) PathOpen(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (wasi.FD, wasi.Errno) {
+	if openFlags.Has(wasi.OpenCreate) || openFlags.Has(wasi.OpenTruncate) {
+		s.invalidateFileStats()
+	}
+	if newDevice, ok := s.lookupDevicePath(ctx, fd, path); ok {
+		return s.openDevice(ctx, fd, newDevice, openFlags, rightsBase, rightsInheriting, fdFlags)
+	}
+	newfd, errno := s.FileTable.PathOpenFunc(ctx, fd, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags, s.pathOpen)
+	if errno != wasi.ESUCCESS || !s.NoAtime {
+		return newfd, errno
+	}
+	f, _, errno := s.LookupFD(newfd, 0)
+	if errno != wasi.ESUCCESS {
+		return newfd, errno
+	}
+	if err := setNoAtime(int(f)); err != nil && err != unix.EPERM {
+		s.FDClose(ctx, newfd)
+		return -1, makeErrno(err)
+	}
+	return newfd, wasi.ESUCCESS
+}
+
+func (s *System) pathOpen(dir FD, lookupFlags wasi.LookupFlags, path string, openFlags wasi.OpenFlags, rightsBase, rightsInheriting wasi.Rights, fdFlags wasi.FDFlags) (FD, wasi.Errno) {
+	return dir.pathOpen(lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags, s.Umask)
+}
+
+// PathOpenTemp creates an anonymous file in the directory at path, relative to
+// the directory fd, and returns a file descriptor open for reading and writing
+// with the rights in rightsBase. The file has no entry in the directory and is
+// removed when the file descriptor is closed.
+//
+// On Linux, the file is created with O_TMPFILE. On the other platforms, and on
+// the file systems which do not support O_TMPFILE, the file is created with a
+// unique name and unlinked right away.
+//
+// The directory fd must have the PathOpenRight and PathCreateFileRight rights,
+// and the rights of the new file descriptor are limited by its inheriting
+// rights.
+func (s *System) PathOpenTemp(ctx context.Context, fd wasi.FD, path string, rightsBase wasi.Rights) (wasi.FD, wasi.Errno) {
+	// The file is checked like a file created by PathOpen.
+	d, rightsBase, _, errno := s.CheckPathOpen(fd, path, wasi.OpenCreate, rightsBase, 0, 0)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	if _, stat, _ := s.LookupFD(fd, 0); stat.FileType != wasi.DirectoryType {
+		return -1, wasi.ENOTDIR
+	}
+	if s.readOnlyDir(fd) {
+		return -1, wasi.EROFS
+	}
+	if !validPathLength(path) {
+		return -1, wasi.ENAMETOOLONG
+	}
+	s.invalidateFileStats()
+	newfd, errno := d.openTemp(filepath.Clean(path), s.Umask)
+	if errno != wasi.ESUCCESS {
+		return -1, errno
+	}
+	return s.Register(newfd, wasi.FDStat{
+		FileType:   wasi.RegularFileType,
+		RightsBase: rightsBase,
+	}), wasi.ESUCCESS
+}
+
+func (s *System) PathReadLink(ctx context.Context, fd wasi.FD, path string, buffer []byte) (int, wasi.Errno) {
+	if target, errno, ok := s.lookupFDLink(ctx, fd, path); ok {
+		if errno != wasi.ESUCCESS {
+			return 0, errno
+		}
+		// Match the behavior of the host when the buffer is too short.
+		n := copy(buffer, target)
+		if n == len(buffer) {
+			return n, wasi.ERANGE
+		}
+		return n, wasi.ESUCCESS
+	}
+	return s.FileTable.PathReadLink(ctx, fd, path, buffer)
+}
+
+func (s *System) PathRemoveDirectory(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if s.readOnlyDir(fd) {
+		return wasi.EROFS
+	}
+	return s.FileTable.PathRemoveDirectory(ctx, fd, path)
+}
+
+func (s *System) PathRename(ctx context.Context, fd wasi.FD, oldPath string, newFD wasi.FD, newPath string) wasi.Errno {
+	if s.readOnlyDir(fd) || s.readOnlyDir(newFD) {
+		return wasi.EROFS
+	}
+	s.invalidateFileStats()
+	errno := s.FileTable.PathRename(ctx, fd, oldPath, newFD, newPath)
+	if errno != wasi.EXDEV || !s.CrossDeviceRename {
+		return errno
+	}
+	// The rights were checked by FileTable.PathRename.
+	oldDir, _, _ := s.LookupFD(fd, wasi.PathRenameSourceRight)
+	newDir, _, _ := s.LookupFD(newFD, wasi.PathRenameTargetRight)
+	return makeErrno(copyRename(int(oldDir), oldPath, int(newDir), newPath))
+}
+
+func (s *System) PathSymlink(ctx context.Context, oldPath string, fd wasi.FD, newPath string) wasi.Errno {
+	if s.readOnlyDir(fd) {
+		return wasi.EROFS
+	}
+	return s.FileTable.PathSymlink(ctx, oldPath, fd, newPath)
+}
+
+func (s *System) PathUnlinkFile(ctx context.Context, fd wasi.FD, path string) wasi.Errno {
+	if s.readOnlyDir(fd) {
+		return wasi.EROFS
+	}
+	s.invalidateFileStats()
+	return s.FileTable.PathUnlinkFile(ctx, fd, path)
+}
+
 func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags) (wasi.FD, wasi.SocketAddress, wasi.SocketAddress, wasi.Errno) {
 	socket, stat, errno := s.LookupSocketFD(fd, wasi.SockAcceptRight)
 	if errno != wasi.ESUCCESS {
@@ -369,11 +1067,22 @@ func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags)
 	if errno != wasi.ESUCCESS {
 		return -1, nil, nil, errno
 	}
+	// The limit is checked before accepting the connection so it remains
+	// in the backlog, as accept(2) does when the process reaches its
+	// limit of open files.
+	if errno := s.CheckMaxSockets(); errno != wasi.ESUCCESS {
+		return -1, nil, nil, errno
+	}
 	connflags := 0
 	if (flags & wasi.NonBlock) != 0 {
 		connflags |= unix.O_NONBLOCK
 	}
-	connfd, sa, err := accept(int(socket), connflags)
+	var connfd int
+	var sa unix.Sockaddr
+	err := ignoreEINTR(func() (err error) {
+		connfd, sa, err = accept(int(socket), connflags)
+		return err
+	})
 	if err != nil {
 		return -1, nil, nil, makeErrno(err)
 	}
@@ -386,6 +1095,10 @@ func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags)
 		_ = closeTraceEBADF(connfd)
 		return -1, nil, nil, wasi.ENOTSUP
 	}
+	if err := s.setAcceptOptions(connfd, peer); err != nil {
+		_ = closeTraceEBADF(connfd)
+		return -1, nil, nil, makeErrno(err)
+	}
 	guestfd := s.Register(FD(connfd), wasi.FDStat{
 		FileType:         wasi.SocketStreamType,
 		Flags:            flags,
@@ -395,12 +1108,57 @@ func (s *System) SockAccept(ctx context.Context, fd wasi.FD, flags wasi.FDFlags)
 	return guestfd, peer, addr, wasi.ESUCCESS
 }
 
+// setAcceptOptions applies AcceptKeepAlive and AcceptNoDelay to a connection
+// accepted from peer. The options only apply to TCP connections.
+func (s *System) setAcceptOptions(connfd int, peer wasi.SocketAddress) error {
+	switch peer.(type) {
+	case *wasi.Inet4Address, *wasi.Inet6Address:
+	default:
+		return nil
+	}
+	if s.AcceptKeepAlive {
+		if err := unix.SetsockoptInt(connfd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return err
+		}
+	}
+	if s.AcceptNoDelay {
+		if err := unix.SetsockoptInt(connfd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dontWaitKey struct{}
+
+// WithDontWait returns a context making the calls to SockRecv, SockRecvFrom,
+// SockSend and SockSendTo non-blocking, as if the socket had the NonBlock
+// flag, without changing the flags of the socket. The calls fail with EAGAIN
+// instead of blocking.
+//
+// WASI has no flags for non-blocking socket I/O on individual calls, this is
+// intended for host functions which attempt I/O on the sockets of the guest,
+// e.g. to implement edge-triggered event notifications.
+func WithDontWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dontWaitKey{}, true)
+}
+
+func dontWait(ctx context.Context) int {
+	if enable, _ := ctx.Value(dontWaitKey{}).(bool); enable {
+		return unix.MSG_DONTWAIT
+	}
+	return 0
+}
+
 func (s *System) SockRecv(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.RIFlags) (wasi.Size, wasi.ROFlags, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, wasi.FDReadRight)
+	socket, stat, errno := s.LookupSocketFD(fd, wasi.FDReadRight)
 	if errno != wasi.ESUCCESS {
 		return 0, 0, errno
 	}
-	var sysIFlags int
+	if emptyIO(stat.FileType, iovecs) {
+		return 0, 0, wasi.ESUCCESS
+	}
+	sysIFlags := dontWait(ctx)
 	if flags.Has(wasi.RecvPeek) {
 		sysIFlags |= unix.MSG_PEEK
 	}
@@ -421,12 +1179,15 @@ func (s *System) SockRecv(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec,
 }
 
 func (s *System) SockSend(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec, flags wasi.SIFlags) (wasi.Size, wasi.Errno) {
-	socket, _, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
+	socket, stat, errno := s.LookupSocketFD(fd, wasi.FDWriteRight)
 	if errno != wasi.ESUCCESS {
 		return 0, errno
 	}
+	if emptyIO(stat.FileType, iovecs) {
+		return 0, wasi.ESUCCESS
+	}
 	n, err := handleEINTR(func() (int, error) {
-		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, nil, 0)
+		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, nil, dontWait(ctx))
 	})
 	return wasi.Size(n), makeErrno(err)
 }
@@ -436,6 +1197,9 @@ func (s *System) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlag
 	if errno != wasi.ESUCCESS {
 		return errno
 	}
+	if (flags &^ (wasi.ShutdownRD | wasi.ShutdownWR)) != 0 {
+		return wasi.EINVAL
+	}
 	var sysHow int
 	switch {
 	case flags.Has(wasi.ShutdownRD | wasi.ShutdownWR):
@@ -470,7 +1234,22 @@ func (s *System) SockShutdown(ctx context.Context, fd wasi.FD, flags wasi.SDFlag
 		}
 	}
 	err := ignoreEINTR(func() error { return unix.Shutdown(int(socket), sysHow) })
-	return makeErrno(err)
+	// Shutting down a direction which is already shut down may report
+	// ENOTCONN, depending on the system and on the state of the other
+	// direction. The socket was connected when it was first shut down, so the
+	// error is not reported back to the guest, allowing cleanup code to call
+	// shutdown repeatedly.
+	if err == unix.ENOTCONN && s.shutdowns[fd] != 0 {
+		err = nil
+	}
+	if err != nil {
+		return makeErrno(err)
+	}
+	if s.shutdowns == nil {
+		s.shutdowns = make(map[wasi.FD]wasi.SDFlags)
+	}
+	s.shutdowns[fd] |= flags
+	return wasi.ESUCCESS
 }
 
 func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketType wasi.SocketType, protocol wasi.Protocol, rightsBase, rightsInheriting wasi.Rights) (wasi.FD, wasi.Errno) {
@@ -523,9 +1302,12 @@ func (s *System) SockOpen(ctx context.Context, pf wasi.ProtocolFamily, socketTyp
 	if s.MaxOpenFiles > 0 && s.NumOpenFiles() >= s.MaxOpenFiles {
 		return -1, wasi.ENFILE
 	}
+	if errno := s.CheckMaxSockets(); errno != wasi.ESUCCESS {
+		return -1, errno
+	}
 
 	fd, err := ignoreEINTR2(func() (int, error) {
-		return unix.Socket(sysDomain, sysType, sysProtocol)
+		return socket(sysDomain, sysType, sysProtocol)
 	})
 	if err != nil {
 		// Darwin gives EPROTOTYPE when the socket type and protocol do
@@ -628,6 +1410,9 @@ func (s *System) SockListen(ctx context.Context, fd wasi.FD, backlog int) wasi.E
 	if errno != wasi.ESUCCESS {
 		return errno
 	}
+	if s.MaxListenBacklog > 0 {
+		backlog = min(backlog, s.MaxListenBacklog)
+	}
 	err := ignoreEINTR(func() error { return unix.Listen(int(socket), backlog) })
 	return makeErrno(err)
 }
@@ -656,7 +1441,7 @@ func (s *System) SockSendTo(ctx context.Context, fd wasi.FD, iovecs []wasi.IOVec
 		return 0, wasi.EINVAL
 	}
 	n, err := handleEINTR(func() (int, error) {
-		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, sa, 0)
+		return unix.SendmsgBuffers(int(socket), makeIOVecs(iovecs), nil, sa, dontWait(ctx))
 	})
 	return wasi.Size(n), makeErrno(err)
 }
@@ -666,7 +1451,7 @@ func (s *System) SockRecvFrom(ctx context.Context, fd wasi.FD, iovecs []wasi.IOV
 	if errno != wasi.ESUCCESS {
 		return 0, 0, nil, errno
 	}
-	var sysIFlags int
+	sysIFlags := dontWait(ctx)
 	if flags.Has(wasi.RecvPeek) {
 		sysIFlags |= unix.MSG_PEEK
 	}
@@ -705,6 +1490,10 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysLevel = unix.SOL_SOCKET
 	case wasi.TcpLevel:
 		sysLevel = unix.IPPROTO_TCP
+	case wasi.IpLevel:
+		sysLevel = unix.IPPROTO_IP
+	case wasi.Ipv6Level:
+		sysLevel = unix.IPPROTO_IPV6
 	default:
 		return nil, wasi.EINVAL
 	}
@@ -735,9 +1524,13 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysOption = unix.SO_ACCEPTCONN
 	case wasi.TcpNoDelay:
 		sysOption = unix.TCP_NODELAY
+	case wasi.IpTypeOfService:
+		sysOption = unix.IP_TOS
+	case wasi.Ipv6TrafficClass:
+		sysOption = unix.IPV6_TCLASS
 	case wasi.Linger:
 		// This returns a struct linger value.
-		return nil, wasi.ENOTSUP // TODO: implement SO_LINGER
+		sysOption = unix.SO_LINGER
 	case wasi.RecvTimeout:
 		// These return a struct timeval value.
 		sysOption = unix.SO_RCVTIMEO
@@ -759,6 +1552,14 @@ func (s *System) SockGetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 			return nil, makeErrno(err)
 		}
 		return wasi.TimeValue(tv.Nano()), wasi.ESUCCESS
+	case wasi.Linger:
+		l, err := ignoreEINTR2(func() (*unix.Linger, error) {
+			return unix.GetsockoptLinger(int(socket), sysLevel, sysOption)
+		})
+		if err != nil {
+			return nil, makeErrno(err)
+		}
+		return wasi.LingerValue{Enabled: l.Onoff != 0, Timeout: int(l.Linger)}, wasi.ESUCCESS
 	}
 
 	value, err := ignoreEINTR2(func() (int, error) {
@@ -805,6 +1606,10 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysLevel = unix.SOL_SOCKET
 	case wasi.TcpLevel:
 		sysLevel = unix.IPPROTO_TCP
+	case wasi.IpLevel:
+		sysLevel = unix.IPPROTO_IP
+	case wasi.Ipv6Level:
+		sysLevel = unix.IPPROTO_IPV6
 	default:
 		return wasi.EINVAL
 	}
@@ -835,9 +1640,13 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		sysOption = unix.SO_ACCEPTCONN
 	case wasi.TcpNoDelay:
 		sysOption = unix.TCP_NODELAY
+	case wasi.IpTypeOfService:
+		sysOption = unix.IP_TOS
+	case wasi.Ipv6TrafficClass:
+		sysOption = unix.IPV6_TCLASS
 	case wasi.Linger:
 		// This accepts a struct linger value.
-		return wasi.ENOTSUP // TODO: implement SO_LINGER
+		sysOption = unix.SO_LINGER
 	case wasi.RecvTimeout:
 		sysOption = unix.SO_RCVTIMEO
 	case wasi.SendTimeout:
@@ -851,11 +1660,15 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 
 	var intval wasi.IntValue
 	var timeval wasi.TimeValue
+	var linger wasi.LingerValue
 	var ok bool
 
 	switch option {
 	case wasi.RecvTimeout, wasi.SendTimeout:
 		timeval, ok = value.(wasi.TimeValue)
+	case wasi.Linger:
+		linger, ok = value.(wasi.LingerValue)
+		ok = ok && linger.Timeout >= 0 && linger.Timeout <= math.MaxInt32
 	default:
 		intval, ok = value.(wasi.IntValue)
 	}
@@ -870,6 +1683,13 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		if intval < 0 {
 			return wasi.EINVAL
 		}
+	case wasi.IpTypeOfService, wasi.Ipv6TrafficClass:
+		// The values are a byte of the IP header, the values out of range
+		// are rejected rather than truncated or interpreted differently on
+		// each platform.
+		if intval < 0 || intval > 255 {
+			return wasi.EINVAL
+		}
 	}
 
 	// Linux allows setting the socket buffer size to zero, but darwin does not,
@@ -895,6 +1715,14 @@ func (s *System) SockSetOpt(ctx context.Context, fd wasi.FD, option wasi.SocketO
 		err = ignoreEINTR(func() error {
 			return unix.SetsockoptTimeval(int(socket), sysLevel, sysOption, &tv)
 		})
+	case wasi.Linger:
+		l := unix.Linger{Linger: int32(linger.Timeout)}
+		if linger.Enabled {
+			l.Onoff = 1
+		}
+		err = ignoreEINTR(func() error {
+			return unix.SetsockoptLinger(int(socket), sysLevel, sysOption, &l)
+		})
 	default:
 		err = ignoreEINTR(func() error {
 			return unix.SetsockoptInt(int(socket), sysLevel, sysOption, int(intval))
@@ -1089,6 +1917,10 @@ func (s *System) Close(ctx context.Context) error {
 	if w != nil {
 		w.Close()
 	}
+	s.devices = nil
+	s.filestats = nil
+	s.shutdowns = nil
+	s.syncs = nil
 	return s.FileTable.Close(ctx)
 }
 
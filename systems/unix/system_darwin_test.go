@@ -0,0 +1,52 @@
+package unix_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func TestSystemFastSync(t *testing.T) {
+	for _, fastSync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FastSync=%t", fastSync), func(t *testing.T) {
+			ctx := context.Background()
+			dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := newSystem()
+			s.FastSync = fastSync
+			defer s.Close(ctx)
+
+			rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+				FileType:         wasi.DirectoryType,
+				RightsBase:       wasi.AllRights,
+				RightsInheriting: wasi.AllRights,
+			})
+
+			for _, flags := range []wasi.FDFlags{0, wasi.Sync, wasi.DSync} {
+				fd, errno := s.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, 0, flags)
+				if errno != wasi.ESUCCESS {
+					t.Fatal(errno)
+				}
+				if _, errno := s.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+					t.Errorf("fd_write with flags %s: %s", flags, errno)
+				}
+				if errno := s.FDSync(ctx, fd); errno != wasi.ESUCCESS {
+					t.Errorf("fd_sync: %s", errno)
+				}
+				if errno := s.FDDataSync(ctx, fd); errno != wasi.ESUCCESS {
+					t.Errorf("fd_datasync: %s", errno)
+				}
+				if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+					t.Fatal(errno)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package unix_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	sysunix "golang.org/x/sys/unix"
+)
+
+func TestSystemNoAtime(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	path := filepath.Join(tmp, "file")
+	if err := os.WriteFile(path, []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Move the access time far in the past so the kernel would update it on
+	// the next read, even with the relatime mount option.
+	atime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, atime, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	s.NoAtime = true
+	defer s.Close(ctx)
+
+	rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+
+	fd, errno := s.PathOpen(ctx, rootFD, 0, "file", 0, wasi.AllRights, wasi.AllRights, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 32)
+	if _, errno := s.FDRead(ctx, fd, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	stat, errno := s.FDFileStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.AccessTime != wasi.Timestamp(atime.UnixNano()) {
+		t.Errorf("access time was updated: want %v, got %v", atime, time.Unix(0, int64(stat.AccessTime)).UTC())
+	}
+}
+
+func TestSystemPollSubMillisecondTimeout(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		const timeout = 500 * time.Microsecond
+
+		subscriptions := []wasi.Subscription{subscribeTimeout(timeout)}
+		events := make([]wasi.Event, len(subscriptions))
+
+		// The smallest duration is measured over multiple iterations to limit
+		// the impact of the scheduling noise.
+		minElapsed := time.Duration(math.MaxInt64)
+		for i := 0; i < 10; i++ {
+			start := time.Now()
+			n, errno := p.PollOneOff(ctx, subscriptions, events)
+			elapsed := time.Since(start)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n != 1 || events[0].EventType != wasi.ClockEvent || events[0].Errno != wasi.ESUCCESS {
+				t.Fatalf("poll_oneoff: wrong events: %+v", events[:n])
+			}
+			if elapsed < timeout {
+				t.Fatalf("poll_oneoff: returned before the timeout: %v < %v", elapsed, timeout)
+			}
+			minElapsed = min(minElapsed, elapsed)
+		}
+
+		if minElapsed >= time.Millisecond {
+			t.Errorf("poll_oneoff: timeout was rounded up to milliseconds: %v", minElapsed)
+		}
+	})
+}
+
+func TestSystemPollInterrupted(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sysunix.Close(fds[1])
+		fd := p.Preopen(unix.FD(fds[0]), "pipe", wasi.FDStat{RightsBase: wasi.AllRights})
+
+		type result struct {
+			n     int
+			errno wasi.Errno
+		}
+		tids := make(chan int, 1)
+		results := make(chan result, 1)
+		subscriptions := []wasi.Subscription{subscribeFDRead(fd)}
+		events := make([]wasi.Event, len(subscriptions))
+		go func() {
+			// The signals must interrupt the thread blocked in poll(2).
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			tids <- sysunix.Gettid()
+			n, errno := p.PollOneOff(ctx, subscriptions, events)
+			results <- result{n, errno}
+		}()
+
+		tid := <-tids
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if err := sysunix.Tgkill(sysunix.Getpid(), tid, sysunix.SIGURG); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case r := <-results:
+			t.Fatalf("poll_oneoff returned after being interrupted: %+v %+v", r, events[:max(r.n, 0)])
+		default:
+		}
+
+		if _, err := sysunix.Write(fds[1], []byte("Hello, World!")); err != nil {
+			t.Fatal(err)
+		}
+		r := <-results
+		if r.errno != wasi.ESUCCESS {
+			t.Fatal(r.errno)
+		}
+		if !reflect.DeepEqual(events[:r.n], []wasi.Event{
+			{UserData: wasi.UserData(42 + fd), EventType: wasi.FDReadEvent},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:r.n])
+		}
+	})
+}
+
+func TestSystemReadDirCookieAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(filepath.Join(tmp, fmt.Sprintf("file-%d", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// readDir reads up to n entries from cookie with a new system, as if the
+	// guest had been restarted.
+	readDir := func(cookie wasi.DirCookie, n int) ([]string, wasi.DirCookie) {
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newSystem()
+		defer s.Close(ctx)
+		fd := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		var names []string
+		entries := make([]wasi.DirEntry, 1)
+		for len(names) < n {
+			rn, errno := s.FDReadDir(ctx, fd, entries, cookie, 1024)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if rn == 0 {
+				break
+			}
+			names = append(names, string(entries[0].Name))
+			cookie = entries[0].Next
+		}
+		return names, cookie
+	}
+
+	all, _ := readDir(0, 100)
+	if len(all) != 12 {
+		t.Fatalf("wrong number of entries: %q", all)
+	}
+
+	first, cookie := readDir(0, 5)
+	// Removing an entry that was already read must not shift the entries
+	// read from the cookie.
+	for _, name := range first {
+		if strings.HasPrefix(name, "file-") {
+			if err := os.Remove(filepath.Join(tmp, name)); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	second, _ := readDir(cookie, 100)
+
+	if !reflect.DeepEqual(append(first, second...), all) {
+		t.Errorf("reading in two chunks: wrong entries:\n%q + %q\nwant %q", first, second, all)
+	}
+}
+
+func TestSystemReadDirNonUTF8(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// Linux file names are arbitrary bytes, this one is "café" encoded in
+	// latin1 followed by a byte which is never valid in UTF-8.
+	name := "caf\xe9\xff"
+	if err := os.WriteFile(filepath.Join(tmp, name), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSystem()
+	defer s.Close(ctx)
+	fd := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+
+	var names []string
+	entries := make([]wasi.DirEntry, 1)
+	for cookie := wasi.DirCookie(0); ; {
+		n, errno := s.FDReadDir(ctx, fd, entries, cookie, 1024)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n == 0 {
+			break
+		}
+		if string(entries[0].Name) == name && entries[0].Type != wasi.RegularFileType {
+			t.Errorf("wrong type for the directory entry: %s", entries[0].Type)
+		}
+		names = append(names, string(entries[0].Name))
+		cookie = entries[0].Next
+	}
+	sort.Strings(names)
+	if want := []string{".", "..", name}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("wrong directory entries: want %q, got %q", want, names)
+	}
+
+	stat, errno := s.PathFileStatGet(ctx, fd, 0, name)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.Size != 5 {
+		t.Errorf("wrong file size: want 5, got %d", stat.Size)
+	}
+}
+
+func TestSystemPathOpenTemp(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmp, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+
+	fd, errno := s.PathOpenTemp(ctx, rootFD, "dir", wasi.FileRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := s.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 32)
+	n, errno := s.FDPread(ctx, fd, []wasi.IOVec{buf}, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "Hello, World!" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+
+	entries, err := os.ReadDir(filepath.Join(tmp, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the temporary file has a directory entry: %v", entries)
+	}
+
+	// The arguments are checked like the ones of path_open.
+	for _, path := range []string{"..", "../", "dir/../.."} {
+		if _, errno := s.PathOpenTemp(ctx, rootFD, path, wasi.FileRights); errno != wasi.EPERM {
+			t.Errorf("path_open_temp %q outside of the preopen: want EPERM, got %s", path, errno)
+		}
+		if _, errno := s.PathOpen(ctx, rootFD, 0, path, wasi.OpenDirectory, wasi.DirectoryRights, 0, 0); errno != wasi.EPERM {
+			t.Errorf("path_open %q outside of the preopen: want EPERM, got %s", path, errno)
+		}
+	}
+	s.StrictPaths = true
+	if _, errno := s.PathOpenTemp(ctx, rootFD, "dir/../..", wasi.FileRights); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_open_temp with strict paths: want ENOTCAPABLE, got %s", errno)
+	}
+	s.StrictPaths = false
+	readOnly := s.Preopen(unix.FD(dirfd), "/ro", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.DirectoryRights &^ wasi.PathCreateFileRight,
+		RightsInheriting: wasi.AllRights,
+	})
+	if _, errno := s.PathOpenTemp(ctx, readOnly, "dir", wasi.FileRights); errno != wasi.ENOTCAPABLE {
+		t.Errorf("path_open_temp without the right to create files: want ENOTCAPABLE, got %s", errno)
+	}
+	if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+}
+
+func TestSystemCrossDeviceRename(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	dst, err := os.MkdirTemp("/dev/shm", "wasi-go-")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.RemoveAll(dst)
+
+	var srcStat, dstStat sysunix.Stat_t
+	if err := sysunix.Stat(src, &srcStat); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysunix.Stat(dst, &dstStat); err != nil {
+		t.Fatal(err)
+	}
+	if srcStat.Dev == dstStat.Dev {
+		t.Skip("the temporary directories are on the same device")
+	}
+
+	path := filepath.Join(src, "file")
+	if err := os.WriteFile(path, []byte("Hello, World!"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	preopen := func(dir string) wasi.FD {
+		dirfd, err := sysunix.Open(dir, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Preopen(unix.FD(dirfd), dir, wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+	}
+	srcFD, dstFD := preopen(src), preopen(dst)
+
+	if errno := s.PathRename(ctx, srcFD, "file", dstFD, "file"); errno != wasi.EXDEV {
+		t.Fatalf("path_rename without CrossDeviceRename: want EXDEV, got %s", errno)
+	}
+
+	s.CrossDeviceRename = true
+	if errno := s.PathRename(ctx, srcFD, "file", dstFD, "moved"); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the source file was not removed: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello, World!" {
+		t.Errorf("wrong file content: %q", b)
+	}
+	info, err := os.Stat(filepath.Join(dst, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("wrong file mode: %s", info.Mode())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("wrong modification time: %v", info.ModTime())
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("wrong directory entries: %v", entries)
+	}
+}
+
+func TestSystemFullWrites(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Use the smallest pipe buffer so the writes are short.
+		if _, err := sysunix.FcntlInt(uintptr(fds[1]), sysunix.F_SETPIPE_SZ, 4096); err != nil {
+			t.Fatal(err)
+		}
+		if err := sysunix.SetNonblock(fds[1], true); err != nil {
+			t.Fatal(err)
+		}
+		fd := p.Preopen(unix.FD(fds[1]), "pipe", wasi.FDStat{
+			FileType:   wasi.CharacterDeviceType,
+			Flags:      wasi.NonBlock,
+			RightsBase: wasi.AllRights,
+		})
+
+		data := make([]byte, 4*4096)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		iovecs := []wasi.IOVec{data[:1000], data[1000:5000], data[5000:]}
+
+		// Without FullWrites, the write stops when the pipe buffer is full.
+		n, errno := p.FDWrite(ctx, fd, iovecs)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if int(n) >= len(data) {
+			t.Fatalf("fd_write: expected a short write, wrote %d bytes", n)
+		}
+		r := os.NewFile(uintptr(fds[0]), "pipe")
+		defer r.Close()
+		if _, err := io.ReadFull(r, make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		p.FullWrites = true
+		done := make(chan []byte)
+		go func() {
+			b := make([]byte, len(data))
+			if _, err := io.ReadFull(r, b); err != nil {
+				t.Error(err)
+			}
+			done <- b
+		}()
+
+		n, errno = p.FDWrite(ctx, fd, iovecs)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if int(n) != len(data) {
+			t.Errorf("fd_write: wrong number of bytes written: want %d, got %d", len(data), n)
+		}
+		if b := <-done; !reflect.DeepEqual(b, data) {
+			t.Error("the data read from the pipe does not match the data written")
+		}
+	})
+}
+
+func TestSystemPathOpenDirectoryPathOnly(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmp, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "dir", "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+
+	const pathRights = wasi.PathOpenRight | wasi.PathFileStatGetRight | wasi.FDFileStatGetRight
+	fd, errno := s.PathOpen(ctx, rootFD, 0, "dir", wasi.OpenDirectory, pathRights, wasi.FileRights, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	hostfd, _, errno := s.LookupFD(fd, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	fl, err := sysunix.FcntlInt(uintptr(hostfd), sysunix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (fl & sysunix.O_PATH) == 0 {
+		t.Errorf("directory not opened with O_PATH: flags=%#o", fl)
+	}
+
+	stat, errno := s.FDFileStatGet(ctx, fd)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.FileType != wasi.DirectoryType {
+		t.Errorf("wrong file type: want %s, got %s", wasi.DirectoryType, stat.FileType)
+	}
+	stat, errno = s.PathFileStatGet(ctx, fd, 0, "file")
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if stat.Size != 13 {
+		t.Errorf("wrong file size: want 13, got %d", stat.Size)
+	}
+
+	file, errno := s.PathOpen(ctx, fd, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 32)
+	n, errno := s.FDRead(ctx, file, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "Hello, World!" {
+		t.Errorf("wrong file content: %q", buf[:n])
+	}
+
+	// Directories opened with the right to list their entries are still
+	// opened for reading.
+	dir, errno := s.PathOpen(ctx, rootFD, 0, "dir", wasi.OpenDirectory, pathRights|wasi.FDReadDirRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	hostfd, _, errno = s.LookupFD(dir, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	fl, err = sysunix.FcntlInt(uintptr(hostfd), sysunix.F_GETFL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (fl & sysunix.O_PATH) != 0 {
+		t.Errorf("directory with read rights opened with O_PATH: flags=%#o", fl)
+	}
+}
+
+func TestSystemPathXattr(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+
+	errno := s.PathSetXattr(ctx, rootFD, 0, "file", "user.wasi", []byte("hello"))
+	if errno == wasi.ENOTSUP {
+		t.Skip("the file system does not support user extended attributes")
+	}
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	n, errno := s.PathGetXattr(ctx, rootFD, 0, "file", "user.wasi", nil)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if n != 5 {
+		t.Errorf("wrong xattr size: want 5, got %d", n)
+	}
+	value := make([]byte, 32)
+	n, errno = s.PathGetXattr(ctx, rootFD, 0, "file", "user.wasi", value)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(value[:n]) != "hello" {
+		t.Errorf("wrong xattr value: %q", value[:n])
+	}
+	if _, errno := s.PathGetXattr(ctx, rootFD, 0, "file", "user.wasi", value[:2]); errno != wasi.ERANGE {
+		t.Errorf("reading xattr into a short buffer: want %s, got %s", wasi.ERANGE, errno)
+	}
+	if _, errno := s.PathGetXattr(ctx, rootFD, 0, "file", "user.missing", value); errno != wasi.ENOENT {
+		t.Errorf("reading a missing xattr: want %s, got %s", wasi.ENOENT, errno)
+	}
+
+	names := make([]byte, 256)
+	n, errno = s.PathListXattr(ctx, rootFD, 0, "file", names)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if !strings.Contains(string(names[:n]), "user.wasi\x00") {
+		t.Errorf("xattr missing from the list: %q", names[:n])
+	}
+
+	if errno := s.FDStatSetRights(ctx, rootFD, wasi.AllRights&^(wasi.PathFileStatGetRight|wasi.PathFileStatSetTimesRight), wasi.AllRights); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if _, errno := s.PathGetXattr(ctx, rootFD, 0, "file", "user.wasi", value); errno != wasi.ENOTCAPABLE {
+		t.Errorf("reading xattr without rights: want %s, got %s", wasi.ENOTCAPABLE, errno)
+	}
+	if errno := s.PathSetXattr(ctx, rootFD, 0, "file", "user.wasi", nil); errno != wasi.ENOTCAPABLE {
+		t.Errorf("setting xattr without rights: want %s, got %s", wasi.ENOTCAPABLE, errno)
+	}
+	if _, errno := s.PathListXattr(ctx, rootFD, 0, "file", names); errno != wasi.ENOTCAPABLE {
+		t.Errorf("listing xattrs without rights: want %s, got %s", wasi.ENOTCAPABLE, errno)
+	}
+}
+
+func TestSystemDetectReadOnlyFS(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	if err := sysunix.Mount("tmpfs", tmp, "tmpfs", 0, ""); err != nil {
+		t.Skip("mounting a file system requires privileges:", err)
+	}
+	defer sysunix.Unmount(tmp, sysunix.MNT_DETACH)
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysunix.Mount("tmpfs", tmp, "tmpfs", sysunix.MS_REMOUNT|sysunix.MS_RDONLY, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSystem()
+	defer s.Close(ctx)
+	preopen := func() wasi.FD {
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+	}
+
+	// The host reports EROFS, unless another error is detected first.
+	rootFD := preopen()
+	if _, errno := s.PathOpen(ctx, rootFD, 0, "file", 0, wasi.FDWriteRight, 0, 0); errno != wasi.EROFS {
+		t.Errorf("path_open: want EROFS, got %s", errno)
+	}
+	if errno := s.PathCreateDirectory(ctx, rootFD, "file"); errno != wasi.EEXIST {
+		t.Errorf("path_create_directory: want EEXIST, got %s", errno)
+	}
+
+	s.DetectReadOnlyFS = true
+	rootFD = preopen()
+	for name, errno := range map[string]wasi.Errno{
+		"path_create_directory":   s.PathCreateDirectory(ctx, rootFD, "file"),
+		"path_filestat_set_times": s.PathFileStatSetTimes(ctx, rootFD, 0, "file", 0, 0, wasi.AccessTime),
+		"path_link":               s.PathLink(ctx, rootFD, 0, "file", rootFD, "link"),
+		"path_remove_directory":   s.PathRemoveDirectory(ctx, rootFD, "dir"),
+		"path_rename":             s.PathRename(ctx, rootFD, "file", rootFD, "renamed"),
+		"path_symlink":            s.PathSymlink(ctx, "file", rootFD, "symlink"),
+		"path_unlink_file":        s.PathUnlinkFile(ctx, rootFD, "file"),
+	} {
+		if errno != wasi.EROFS {
+			t.Errorf("%s: want EROFS, got %s", name, errno)
+		}
+	}
+
+	fd, errno := s.PathOpen(ctx, rootFD, 0, "file", 0, wasi.FDReadRight, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 32)
+	n, errno := s.FDRead(ctx, fd, []wasi.IOVec{buf})
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if string(buf[:n]) != "Hello, World!" {
+		t.Errorf("fd_read: wrong content: %q", buf[:n])
+	}
+
+	if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	// The detection is forgotten when the directory is closed, even if its
+	// file descriptor is reused.
+	if errno := s.FDClose(ctx, rootFD); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DetectReadOnlyFS = false
+	tmpFD := s.Preopen(unix.FD(dirfd), "/tmp", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
+	})
+	if tmpFD != rootFD {
+		t.Fatalf("the file descriptor was not reused: want %d, got %d", rootFD, tmpFD)
+	}
+	if errno := s.PathCreateDirectory(ctx, tmpFD, "dir"); errno != wasi.ESUCCESS {
+		t.Errorf("path_create_directory: %s", errno)
+	}
+}
+
+func TestSystemMaxListenBacklog(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		for _, test := range []struct {
+			max, backlog, want int
+		}{
+			{max: 0, backlog: 128, want: 128},
+			{max: 1, backlog: 128, want: 1},
+			{max: 16, backlog: 8, want: 8},
+		} {
+			p.MaxListenBacklog = test.max
+
+			server, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if _, errno := p.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}}); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if errno := p.SockListen(ctx, server, test.backlog); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			hostfd, _, errno := p.LookupFD(server, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			// On listening sockets, Linux reports the backlog in the
+			// tcpi_sacked field of TCP_INFO.
+			info, err := sysunix.GetsockoptTCPInfo(int(hostfd), sysunix.IPPROTO_TCP, sysunix.TCP_INFO)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := int(info.Sacked); got != test.want {
+				t.Errorf("listen(%d) with MaxListenBacklog=%d: want backlog %d, got %d", test.backlog, test.max, test.want, got)
+			}
+			if errno := p.FDClose(ctx, server); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+	})
+}
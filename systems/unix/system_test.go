@@ -1,13 +1,15 @@
 package unix_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"syscall"
 	"testing"
 	"testing/fstest"
@@ -16,7 +18,6 @@ import (
 	"github.com/stealthrocket/wasi-go"
 	"github.com/stealthrocket/wasi-go/systems/unix"
 	"github.com/stealthrocket/wasi-go/wasitest"
-	"github.com/tetratelabs/wazero/sys"
 	sysunix "golang.org/x/sys/unix"
 )
 
@@ -47,230 +48,2152 @@ func TestFS(t *testing.T) {
 }
 
 func TestSystem(t *testing.T) {
-	wasitest.TestSystem(t, makeSystem)
+	wasitest.TestSystem(t, wasitest.MakeUnixSystem)
+}
+
+func TestSystemWithCacheFileStat(t *testing.T) {
+	wasitest.TestSystem(t, func(config wasitest.TestConfig) (wasi.System, error) {
+		s, err := wasitest.MakeUnixSystem(config)
+		if err != nil {
+			return nil, err
+		}
+		s.(*unix.System).CacheFileStat = true
+		return s, nil
+	})
 }
 
 func TestWASIP1(t *testing.T) {
 	files, _ := filepath.Glob("../testdata/*/*.wasm")
-	wasitest.TestWASIP1(t, files, makeSystem)
+	wasitest.TestWASIP1(t, files, wasitest.MakeUnixSystem)
 }
 
-func makeSystem(config wasitest.TestConfig) (wasi.System, error) {
-	s := &unix.System{
-		Args:    config.Args,
-		Environ: config.Environ,
-		Rand:    config.Rand,
-		Yield: func(ctx context.Context) error {
-			return nil
-		},
-		Exit: func(ctx context.Context, code int) error {
-			panic(sys.NewExitError(uint32(code)))
-		},
-		Raise: func(ctx context.Context, code int) error {
-			panic(sys.NewExitError(127 + uint32(code)))
-		},
+func pipe() (fds [2]int, err error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	if err := sysunix.Pipe(fds[:]); err != nil {
+		return fds, err
 	}
-	s.MaxOpenFiles = config.MaxOpenFiles
-	s.MaxOpenDirs = config.MaxOpenDirs
-	defer func() {
-		if s != nil {
-			s.Close(context.Background())
+	sysunix.CloseOnExec(fds[0])
+	sysunix.CloseOnExec(fds[1])
+	return fds, nil
+}
+
+func TestSystemPollAndShutdown(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		if err := p.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// This call should block forever, unless async shutdown works, which is
+		// what we are testing here.
+		subscriptions := []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeFDRead(1),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeFDRead(1),
+		}) {
+			t.Error("poll_oneoff: altered subscriptions")
+		}
+
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 42, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
+			{UserData: 43, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events)
+		}
+	})
+}
+
+func TestSystemPollBadFileDescriptor(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		subscriptions := []wasi.Subscription{
+			subscribeFDRead(0),
+			// Subscribe to a file descriptor which is not registered in the
+			// system. This must not fail the poll_oneoff call and instead
+			// report an error on the
+			subscribeFDRead(42),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, err := p.PollOneOff(ctx, subscriptions, events)
+		if err != wasi.ESUCCESS {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeFDRead(42),
+		}) {
+			t.Error("poll_oneoff: altered subscriptions")
+		}
+
+		if n != 1 {
+			t.Errorf("poll_oneoff: wrong number of events: %d", n)
+		} else if !reflect.DeepEqual(events[0], wasi.Event{
+			UserData:  84,
+			EventType: wasi.FDReadEvent,
+			Errno:     wasi.EBADF,
+		}) {
+			t.Errorf("poll_oneoff: wrong event (0): %+v", events[0])
+		}
+	})
+}
+
+func TestSystemPollHangup(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// fd0 and fd1 are the read and write ends of a pipe.
+		if _, errno := p.FDWrite(ctx, 1, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.FDClose(ctx, 1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		subscriptions := []wasi.Subscription{subscribeFDRead(0)}
+		events := make([]wasi.Event, len(subscriptions))
+
+		// Data is still buffered in the pipe, the hangup must not be
+		// reported until it has been drained.
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 42, EventType: wasi.FDReadEvent},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+
+		buf := make([]byte, 32)
+		if rn, errno := p.FDRead(ctx, 0, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		} else if string(buf[:rn]) != "Hello, World!" {
+			t.Fatalf("wrong data read from the pipe: %q", buf[:rn])
+		}
+
+		n, errno = p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 42, EventType: wasi.FDReadEvent, FDReadWrite: wasi.EventFDReadWrite{Flags: wasi.Hangup}},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+}
+
+func TestSystemPollInterleavedSubscriptions(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		preopenPipe := func(name string) (r, w wasi.FD) {
+			fds, err := pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := wasi.FDStat{FileType: wasi.CharacterDeviceType, RightsBase: wasi.AllRights}
+			r = p.Preopen(unix.FD(fds[0]), name+".r", stat)
+			w = p.Preopen(unix.FD(fds[1]), name+".w", stat)
+			return r, w
+		}
+		r1, w1 := preopenPipe("pipe-1")
+		r2, w2 := preopenPipe("pipe-2")
+		for _, fd := range []wasi.FD{w1, w2} {
+			if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+
+		subscribe := func(userData wasi.UserData, eventType wasi.EventType, fd wasi.FD) wasi.Subscription {
+			return wasi.MakeSubscriptionFDReadWrite(userData, eventType, wasi.SubscriptionFDReadWrite{FD: fd})
+		}
+		subscribeClock := func(userData wasi.UserData, timeout time.Duration) wasi.Subscription {
+			return wasi.MakeSubscriptionClock(userData, wasi.SubscriptionClock{
+				ID:      wasi.Monotonic,
+				Timeout: wasi.Timestamp(timeout),
+			})
+		}
+		// The clock subscriptions do not expire, the pipe fd0 has no data
+		// to read, and fd 100 does not exist.
+		subscriptions := []wasi.Subscription{
+			subscribeClock(1, time.Hour),
+			subscribe(2, wasi.FDReadEvent, r1),
+			subscribe(3, wasi.FDReadEvent, 0),
+			subscribeClock(4, 2*time.Hour),
+			subscribe(5, wasi.FDReadEvent, 100),
+			subscribe(6, wasi.FDWriteEvent, 1),
+			subscribeClock(7, 3*time.Hour),
+			subscribe(8, wasi.FDReadEvent, r2),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 2, EventType: wasi.FDReadEvent},
+			{UserData: 5, EventType: wasi.FDReadEvent, Errno: wasi.EBADF},
+			{UserData: 6, EventType: wasi.FDWriteEvent},
+			{UserData: 8, EventType: wasi.FDReadEvent},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+}
+
+func TestSystemPollRegularFile(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		f, err := os.CreateTemp(t.TempDir(), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		hostfd, err := sysunix.Dup(int(f.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd := p.Preopen(unix.FD(hostfd), "file", wasi.FDStat{
+			FileType:   wasi.RegularFileType,
+			RightsBase: wasi.AllRights,
+		})
+
+		// The pipe has no data to read, the poll must not wait for it
+		// since the file is readable.
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(1, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: 0}),
+			wasi.MakeSubscriptionFDReadWrite(2, wasi.FDReadEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+			wasi.MakeSubscriptionFDReadWrite(3, wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: fd}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 2, EventType: wasi.FDReadEvent},
+			{UserData: 3, EventType: wasi.FDWriteEvent},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+}
+
+func TestSystemPollHangupSocket(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		fds, err := sysunix.Socketpair(sysunix.AF_UNIX, sysunix.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sock := p.Register(unix.FD(fds[0]), wasi.FDStat{
+			FileType:   wasi.SocketStreamType,
+			RightsBase: wasi.AllRights,
+		})
+		sysunix.Close(fds[1])
+
+		subscriptions := []wasi.Subscription{subscribeFDRead(sock)}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		// The peer is gone but the socket remains readable (read returns
+		// EOF), so the hangup is not reported on the event.
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: subscriptions[0].UserData, EventType: wasi.FDReadEvent},
+		}) {
+			t.Fatalf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+
+		buf := make([]byte, 32)
+		if rn, _, errno := p.SockRecv(ctx, sock, []wasi.IOVec{buf}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		} else if rn != 0 {
+			t.Fatalf("wrong number of bytes read from the socket: %d", rn)
+		}
+	})
+}
+
+func TestSystemAcceptOptions(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.AcceptKeepAlive = true
+		p.AcceptNoDelay = true
+
+		server, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		addr, errno := p.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.SockListen(ctx, server, 128); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		client, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.SockConnect(ctx, client, addr); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		conn, _, _, errno := p.SockAccept(ctx, server, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		for _, option := range []wasi.SocketOption{wasi.KeepAlive, wasi.TcpNoDelay} {
+			if v, errno := p.SockGetOpt(ctx, conn, option); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			} else if v.(wasi.IntValue) == 0 {
+				t.Errorf("%s is not enabled on the accepted socket", option)
+			}
+			// The guest may override the defaults.
+			if errno := p.SockSetOpt(ctx, conn, option, wasi.IntValue(0)); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if v, errno := p.SockGetOpt(ctx, conn, option); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			} else if v.(wasi.IntValue) != 0 {
+				t.Errorf("%s was not disabled on the accepted socket", option)
+			}
+			// The client socket is not affected.
+			if v, errno := p.SockGetOpt(ctx, client, option); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			} else if v.(wasi.IntValue) != 0 {
+				t.Errorf("%s is enabled on the client socket", option)
+			}
+		}
+	})
+}
+
+func TestSystemSockRecvDontWait(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		server, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		addr, errno := p.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.SockListen(ctx, server, 128); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		client, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.SockConnect(ctx, client, addr); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		// The accepted socket is in blocking mode.
+		conn, _, _, errno := p.SockAccept(ctx, server, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		buf := make([]byte, 32)
+		dontWait := unix.WithDontWait(ctx)
+		if _, _, errno := p.SockRecv(dontWait, conn, []wasi.IOVec{buf}, 0); errno != wasi.EAGAIN {
+			t.Fatalf("sock_recv: want EAGAIN, got %s", errno)
+		}
+		if _, _, _, errno := p.SockRecvFrom(dontWait, conn, []wasi.IOVec{buf}, 0); errno != wasi.EAGAIN {
+			t.Fatalf("sock_recv_from: want EAGAIN, got %s", errno)
+		}
+		stat, errno := p.FDStatGet(ctx, conn)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.Flags.Has(wasi.NonBlock) {
+			t.Error("the socket was put in non-blocking mode")
+		}
+
+		if _, errno := p.SockSend(dontWait, client, []wasi.IOVec{[]byte("Hello, World!")}, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		// Without the option, the call blocks until the data is received.
+		n, _, errno := p.SockRecv(ctx, conn, []wasi.IOVec{buf}, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "Hello, World!" {
+			t.Errorf("sock_recv: wrong data: %q", buf[:n])
+		}
+	})
+}
+
+func TestSystemPollClosedHostFileDescriptor(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// The first call to poll_oneoff creates the pipe used to wake up
+		// the system on shutdown; make sure it exists before closing the
+		// host file descriptor so it does not reuse its number.
+		events := make([]wasi.Event, 2)
+		if _, errno := p.PollOneOff(ctx, []wasi.Subscription{subscribeTimeout(0)}, events); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		fds, err := pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sysunix.Close(fds[1])
+		fd := p.Register(unix.FD(fds[0]), wasi.FDStat{RightsBase: wasi.AllRights})
+		sysunix.Close(fds[0])
+
+		// The clock subscription guarantees that the test does not block
+		// forever if POLLNVAL is not reported.
+		subscriptions := []wasi.Subscription{
+			subscribeFDRead(fd),
+			subscribeTimeout(10 * time.Second),
+		}
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: subscriptions[0].UserData, EventType: wasi.FDReadEvent, Errno: wasi.EBADF},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+
+		// The host file descriptor was already closed.
+		if errno := p.FDClose(ctx, fd); errno != wasi.EBADF {
+			t.Errorf("close: want %s, got %s", wasi.EBADF, errno)
+		}
+	})
+}
+
+func TestSystemPollMissingMonotonicClock(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.Monotonic = nil
+
+		subscriptions := []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeTimeout(1 * time.Second),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, err := p.PollOneOff(ctx, subscriptions, events)
+		if err != wasi.ESUCCESS {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
+			subscribeFDRead(0),
+			subscribeTimeout(1 * time.Second),
+		}) {
+			t.Error("poll_oneoff: altered subscriptions")
+		}
+
+		if n != 1 {
+			t.Errorf("poll_oneoff: wrong number of events: %d", n)
+		} else if !reflect.DeepEqual(events[0], wasi.Event{
+			UserData:  42,
+			EventType: wasi.ClockEvent,
+			Errno:     wasi.ENOTSUP,
+		}) {
+			t.Errorf("poll_oneoff: wrong event (0): %+v", events[0])
+		}
+	})
+}
+
+func TestSystemSchedYieldDefault(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		if errno := p.SchedYield(ctx); errno != wasi.ESUCCESS {
+			t.Errorf("sched_yield: want ESUCCESS, got %s", errno)
+		}
+	})
+}
+
+func TestSystemProcRaiseDefault(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		exitCode := -1
+		p.Exit = func(ctx context.Context, code int) error {
+			exitCode = code
+			return nil
+		}
+
+		if errno := p.ProcRaise(ctx, wasi.SIGCHLD); errno != wasi.ESUCCESS {
+			t.Errorf("proc_raise(SIGCHLD): want ESUCCESS, got %s", errno)
+		}
+		if exitCode != -1 {
+			t.Errorf("proc_raise(SIGCHLD): the signal was not ignored (exit code %d)", exitCode)
+		}
+
+		if errno := p.ProcRaise(ctx, wasi.SIGABRT); errno != wasi.ESUCCESS {
+			t.Errorf("proc_raise(SIGABRT): want ESUCCESS, got %s", errno)
+		}
+		if exitCode != 128+int(wasi.SIGABRT) {
+			t.Errorf("proc_raise(SIGABRT): wrong exit code: want %d, got %d", 128+int(wasi.SIGABRT), exitCode)
+		}
+	})
+}
+
+func TestSystemOnExit(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		var calls []string
+		p.OnExit = func(ctx context.Context, code int) {
+			calls = append(calls, fmt.Sprintf("OnExit(%d)", code))
+		}
+		p.Exit = func(ctx context.Context, code int) error {
+			calls = append(calls, fmt.Sprintf("Exit(%d)", code))
+			return nil
+		}
+
+		if errno := p.ProcExit(ctx, 1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.ProcRaise(ctx, wasi.SIGTERM); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		exitCode := 128 + int(syscall.SIGTERM)
+		want := []string{"OnExit(1)", "Exit(1)", fmt.Sprintf("OnExit(%d)", exitCode), fmt.Sprintf("Exit(%d)", exitCode)}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("wrong calls: want %q, got %q", want, calls)
+		}
+
+		// The hook is called even if the system has no exit function.
+		p.Exit, calls = nil, nil
+		if errno := p.ProcExit(ctx, 2); errno != wasi.ENOSYS {
+			t.Errorf("proc_exit: want ENOSYS, got %s", errno)
+		}
+		if !reflect.DeepEqual(calls, []string{"OnExit(2)"}) {
+			t.Errorf("wrong calls: %q", calls)
+		}
+	})
+}
+
+func TestSystemProcRaiseHostSignals(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		raised := -1
+		p.Raise = func(ctx context.Context, signal int) error {
+			raised = signal
+			return nil
+		}
+
+		for signal, want := range map[wasi.Signal]syscall.Signal{
+			wasi.SIGHUP:  syscall.SIGHUP,
+			wasi.SIGINT:  syscall.SIGINT,
+			wasi.SIGBUS:  syscall.SIGBUS,
+			wasi.SIGKILL: syscall.SIGKILL,
+			wasi.SIGUSR1: syscall.SIGUSR1,
+			wasi.SIGUSR2: syscall.SIGUSR2,
+			wasi.SIGTERM: syscall.SIGTERM,
+			wasi.SIGCHLD: syscall.SIGCHLD,
+			wasi.SIGSTOP: syscall.SIGSTOP,
+			wasi.SIGXCPU: syscall.SIGXCPU,
+			wasi.SIGPOLL: syscall.SIGIO,
+			wasi.SIGSYS:  syscall.SIGSYS,
+		} {
+			if errno := p.ProcRaise(ctx, signal); errno != wasi.ESUCCESS {
+				t.Errorf("proc_raise(%s): want ESUCCESS, got %s", signal.Name(), errno)
+			} else if raised != int(want) {
+				t.Errorf("proc_raise(%s): wrong host signal: want %d, got %d", signal.Name(), want, raised)
+			}
+		}
+
+		raised = -1
+		if errno := p.ProcRaise(ctx, wasi.SIGSYS+1); errno != wasi.EINVAL {
+			t.Errorf("proc_raise(%d): want EINVAL, got %s", wasi.SIGSYS+1, errno)
+		}
+		if raised != -1 {
+			t.Errorf("proc_raise(%d): Raise was called with %d", wasi.SIGSYS+1, raised)
+		}
+
+		p.Raise = nil
+		exitCode := -1
+		p.Exit = func(ctx context.Context, code int) error {
+			exitCode = code
+			return nil
+		}
+		if errno := p.ProcRaise(ctx, wasi.SIGXCPU); errno != wasi.ESUCCESS {
+			t.Errorf("proc_raise(SIGXCPU): want ESUCCESS, got %s", errno)
+		}
+		if exitCode != 128+int(syscall.SIGXCPU) {
+			t.Errorf("proc_raise(SIGXCPU): wrong exit code: want %d, got %d", 128+int(syscall.SIGXCPU), exitCode)
+		}
+	})
+}
+
+func TestSystemPollUnsupportedClock(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		subscribeClock := func(userData wasi.UserData, id wasi.ClockID) wasi.Subscription {
+			return wasi.MakeSubscriptionClock(userData, wasi.SubscriptionClock{ID: id})
+		}
+		subscriptions := []wasi.Subscription{
+			subscribeClock(1, wasi.ProcessCPUTimeID),
+			subscribeClock(2, wasi.ClockID(42)),
+			subscribeTimeout(0),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{
+			{UserData: 1, EventType: wasi.ClockEvent, Errno: wasi.ENOTSUP},
+			{UserData: 2, EventType: wasi.ClockEvent, Errno: wasi.EINVAL},
+			{UserData: 42, EventType: wasi.ClockEvent},
+		}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+}
+
+// FuzzSystemPollOneOff decodes each group of 4 bytes of the input as a
+// subscription, and verifies that the events reported by PollOneOff match the
+// subscriptions. A clock subscription is always added to bound the call.
+func FuzzSystemPollOneOff(f *testing.F) {
+	f.Add([]byte{0, 0, 10, 0})
+	f.Add([]byte{1, 0, 0, 0, 1, 0, 0, 0, 2, 1, 0, 0})
+	f.Add([]byte{1, 2, 0, 0, 2, 3, 0, 0, 1, 4, 0, 0})
+	f.Add([]byte{0, 0, 0, 0xff, 0, 0, 1, 0xfe})
+	f.Add([]byte{0, 1, 5, 0x0f, 4, 0, 5, 0x01})
+	f.Add([]byte{3, 0, 0, 0})
+	f.Add([]byte{3, 1, 0xfc, 0, 1, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		testSystem(func(ctx context.Context, p *unix.System) {
+			tmp := t.TempDir()
+			if err := os.WriteFile(filepath.Join(tmp, "file"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+				FileType:         wasi.DirectoryType,
+				RightsBase:       wasi.AllRights,
+				RightsInheriting: wasi.AllRights,
+			})
+			file, errno := p.PathOpen(ctx, rootFD, 0, "file", 0, wasi.AllRights, 0, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			closed, errno := p.PathOpen(ctx, rootFD, 0, "file", 0, wasi.AllRights, 0, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			p.FDClose(ctx, closed)
+			// fd0 and fd1 are the read and write ends of an empty pipe.
+			fds := []wasi.FD{0, 1, file, closed, 1000}
+
+			const maxSubscriptions = 16
+			var subscriptions []wasi.Subscription
+			invalid := false
+			for len(data) >= 4 && len(subscriptions) < maxSubscriptions {
+				kind, fdsel, timeout, flags := data[0], data[1], data[2], data[3]
+				data = data[4:]
+				userData := wasi.UserData(len(subscriptions))
+
+				switch kind % 5 {
+				case 0, 4:
+					c := wasi.SubscriptionClock{
+						ID:        wasi.ClockID(fdsel % 5),
+						Timeout:   wasi.Timestamp(timeout) * wasi.Timestamp(time.Microsecond),
+						Precision: wasi.Timestamp(flags&0x3) * wasi.Timestamp(time.Microsecond),
+					}
+					if (flags & 0x80) != 0 {
+						c.Timeout = math.MaxUint64 - c.Timeout
+					}
+					if (flags & 0x40) != 0 {
+						c.Precision = math.MaxUint64 - c.Precision
+					}
+					if kind%5 == 4 {
+						c.Flags = wasi.Abstime
+					}
+					subscriptions = append(subscriptions, wasi.MakeSubscriptionClock(userData, c))
+				case 1, 2:
+					eventType := wasi.FDReadEvent
+					if kind%5 == 2 {
+						eventType = wasi.FDWriteEvent
+					}
+					subscriptions = append(subscriptions, wasi.MakeSubscriptionFDReadWrite(userData, eventType,
+						wasi.SubscriptionFDReadWrite{FD: fds[int(fdsel)%len(fds)]},
+					))
+				case 3:
+					subscriptions = append(subscriptions, wasi.Subscription{
+						UserData:  userData,
+						EventType: wasi.EventType(3 + int(fdsel)%253),
+					})
+					invalid = true
+				}
+			}
+			subscriptions = append(subscriptions, subscribeTimeout(time.Millisecond))
+			subscriptions[len(subscriptions)-1].UserData = wasi.UserData(len(subscriptions) - 1)
+
+			events := make([]wasi.Event, len(subscriptions))
+			start := time.Now()
+			n, errno := p.PollOneOff(ctx, subscriptions, events)
+			elapsed := time.Since(start)
+
+			if invalid {
+				if errno != wasi.EINVAL {
+					t.Fatalf("poll_oneoff with an invalid event type: want EINVAL, got %s", errno)
+				}
+				return
+			}
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n < 1 || n > len(subscriptions) {
+				t.Fatalf("poll_oneoff: wrong number of events: %d", n)
+			}
+			seen := make(map[wasi.UserData]bool)
+			for _, e := range events[:n] {
+				if e.UserData >= wasi.UserData(len(subscriptions)) {
+					t.Fatalf("poll_oneoff: event for an unknown subscription: %+v", e)
+				}
+				if seen[e.UserData] {
+					t.Fatalf("poll_oneoff: duplicate events for subscription %d", e.UserData)
+				}
+				seen[e.UserData] = true
+				sub := subscriptions[e.UserData]
+				if e.EventType != sub.EventType {
+					t.Fatalf("poll_oneoff: wrong type for the event of subscription %d: want %s, got %s", e.UserData, sub.EventType, e.EventType)
+				}
+				if sub.EventType != wasi.ClockEvent || e.Errno != wasi.ESUCCESS {
+					continue
+				}
+				c := sub.GetClock()
+				if c.Flags.Has(wasi.Abstime) {
+					continue
+				}
+				// The clock subscriptions must not expire early, allowing
+				// for the granularity of the host timeouts. Timeouts which
+				// wrap around to negative durations are in the past.
+				if c.Timeout > math.MaxInt64 || c.Precision > math.MaxInt64-c.Timeout {
+					continue
+				}
+				if timeout := c.Timeout.Duration(); elapsed+time.Millisecond < timeout {
+					t.Fatalf("poll_oneoff: subscription %d expired early: timeout=%d elapsed=%v", e.UserData, c.Timeout, elapsed)
+				}
+			}
+		})
+	})
+}
+
+func TestSystemPreadPipe(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// fd0 and fd1 are the read and write ends of a pipe.
+		buf := make([]byte, 32)
+		if _, errno := p.FDPread(ctx, 0, []wasi.IOVec{buf}, 0); errno != wasi.ESPIPE {
+			t.Errorf("fd_pread: want ESPIPE, got %s", errno)
+		}
+		if _, errno := p.FDPwrite(ctx, 1, []wasi.IOVec{buf}, 0); errno != wasi.ESPIPE {
+			t.Errorf("fd_pwrite: want ESPIPE, got %s", errno)
+		}
+
+		socket, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.FDPread(ctx, socket, []wasi.IOVec{buf}, 0); errno != wasi.ESPIPE {
+			t.Errorf("fd_pread: want ESPIPE, got %s", errno)
+		}
+	})
+}
+
+func TestSystemPreStatDirectory(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd := p.Preopen(unix.FD(dirfd), "/tmp", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		stat, errno := p.FDPreStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(stat, wasi.PreStat{
+			Type:       wasi.PreOpenDir,
+			PreStatDir: wasi.PreStatDir{NameLength: 4},
+		}) {
+			t.Errorf("fd_prestat_get: wrong pre-open: %+v", stat)
+		}
+	})
+}
+
+func TestSystemClosePreopen(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirStat := wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		}
+		fd := p.Preopen(unix.FD(dirfd), "/tmp", dirStat)
+
+		if n := p.NumPreopens(); n != 3 {
+			t.Fatalf("wrong number of preopens: %d", n)
+		}
+		if name, errno := p.FDPreStatDirName(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		} else if name != "/tmp" {
+			t.Fatalf("wrong preopen name: %q", name)
+		}
+		if errno := p.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n := p.NumPreopens(); n != 2 {
+			t.Fatalf("wrong number of preopens after close: %d", n)
+		}
+
+		// The descriptor number is reused by the next file, which must not
+		// inherit the preopen state of the file that was closed.
+		dirfd, err = sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newfd := p.Register(unix.FD(dirfd), dirStat); newfd != fd {
+			t.Fatalf("descriptor was not reused: want %d, got %d", fd, newfd)
+		}
+		if _, errno := p.FDPreStatGet(ctx, fd); errno != wasi.EBADF {
+			t.Errorf("prestat of reused descriptor: want %s, got %s", wasi.EBADF, errno)
+		}
+		if errno := p.FDRenumber(ctx, fd, 10); errno != wasi.ESUCCESS {
+			t.Errorf("renumber of reused descriptor: %s", errno)
+		}
+		if errno := p.FDRenumber(ctx, 0, 11); errno != wasi.ENOTSUP {
+			t.Errorf("renumber of preopen: want %s, got %s", wasi.ENOTSUP, errno)
+		}
+	})
+}
+
+func TestSystemClosePreopenInterleaved(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// System is not safe for concurrent use, so the test interleaves the
+		// operations which could cause the preopen state to get out of sync
+		// with the file table: close, renumber, and preopen lookups.
+		dirStat := wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		}
+		openDir := func() unix.FD {
+			dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY|sysunix.O_CLOEXEC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return unix.FD(dirfd)
+		}
+
+		for i := 0; i < 10; i++ {
+			name := fmt.Sprintf("/tmp/%d", i)
+			preopen := p.Preopen(openDir(), name, dirStat)
+			file := p.Register(openDir(), dirStat)
+
+			if dir, errno := p.FDPreStatDirName(ctx, preopen); errno != wasi.ESUCCESS || dir != name {
+				t.Fatalf("prestat of preopen: %q, %s", dir, errno)
+			}
+			if errno := p.FDRenumber(ctx, file, preopen); errno != wasi.ENOTSUP {
+				t.Fatalf("renumber over preopen: want %s, got %s", wasi.ENOTSUP, errno)
+			}
+			if errno := p.FDClose(ctx, preopen); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			// Once closed, the descriptor number may be taken over by the
+			// renumbered file, which must not be seen as a preopen.
+			if errno := p.FDRenumber(ctx, file, preopen); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if _, errno := p.FDPreStatDirName(ctx, preopen); errno != wasi.EBADF {
+				t.Fatalf("prestat of renumbered file: want %s, got %s", wasi.EBADF, errno)
+			}
+			if _, errno := p.FDPreStatDirName(ctx, file); errno != wasi.EBADF {
+				t.Fatalf("prestat of renumbered source: want %s, got %s", wasi.EBADF, errno)
+			}
+			if n := p.NumPreopens(); n != 2 {
+				t.Fatalf("wrong number of preopens: %d", n)
+			}
+			if errno := p.FDClose(ctx, preopen); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+	})
+}
+
+func TestSystemSetArgsAndEnviron(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.Args = []string{"prog", "first"}
+		p.Environ = []string{"RUN=1"}
+
+		args, errno := p.ArgsGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(args, []string{"prog", "first"}) {
+			t.Errorf("wrong args: %q", args)
+		}
+
+		newArgs := []string{"prog", "second", "run"}
+		p.SetArgs(newArgs)
+		p.SetEnviron([]string{"RUN=2", "DEBUG=1"})
+		// The system must not retain the caller's slice.
+		newArgs[1] = "modified"
+
+		argCount, stringBytes, errno := p.ArgsSizesGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if argCount != 3 || stringBytes != len("prog\x00second\x00run\x00") {
+			t.Errorf("wrong args sizes: %d, %d", argCount, stringBytes)
+		}
+		args, errno = p.ArgsGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(args, []string{"prog", "second", "run"}) {
+			t.Errorf("wrong args: %q", args)
+		}
+
+		environ, errno := p.EnvironGet(ctx)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(environ, []string{"RUN=2", "DEBUG=1"}) {
+			t.Errorf("wrong environ: %q", environ)
+		}
+	})
+}
+
+func TestSystemVirtualCmdline(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.Args = []string{"prog", "-v", "hello world"}
+		p.VirtualDevices = true
+
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		if _, errno := p.PathOpen(ctx, rootFD, 0, "proc/self/cmdline", 0, wasi.FDWriteRight, 0, 0); errno != wasi.EACCES {
+			t.Errorf("opening cmdline for writing: want %s, got %s", wasi.EACCES, errno)
+		}
+
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "proc/self/cmdline", 0, wasi.FileRights&^wasi.FDWriteRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		var cmdline []byte
+		buf := make([]byte, 5)
+		for {
+			n, errno := p.FDRead(ctx, fd, []wasi.IOVec{buf})
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			cmdline = append(cmdline, buf[:n]...)
+		}
+
+		args := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+		if !reflect.DeepEqual(args, p.Args) {
+			t.Errorf("wrong cmdline: want %q, got %q", p.Args, args)
+		}
+
+		stat, errno := p.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.FileType != wasi.RegularFileType || stat.Size != wasi.FileSize(len(cmdline)) {
+			t.Errorf("wrong cmdline stat: %+v", stat)
+		}
+
+		if _, errno := p.PathOpen(ctx, rootFD, 0, "proc/self/cmdline", wasi.OpenTruncate, wasi.FDReadRight, 0, 0); errno != wasi.EACCES {
+			t.Errorf("opening cmdline with truncation: want %s, got %s", wasi.EACCES, errno)
+		}
+		if errno := p.FDAdvise(ctx, fd, 0, 0, wasi.Sequential); errno != wasi.ESUCCESS {
+			t.Errorf("fd_advise: %s", errno)
+		}
+		if errno := p.FDSync(ctx, fd); errno != wasi.ESUCCESS {
+			t.Errorf("fd_sync: %s", errno)
+		}
+		if errno := p.FDDataSync(ctx, fd); errno != wasi.ESUCCESS {
+			t.Errorf("fd_datasync: %s", errno)
+		}
+		if errno := p.FDStatSetFlags(ctx, fd, wasi.NonBlock); errno != wasi.ESUCCESS {
+			t.Errorf("fd_fdstat_set_flags: %s", errno)
+		}
+		if fdstat, errno := p.FDStatGet(ctx, fd); errno != wasi.ESUCCESS {
+			t.Error(errno)
+		} else if fdstat.Flags != wasi.NonBlock {
+			t.Errorf("wrong cmdline flags: want %s, got %s", wasi.NonBlock, fdstat.Flags)
+		}
+		if errno := p.FDAllocate(ctx, fd, 0, 1); errno != wasi.EBADF {
+			t.Errorf("fd_allocate: want %s, got %s", wasi.EBADF, errno)
+		}
+		if errno := p.FDFileStatSetSize(ctx, fd, 0); errno != wasi.EBADF {
+			t.Errorf("fd_filestat_set_size: want %s, got %s", wasi.EBADF, errno)
+		}
+		if errno := p.FDFileStatSetTimes(ctx, fd, 0, 0, wasi.AccessTimeNow); errno != wasi.EBADF {
+			t.Errorf("fd_filestat_set_times: want %s, got %s", wasi.EBADF, errno)
+		}
+
+		if errno := p.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.FDRead(ctx, fd, []wasi.IOVec{buf}); errno != wasi.EBADF {
+			t.Errorf("read after close: want %s, got %s", wasi.EBADF, errno)
+		}
+	})
+}
+
+func TestSystemVirtualFile(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		const resolvConf = "nameserver 127.0.0.1\n"
+		var paths []string
+		p.VirtualFile = func(path string) ([]byte, bool) {
+			paths = append(paths, path)
+			if path == "/etc/resolv.conf" {
+				return []byte(resolvConf), true
+			}
+			return nil, false
+		}
+
+		tmp := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tmp, "hosts"), []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		etcFD := p.Preopen(unix.FD(dirfd), "/etc", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		stat, errno := p.PathFileStatGet(ctx, etcFD, 0, "resolv.conf")
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.FileType != wasi.RegularFileType || stat.Size != wasi.FileSize(len(resolvConf)) {
+			t.Errorf("wrong resolv.conf stat: %+v", stat)
+		}
+
+		if _, errno := p.PathOpen(ctx, etcFD, 0, "resolv.conf", 0, wasi.FDWriteRight, 0, 0); errno != wasi.EACCES {
+			t.Errorf("opening resolv.conf for writing: want %s, got %s", wasi.EACCES, errno)
+		}
+		fd, errno := p.PathOpen(ctx, etcFD, 0, "./resolv.conf", 0, wasi.FileRights&^wasi.FDWriteRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		buf := make([]byte, 64)
+		n, errno := p.FDRead(ctx, fd, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != resolvConf {
+			t.Errorf("wrong resolv.conf content: %q", buf[:n])
+		}
+		if errno := p.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, err := os.Stat(filepath.Join(tmp, "resolv.conf")); !os.IsNotExist(err) {
+			t.Errorf("resolv.conf exists on the host: %v", err)
+		}
+
+		// Paths that are not claimed are resolved on the host.
+		fd, errno = p.PathOpen(ctx, etcFD, 0, "hosts", 0, wasi.FDReadRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		n, errno = p.FDRead(ctx, fd, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "127.0.0.1 localhost\n" {
+			t.Errorf("wrong hosts content: %q", buf[:n])
+		}
+		if _, errno := p.PathFileStatGet(ctx, etcFD, 0, "passwd"); errno != wasi.ENOENT {
+			t.Errorf("stat of a missing file: want %s, got %s", wasi.ENOENT, errno)
+		}
+
+		want := []string{"/etc/resolv.conf", "/etc/resolv.conf", "/etc/resolv.conf", "/etc/hosts", "/etc/passwd"}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("wrong intercepted paths: want %q, got %q", want, paths)
+		}
+	})
+}
+
+func TestSystemProcSelfFD(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		tmp := t.TempDir()
+		if err := os.Mkdir(filepath.Join(tmp, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		preopen := func(path string) wasi.FD {
+			dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p.Preopen(unix.FD(dirfd), path, wasi.FDStat{
+				FileType:         wasi.DirectoryType,
+				RightsBase:       wasi.AllRights,
+				RightsInheriting: wasi.AllRights,
+			})
+		}
+		rootFD := preopen("/")
+		dataFD := preopen("/data")
+
+		fd, errno := p.PathOpen(ctx, dataFD, 0, "dir/file", wasi.OpenCreate, wasi.FileRights, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		link := fmt.Sprintf("proc/self/fd/%d", fd)
+
+		buf := make([]byte, 64)
+		if _, errno := p.PathReadLink(ctx, rootFD, link, buf); errno != wasi.ENOENT {
+			t.Errorf("readlink without ProcSelfFD: want %s, got %s", wasi.ENOENT, errno)
+		}
+
+		p.ProcSelfFD = true
+		if _, errno := p.PathReadLink(ctx, dataFD, link, buf); errno != wasi.ENOENT {
+			t.Errorf("readlink relative to /data: want %s, got %s", wasi.ENOENT, errno)
+		}
+
+		n, errno := p.PathReadLink(ctx, rootFD, link, buf)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "/data/dir/file" {
+			t.Errorf("wrong link target: want %q, got %q", "/data/dir/file", buf[:n])
+		}
+		if n, errno := p.PathReadLink(ctx, rootFD, link, buf[:4]); errno != wasi.ERANGE || string(buf[:n]) != "/dat" {
+			t.Errorf("readlink with short buffer: want %q (%s), got %q (%s)", "/dat", wasi.ERANGE, buf[:n], errno)
+		}
+
+		// File descriptors with no known path, like sockets, and closed file
+		// descriptors have no link.
+		sock, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.SockListenRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		for _, name := range []string{fmt.Sprintf("proc/self/fd/%d", sock), "proc/self/fd/42", fmt.Sprintf("proc/self/fd/0%d", fd), "proc/self/fd/x"} {
+			if _, errno := p.PathReadLink(ctx, rootFD, name, buf); errno != wasi.ENOENT {
+				t.Errorf("readlink %s: want %s, got %s", name, wasi.ENOENT, errno)
+			}
+		}
+
+		if errno := p.FDStatSetRights(ctx, rootFD, wasi.AllRights&^wasi.PathReadLinkRight, wasi.AllRights); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.PathReadLink(ctx, rootFD, link, buf); errno != wasi.ENOTCAPABLE {
+			t.Errorf("readlink without right: want %s, got %s", wasi.ENOTCAPABLE, errno)
+		}
+	})
+}
+
+func TestSystemVirtualCharDevices(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.Rand = strings.NewReader("0123456789")
+		p.VirtualDevices = true
+
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		open := func(path string) wasi.FD {
+			t.Helper()
+			fd, errno := p.PathOpen(ctx, rootFD, 0, path, wasi.OpenTruncate, wasi.FileRights, 0, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("opening %s: %s", path, errno)
+			}
+			if stat, errno := p.FDFileStatGet(ctx, fd); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			} else if stat.FileType != wasi.CharacterDeviceType {
+				t.Errorf("wrong file type of %s: %s", path, stat.FileType)
+			}
+			return fd
+		}
+
+		buf := []byte("Hello, World!")
+		iovecs := []wasi.IOVec{buf[:5], buf[5:]}
+
+		zero := open("dev/zero")
+		if n, errno := p.FDRead(ctx, zero, iovecs); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		} else if n != wasi.Size(len(buf)) || string(buf) != string(make([]byte, len(buf))) {
+			t.Errorf("wrong data read from /dev/zero: %q", buf[:n])
+		}
+
+		null := open("dev/null")
+		if n, errno := p.FDWrite(ctx, null, iovecs); errno != wasi.ESUCCESS || n != wasi.Size(len(buf)) {
+			t.Errorf("writing to /dev/null: n=%d errno=%s", n, errno)
+		}
+		if n, errno := p.FDRead(ctx, null, iovecs); errno != wasi.ESUCCESS || n != 0 {
+			t.Errorf("reading from /dev/null: n=%d errno=%s", n, errno)
+		}
+		if errno := p.FDFileStatSetSize(ctx, null, 0); errno != wasi.EINVAL {
+			t.Errorf("fd_filestat_set_size: want %s, got %s", wasi.EINVAL, errno)
+		}
+
+		random := open("dev/urandom")
+		if n, errno := p.FDRead(ctx, random, []wasi.IOVec{buf[:4]}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		} else if string(buf[:n]) != "0123" {
+			t.Errorf("wrong data read from /dev/urandom: %q", buf[:n])
+		}
+	})
+}
+
+func TestSystemCacheFileStat(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.CacheFileStat = true
+
+		tmp := t.TempDir()
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		assertSize := func(size wasi.FileSize) {
+			t.Helper()
+			stat, errno := p.FDFileStatGet(ctx, fd)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if stat.Size != size {
+				t.Errorf("wrong file size: want %d, got %d", size, stat.Size)
+			}
+		}
+
+		assertSize(0)
+		// Modifications made outside of the guest are not observed while
+		// the cache is enabled.
+		if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assertSize(0)
+
+		if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		assertSize(13)
+		if errno := p.FDFileStatSetSize(ctx, fd, 5); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		assertSize(5)
+		if _, errno := p.FDPwrite(ctx, fd, []wasi.IOVec{[]byte("!")}, 9); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		assertSize(10)
+
+		p.CacheFileStat = false
+		if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assertSize(5)
+	})
+}
+
+func TestSystemFDDup(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// fd0 and fd1 are the read and write ends of a pipe.
+		fd, errno := p.FDDup(ctx, 1)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if fd == 1 {
+			t.Fatal("dup returned the same file descriptor")
+		}
+		if errno := p.FDStatSetRights(ctx, fd, wasi.FDWriteRight, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat, _ := p.FDStatGet(ctx, 1); stat.RightsBase != wasi.AllRights {
+			t.Errorf("rights of the original file descriptor changed: %s", stat.RightsBase)
+		}
+
+		// Closing the original must not close the duplicate.
+		if errno := p.FDClose(ctx, 1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		buf := make([]byte, 32)
+		n, errno := p.FDRead(ctx, 0, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "Hello, World!" {
+			t.Errorf("wrong data read from the pipe: %q", buf[:n])
+		}
+
+		if _, errno := p.FDDup(ctx, 42); errno != wasi.EBADF {
+			t.Errorf("dup of invalid file descriptor: want %s, got %s", wasi.EBADF, errno)
+		}
+	})
+}
+
+func TestSystemNonBlockingPipe(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// fd0 and fd1 are the read and write ends of a pipe.
+		for _, fd := range []wasi.FD{0, 1} {
+			if errno := p.FDStatSetFlags(ctx, fd, wasi.NonBlock); errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+
+		buf := make([]byte, 4096)
+		if _, errno := p.FDRead(ctx, 0, []wasi.IOVec{buf}); errno != wasi.EAGAIN {
+			t.Fatalf("reading from an empty pipe: want %s, got %s", wasi.EAGAIN, errno)
+		}
+
+		for {
+			_, errno := p.FDWrite(ctx, 1, []wasi.IOVec{buf})
+			if errno == wasi.EAGAIN {
+				break
+			}
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("writing to a full pipe: want %s, got %s", wasi.EAGAIN, errno)
+			}
+		}
+
+		// Draining the pipe makes it writable again.
+		subscriptions := []wasi.Subscription{
+			wasi.MakeSubscriptionFDReadWrite(42, wasi.FDWriteEvent, wasi.SubscriptionFDReadWrite{FD: 1}),
+		}
+		events := make([]wasi.Event, len(subscriptions))
+		for {
+			_, errno := p.FDRead(ctx, 0, []wasi.IOVec{buf})
+			if errno == wasi.EAGAIN {
+				break
+			}
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+		}
+		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if !reflect.DeepEqual(events[:n], []wasi.Event{{UserData: 42, EventType: wasi.FDWriteEvent}}) {
+			t.Errorf("poll_oneoff: wrong events: %+v", events[:n])
+		}
+	})
+}
+
+func TestSystemZeroLengthPipeIO(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		// fd0 and fd1 are the read and write ends of a blocking pipe, the
+		// zero-length reads complete immediately even though it is empty.
+		for _, iovecs := range [][]wasi.IOVec{nil, {}, {nil}, {{}, {}}} {
+			if n, errno := p.FDRead(ctx, 0, iovecs); errno != wasi.ESUCCESS || n != 0 {
+				t.Errorf("zero-length read: %d, %s", n, errno)
+			}
+			if n, errno := p.FDWrite(ctx, 1, iovecs); errno != wasi.ESUCCESS || n != 0 {
+				t.Errorf("zero-length write: %d, %s", n, errno)
+			}
+		}
+
+		if _, errno := p.FDWrite(ctx, 1, []wasi.IOVec{[]byte("x")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n, errno := p.FDRead(ctx, 0, []wasi.IOVec{{}}); errno != wasi.ESUCCESS || n != 0 {
+			t.Errorf("zero-length read: %d, %s", n, errno)
+		}
+		// The zero-length read did not consume the data.
+		buf := make([]byte, 2)
+		if n, errno := p.FDRead(ctx, 0, []wasi.IOVec{buf}); errno != wasi.ESUCCESS || string(buf[:n]) != "x" {
+			t.Errorf("read: %q, %s", buf[:n], errno)
+		}
+
+		// The rights are still checked.
+		if errno := p.FDStatSetRights(ctx, 1, 0, 0); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.FDWrite(ctx, 1, nil); errno != wasi.ENOTCAPABLE {
+			t.Errorf("zero-length write without rights: want %s, got %s", wasi.ENOTCAPABLE, errno)
+		}
+	})
+}
+
+func TestSystemFDs(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootStat := wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.FileRights,
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/tmp", rootStat)
+
+		// Closing fd1 frees its number for the file opened next.
+		if errno := p.FDClose(ctx, 1); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "./file", wasi.OpenCreate, wasi.FDReadRight, 0, wasi.Append)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		fds := p.FDs()
+		want := []wasi.FDInfo{
+			{FD: 0, Path: "fd0", Preopen: true, FDStat: wasi.FDStat{RightsBase: wasi.AllRights}},
+			{FD: 1, Path: "/tmp/file", FDStat: wasi.FDStat{
+				FileType:   wasi.RegularFileType,
+				Flags:      wasi.Append,
+				RightsBase: wasi.FDReadRight,
+			}},
+			{FD: rootFD, Path: "/tmp", Preopen: true, FDStat: rootStat},
+		}
+		if fd != 1 {
+			t.Fatalf("wrong file descriptor number: want 1, got %d", fd)
+		}
+		if !reflect.DeepEqual(fds, want) {
+			t.Errorf("wrong file descriptors:\nwant %+v\ngot  %+v", want, fds)
+		}
+
+		// The snapshot must not share memory with the file table.
+		fds[0].RightsBase = 0
+		if stat, _ := p.FDStatGet(ctx, 0); stat.RightsBase != wasi.AllRights {
+			t.Errorf("modifying the snapshot altered the file table: %s", stat.RightsBase)
+		}
+	})
+}
+
+func TestSystemFileStatSetTimesNow(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		now := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+		p.Realtime = func(context.Context) (uint64, error) {
+			return uint64(now.UnixNano()), nil
+		}
+
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		if errno := p.FDFileStatSetTimes(ctx, fd, 0, 0, wasi.ModifyTime|wasi.ModifyTimeNow); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.PathFileStatSetTimes(ctx, rootFD, 0, "file", 0, 0, wasi.AccessTime|wasi.AccessTimeNow); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		stat, errno := p.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		want := wasi.Timestamp(now.UnixNano())
+		if stat.AccessTime != want || stat.ModifyTime != want {
+			t.Errorf("times were not set from the realtime clock: want %d, got atime=%d mtime=%d", want, stat.AccessTime, stat.ModifyTime)
+		}
+
+		p.Realtime = nil
+		if errno := p.FDFileStatSetTimes(ctx, fd, 0, 0, wasi.ModifyTime|wasi.ModifyTimeNow); errno != wasi.ENOSYS {
+			t.Errorf("setting times without a realtime clock: want %s, got %s", wasi.ENOSYS, errno)
+		}
+	})
+}
+
+func TestSystemFileStatSetTimesOmit(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		const (
+			atime = wasi.Timestamp(1e18)
+			mtime = wasi.Timestamp(2e18)
+		)
+		if errno := p.FDFileStatSetTimes(ctx, fd, atime, mtime, wasi.AccessTime|wasi.ModifyTime); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		if errno := p.FDFileStatSetTimes(ctx, fd, atime+1e9, 0, wasi.AccessTime); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		stat, errno := p.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.AccessTime != atime+1e9 || stat.ModifyTime != mtime {
+			t.Errorf("fd_filestat_set_times: want atime=%d mtime=%d, got atime=%d mtime=%d", atime+1e9, mtime, stat.AccessTime, stat.ModifyTime)
+		}
+
+		if errno := p.PathFileStatSetTimes(ctx, rootFD, 0, "file", 0, mtime+1e9, wasi.ModifyTime); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		stat, errno = p.FDFileStatGet(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.AccessTime != atime+1e9 || stat.ModifyTime != mtime+1e9 {
+			t.Errorf("path_filestat_set_times: want atime=%d mtime=%d, got atime=%d mtime=%d", atime+1e9, mtime+1e9, stat.AccessTime, stat.ModifyTime)
+		}
+	})
+}
+
+func TestSystemWriteByteLimit(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.WriteByteLimit = 10
+
+		tmp := t.TempDir()
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.FDPwrite(ctx, fd, []wasi.IOVec{[]byte(", World!")}, 5); errno != wasi.EDQUOT {
+			t.Errorf("writing past the limit: want %s, got %s", wasi.EDQUOT, errno)
+		}
+		if errno := p.FDAllocate(ctx, fd, 0, 6); errno != wasi.EDQUOT {
+			t.Errorf("allocating past the limit: want %s, got %s", wasi.EDQUOT, errno)
+		}
+		// Writing to pipes does not count against the limit.
+		if _, errno := p.FDWrite(ctx, 1, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if n := p.BytesWritten(); n != 5 {
+			t.Errorf("wrong number of bytes written: want 5, got %d", n)
+		}
+
+		b, err := os.ReadFile(filepath.Join(tmp, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "Hello" {
+			t.Errorf("wrong file content: %q", b)
+		}
+
+		p.ResetBytesWritten()
+		if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte(", World!")}); errno != wasi.ESUCCESS {
+			t.Errorf("writing after resetting the limit: %s", errno)
+		}
+	})
+}
+
+func TestSystemUmask(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		p.Umask = 077
+
+		tmp := t.TempDir()
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.PathCreateDirectory(ctx, rootFD, "dir"); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		for name, want := range map[string]os.FileMode{"file": 0600, "dir": 0700} {
+			info, err := os.Stat(filepath.Join(tmp, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != want {
+				t.Errorf("wrong permissions of %s: want %s, got %s", name, want, mode)
+			}
+		}
+	})
+}
+
+func TestSystemLargeFileOffsets(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		tmp := t.TempDir()
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "sparse", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		defer p.FDClose(ctx, fd)
+
+		// The file is sparse, so it does not use 5GiB of disk space.
+		const size = 5 << 30
+		const offset = 4<<30 + 10
+		if errno := p.FDFileStatSetSize(ctx, fd, size); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		pos, errno := p.FDSeek(ctx, fd, offset, wasi.SeekStart)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if pos != offset {
+			t.Errorf("fd_seek: wrong position: want %d, got %d", offset, pos)
+		}
+		if _, errno := p.FDWrite(ctx, fd, []wasi.IOVec{[]byte("Hello, World!")}); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		pos, errno = p.FDTell(ctx, fd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if pos != offset+13 {
+			t.Errorf("fd_tell: wrong position: want %d, got %d", offset+13, pos)
+		}
+		pos, errno = p.FDSeek(ctx, fd, 0, wasi.SeekEnd)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if pos != size {
+			t.Errorf("fd_seek: wrong end position: want %d, got %d", size, pos)
+		}
+
+		buf := make([]byte, 13)
+		n, errno := p.FDPread(ctx, fd, []wasi.IOVec{buf}, offset)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "Hello, World!" {
+			t.Errorf("fd_pread: wrong data: %q", buf[:n])
+		}
+
+		if _, errno := p.FDPread(ctx, fd, []wasi.IOVec{buf}, math.MaxUint64); errno != wasi.EINVAL {
+			t.Errorf("fd_pread: want EINVAL, got %s", errno)
+		}
+		if _, errno := p.FDPwrite(ctx, fd, []wasi.IOVec{buf}, 1<<63); errno != wasi.EINVAL {
+			t.Errorf("fd_pwrite: want EINVAL, got %s", errno)
+		}
+		if errno := p.FDFileStatSetSize(ctx, fd, 1<<63); errno != wasi.EFBIG {
+			t.Errorf("fd_filestat_set_size: want EFBIG, got %s", errno)
+		}
+		if errno := p.FDAllocate(ctx, fd, math.MaxInt64, 1); errno != wasi.EFBIG {
+			t.Errorf("fd_allocate: want EFBIG, got %s", errno)
+		}
+	})
+}
+
+func TestSystemPathOpenLowestFD(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		tmp := t.TempDir()
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
 		}
-	}()
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
 
-	if now := config.Now; now != nil {
-		epoch := now()
-		s.Monotonic = func(context.Context) (uint64, error) {
-			return uint64(now().Sub(epoch)), nil
+		var fds []wasi.FD
+		for i := 0; i < 3; i++ {
+			fd, errno := p.PathOpen(ctx, rootFD, 0, fmt.Sprintf("file-%d", i), wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			fds = append(fds, fd)
 		}
-		s.MonotonicPrecision = time.Nanosecond
-		s.Realtime = func(context.Context) (uint64, error) {
-			return uint64(now().UnixNano()), nil
+		if errno := p.FDClose(ctx, fds[0]); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.FDClose(ctx, fds[1]); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
 		}
-		s.RealtimePrecision = time.Microsecond
-	}
 
-	stdin, err := pipe()
-	if err != nil {
-		return nil, err
-	}
-	if config.Stdin == nil {
-		sysunix.Close(stdin[1])
-	} else {
-		f := os.NewFile(uintptr(stdin[1]), "/dev/stdin")
-		go copyAndClose(f, config.Stdin)
-	}
-	s.Preopen(unix.FD(stdin[0]), "/dev/stdin", wasi.FDStat{
-		FileType:   wasi.CharacterDeviceType,
-		RightsBase: wasi.AllRights,
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file-0", 0, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if fd != fds[0] {
+			t.Errorf("path_open did not reuse the lowest file descriptor: want %d, got %d", fds[0], fd)
+		}
 	})
+}
 
-	stdout, err := pipe()
-	if err != nil {
-		return nil, err
-	}
-	if config.Stdout == nil {
-		sysunix.Close(stdout[0])
-	} else {
-		f := os.NewFile(uintptr(stdout[0]), "/dev/stdout")
-		go copyAndClose(config.Stdout, f)
-	}
-	s.Preopen(unix.FD(stdout[1]), "/dev/stdout", wasi.FDStat{
-		FileType:   wasi.CharacterDeviceType,
-		RightsBase: wasi.AllRights,
-	})
+func TestSystemReadDirSymlink(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		tmp := t.TempDir()
+		if err := os.Mkdir(filepath.Join(tmp, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("dir", filepath.Join(tmp, "link")); err != nil {
+			t.Fatal(err)
+		}
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:   wasi.DirectoryType,
+			RightsBase: wasi.AllRights,
+		})
 
-	stderr, err := pipe()
-	if err != nil {
-		return nil, err
-	}
-	if config.Stderr == nil {
-		sysunix.Close(stderr[0])
-	} else {
-		f := os.NewFile(uintptr(stderr[0]), "/dev/stderr")
-		go copyAndClose(config.Stderr, f)
-	}
-	s.Preopen(unix.FD(stderr[1]), "/dev/stderr", wasi.FDStat{
-		FileType:   wasi.CharacterDeviceType,
-		RightsBase: wasi.AllRights,
+		types := map[string]wasi.FileType{}
+		entries := make([]wasi.DirEntry, 8)
+		var cookie wasi.DirCookie
+		for {
+			n, errno := p.FDReadDir(ctx, fd, entries, cookie, 4096)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			for _, entry := range entries[:n] {
+				types[string(entry.Name)] = entry.Type
+			}
+			cookie = entries[n-1].Next
+		}
+
+		if types["dir"] != wasi.DirectoryType {
+			t.Errorf("dir: wrong type: %s", types["dir"])
+		}
+		if types["link"] != wasi.SymbolicLinkType {
+			t.Errorf("link: wrong type: %s", types["link"])
+		}
 	})
+}
 
-	if config.RootFS != "" {
-		rootFS, err := sysunix.Open(config.RootFS, sysunix.O_DIRECTORY, 0)
+func TestSystemDenyPaths(t *testing.T) {
+	testSystem(func(ctx context.Context, p *unix.System) {
+		tmp := t.TempDir()
+		for _, name := range []string{".env", "main.go"} {
+			if err := os.WriteFile(filepath.Join(tmp, name), []byte("SECRET=42"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
 		if err != nil {
-			return nil, err
+			t.Fatal(err)
 		}
-		s.Preopen(unix.FD(rootFS), "/", wasi.FDStat{
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
 			FileType:         wasi.DirectoryType,
 			RightsBase:       wasi.AllRights,
 			RightsInheriting: wasi.AllRights,
 		})
-	}
 
-	ret := s
-	s = nil
-	return ret, nil
-}
+		s := wasi.DenyPaths(p, ".env", ".git")
 
-func copyAndClose(w io.WriteCloser, r io.ReadCloser) {
-	defer w.Close()
-	defer r.Close()
-	_, _ = io.Copy(w, r)
-}
+		for _, name := range []string{".env", "./.env", "dir/../.env"} {
+			if _, errno := s.PathOpen(ctx, rootFD, 0, name, 0, wasi.AllRights, wasi.AllRights, 0); errno != wasi.ENOENT {
+				t.Errorf("path_open %q: want ENOENT, got %s", name, errno)
+			}
+			if _, errno := s.PathFileStatGet(ctx, rootFD, 0, name); errno != wasi.ENOENT {
+				t.Errorf("path_filestat_get %q: want ENOENT, got %s", name, errno)
+			}
+		}
+		if errno := s.PathUnlinkFile(ctx, rootFD, ".env"); errno != wasi.ENOENT {
+			t.Errorf("path_unlink_file: want ENOENT, got %s", errno)
+		}
+		if errno := s.PathRename(ctx, rootFD, ".env", rootFD, "env"); errno != wasi.ENOENT {
+			t.Errorf("path_rename: want ENOENT, got %s", errno)
+		}
+		if errno := s.PathRename(ctx, rootFD, "main.go", rootFD, ".git"); errno != wasi.EPERM {
+			t.Errorf("path_rename: want EPERM, got %s", errno)
+		}
+		if errno := s.PathSymlink(ctx, ".env", rootFD, "env"); errno != wasi.EPERM {
+			t.Errorf("path_symlink: want EPERM, got %s", errno)
+		}
+		if errno := s.PathCreateDirectory(ctx, rootFD, ".git"); errno != wasi.EPERM {
+			t.Errorf("path_create_directory: want EPERM, got %s", errno)
+		}
+		if _, errno := s.PathOpen(ctx, rootFD, 0, ".git/HEAD", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0); errno != wasi.EPERM {
+			t.Errorf("path_open: want EPERM, got %s", errno)
+		}
 
-func pipe() (fds [2]int, err error) {
-	syscall.ForkLock.RLock()
-	defer syscall.ForkLock.RUnlock()
-	if err := sysunix.Pipe(fds[:]); err != nil {
-		return fds, err
-	}
-	sysunix.CloseOnExec(fds[0])
-	sysunix.CloseOnExec(fds[1])
-	return fds, nil
+		fd, errno := s.PathOpen(ctx, rootFD, 0, "main.go", 0, wasi.AllRights, wasi.AllRights, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+
+		var names []string
+		entries := make([]wasi.DirEntry, 1)
+		for cookie := wasi.DirCookie(0); ; {
+			n, errno := s.FDReadDir(ctx, rootFD, entries, cookie, 1024)
+			if errno != wasi.ESUCCESS {
+				t.Fatal(errno)
+			}
+			if n == 0 {
+				break
+			}
+			for _, entry := range entries[:n] {
+				if name := string(entry.Name); name != "." && name != ".." {
+					names = append(names, name)
+				}
+				cookie = entry.Next
+			}
+		}
+		if !reflect.DeepEqual(names, []string{"main.go"}) {
+			t.Errorf("fd_readdir: wrong entries: %q", names)
+		}
+	})
 }
 
-func TestSystemPollAndShutdown(t *testing.T) {
+func TestSystemWorkingDirectory(t *testing.T) {
 	testSystem(func(ctx context.Context, p *unix.System) {
-		if err := p.Shutdown(ctx); err != nil {
+		tmp := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
 			t.Fatal(err)
 		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/tmp", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
 
-		// This call should block forever, unless async shutdown works, which is
-		// what we are testing here.
-		subscriptions := []wasi.Subscription{
-			subscribeFDRead(0),
-			subscribeFDRead(1),
+		if _, errno := p.PathOpen(ctx, wasi.WorkingDirectoryFD, 0, "file", 0, wasi.AllRights, wasi.AllRights, 0); errno != wasi.EBADF {
+			t.Errorf("path_open without working directory: want EBADF, got %s", errno)
 		}
-		events := make([]wasi.Event, len(subscriptions))
 
-		n, errno := p.PollOneOff(ctx, subscriptions, events)
+		s := wasi.WorkingDirectory(p, rootFD)
+
+		fd, errno := s.PathOpen(ctx, wasi.WorkingDirectoryFD, 0, "file", 0, wasi.AllRights, wasi.AllRights, 0)
 		if errno != wasi.ESUCCESS {
 			t.Fatal(errno)
 		}
-
-		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
-			subscribeFDRead(0),
-			subscribeFDRead(1),
-		}) {
-			t.Error("poll_oneoff: altered subscriptions")
+		buf := make([]byte, 32)
+		n, errno := s.FDRead(ctx, fd, []wasi.IOVec{buf})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if string(buf[:n]) != "Hello, World!" {
+			t.Errorf("fd_read: wrong content: %q", buf[:n])
+		}
+		if errno := s.FDClose(ctx, fd); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
 		}
 
-		if !reflect.DeepEqual(events[:n], []wasi.Event{
-			{UserData: 42, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
-			{UserData: 43, EventType: wasi.FDReadEvent, Errno: wasi.ECANCELED},
-		}) {
-			t.Errorf("poll_oneoff: wrong events: %+v", events)
+		if errno := s.PathCreateDirectory(ctx, wasi.WorkingDirectoryFD, "dir"); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := s.PathRename(ctx, wasi.WorkingDirectoryFD, "file", rootFD, "dir/file"); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		stat, errno := s.PathFileStatGet(ctx, rootFD, 0, "dir/file")
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if stat.Size != 13 {
+			t.Errorf("path_filestat_get: wrong size: %d", stat.Size)
 		}
 	})
 }
 
-func TestSystemPollBadFileDescriptor(t *testing.T) {
+func TestSystemCloseOnExec(t *testing.T) {
 	testSystem(func(ctx context.Context, p *unix.System) {
-		subscriptions := []wasi.Subscription{
-			subscribeFDRead(0),
-			// Subscribe to a file descriptor which is not registered in the
-			// system. This must not fail the poll_oneoff call and instead
-			// report an error on the
-			subscribeFDRead(42),
+		tmp := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0644); err != nil {
+			t.Fatal(err)
 		}
-		events := make([]wasi.Event, len(subscriptions))
-
-		n, err := p.PollOneOff(ctx, subscriptions, events)
-		if err != wasi.ESUCCESS {
+		// The application may preopen file descriptors which do not have the
+		// close-on-exec flag.
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
 			t.Fatal(err)
 		}
+		rootFD := p.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
 
-		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
-			subscribeFDRead(0),
-			subscribeFDRead(42),
-		}) {
-			t.Error("poll_oneoff: altered subscriptions")
+		fds := map[string]wasi.FD{"preopen": rootFD}
+		open := func(name string, fd wasi.FD, errno wasi.Errno) {
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("%s: %s", name, errno)
+			}
+			fds[name] = fd
 		}
+		fd, errno := p.PathOpen(ctx, rootFD, 0, "file", 0, wasi.AllRights, 0, 0)
+		open("path_open", fd, errno)
+		fd, errno = p.PathOpen(ctx, rootFD, 0, ".", wasi.OpenDirectory, wasi.DirectoryRights, 0, 0)
+		open("path_open directory", fd, errno)
+		fd, errno = p.PathOpenTemp(ctx, rootFD, ".", wasi.FileRights)
+		open("path_open_temp", fd, errno)
+		fd, errno = p.FDDup(ctx, rootFD)
+		open("fd_dup", fd, errno)
 
-		if n != 1 {
-			t.Errorf("poll_oneoff: wrong number of events: %d", n)
-		} else if !reflect.DeepEqual(events[0], wasi.Event{
-			UserData:  84,
-			EventType: wasi.FDReadEvent,
-			Errno:     wasi.EBADF,
-		}) {
-			t.Errorf("poll_oneoff: wrong event (0): %+v", events[0])
+		server, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		open("sock_open", server, errno)
+		addr, errno := p.SockBind(ctx, server, &wasi.Inet4Address{Addr: [4]byte{127, 0, 0, 1}})
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if errno := p.SockListen(ctx, server, 128); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		client, errno := p.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := p.SockConnect(ctx, client, addr); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		conn, _, _, errno := p.SockAccept(ctx, server, 0)
+		open("sock_accept", conn, errno)
+
+		for name, fd := range fds {
+			f, _, errno := p.LookupFD(fd, 0)
+			if errno != wasi.ESUCCESS {
+				t.Fatalf("%s: %s", name, errno)
+			}
+			flags, err := sysunix.FcntlInt(uintptr(f), sysunix.F_GETFD, 0)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if (flags & sysunix.FD_CLOEXEC) == 0 {
+				t.Errorf("%s: the file descriptor does not have the close-on-exec flag", name)
+			}
 		}
 	})
 }
 
-func TestSystemPollMissingMonotonicClock(t *testing.T) {
-	testSystem(func(ctx context.Context, p *unix.System) {
-		p.Monotonic = nil
+func TestSystemPathTooLong(t *testing.T) {
+	testSystem(func(ctx context.Context, s *unix.System) {
+		dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
 
-		subscriptions := []wasi.Subscription{
-			subscribeFDRead(0),
-			subscribeTimeout(1 * time.Second),
+		// A path made of short components does not exceed NAME_MAX, so the
+		// error can only come from its total length.
+		path := strings.Repeat("a/", sysunix.PathMax/2)
+
+		if _, errno := s.PathOpen(ctx, rootFD, 0, path, wasi.OpenCreate, wasi.AllRights, wasi.AllRights, 0); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_open: want ENAMETOOLONG, got %s", errno)
+		}
+		if _, errno := s.PathFileStatGet(ctx, rootFD, 0, path); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_filestat_get: want ENAMETOOLONG, got %s", errno)
+		}
+		if errno := s.PathCreateDirectory(ctx, rootFD, path); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_create_directory: want ENAMETOOLONG, got %s", errno)
+		}
+		if errno := s.PathRename(ctx, rootFD, "a", rootFD, path); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_rename: want ENAMETOOLONG, got %s", errno)
+		}
+		if errno := s.PathSymlink(ctx, path, rootFD, "a"); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_symlink: want ENAMETOOLONG, got %s", errno)
+		}
+		if errno := s.PathUnlinkFile(ctx, rootFD, path); errno != wasi.ENAMETOOLONG {
+			t.Errorf("path_unlink_file: want ENAMETOOLONG, got %s", errno)
 		}
-		events := make([]wasi.Event, len(subscriptions))
 
-		n, err := p.PollOneOff(ctx, subscriptions, events)
-		if err != wasi.ESUCCESS {
-			t.Fatal(err)
+		path = strings.Repeat("a/", sysunix.PathMax/2-1) + "a"
+		if _, errno := s.PathFileStatGet(ctx, rootFD, 0, path); errno != wasi.ENOENT {
+			t.Errorf("path_filestat_get: want ENOENT, got %s", errno)
 		}
+	})
+}
 
-		if !reflect.DeepEqual(subscriptions, []wasi.Subscription{
-			subscribeFDRead(0),
-			subscribeTimeout(1 * time.Second),
-		}) {
-			t.Error("poll_oneoff: altered subscriptions")
+func TestSystemFDCopy(t *testing.T) {
+	testSystem(func(ctx context.Context, s *unix.System) {
+		tmp := t.TempDir()
+		data := bytes.Repeat([]byte("0123456789"), 100)
+		if err := os.WriteFile(filepath.Join(tmp, "file"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		dirfd, err := sysunix.Open(tmp, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootFD := s.Preopen(unix.FD(dirfd), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+		file, errno := s.PathOpen(ctx, rootFD, 0, "file", 0, wasi.FDReadRight|wasi.FDSeekRight, 0, 0)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
+		}
+		if _, errno := s.FDSeek(ctx, file, 10, wasi.SeekStart); errno != wasi.ESUCCESS {
+			t.Fatal(errno)
 		}
 
-		if n != 1 {
-			t.Errorf("poll_oneoff: wrong number of events: %d", n)
-		} else if !reflect.DeepEqual(events[0], wasi.Event{
-			UserData:  42,
-			EventType: wasi.ClockEvent,
-			Errno:     wasi.ENOTSUP,
-		}) {
-			t.Errorf("poll_oneoff: wrong event (0): %+v", events[0])
+		// fd0 and fd1 are the read and write ends of a pipe.
+		if _, errno := s.FDCopy(ctx, file, 0, 10); errno != wasi.ENOTCAPABLE {
+			t.Errorf("fd_copy to a read-only file: want ENOTCAPABLE, got %s", errno)
+		}
+		var copied []byte
+		for {
+			n, errno := s.FDCopy(ctx, 1, file, 300)
+			if errno != wasi.ESUCCESS {
+				t.Fatal("fd_copy:", errno)
+			}
+			if n == 0 {
+				break
+			}
+			if n > 300 {
+				t.Fatalf("fd_copy copied too many bytes: %d", n)
+			}
+			buf := make([]byte, n)
+			if _, errno := s.FDRead(ctx, 0, []wasi.IOVec{buf}); errno != wasi.ESUCCESS {
+				t.Fatal("fd_read:", errno)
+			}
+			copied = append(copied, buf...)
+		}
+		if !bytes.Equal(copied, data[10:]) {
+			t.Errorf("wrong data copied to the pipe: %q", copied)
+		}
+		offset, errno := s.FDTell(ctx, file)
+		if errno != wasi.ESUCCESS {
+			t.Fatal(errno)
 		}
+		if offset != wasi.FileSize(len(data)) {
+			t.Errorf("wrong file offset after fd_copy: want %d, got %d", len(data), offset)
+		}
+	})
+}
+
+func TestSystemTrackOpenFiles(t *testing.T) {
+	ctx := context.Background()
+	s := newSystem()
+	s.TrackOpenFiles = true
+
+	dirfd, err := sysunix.Open(t.TempDir(), sysunix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootFD := s.Preopen(unix.FD(dirfd), "/tmp", wasi.FDStat{
+		FileType:         wasi.DirectoryType,
+		RightsBase:       wasi.AllRights,
+		RightsInheriting: wasi.AllRights,
 	})
+	closed, errno := s.PathOpen(ctx, rootFD, 0, "closed", wasi.OpenCreate, wasi.AllRights, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	leaked, errno := s.PathOpen(ctx, rootFD, 0, "leaked", wasi.OpenCreate, wasi.AllRights, 0, 0)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	socket, errno := s.SockOpen(ctx, wasi.InetFamily, wasi.StreamSocket, wasi.TCPProtocol, wasi.AllRights, wasi.AllRights)
+	if errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+	if errno := s.FDClose(ctx, closed); errno != wasi.ESUCCESS {
+		t.Fatal(errno)
+	}
+
+	if files := s.OpenFiles(); len(files) != 2 || files[0].FD != leaked || files[1].FD != socket {
+		t.Errorf("wrong open files: %+v", files)
+	}
+
+	err = s.Close(ctx)
+	leakErr, ok := err.(*wasi.LeakError)
+	if !ok {
+		t.Fatalf("closing the system: want a leak error, got %v", err)
+	}
+	if len(leakErr.Files) != 2 {
+		t.Fatalf("wrong number of leaked files: %v", err)
+	}
+	if f := leakErr.Files[0]; f.FD != leaked || f.Path != "/tmp/leaked" {
+		t.Errorf("wrong leaked file: fd=%d path=%q", f.FD, f.Path)
+	}
+	for _, f := range leakErr.Files {
+		// The call site is the first frame outside of the wasi packages.
+		if site := f.CallSite(); !strings.Contains(site, "TestSystemTrackOpenFiles") {
+			t.Errorf("wrong call site for fd %d: %s", f.FD, site)
+		}
+	}
+	if !strings.Contains(err.Error(), `"/tmp/leaked"`) {
+		t.Errorf("leaked file path missing from the error: %v", err)
+	}
 }
 
 func TestSockAddressInfo(t *testing.T) {
@@ -426,3 +2349,69 @@ func subscribeTimeout(timeout time.Duration) wasi.Subscription {
 		},
 	)
 }
+
+func BenchmarkSystemFDRead(b *testing.B) {
+	for _, numIovecs := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("iovecs=%d", numIovecs), func(b *testing.B) {
+			ctx := context.Background()
+			s := newSystem()
+			defer s.Close(ctx)
+
+			devzero, err := sysunix.Open("/dev/zero", sysunix.O_RDONLY|sysunix.O_CLOEXEC, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			fd := s.Register(unix.FD(devzero), wasi.FDStat{
+				FileType:   wasi.CharacterDeviceType,
+				RightsBase: wasi.AllRights,
+			})
+
+			buf := make([]byte, 16)
+			iovecs := make([]wasi.IOVec, numIovecs)
+			for i := range iovecs {
+				iovecs[i] = buf
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, errno := s.FDRead(ctx, fd, iovecs); errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSystemFDWrite(b *testing.B) {
+	for _, numIovecs := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("iovecs=%d", numIovecs), func(b *testing.B) {
+			ctx := context.Background()
+			s := newSystem()
+			defer s.Close(ctx)
+
+			devnull, err := sysunix.Open("/dev/null", sysunix.O_WRONLY|sysunix.O_CLOEXEC, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			fd := s.Register(unix.FD(devnull), wasi.FDStat{
+				FileType:   wasi.CharacterDeviceType,
+				RightsBase: wasi.AllRights,
+			})
+
+			buf := make([]byte, 16)
+			iovecs := make([]wasi.IOVec, numIovecs)
+			for i := range iovecs {
+				iovecs[i] = buf
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, errno := s.FDWrite(ctx, fd, iovecs); errno != wasi.ESUCCESS {
+					b.Fatal(errno)
+				}
+			}
+		})
+	}
+}
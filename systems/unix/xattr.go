@@ -0,0 +1,86 @@
+package unix
+
+import (
+	"context"
+
+	"github.com/stealthrocket/wasi-go"
+	"golang.org/x/sys/unix"
+)
+
+// The methods below extend WASI preview 1 with access to the extended
+// attributes of files, which guests have no standard way to reach. They are
+// intended to back host functions exposed to guests that need them, like
+// archivers or backup tools.
+//
+// The path is resolved relative to the directory fd like the other Path*
+// methods. Reading and listing the attributes requires PathFileStatGetRight
+// on the directory, and setting them requires PathFileStatSetTimesRight, since
+// WASI has no rights dedicated to extended attributes. The file is opened for
+// the duration of the call, so the host process must have permission to read
+// it. The host reports ENOTSUP on file systems without extended attributes.
+
+// PathGetXattr reads the value of the extended attribute name of the file at
+// path into value, and returns the length of the value. If value is empty, the
+// call only returns the length, and fails with ERANGE if value is too short.
+// WASI has no error for missing attributes, ENOENT is returned instead.
+func (s *System) PathGetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, value []byte) (int, wasi.Errno) {
+	var n int
+	errno := s.withXattrFile(fd, wasi.PathFileStatGetRight, lookupFlags, path, func(hostfd int) (err error) {
+		n, err = unix.Fgetxattr(hostfd, name, value)
+		if err == __ENOATTR {
+			err = unix.ENOENT
+		}
+		return err
+	})
+	return n, errno
+}
+
+// PathSetXattr sets the value of the extended attribute name of the file at
+// path, creating the attribute if it does not exist.
+func (s *System) PathSetXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path, name string, value []byte) wasi.Errno {
+	return s.withXattrFile(fd, wasi.PathFileStatSetTimesRight, lookupFlags, path, func(hostfd int) error {
+		return unix.Fsetxattr(hostfd, name, value, 0)
+	})
+}
+
+// PathListXattr writes the names of the extended attributes of the file at
+// path to buffer, each followed by a null byte, and returns the number of
+// bytes written. If buffer is empty, the call only returns the size of the
+// list, and fails with ERANGE if buffer is too short.
+func (s *System) PathListXattr(ctx context.Context, fd wasi.FD, lookupFlags wasi.LookupFlags, path string, buffer []byte) (int, wasi.Errno) {
+	var n int
+	errno := s.withXattrFile(fd, wasi.PathFileStatGetRight, lookupFlags, path, func(hostfd int) (err error) {
+		n, err = unix.Flistxattr(hostfd, buffer)
+		return err
+	})
+	return n, errno
+}
+
+func (s *System) withXattrFile(fd wasi.FD, rights wasi.Rights, lookupFlags wasi.LookupFlags, path string, f func(int) error) wasi.Errno {
+	d, stat, errno := s.LookupFD(fd, rights)
+	if errno != wasi.ESUCCESS {
+		return errno
+	}
+	if stat.FileType != wasi.DirectoryType {
+		return wasi.ENOTDIR
+	}
+	if errno := s.CheckPath(path); errno != wasi.ESUCCESS {
+		return errno
+	}
+	if !validPathLength(path) {
+		return wasi.ENAMETOOLONG
+	}
+	// O_NONBLOCK prevents blocking on named pipes, the file is not read.
+	oflags := unix.O_RDONLY | unix.O_CLOEXEC | unix.O_NONBLOCK
+	if !lookupFlags.Has(wasi.SymlinkFollow) {
+		oflags |= unix.O_NOFOLLOW
+	}
+	hostfd, err := ignoreEINTR2(func() (int, error) {
+		return unix.Openat(int(d), path, oflags, 0)
+	})
+	if err != nil {
+		return makeErrno(err)
+	}
+	defer unix.Close(hostfd)
+	return makeErrno(ignoreEINTR(func() error { return f(hostfd) }))
+}
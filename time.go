@@ -1,7 +1,9 @@
 package wasi
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,3 +59,48 @@ func (c ClockID) String() string {
 		return fmt.Sprintf("ClockID(%d)", c)
 	}
 }
+
+// Clocks is a pair of realtime and monotonic clocks, with the signature of the
+// clock functions of the systems.
+type Clocks struct {
+	Realtime  func(context.Context) (uint64, error)
+	Monotonic func(context.Context) (uint64, error)
+}
+
+// MakeClocks returns a consistent pair of clocks deriving their values from
+// the times returned by now.
+//
+// The realtime clock is the Unix time of now in nanoseconds. The monotonic
+// clock is the time elapsed since the creation of the clocks, which starts at
+// zero and never decreases: it uses the monotonic clock reading of the times
+// when they have one (like the times returned by time.Now), so it is not
+// affected by changes of the wall clock, and otherwise holds its value while
+// now goes backward. Deadlines computed from the monotonic clock therefore
+// never yield negative durations, and the two clocks advance at the same rate
+// unless the wall clock is adjusted.
+func MakeClocks(now func() time.Time) Clocks {
+	epoch := now()
+	elapsed := new(atomic.Int64)
+	return Clocks{
+		Realtime: func(context.Context) (uint64, error) {
+			return uint64(now().UnixNano()), nil
+		},
+		Monotonic: func(context.Context) (uint64, error) {
+			t := int64(now().Sub(epoch))
+			for {
+				last := elapsed.Load()
+				if t <= last {
+					return uint64(last), nil
+				}
+				if elapsed.CompareAndSwap(last, t) {
+					return uint64(t), nil
+				}
+			}
+		},
+	}
+}
+
+// SystemClocks returns the clocks of the host, derived from time.Now.
+func SystemClocks() Clocks {
+	return MakeClocks(time.Now)
+}
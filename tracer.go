@@ -33,14 +33,28 @@ func WithTracerStringSize(stringSize int) TracerOption {
 	return func(t *tracer) { t.stringSize = stringSize }
 }
 
+// WithTracerTimestamps prefixes each call with the time returned by clock
+// when the call was made. The clock is called by the tracer rather than
+// through the traced system, so the timestamps do not alter the calls that
+// the system observes, and tracing a system which is recorded, replayed or
+// rate limited does not change its behavior. Programs running with a
+// deterministic clock may pass the same clock to the tracer so their traces
+// can be compared.
+//
+// Timestamps are disabled when clock is nil, which is the default.
+func WithTracerTimestamps(clock func() Timestamp) TracerOption {
+	return func(t *tracer) { t.clock = clock }
+}
+
 type tracer struct {
 	writer     io.Writer
 	system     System
 	stringSize int
+	clock      func() Timestamp
 }
 
 func (t *tracer) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
-	t.printf("ArgsSizesGet() => ")
+	t.printCall("ArgsSizesGet() => ")
 	argCount, stringBytes, errno := t.system.ArgsSizesGet(ctx)
 	if errno == ESUCCESS {
 		t.printf("%d, %d", argCount, stringBytes)
@@ -52,7 +66,7 @@ func (t *tracer) ArgsSizesGet(ctx context.Context) (int, int, Errno) {
 }
 
 func (t *tracer) ArgsGet(ctx context.Context) ([]string, Errno) {
-	t.printf("ArgsGet() => ")
+	t.printCall("ArgsGet() => ")
 	args, errno := t.system.ArgsGet(ctx)
 	if errno == ESUCCESS {
 		t.printf("%q", args)
@@ -64,7 +78,7 @@ func (t *tracer) ArgsGet(ctx context.Context) ([]string, Errno) {
 }
 
 func (t *tracer) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
-	t.printf("EnvironSizesGet() => ")
+	t.printCall("EnvironSizesGet() => ")
 	envCount, stringBytes, errno := t.system.EnvironSizesGet(ctx)
 	if errno == ESUCCESS {
 		t.printf("%d, %d", envCount, stringBytes)
@@ -76,7 +90,7 @@ func (t *tracer) EnvironSizesGet(ctx context.Context) (int, int, Errno) {
 }
 
 func (t *tracer) EnvironGet(ctx context.Context) ([]string, Errno) {
-	t.printf("EnvironGet() => ")
+	t.printCall("EnvironGet() => ")
 	environ, errno := t.system.EnvironGet(ctx)
 	if errno == ESUCCESS {
 		t.printf("%q", environ)
@@ -88,7 +102,7 @@ func (t *tracer) EnvironGet(ctx context.Context) ([]string, Errno) {
 }
 
 func (t *tracer) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno) {
-	t.printf("ClockResGet(%d) => ", id)
+	t.printCall("ClockResGet(%d) => ", id)
 	precision, errno := t.system.ClockResGet(ctx, id)
 	if errno == ESUCCESS {
 		t.printf("%d", precision)
@@ -100,7 +114,7 @@ func (t *tracer) ClockResGet(ctx context.Context, id ClockID) (Timestamp, Errno)
 }
 
 func (t *tracer) ClockTimeGet(ctx context.Context, id ClockID, precision Timestamp) (Timestamp, Errno) {
-	t.printf("ClockTimeGet(%d, %d) => ", id, precision)
+	t.printCall("ClockTimeGet(%d, %d) => ", id, precision)
 	timestamp, errno := t.system.ClockTimeGet(ctx, id, precision)
 	if errno == ESUCCESS {
 		t.printf("%d", timestamp)
@@ -112,7 +126,7 @@ func (t *tracer) ClockTimeGet(ctx context.Context, id ClockID, precision Timesta
 }
 
 func (t *tracer) FDAdvise(ctx context.Context, fd FD, offset, length FileSize, advice Advice) Errno {
-	t.printf("FDAdvise(%d, %d, %d, %s) => ", fd, offset, length, advice)
+	t.printCall("FDAdvise(%d, %d, %d, %s) => ", fd, offset, length, advice)
 	errno := t.system.FDAdvise(ctx, fd, offset, length, advice)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -124,7 +138,7 @@ func (t *tracer) FDAdvise(ctx context.Context, fd FD, offset, length FileSize, a
 }
 
 func (t *tracer) FDAllocate(ctx context.Context, fd FD, offset, length FileSize) Errno {
-	t.printf("FDAllocate(%d, %d, %d) => ", fd, offset, length)
+	t.printCall("FDAllocate(%d, %d, %d) => ", fd, offset, length)
 	errno := t.system.FDAllocate(ctx, fd, offset, length)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -136,7 +150,7 @@ func (t *tracer) FDAllocate(ctx context.Context, fd FD, offset, length FileSize)
 }
 
 func (t *tracer) FDClose(ctx context.Context, fd FD) Errno {
-	t.printf("FDClose(%d) => ", fd)
+	t.printCall("FDClose(%d) => ", fd)
 	errno := t.system.FDClose(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -148,7 +162,7 @@ func (t *tracer) FDClose(ctx context.Context, fd FD) Errno {
 }
 
 func (t *tracer) FDDataSync(ctx context.Context, fd FD) Errno {
-	t.printf("FDDataSync(%d) => ", fd)
+	t.printCall("FDDataSync(%d) => ", fd)
 	errno := t.system.FDDataSync(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -160,7 +174,7 @@ func (t *tracer) FDDataSync(ctx context.Context, fd FD) Errno {
 }
 
 func (t *tracer) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
-	t.printf("FDStatGet(%d) => ", fd)
+	t.printCall("FDStatGet(%d) => ", fd)
 	fdstat, errno := t.system.FDStatGet(ctx, fd)
 	if errno == ESUCCESS {
 		t.printFDStat(fdstat)
@@ -172,7 +186,7 @@ func (t *tracer) FDStatGet(ctx context.Context, fd FD) (FDStat, Errno) {
 }
 
 func (t *tracer) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno {
-	t.printf("FDStatSetFlags(%d, %s) => ", fd, flags)
+	t.printCall("FDStatSetFlags(%d, %s) => ", fd, flags)
 	errno := t.system.FDStatSetFlags(ctx, fd, flags)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -184,7 +198,7 @@ func (t *tracer) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags) Errno
 }
 
 func (t *tracer) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsInheriting Rights) Errno {
-	t.printf("FDStatSetRights(%d, %s, %s) => ", fd, rightsBase, rightsInheriting)
+	t.printCall("FDStatSetRights(%d, %s, %s) => ", fd, rightsBase, rightsInheriting)
 	errno := t.system.FDStatSetRights(ctx, fd, rightsBase, rightsInheriting)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -196,7 +210,7 @@ func (t *tracer) FDStatSetRights(ctx context.Context, fd FD, rightsBase, rightsI
 }
 
 func (t *tracer) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
-	t.printf("FDFileStatGet(%d) => ", fd)
+	t.printCall("FDFileStatGet(%d) => ", fd)
 	filestat, errno := t.system.FDFileStatGet(ctx, fd)
 	if errno == ESUCCESS {
 		t.printFileStat(filestat)
@@ -208,7 +222,7 @@ func (t *tracer) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errno) {
 }
 
 func (t *tracer) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Errno {
-	t.printf("FDFileStatSetSize(%d, %d) => ", fd, size)
+	t.printCall("FDFileStatSetSize(%d, %d) => ", fd, size)
 	errno := t.system.FDFileStatSetSize(ctx, fd, size)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -220,7 +234,7 @@ func (t *tracer) FDFileStatSetSize(ctx context.Context, fd FD, size FileSize) Er
 }
 
 func (t *tracer) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
-	t.printf("FDFileStatSetTimes(%d, %d, %d, %s) => ", fd, accessTime, modifyTime, flags)
+	t.printCall("FDFileStatSetTimes(%d, %d, %d, %s) => ", fd, accessTime, modifyTime, flags)
 	errno := t.system.FDFileStatSetTimes(ctx, fd, accessTime, modifyTime, flags)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -232,7 +246,7 @@ func (t *tracer) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime, modi
 }
 
 func (t *tracer) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
-	t.printf("FDPread(%d, ", fd)
+	t.printCall("FDPread(%d, ", fd)
 	t.printIOVecsProto(iovecs)
 	t.printf("%d) => ", offset)
 	n, errno := t.system.FDPread(ctx, fd, iovecs, offset)
@@ -247,7 +261,7 @@ func (t *tracer) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset File
 }
 
 func (t *tracer) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
-	t.printf("FDPreStatGet(%d) => ", fd)
+	t.printCall("FDPreStatGet(%d) => ", fd)
 	prestat, errno := t.system.FDPreStatGet(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("{Type:%s,PreStatDir.NameLength:%d}", prestat.Type, prestat.PreStatDir.NameLength)
@@ -259,7 +273,7 @@ func (t *tracer) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
 }
 
 func (t *tracer) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
-	t.printf("FDPreStatDirName(%d) => ", fd)
+	t.printCall("FDPreStatDirName(%d) => ", fd)
 	name, errno := t.system.FDPreStatDirName(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("%q", name)
@@ -271,7 +285,7 @@ func (t *tracer) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
 }
 
 func (t *tracer) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
-	t.printf("FDPwrite(%d, ", fd)
+	t.printCall("FDPwrite(%d, ", fd)
 	t.printIOVecs(iovecs, -1)
 	t.printf(", %d) => ", offset)
 	n, errno := t.system.FDPwrite(ctx, fd, iovecs, offset)
@@ -285,7 +299,7 @@ func (t *tracer) FDPwrite(ctx context.Context, fd FD, iovecs []IOVec, offset Fil
 }
 
 func (t *tracer) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
-	t.printf("FDRead(%d, ", fd)
+	t.printCall("FDRead(%d, ", fd)
 	t.printIOVecsProto(iovecs)
 	t.printf(") => ")
 	n, errno := t.system.FDRead(ctx, fd, iovecs)
@@ -300,7 +314,7 @@ func (t *tracer) FDRead(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno
 }
 
 func (t *tracer) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cookie DirCookie, bufferSizeBytes int) (int, Errno) {
-	t.printf("FDReadDir(%d, %d) => ", fd, cookie)
+	t.printCall("FDReadDir(%d, %d) => ", fd, cookie)
 	n, errno := t.system.FDReadDir(ctx, fd, entries, cookie, bufferSizeBytes)
 	if errno == ESUCCESS {
 		t.printDirEntries(entries[:n], bufferSizeBytes)
@@ -312,7 +326,7 @@ func (t *tracer) FDReadDir(ctx context.Context, fd FD, entries []DirEntry, cooki
 }
 
 func (t *tracer) FDRenumber(ctx context.Context, from, to FD) Errno {
-	t.printf("FDRenumber(%d, %d) => ", from, to)
+	t.printCall("FDRenumber(%d, %d) => ", from, to)
 	errno := t.system.FDRenumber(ctx, from, to)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -324,7 +338,7 @@ func (t *tracer) FDRenumber(ctx context.Context, from, to FD) Errno {
 }
 
 func (t *tracer) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whence) (FileSize, Errno) {
-	t.printf("FDSeek(%d, %d, %s) => ", fd, offset, whence)
+	t.printCall("FDSeek(%d, %d, %s) => ", fd, offset, whence)
 	result, errno := t.system.FDSeek(ctx, fd, offset, whence)
 	if errno == ESUCCESS {
 		t.printf("%d", offset)
@@ -336,7 +350,7 @@ func (t *tracer) FDSeek(ctx context.Context, fd FD, offset FileDelta, whence Whe
 }
 
 func (t *tracer) FDSync(ctx context.Context, fd FD) Errno {
-	t.printf("FDSync(%d) => ", fd)
+	t.printCall("FDSync(%d) => ", fd)
 	errno := t.system.FDSync(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -348,7 +362,7 @@ func (t *tracer) FDSync(ctx context.Context, fd FD) Errno {
 }
 
 func (t *tracer) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
-	t.printf("FDTell(%d) => ", fd)
+	t.printCall("FDTell(%d) => ", fd)
 	fileSize, errno := t.system.FDTell(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("%d", fileSize)
@@ -360,7 +374,7 @@ func (t *tracer) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
 }
 
 func (t *tracer) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errno) {
-	t.printf("FDWrite(%d, ", fd)
+	t.printCall("FDWrite(%d, ", fd)
 	t.printIOVecs(iovecs, -1)
 	t.printf(") => ")
 	n, errno := t.system.FDWrite(ctx, fd, iovecs)
@@ -374,7 +388,7 @@ func (t *tracer) FDWrite(ctx context.Context, fd FD, iovecs []IOVec) (Size, Errn
 }
 
 func (t *tracer) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
-	t.printf("PathCreateDirectory(%d, %q) => ", fd, path)
+	t.printCall("PathCreateDirectory(%d, %q) => ", fd, path)
 	errno := t.system.PathCreateDirectory(ctx, fd, path)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -386,7 +400,7 @@ func (t *tracer) PathCreateDirectory(ctx context.Context, fd FD, path string) Er
 }
 
 func (t *tracer) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
-	t.printf("PathFileStatGet(%d, %s, %q) => ", fd, lookupFlags, path)
+	t.printCall("PathFileStatGet(%d, %s, %q) => ", fd, lookupFlags, path)
 	filestat, errno := t.system.PathFileStatGet(ctx, fd, lookupFlags, path)
 	if errno == ESUCCESS {
 		t.printFileStat(filestat)
@@ -398,7 +412,7 @@ func (t *tracer) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupF
 }
 
 func (t *tracer) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
-	t.printf("PathFileStatSetTimes(%d, %s, %q, %d, %d, %s) => ", fd, lookupFlags, path, accessTime, modifyTime, flags)
+	t.printCall("PathFileStatSetTimes(%d, %s, %q, %d, %d, %s) => ", fd, lookupFlags, path, accessTime, modifyTime, flags)
 	errno := t.system.PathFileStatSetTimes(ctx, fd, lookupFlags, path, accessTime, modifyTime, flags)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -410,7 +424,7 @@ func (t *tracer) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags Lo
 }
 
 func (t *tracer) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
-	t.printf("PathLink(%d, %s, %q, %d, %q) => ", oldFD, oldFlags, oldPath, newFD, newPath)
+	t.printCall("PathLink(%d, %s, %q, %d, %q) => ", oldFD, oldFlags, oldPath, newFD, newPath)
 	errno := t.system.PathLink(ctx, oldFD, oldFlags, oldPath, newFD, newPath)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -422,7 +436,7 @@ func (t *tracer) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, o
 }
 
 func (t *tracer) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
-	t.printf("PathOpen(%d, %s, %q, %s, %s, %s, %s) => ", fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	t.printCall("PathOpen(%d, %s, %q, %s, %s, %s, %s) => ", fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
 	fd, errno := t.system.PathOpen(ctx, fd, dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
 	if errno == ESUCCESS {
 		t.printf("%d", fd)
@@ -434,7 +448,7 @@ func (t *tracer) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path
 }
 
 func (t *tracer) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
-	t.printf("PathReadLink(%d, %q, [%d]byte) => ", fd, path, len(buffer))
+	t.printCall("PathReadLink(%d, %q, [%d]byte) => ", fd, path, len(buffer))
 	n, errno := t.system.PathReadLink(ctx, fd, path, buffer)
 	if errno == ESUCCESS {
 		t.printBytes(buffer[:n])
@@ -446,7 +460,7 @@ func (t *tracer) PathReadLink(ctx context.Context, fd FD, path string, buffer []
 }
 
 func (t *tracer) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
-	t.printf("PathRemoveDirectory(%d, %q) => ", fd, path)
+	t.printCall("PathRemoveDirectory(%d, %q) => ", fd, path)
 	errno := t.system.PathRemoveDirectory(ctx, fd, path)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -458,7 +472,7 @@ func (t *tracer) PathRemoveDirectory(ctx context.Context, fd FD, path string) Er
 }
 
 func (t *tracer) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
-	t.printf("PathRename(%d, %q, %d, %q) => ", fd, oldPath, newFD, newPath)
+	t.printCall("PathRename(%d, %q, %d, %q) => ", fd, oldPath, newFD, newPath)
 	errno := t.system.PathRename(ctx, fd, oldPath, newFD, newPath)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -470,7 +484,7 @@ func (t *tracer) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD
 }
 
 func (t *tracer) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
-	t.printf("PathSymlink(%q, %d, %q) => ", oldPath, fd, newPath)
+	t.printCall("PathSymlink(%q, %d, %q) => ", oldPath, fd, newPath)
 	errno := t.system.PathSymlink(ctx, oldPath, fd, newPath)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -482,7 +496,7 @@ func (t *tracer) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath
 }
 
 func (t *tracer) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
-	t.printf("PathUnlinkFile(%d, %q) => ", fd, path)
+	t.printCall("PathUnlinkFile(%d, %q) => ", fd, path)
 	errno := t.system.PathUnlinkFile(ctx, fd, path)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -494,7 +508,7 @@ func (t *tracer) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
 }
 
 func (t *tracer) PollOneOff(ctx context.Context, subscriptions []Subscription, events []Event) (int, Errno) {
-	t.printf("PollOneoff(")
+	t.printCall("PollOneoff(")
 	for i, s := range subscriptions {
 		if i > 0 {
 			t.printf(",")
@@ -521,7 +535,7 @@ func (t *tracer) PollOneOff(ctx context.Context, subscriptions []Subscription, e
 }
 
 func (t *tracer) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
-	t.printf("ProcExit(%d) => ", exitCode)
+	t.printCall("ProcExit(%d) => ", exitCode)
 	errno := t.system.ProcExit(ctx, exitCode)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -533,7 +547,7 @@ func (t *tracer) ProcExit(ctx context.Context, exitCode ExitCode) Errno {
 }
 
 func (t *tracer) ProcRaise(ctx context.Context, signal Signal) Errno {
-	t.printf("ProcRaise(%d) => ", signal)
+	t.printCall("ProcRaise(%d) => ", signal)
 	errno := t.system.ProcRaise(ctx, signal)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -545,7 +559,7 @@ func (t *tracer) ProcRaise(ctx context.Context, signal Signal) Errno {
 }
 
 func (t *tracer) SchedYield(ctx context.Context) Errno {
-	t.printf("SchedYield() => ")
+	t.printCall("SchedYield() => ")
 	errno := t.system.SchedYield(ctx)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -557,7 +571,7 @@ func (t *tracer) SchedYield(ctx context.Context) Errno {
 }
 
 func (t *tracer) RandomGet(ctx context.Context, b []byte) Errno {
-	t.printf("RandomGet([%d]byte) => ", len(b))
+	t.printCall("RandomGet([%d]byte) => ", len(b))
 	errno := t.system.RandomGet(ctx, b)
 	if errno == ESUCCESS {
 		t.printBytes(b)
@@ -569,7 +583,7 @@ func (t *tracer) RandomGet(ctx context.Context, b []byte) Errno {
 }
 
 func (t *tracer) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, SocketAddress, SocketAddress, Errno) {
-	t.printf("SockAccept(%d, %s) => ", fd, flags)
+	t.printCall("SockAccept(%d, %s) => ", fd, flags)
 	newfd, peer, addr, errno := t.system.SockAccept(ctx, fd, flags)
 	if errno == ESUCCESS {
 		t.printf("%d, %s > %s", newfd, peer, addr)
@@ -581,7 +595,7 @@ func (t *tracer) SockAccept(ctx context.Context, fd FD, flags FDFlags) (FD, Sock
 }
 
 func (t *tracer) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
-	t.printf("SockShutdown(%d, %s) => ", fd, flags)
+	t.printCall("SockShutdown(%d, %s) => ", fd, flags)
 	errno := t.system.SockShutdown(ctx, fd, flags)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -593,7 +607,7 @@ func (t *tracer) SockShutdown(ctx context.Context, fd FD, flags SDFlags) Errno {
 }
 
 func (t *tracer) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, iflags RIFlags) (Size, ROFlags, Errno) {
-	t.printf("SockRecv(%d, ", fd)
+	t.printCall("SockRecv(%d, ", fd)
 	t.printIOVecsProto(iovecs)
 	t.printf(", %s) => ", iflags)
 	n, oflags, errno := t.system.SockRecv(ctx, fd, iovecs, iflags)
@@ -609,7 +623,7 @@ func (t *tracer) SockRecv(ctx context.Context, fd FD, iovecs []IOVec, iflags RIF
 }
 
 func (t *tracer) SockSend(ctx context.Context, fd FD, iovecs []IOVec, iflags SIFlags) (Size, Errno) {
-	t.printf("SockSend(%d, ", fd)
+	t.printCall("SockSend(%d, ", fd)
 	t.printIOVecs(iovecs, -1)
 	t.printf(", %s) => ", iflags)
 	n, errno := t.system.SockSend(ctx, fd, iovecs, iflags)
@@ -623,7 +637,7 @@ func (t *tracer) SockSend(ctx context.Context, fd FD, iovecs []IOVec, iflags SIF
 }
 
 func (t *tracer) SockOpen(ctx context.Context, pf ProtocolFamily, socketType SocketType, protocol Protocol, rightsBase, rightsInheriting Rights) (FD, Errno) {
-	t.printf("SockOpen(%s, %s, %s, %s, %s) => ", pf, socketType, protocol, rightsBase, rightsInheriting)
+	t.printCall("SockOpen(%s, %s, %s, %s, %s) => ", pf, socketType, protocol, rightsBase, rightsInheriting)
 	fd, errno := t.system.SockOpen(ctx, pf, socketType, protocol, rightsBase, rightsInheriting)
 	if errno == ESUCCESS {
 		t.printf("%d", fd)
@@ -635,7 +649,7 @@ func (t *tracer) SockOpen(ctx context.Context, pf ProtocolFamily, socketType Soc
 }
 
 func (t *tracer) SockBind(ctx context.Context, fd FD, addr SocketAddress) (SocketAddress, Errno) {
-	t.printf("SockBind(%d, %s) => ", fd, addr)
+	t.printCall("SockBind(%d, %s) => ", fd, addr)
 	addr, errno := t.system.SockBind(ctx, fd, addr)
 	if errno == ESUCCESS {
 		t.printf("%s", addr)
@@ -647,7 +661,7 @@ func (t *tracer) SockBind(ctx context.Context, fd FD, addr SocketAddress) (Socke
 }
 
 func (t *tracer) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (SocketAddress, Errno) {
-	t.printf("SockConnect(%d, %s) => ", fd, peer)
+	t.printCall("SockConnect(%d, %s) => ", fd, peer)
 	addr, errno := t.system.SockConnect(ctx, fd, peer)
 	if errno == EINPROGRESS {
 		t.printf("%s (EINPROGRESS)", addr)
@@ -661,7 +675,7 @@ func (t *tracer) SockConnect(ctx context.Context, fd FD, peer SocketAddress) (So
 }
 
 func (t *tracer) SockListen(ctx context.Context, fd FD, backlog int) Errno {
-	t.printf("SockListen(%d, %d) => ", fd, backlog)
+	t.printCall("SockListen(%d, %d) => ", fd, backlog)
 	errno := t.system.SockListen(ctx, fd, backlog)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -673,7 +687,7 @@ func (t *tracer) SockListen(ctx context.Context, fd FD, backlog int) Errno {
 }
 
 func (t *tracer) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, iflags SIFlags, addr SocketAddress) (Size, Errno) {
-	t.printf("SockSendTo(%d, ", fd)
+	t.printCall("SockSendTo(%d, ", fd)
 	t.printIOVecs(iovecs, -1)
 	t.printf(", %s, %s) => ", iflags, addr)
 	n, errno := t.system.SockSendTo(ctx, fd, iovecs, iflags, addr)
@@ -687,7 +701,7 @@ func (t *tracer) SockSendTo(ctx context.Context, fd FD, iovecs []IOVec, iflags S
 }
 
 func (t *tracer) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, iflags RIFlags) (Size, ROFlags, SocketAddress, Errno) {
-	t.printf("SockRecvFrom(%d, ", fd)
+	t.printCall("SockRecvFrom(%d, ", fd)
 	t.printIOVecsProto(iovecs)
 	t.printf(", %s) => ", iflags)
 	n, oflags, addr, errno := t.system.SockRecvFrom(ctx, fd, iovecs, iflags)
@@ -703,7 +717,7 @@ func (t *tracer) SockRecvFrom(ctx context.Context, fd FD, iovecs []IOVec, iflags
 }
 
 func (t *tracer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (SocketOptionValue, Errno) {
-	t.printf("SockGetOpt(%d, %s) => ", fd, option)
+	t.printCall("SockGetOpt(%d, %s) => ", fd, option)
 	value, errno := t.system.SockGetOpt(ctx, fd, option)
 	if errno == ESUCCESS {
 		t.printf("%d", value)
@@ -715,7 +729,7 @@ func (t *tracer) SockGetOpt(ctx context.Context, fd FD, option SocketOption) (So
 }
 
 func (t *tracer) SockSetOpt(ctx context.Context, fd FD, option SocketOption, value SocketOptionValue) Errno {
-	t.printf("SockSetOpt(%d, %s, %s) => ", fd, option, value)
+	t.printCall("SockSetOpt(%d, %s, %s) => ", fd, option, value)
 	errno := t.system.SockSetOpt(ctx, fd, option, value)
 	if errno == ESUCCESS {
 		t.printf("ok")
@@ -727,7 +741,7 @@ func (t *tracer) SockSetOpt(ctx context.Context, fd FD, option SocketOption, val
 }
 
 func (t *tracer) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
-	t.printf("SockLocalAddress(%d) => ", fd)
+	t.printCall("SockLocalAddress(%d) => ", fd)
 	addr, errno := t.system.SockLocalAddress(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("%s", addr)
@@ -739,7 +753,7 @@ func (t *tracer) SockLocalAddress(ctx context.Context, fd FD) (SocketAddress, Er
 }
 
 func (t *tracer) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, Errno) {
-	t.printf("SockRemoteAddress(%d) => ", fd)
+	t.printCall("SockRemoteAddress(%d) => ", fd)
 	addr, errno := t.system.SockRemoteAddress(ctx, fd)
 	if errno == ESUCCESS {
 		t.printf("%s", addr)
@@ -751,7 +765,7 @@ func (t *tracer) SockRemoteAddress(ctx context.Context, fd FD) (SocketAddress, E
 }
 
 func (t *tracer) SockAddressInfo(ctx context.Context, name, service string, hints AddressInfo, results []AddressInfo) (int, Errno) {
-	t.printf("SockAddressInfo(%s, %s, ", name, service)
+	t.printCall("SockAddressInfo(%s, %s, ", name, service)
 	t.printAddressInfo(hints)
 	t.printf(", [%d]AddressInfo) => ", len(results))
 	n, errno := t.system.SockAddressInfo(ctx, name, service, hints, results)
@@ -772,7 +786,7 @@ func (t *tracer) SockAddressInfo(ctx context.Context, name, service string, hint
 }
 
 func (t *tracer) Close(ctx context.Context) error {
-	t.printf("Close() => ")
+	t.printCall("Close() => ")
 	err := t.system.Close(ctx)
 	if err == nil {
 		t.printf("ok\n")
@@ -782,6 +796,13 @@ func (t *tracer) Close(ctx context.Context) error {
 	return err
 }
 
+func (t *tracer) printCall(msg string, args ...interface{}) {
+	if t.clock != nil {
+		t.printf("[%d] ", t.clock())
+	}
+	t.printf(msg, args...)
+}
+
 func (t *tracer) printf(msg string, args ...interface{}) {
 	fmt.Fprintf(t.writer, msg, args...)
 }
@@ -0,0 +1,91 @@
+package wasi_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+)
+
+func TestTracerTimestamps(t *testing.T) {
+	ctx := context.Background()
+	system := &unix.System{Args: []string{"hello"}}
+
+	var now wasi.Timestamp
+	clock := func() wasi.Timestamp {
+		now += 1000
+		return now
+	}
+
+	for _, test := range []struct {
+		scenario string
+		options  []wasi.TracerOption
+		want     string
+	}{
+		{
+			scenario: "timestamps are disabled by default",
+			want:     "ArgsSizesGet() => 1, 6\nArgsGet() => [\"hello\"]\n",
+		},
+		{
+			scenario: "timestamps are read from the clock of the tracer",
+			options:  []wasi.TracerOption{wasi.WithTracerTimestamps(clock)},
+			want:     "[1000] ArgsSizesGet() => 1, 6\n[2000] ArgsGet() => [\"hello\"]\n",
+		},
+	} {
+		t.Run(test.scenario, func(t *testing.T) {
+			var buf bytes.Buffer
+			tracer := wasi.Trace(&buf, system, test.options...)
+			tracer.ArgsSizesGet(ctx)
+			tracer.ArgsGet(ctx)
+			if got := buf.String(); got != test.want {
+				t.Errorf("wrong trace:\nwant: %q\ngot:  %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestTracerTimestampsReplay(t *testing.T) {
+	ctx := context.Background()
+	host := &unix.System{
+		Args: []string{"hello"},
+		Realtime: func(context.Context) (uint64, error) {
+			return 42, nil
+		},
+	}
+	clock := func() wasi.Timestamp { return 1 }
+
+	// program makes calls to the system, and returns what it observed.
+	program := func(s wasi.System) string {
+		argc, size, errno := s.ArgsSizesGet(ctx)
+		now, _ := s.ClockTimeGet(ctx, wasi.Realtime, 1)
+		args, _ := s.ArgsGet(ctx)
+		return fmt.Sprintf("%d %d %s %d %q", argc, size, errno, now, args)
+	}
+
+	// The timestamps must not add calls to the recording...
+	var recording bytes.Buffer
+	var trace bytes.Buffer
+	recorder := wasi.Record(&recording, host)
+	recorded := program(wasi.Trace(&trace, recorder, wasi.WithTracerTimestamps(clock)))
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...nor consume the recorded calls when replaying.
+	trace.Reset()
+	replayer := wasi.Replay(&recording)
+	replayed := program(wasi.Trace(&trace, replayer, wasi.WithTracerTimestamps(clock)))
+	if err := replayer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if replayed != recorded {
+		t.Errorf("wrong replay:\nwant: %s\ngot:  %s", recorded, replayed)
+	}
+	want := "[1] ArgsSizesGet() => 1, 6\n[1] ClockTimeGet(0, 1) => 42\n[1] ArgsGet() => [\"hello\"]\n"
+	if got := trace.String(); got != want {
+		t.Errorf("wrong trace:\nwant: %q\ngot:  %q", want, got)
+	}
+}
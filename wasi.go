@@ -102,51 +102,122 @@ type FileTable[T File[T]] struct {
 	//
 	// Zero means no limit.
 	MaxOpenDirs int
+	// Limit the number of sockets that may be opened on the table, which is
+	// also bounded by MaxOpenFiles. Sockets hold kernel resources and ports,
+	// so the limit prevents guests from exhausting them.
+	//
+	// Zero means no limit.
+	MaxSockets int
+	// OmitDotEntries instructs FDReadDir to omit the "." and ".." entries
+	// of directories.
+	//
+	// The entries are reported by default, as POSIX readdir(3) does and the
+	// WASI test suites expect. wasi-libc passes them through to programs,
+	// while the standard libraries of Go and Rust skip them, so either mode
+	// works for those. Guests which list directories with fd_readdir
+	// directly and do not expect the entries may set this option.
+	OmitDotEntries bool
+	// StrictPaths rejects the paths which escape the directory they are
+	// resolved from with ENOTCAPABLE. The check applies to the paths passed
+	// to all the Path* methods, and to the targets of the symbolic links
+	// created with PathSymlink. A path escapes when it is absolute, or when
+	// any of its ".." components refers to the parent of the directory,
+	// regardless of how the path is constructed (e.g. "a/b/../../../etc" or
+	// "a//b/.//../../.."). Only '/' separates the components of a path.
+	//
+	// The check is lexical: symbolic links which already exist in the file
+	// system are resolved by the File implementation. Without the option,
+	// only PathOpen rejects paths which escape the directory, with EPERM.
+	StrictPaths bool
+	// TrackOpenFiles records the call stack where each file is opened, so the
+	// files still open when the table is closed can be reported: Close then
+	// returns a *LeakError listing them, with their paths. Preopens are not
+	// tracked, since they are owned by the host. OpenFiles also lists the
+	// tracked files while the table is in use.
+	//
+	// The option is intended to detect file descriptor leaks of guests and
+	// hosts in tests, it is disabled by default and has no cost unless it is
+	// enabled.
+	TrackOpenFiles bool
 
-	files    descriptor.Table[FD, fileEntry[T]]
-	preopens descriptor.Table[FD, string]
-	dirs     map[FD]Dir
+	files descriptor.Table[FD, fileEntry[T]]
+	dirs  map[FD]Dir
+	// numSockets is the number of sockets in files.
+	numSockets int
 }
 
+// fileEntry is the value stored in the file table for each open file.
+//
+// Whether a file descriptor is a preopen is recorded on its entry rather than
+// in a separate table, so the information is always released or moved along
+// with the file when the descriptor is closed or renumbered.
 type fileEntry[T File[T]] struct {
-	file T
-	stat FDStat
+	file    T
+	stat    FDStat
+	path    string
+	preopen bool
+	// stack is the call stack where the file was opened, recorded when the
+	// table tracks open files.
+	stack []uintptr
 }
 
 func (t *FileTable[T]) Close(ctx context.Context) error {
+	var leaks []OpenFile
+	if t.TrackOpenFiles {
+		leaks = t.OpenFiles()
+	}
 	t.files.Range(func(fd FD, f fileEntry[T]) bool {
 		f.file.FDClose(ctx)
 		return true
 	})
 	t.files.Reset()
-	t.preopens.Reset()
+	t.numSockets = 0
 	for _, dir := range t.dirs {
 		dir.FDCloseDir(ctx)
 	}
 	for fd := range t.dirs {
 		delete(t.dirs, fd)
 	}
+	if len(leaks) > 0 {
+		return &LeakError{Files: leaks}
+	}
 	return nil
 }
 
 func (t *FileTable[T]) Preopen(file T, path string, stat FDStat) FD {
-	fd := t.Register(file, stat)
-	t.preopens.Assign(fd, path)
-	return fd
+	return t.insert(fileEntry[T]{file: file, stat: stat, path: path, preopen: true})
 }
 
 func (t *FileTable[T]) PreopenFD(fd FD) {
-	t.preopens.Assign(fd, "")
+	if f := t.files.Access(fd); f != nil {
+		f.path, f.preopen, f.stack = "", true, nil
+	}
 }
 
 func (t *FileTable[T]) Register(file T, stat FDStat) FD {
-	stat.RightsBase &= AllRights
-	stat.RightsInheriting &= AllRights
-	return t.files.Insert(fileEntry[T]{file: file, stat: stat})
+	return t.insert(fileEntry[T]{file: file, stat: stat})
 }
 
-func (t *FileTable[T]) NumPreopens() int {
-	return t.preopens.Len()
+func (t *FileTable[T]) insert(f fileEntry[T]) FD {
+	if t.TrackOpenFiles && !f.preopen {
+		f.stack = callers()
+	}
+	f.stat.RightsBase &= AllRights
+	f.stat.RightsInheriting &= AllRights
+	if f.stat.FileType.isSocket() {
+		t.numSockets++
+	}
+	return t.files.Insert(f)
+}
+
+func (t *FileTable[T]) NumPreopens() (n int) {
+	t.files.Range(func(_ FD, f fileEntry[T]) bool {
+		if f.preopen {
+			n++
+		}
+		return true
+	})
+	return n
 }
 
 func (t *FileTable[T]) NumOpenFiles() int {
@@ -157,6 +228,58 @@ func (t *FileTable[T]) NumOpenDirs() int {
 	return len(t.dirs)
 }
 
+// NumSockets returns the number of sockets open on the table.
+func (t *FileTable[T]) NumSockets() int {
+	return t.numSockets
+}
+
+// CheckMaxSockets returns EMFILE if the limit of sockets is reached.
+func (t *FileTable[T]) CheckMaxSockets() Errno {
+	if t.MaxSockets > 0 && t.numSockets >= t.MaxSockets {
+		return EMFILE
+	}
+	return ESUCCESS
+}
+
+// FDInfo describes an open file descriptor of a FileTable.
+type FDInfo struct {
+	FD FD
+	// Path of the file, if it was preopened or opened with PathOpen relative
+	// to a directory with a known path. Empty if the path is unknown.
+	Path    string
+	Preopen bool
+	FDStat
+}
+
+// FDPath returns the path of fd, as reported by FDs. The path is empty if it
+// is unknown.
+func (t *FileTable[T]) FDPath(fd FD) (string, Errno) {
+	f := t.files.Access(fd)
+	if f == nil {
+		return "", EBADF
+	}
+	return f.path, ESUCCESS
+}
+
+// FDs returns a snapshot of the file descriptors open in the table, ordered
+// by file descriptor number. It is intended to help debugging guests, for
+// example to detect leaks of file descriptors.
+//
+// The returned slice is a copy; modifying it has no effect on the table.
+func (t *FileTable[T]) FDs() []FDInfo {
+	fds := make([]FDInfo, 0, t.files.Len())
+	t.files.Range(func(fd FD, f fileEntry[T]) bool {
+		fds = append(fds, FDInfo{
+			FD:      fd,
+			Path:    f.path,
+			Preopen: f.preopen,
+			FDStat:  f.stat,
+		})
+		return true
+	})
+	return fds
+}
+
 func (t *FileTable[T]) LookupFD(fd FD, rights Rights) (file T, stat FDStat, errno Errno) {
 	f, errno := t.lookupFD(fd, rights)
 	if f != nil {
@@ -176,7 +299,8 @@ func (t *FileTable[T]) LookupSocketFD(fd FD, rights Rights) (file T, stat FDStat
 }
 
 func (t *FileTable[T]) isPreopen(fd FD) bool {
-	return t.preopens.Access(fd) != nil
+	f := t.files.Access(fd)
+	return f != nil && f.preopen
 }
 
 func (t *FileTable[T]) lookupFD(fd FD, rights Rights) (*fileEntry[T], Errno) {
@@ -190,19 +314,19 @@ func (t *FileTable[T]) lookupFD(fd FD, rights Rights) (*fileEntry[T], Errno) {
 	return f, ESUCCESS
 }
 
-func (t *FileTable[T]) lookupPreopenPath(fd FD) (string, Errno) {
-	path, ok := t.preopens.Lookup(fd)
-	if !ok {
-		return "", EBADF
-	}
+// lookupPreopen returns the entry and type of the pre-opened capability fd.
+// Files preopened with a type which is not a pre-open type are not reported
+// as capabilities to the guest; lookupPreopen returns ENOTDIR for them.
+func (t *FileTable[T]) lookupPreopen(fd FD) (*fileEntry[T], PreOpenType, Errno) {
 	f := t.files.Access(fd)
-	if f == nil {
-		return "", EBADF
+	if f == nil || !f.preopen {
+		return nil, 0, EBADF
 	}
-	if f.stat.FileType != DirectoryType {
-		return "", ENOTDIR
+	typ, ok := preOpenTypeOf(f.stat.FileType)
+	if !ok {
+		return nil, 0, ENOTDIR
 	}
-	return path, ESUCCESS
+	return f, typ, ESUCCESS
 }
 
 func (t *FileTable[T]) lookupSocketFD(fd FD, rights Rights) (*fileEntry[T], Errno) {
@@ -238,6 +362,11 @@ func (t *FileTable[T]) FDAllocate(ctx context.Context, fd FD, offset FileSize, l
 	return f.file.FDAllocate(ctx, offset, length)
 }
 
+// FDClose closes the file descriptor.
+//
+// The descriptor is removed from the table even if closing the underlying
+// file reports an error, so the guest never observes a descriptor which it
+// has tried to close, and retrying the call returns EBADF.
 func (t *FileTable[T]) FDClose(ctx context.Context, fd FD) Errno {
 	f, errno := t.lookupFD(fd, 0)
 	if errno != ESUCCESS {
@@ -246,10 +375,13 @@ func (t *FileTable[T]) FDClose(ctx context.Context, fd FD) Errno {
 	// We capture the file before removing the table entry because f is a
 	// pointer into the table and gets erased when the descriptor is deleted.
 	file := f.file
-	t.files.Delete(fd)
-	// Note: closing pre-opens is allowed.
+	if f.stat.FileType.isSocket() {
+		t.numSockets--
+	}
+	// Note: closing pre-opens is allowed, the preopen flag is removed with the
+	// table entry.
 	// See github.com/WebAssembly/wasi-testsuite/blob/1b1d4a5/tests/rust/src/bin/close_preopen.rs
-	t.preopens.Delete(fd)
+	t.files.Delete(fd)
 	if dir := t.dirs[fd]; dir != nil {
 		delete(t.dirs, fd)
 		dir.FDCloseDir(ctx)
@@ -282,9 +414,8 @@ func (t *FileTable[T]) FDStatSetFlags(ctx context.Context, fd FD, flags FDFlags)
 	if changes == 0 {
 		return ESUCCESS
 	}
-	if changes.Has(Sync | DSync | RSync) {
-		return ENOSYS // TODO: support changing {Sync,DSync,Rsync}
-	}
+	// Files return ENOSYS if they cannot apply changes to the Sync, DSync or
+	// RSync flags.
 	if errno := f.file.FDStatSetFlags(ctx, flags); errno != ESUCCESS {
 		return errno
 	}
@@ -328,10 +459,16 @@ func (t *FileTable[T]) FDFileStatGet(ctx context.Context, fd FD) (FileStat, Errn
 	if errno != ESUCCESS {
 		return FileStat{}, errno
 	}
-	// Override stdio size/times.
+	// The host reports the same mode for all sockets, use the type of the
+	// socket recorded when it was created instead.
+	switch f.stat.FileType {
+	case SocketStreamType, SocketDGramType:
+		s.FileType = f.stat.FileType
+	}
+	// Override stdio and socket size/times.
 	// See github.com/WebAssembly/wasi-testsuite/blob/1b1d4a5/tests/rust/src/bin/fd_filestat_get.rs
 	switch s.FileType {
-	case CharacterDeviceType, UnknownType:
+	case CharacterDeviceType, UnknownType, SocketStreamType, SocketDGramType:
 		s.Size = 0
 		s.AccessTime = 0
 		s.ModifyTime = 0
@@ -349,21 +486,27 @@ func (t *FileTable[T]) FDFileStatSetTimes(ctx context.Context, fd FD, accessTime
 }
 
 func (t *FileTable[T]) FDPreStatGet(ctx context.Context, fd FD) (PreStat, Errno) {
-	path, errno := t.lookupPreopenPath(fd)
+	f, typ, errno := t.lookupPreopen(fd)
 	if errno != ESUCCESS {
 		return PreStat{}, errno
 	}
-	stat := PreStat{
-		Type: PreOpenDir,
-		PreStatDir: PreStatDir{
-			NameLength: Size(len(path)),
-		},
+	stat := PreStat{Type: typ}
+	switch typ {
+	case PreOpenDir:
+		stat.PreStatDir.NameLength = Size(len(f.path))
 	}
 	return stat, ESUCCESS
 }
 
 func (t *FileTable[T]) FDPreStatDirName(ctx context.Context, fd FD) (string, Errno) {
-	return t.lookupPreopenPath(fd)
+	f, typ, errno := t.lookupPreopen(fd)
+	if errno != ESUCCESS {
+		return "", errno
+	}
+	if typ != PreOpenDir {
+		return "", ENOTDIR
+	}
+	return f.path, ESUCCESS
 }
 
 func (t *FileTable[T]) FDPread(ctx context.Context, fd FD, iovecs []IOVec, offset FileSize) (Size, Errno) {
@@ -422,7 +565,15 @@ func (t *FileTable[T]) FDReadDir(ctx context.Context, fd FD, entries []DirEntry,
 		t.dirs[fd] = d
 	}
 
-	n, errno := d.FDReadDir(ctx, entries, cookie, bufferSizeBytes)
+	read := func(entries []DirEntry, cookie DirCookie) (int, Errno) {
+		return d.FDReadDir(ctx, entries, cookie, bufferSizeBytes)
+	}
+	var n int
+	if t.OmitDotEntries {
+		n, errno = filterDirEntries(entries, cookie, read, isNotDotEntry)
+	} else {
+		n, errno = read(entries, cookie)
+	}
 	if errno == ESUCCESS && n == 0 { // EOF?
 		delete(t.dirs, fd)
 		d.FDCloseDir(ctx)
@@ -430,6 +581,38 @@ func (t *FileTable[T]) FDReadDir(ctx context.Context, fd FD, entries []DirEntry,
 	return n, errno
 }
 
+// filterDirEntries reads directory entries with read, starting at cookie, and
+// only keeps the entries with names for which keep returns true. Callers stop
+// reading the directory when no entries are returned, so the next entries are
+// read if they were all filtered out.
+func filterDirEntries(entries []DirEntry, cookie DirCookie, read func([]DirEntry, DirCookie) (int, Errno), keep func(name []byte) bool) (int, Errno) {
+	for {
+		n, errno := read(entries, cookie)
+		if errno != ESUCCESS || n == 0 {
+			return n, errno
+		}
+		cookie = entries[n-1].Next
+		i := 0
+		for _, entry := range entries[:n] {
+			if keep(entry.Name) {
+				entries[i] = entry
+				i++
+			}
+		}
+		if i > 0 {
+			return i, ESUCCESS
+		}
+	}
+}
+
+func isNotDotEntry(name []byte) bool {
+	switch string(name) {
+	case ".", "..":
+		return false
+	}
+	return true
+}
+
 func (t *FileTable[T]) FDRenumber(ctx context.Context, from, to FD) Errno {
 	if t.isPreopen(from) || t.isPreopen(to) {
 		return ENOTSUP
@@ -442,6 +625,9 @@ func (t *FileTable[T]) FDRenumber(ctx context.Context, from, to FD) Errno {
 	// TODO: limit max file descriptor number
 	g, replaced := t.files.Assign(to, *f)
 	if replaced {
+		if g.stat.FileType.isSocket() {
+			t.numSockets--
+		}
 		g.file.FDClose(ctx)
 		if dir := t.dirs[to]; dir != nil {
 			dir.FDCloseDir(ctx)
@@ -484,11 +670,42 @@ func (t *FileTable[T]) FDTell(ctx context.Context, fd FD) (FileSize, Errno) {
 	return t.FDSeek(ctx, fd, 0, SeekCurrent)
 }
 
+// CheckPath returns ENOTCAPABLE if StrictPaths is enabled and path escapes the
+// directory that it is resolved from.
+func (t *FileTable[T]) CheckPath(path string) Errno {
+	if t.StrictPaths && escapesDir(path) {
+		return ENOTCAPABLE
+	}
+	return ESUCCESS
+}
+
+func escapesDir(path string) bool {
+	if strings.HasPrefix(path, "/") {
+		return true
+	}
+	depth := 0
+	for _, elem := range strings.Split(path, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
 func (t *FileTable[T]) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
 	d, errno := t.lookupFD(fd, PathCreateDirectoryRight)
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return errno
+	}
 	return d.file.PathCreateDirectory(ctx, path)
 }
 
@@ -497,6 +714,9 @@ func (t *FileTable[T]) PathFileStatGet(ctx context.Context, fd FD, lookupFlags L
 	if errno != ESUCCESS {
 		return FileStat{}, errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return FileStat{}, errno
+	}
 	return d.file.PathFileStatGet(ctx, lookupFlags, path)
 }
 
@@ -505,6 +725,9 @@ func (t *FileTable[T]) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFl
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return errno
+	}
 	return d.file.PathFileStatSetTimes(ctx, lookupFlags, path, accessTime, modifyTime, fstFlags)
 }
 
@@ -517,65 +740,134 @@ func (t *FileTable[T]) PathLink(ctx context.Context, fd FD, flags LookupFlags, o
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(oldPath); errno != ESUCCESS {
+		return errno
+	}
+	if errno := t.CheckPath(newPath); errno != ESUCCESS {
+		return errno
+	}
 	return oldDir.file.PathLink(ctx, flags, oldPath, newDir.file, newPath)
 }
 
 func (t *FileTable[T]) PathOpen(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
-	d, errno := t.lookupFD(fd, PathOpenRight)
+	return t.PathOpenFunc(ctx, fd, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags, func(dir T, lookupFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (T, Errno) {
+		return dir.PathOpen(ctx, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	})
+}
+
+// PathOpenFunc is like PathOpen, but the file is opened by calling open with
+// the directory and the arguments once they are checked, instead of calling
+// the PathOpen method of the directory. Systems use it to pass their own
+// configuration to the files that they open.
+func (t *FileTable[T]) PathOpenFunc(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags, open func(dir T, lookupFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (T, Errno)) (FD, Errno) {
+	// Like opening a directory for writing on POSIX systems, the rights to
+	// write to the directory content are rejected instead of being silently
+	// removed, so the guest does not discover later that the file descriptor
+	// cannot be written to.
+	writableDir := openFlags.Has(OpenDirectory) && (rightsBase&(FDWriteRight|FDAllocateRight)) != 0
+
+	d, rightsBase, rightsInheriting, errno := t.checkPathOpen(fd, path, openFlags, rightsBase, rightsInheriting, fdFlags)
 	if errno != ESUCCESS {
 		return -1, errno
 	}
+
+	if writableDir {
+		// The request is only rejected with EISDIR if the path is a
+		// directory; POSIX open(2) reports ENOTDIR for other types of
+		// files and ENOENT for missing paths, which are discovered by
+		// opening the path without the other flags or the write rights.
+		probe, errno := open(d.file, lookupFlags, path, OpenDirectory, rightsBase, 0, 0)
+		if errno != ESUCCESS {
+			return -1, errno
+		}
+		probe.FDClose(ctx)
+		return -1, EISDIR
+	}
+
+	newFile, errno := open(d.file, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	if errno != ESUCCESS {
+		return -1, errno
+	}
+
+	fileType := RegularFileType
+	if openFlags.Has(OpenDirectory) {
+		fileType = DirectoryType
+	}
+
+	var newPath string
+	if d.path != "" {
+		newPath = filepath.Join(d.path, filepath.Clean(path))
+	}
+	newFD := t.insert(fileEntry[T]{
+		file: newFile,
+		stat: FDStat{
+			FileType:         fileType,
+			Flags:            fdFlags,
+			RightsBase:       rightsBase,
+			RightsInheriting: rightsInheriting,
+		},
+		path: newPath,
+	})
+	return newFD, ESUCCESS
+}
+
+// CheckPathOpen checks the arguments of PathOpen without opening the file, and
+// returns the directory fd and the rights of the file, which are limited by
+// the inheriting rights of the directory. Systems which open files with other
+// methods than PathOpen use it to apply the same checks.
+func (t *FileTable[T]) CheckPathOpen(fd FD, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (dir T, base, inheriting Rights, errno Errno) {
+	d, base, inheriting, errno := t.checkPathOpen(fd, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+	if d != nil {
+		dir = d.file
+	}
+	return dir, base, inheriting, errno
+}
+
+func (t *FileTable[T]) checkPathOpen(fd FD, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (*fileEntry[T], Rights, Rights, Errno) {
+	d, errno := t.lookupFD(fd, PathOpenRight)
+	if errno != ESUCCESS {
+		return nil, 0, 0, errno
+	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return nil, 0, 0, errno
+	}
 	clean := filepath.Clean(path)
-	if strings.HasPrefix(clean, "/") || strings.HasPrefix(clean, "../") {
-		return -1, EPERM
+	if strings.HasPrefix(clean, "/") || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, 0, 0, EPERM
 	}
 
 	// Rights can only be preserved or removed, not added.
 	rightsBase &= AllRights
 	rightsInheriting &= AllRights
 	if (rightsBase &^ d.stat.RightsInheriting) != 0 {
-		return -1, ENOTCAPABLE
+		return nil, 0, 0, ENOTCAPABLE
 	} else if (rightsInheriting &^ d.stat.RightsInheriting) != 0 {
-		return -1, ENOTCAPABLE
+		return nil, 0, 0, ENOTCAPABLE
 	}
 	rightsBase &= d.stat.RightsInheriting
 	rightsInheriting &= d.stat.RightsInheriting
 
+	if (fdFlags &^ (Append | DSync | NonBlock | RSync | Sync)) != 0 {
+		return nil, 0, 0, EINVAL
+	}
 	if openFlags.Has(OpenDirectory) {
 		rightsBase &= DirectoryRights
 	}
 	if openFlags.Has(OpenCreate) {
 		if !d.stat.RightsBase.Has(PathCreateFileRight) {
-			return -1, ENOTCAPABLE
+			return nil, 0, 0, ENOTCAPABLE
 		}
 	}
 	if openFlags.Has(OpenTruncate) {
 		if !d.stat.RightsBase.Has(PathFileStatSetSizeRight) {
-			return -1, ENOTCAPABLE
+			return nil, 0, 0, ENOTCAPABLE
 		}
 	}
 
 	if t.MaxOpenFiles > 0 && t.NumOpenFiles() >= t.MaxOpenFiles {
-		return -1, ENFILE
-	}
-
-	newFile, errno := d.file.PathOpen(ctx, lookupFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
-	if errno != ESUCCESS {
-		return -1, errno
-	}
-
-	fileType := RegularFileType
-	if openFlags.Has(OpenDirectory) {
-		fileType = DirectoryType
+		return nil, 0, 0, ENFILE
 	}
-
-	newFD := t.Register(newFile, FDStat{
-		FileType:         fileType,
-		Flags:            fdFlags,
-		RightsBase:       rightsBase,
-		RightsInheriting: rightsInheriting,
-	})
-	return newFD, ESUCCESS
+	return d, rightsBase, rightsInheriting, ESUCCESS
 }
 
 func (t *FileTable[T]) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
@@ -583,6 +875,9 @@ func (t *FileTable[T]) PathReadLink(ctx context.Context, fd FD, path string, buf
 	if errno != ESUCCESS {
 		return 0, errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return 0, errno
+	}
 	return d.file.PathReadLink(ctx, path, buffer)
 }
 
@@ -591,6 +886,9 @@ func (t *FileTable[T]) PathRemoveDirectory(ctx context.Context, fd FD, path stri
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return errno
+	}
 	return d.file.PathRemoveDirectory(ctx, path)
 }
 
@@ -603,6 +901,12 @@ func (t *FileTable[T]) PathRename(ctx context.Context, fd FD, oldPath string, ne
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(oldPath); errno != ESUCCESS {
+		return errno
+	}
+	if errno := t.CheckPath(newPath); errno != ESUCCESS {
+		return errno
+	}
 	return oldDir.file.PathRename(ctx, oldPath, newDir.file, newPath)
 }
 
@@ -611,6 +915,18 @@ func (t *FileTable[T]) PathSymlink(ctx context.Context, oldPath string, fd FD, n
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(newPath); errno != ESUCCESS {
+		return errno
+	}
+	// The target of the link is resolved from the directory of the link,
+	// unless it is absolute.
+	target := oldPath
+	if !strings.HasPrefix(target, "/") {
+		target = filepath.Dir(newPath) + "/" + target
+	}
+	if errno := t.CheckPath(target); errno != ESUCCESS {
+		return errno
+	}
 	return d.file.PathSymlink(ctx, oldPath, newPath)
 }
 
@@ -619,6 +935,9 @@ func (t *FileTable[T]) PathUnlinkFile(ctx context.Context, fd FD, path string) E
 	if errno != ESUCCESS {
 		return errno
 	}
+	if errno := t.CheckPath(path); errno != ESUCCESS {
+		return errno
+	}
 	return d.file.PathUnlinkFile(ctx, path)
 }
 
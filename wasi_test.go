@@ -1,6 +1,7 @@
 package wasi
 
 import (
+	"context"
 	"encoding/binary"
 	"math"
 	"reflect"
@@ -348,6 +349,13 @@ func TestExec(t *testing.T) {
 	assertEqual(t, SIGPOLL.Name(), "SIGPOLL")
 	assertEqual(t, SIGPWR.Name(), "SIGPWR")
 	assertEqual(t, SIGSYS.Name(), "SIGSYS")
+	assertEqual(t, SIGABRT.Terminates(), true)
+	assertEqual(t, SIGKILL.Terminates(), true)
+	assertEqual(t, SIGSYS.Terminates(), true)
+	assertEqual(t, SIGNONE.Terminates(), false)
+	assertEqual(t, SIGCHLD.Terminates(), false)
+	assertEqual(t, SIGSTOP.Terminates(), false)
+	assertEqual(t, Signal(31).Terminates(), false)
 }
 
 func TestFile(t *testing.T) {
@@ -498,6 +506,30 @@ func TestFile(t *testing.T) {
 	assertEqual(t, AllRights.String(), "AllRights")
 	assertEqual(t, Rights(math.MaxUint32).String(), "AllRights")
 
+	for _, rights := range []Rights{
+		0,
+		FDReadRight,
+		FDFileStatGetRight | PathSymlinkRight,
+		AllRights,
+		FileRights,
+		DirectoryRights,
+		DirectoryRights | FileRights,
+		TTYRights,
+		SockListenRights,
+		SockConnectionRights,
+	} {
+		r, err := ParseRights(rights.String())
+		assertEqual(t, err, nil)
+		assertEqual(t, r, rights)
+	}
+	r, err := ParseRights("DirectoryRights|FDReadRight")
+	assertEqual(t, err, nil)
+	assertEqual(t, r, DirectoryRights|FDReadRight)
+	_, err = ParseRights("FDReadRight|NoSuchRight")
+	assertEqual(t, err != nil, true)
+	_, err = ParseRights("")
+	assertEqual(t, err != nil, true)
+
 	assertEqual(t, unsafe.Sizeof(DirCookie(0)), 8)
 	assertEqual(t, unsafe.Sizeof(DirNameLength(0)), 4)
 
@@ -695,6 +727,86 @@ func TestTime(t *testing.T) {
 	assertEqual(t, ThreadCPUTimeID.String(), "ThreadCPUTimeID")
 }
 
+func TestClocks(t *testing.T) {
+	ctx := context.Background()
+
+	// The wall clock goes backward after the second reading.
+	base := time.Unix(1e9, 0)
+	offsets := []time.Duration{0, 2 * time.Second, time.Second, 3 * time.Second}
+	now := func() time.Time {
+		now := base.Add(offsets[0])
+		if len(offsets) > 1 {
+			offsets = offsets[1:]
+		}
+		return now
+	}
+
+	clocks := MakeClocks(now)
+	var monotonic []uint64
+	for i := 0; i < 3; i++ {
+		v, err := clocks.Monotonic(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		monotonic = append(monotonic, v)
+	}
+	assertEqual(t, monotonic, []uint64{
+		uint64(2 * time.Second),
+		uint64(2 * time.Second),
+		uint64(3 * time.Second),
+	})
+
+	realtime, err := clocks.Realtime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, realtime, uint64(base.Add(3*time.Second).UnixNano()))
+
+	clocks = SystemClocks()
+	last := uint64(0)
+	for i := 0; i < 1000; i++ {
+		v, err := clocks.Monotonic(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v < last {
+			t.Fatalf("monotonic clock went backward: %d < %d", v, last)
+		}
+		last = v
+	}
+}
+
+// closeErrorFile is a File whose FDClose method fails; other methods are not
+// implemented and panic if called.
+type closeErrorFile struct {
+	File[*closeErrorFile]
+	errno  Errno
+	closed int
+}
+
+func (f *closeErrorFile) FDClose(ctx context.Context) Errno {
+	f.closed++
+	return f.errno
+}
+
+func TestFileTableCloseError(t *testing.T) {
+	ctx := context.Background()
+
+	var table FileTable[*closeErrorFile]
+	f := &closeErrorFile{errno: EIO}
+	fd := table.Register(f, FDStat{RightsBase: AllRights})
+
+	assertEqual(t, table.FDClose(ctx, fd), EIO)
+	assertEqual(t, table.NumOpenFiles(), 0)
+	// The failed close must not leave the descriptor in the table, retrying
+	// reports that it is not open anymore.
+	assertEqual(t, table.FDClose(ctx, fd), EBADF)
+	assertEqual(t, f.closed, 1)
+
+	// The descriptor number is available for reuse.
+	assertEqual(t, table.Register(&closeErrorFile{}, FDStat{}), fd)
+}
+
 func assertEqual[T any](t *testing.T, actual, expected T) {
 	t.Helper()
 
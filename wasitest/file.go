@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stealthrocket/wasi-go"
@@ -12,6 +13,19 @@ import (
 var file = testSuite{
 	"exceeding the limit of open files":       testMaxOpenFiles,
 	"exceeding the limit of open directories": testMaxOpenDirs,
+	"fd_advise accepts all advice values":     testFDAdvise,
+	"fd_allocate grows the file size":         testFDAllocate,
+	"fd_readdir observes directory changes":   testFDReadDirChanges,
+	"fd_readdir reports the dot entries":      testFDReadDirDotEntries,
+	"fd_readdir omits the dot entries":        testFDReadDirOmitDotEntries,
+	"fd_stat_set_flags changes dsync":         testFDStatSetFlagsDSync,
+	"path_open preserves fdflags":             testPathOpenFDFlags,
+	"path_open rejects writable directories":  testPathOpenDirectoryWriteRights,
+	"path_open exclusive fails on symlinks":   testPathOpenExclusiveSymlink,
+	"path_open of a file as a directory":      testPathOpenFileAsDirectory,
+	"path_open of a directory as a file":      testPathOpenDirectoryAsFile,
+	"strict paths reject escaping the root":   testStrictPaths,
+	"strict paths do not split backslashes":   testStrictPathsBackslash,
 }
 
 func testMaxOpenFiles(t *testing.T, ctx context.Context, newSystem newSystem) {
@@ -71,3 +85,424 @@ func testMaxOpenDirs(t *testing.T, ctx context.Context, newSystem newSystem) {
 		assertEqual(t, sys.FDClose(ctx, d), wasi.ESUCCESS)
 	}
 }
+
+func testFDAdvise(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", 0, wasi.AllRights, wasi.AllRights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	for _, test := range []struct {
+		advice wasi.Advice
+		errno  wasi.Errno
+	}{
+		{advice: wasi.Normal},
+		{advice: wasi.Sequential},
+		{advice: wasi.Random},
+		{advice: wasi.WillNeed},
+		{advice: wasi.DontNeed},
+		{advice: wasi.NoReuse},
+		{advice: wasi.NoReuse + 1, errno: wasi.EINVAL},
+		{advice: wasi.Advice(100), errno: wasi.EINVAL},
+		{advice: wasi.Advice(255), errno: wasi.EINVAL},
+	} {
+		t.Run(test.advice.String(), func(t *testing.T) {
+			// The advice applies to the whole file when the length is zero.
+			assertEqual(t, sys.FDAdvise(ctx, fd, 0, 0, test.advice), test.errno)
+			assertEqual(t, sys.FDAdvise(ctx, fd, 4, 8, test.advice), test.errno)
+			// Advice past the end of the file is valid.
+			assertEqual(t, sys.FDAdvise(ctx, fd, 1<<20, 4096, test.advice), test.errno)
+		})
+	}
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDAllocate(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", 0, wasi.AllRights, wasi.AllRights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	for _, test := range []struct {
+		offset, length, size wasi.FileSize
+	}{
+		{offset: 0, length: 4, size: 13},
+		{offset: 13, length: 3, size: 16},
+		{offset: 100, length: 28, size: 128},
+		{offset: 0, length: 4096, size: 4096},
+		{offset: 1000, length: 10, size: 4096},
+	} {
+		assertEqual(t, sys.FDAllocate(ctx, fd, test.offset, test.length), wasi.ESUCCESS)
+		stat, errno := sys.FDFileStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Size, test.size)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmp, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(data[:13]), "Hello, World!")
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testPathOpenFDFlags(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+
+	for _, flags := range []wasi.FDFlags{
+		0,
+		wasi.Append,
+		wasi.NonBlock,
+		wasi.DSync,
+		wasi.Sync,
+		wasi.RSync,
+		wasi.Append | wasi.DSync | wasi.NonBlock | wasi.Sync,
+	} {
+		fd, errno := sys.PathOpen(ctx, 3, 0, "file", 0, wasi.AllRights, wasi.AllRights, flags)
+		if flags.Has(wasi.RSync) && errno == wasi.ENOTSUP {
+			continue // not supported on all platforms
+		}
+		assertEqual(t, errno, wasi.ESUCCESS)
+		stat, errno := sys.FDStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Flags, flags)
+		assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+	}
+
+	_, errno := sys.PathOpen(ctx, 3, 0, "file", 0, wasi.AllRights, wasi.AllRights, 1<<5)
+	assertEqual(t, errno, wasi.EINVAL)
+}
+
+func testPathOpenDirectoryWriteRights(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.Mkdir(filepath.Join(tmp, "dir"), 0777))
+
+	for _, rights := range []wasi.Rights{
+		wasi.FDWriteRight,
+		wasi.FDAllocateRight,
+		wasi.DirectoryRights | wasi.FDWriteRight,
+		wasi.AllRights,
+	} {
+		_, errno := sys.PathOpen(ctx, 3, 0, "dir", wasi.OpenDirectory, rights, wasi.AllRights, 0)
+		assertEqual(t, errno, wasi.EISDIR)
+	}
+
+	// The rights inherited by the files opened in the directory may allow
+	// writing to them.
+	d, errno := sys.PathOpen(ctx, 3, 0, "dir", wasi.OpenDirectory, wasi.DirectoryRights, wasi.AllRights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	f, errno := sys.PathOpen(ctx, d, 0, "file", wasi.OpenCreate, wasi.FileRights, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	n, errno := sys.FDWrite(ctx, f, []wasi.IOVec{[]byte("Hello, World!")})
+	assertEqual(t, n, 13)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, f), wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, d), wasi.ESUCCESS)
+}
+
+func testPathOpenExclusiveSymlink(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+	assertOK(t, os.Symlink("file", filepath.Join(tmp, "symlink")))
+	assertOK(t, os.Symlink("missing", filepath.Join(tmp, "dangling")))
+
+	for _, path := range []string{"symlink", "dangling"} {
+		for _, lookupFlags := range []wasi.LookupFlags{0, wasi.SymlinkFollow} {
+			_, errno := sys.PathOpen(ctx, 3, lookupFlags, path, wasi.OpenCreate|wasi.OpenExclusive, wasi.FileRights, 0, 0)
+			assertEqual(t, errno, wasi.EEXIST)
+		}
+	}
+
+	_, err := os.Lstat(filepath.Join(tmp, "missing"))
+	assertEqual(t, os.IsNotExist(err), true)
+	b, err := os.ReadFile(filepath.Join(tmp, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(b), "Hello, World!")
+}
+
+func testPathOpenFileAsDirectory(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+	assertOK(t, os.Symlink("file", filepath.Join(tmp, "symlink")))
+
+	// The rights which do not apply to directories, including the rights
+	// to write which are otherwise rejected with EISDIR, must not change
+	// the error reported for files.
+	for _, rights := range []wasi.Rights{
+		wasi.PathOpenRight,
+		wasi.DirectoryRights,
+		wasi.FDSeekRight,
+		wasi.FDReadRight,
+		wasi.FDWriteRight,
+		wasi.AllRights,
+	} {
+		_, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenDirectory, rights, wasi.AllRights, 0)
+		assertEqual(t, errno, wasi.ENOTDIR)
+		_, errno = sys.PathOpen(ctx, 3, wasi.SymlinkFollow, "symlink", wasi.OpenDirectory, rights, wasi.AllRights, 0)
+		assertEqual(t, errno, wasi.ENOTDIR)
+		_, errno = sys.PathOpen(ctx, 3, 0, "missing", wasi.OpenDirectory, rights, wasi.AllRights, 0)
+		assertEqual(t, errno, wasi.ENOENT)
+	}
+
+	b, err := os.ReadFile(filepath.Join(tmp, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(b), "Hello, World!")
+}
+
+func testPathOpenDirectoryAsFile(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.Mkdir(filepath.Join(tmp, "dir"), 0777))
+
+	for _, rights := range []wasi.Rights{
+		wasi.FDWriteRight,
+		wasi.FDReadRight | wasi.FDWriteRight,
+		wasi.FileRights,
+		wasi.AllRights,
+	} {
+		_, errno := sys.PathOpen(ctx, 3, 0, "dir", 0, rights, wasi.AllRights, 0)
+		assertEqual(t, errno, wasi.EISDIR)
+		_, errno = sys.PathOpen(ctx, 3, 0, "dir", 0, rights, wasi.AllRights, wasi.Append)
+		assertEqual(t, errno, wasi.EISDIR)
+	}
+}
+
+func testStrictPaths(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS:      tmp,
+		StrictPaths: true,
+	})
+
+	assertOK(t, os.MkdirAll(filepath.Join(tmp, "a", "b"), 0777))
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file"), []byte("Hello, World!"), 0666))
+
+	for _, path := range []string{
+		"..",
+		"../file",
+		"/etc/passwd",
+		"a/../..",
+		"a/b/../../../etc/passwd",
+		"a//b/.//../..//../etc/passwd",
+		"./a/./../../file",
+		"../" + filepath.Base(tmp) + "/file",
+	} {
+		_, errno := sys.PathOpen(ctx, 3, 0, path, 0, wasi.FileRights, 0, 0)
+		assertEqual(t, errno, wasi.ENOTCAPABLE)
+		_, errno = sys.PathFileStatGet(ctx, 3, 0, path)
+		assertEqual(t, errno, wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathCreateDirectory(ctx, 3, path), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathFileStatSetTimes(ctx, 3, 0, path, 0, 0, wasi.AccessTime), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathRemoveDirectory(ctx, 3, path), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathUnlinkFile(ctx, 3, path), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathLink(ctx, 3, 0, "file", 3, path), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathLink(ctx, 3, 0, path, 3, "link"), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathRename(ctx, 3, "file", 3, path), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathRename(ctx, 3, path, 3, "renamed"), wasi.ENOTCAPABLE)
+		assertEqual(t, sys.PathSymlink(ctx, path, 3, "symlink"), wasi.ENOTCAPABLE)
+		_, errno = sys.PathReadLink(ctx, 3, path, make([]byte, 64))
+		assertEqual(t, errno, wasi.ENOTCAPABLE)
+	}
+
+	// Paths which remain in the directory are allowed, whichever way they
+	// are constructed.
+	for _, path := range []string{
+		"file",
+		"./file",
+		"a/../file",
+		"a//b/.//../../file",
+		"a/b/../../a/../file",
+	} {
+		stat, errno := sys.PathFileStatGet(ctx, 3, 0, path)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Size, 13)
+	}
+	// The targets of symbolic links are resolved from their directory.
+	assertEqual(t, sys.PathSymlink(ctx, "../../file", 3, "a/b/symlink"), wasi.ESUCCESS)
+	assertEqual(t, sys.PathSymlink(ctx, "../../file", 3, "a/symlink"), wasi.ENOTCAPABLE)
+	assertEqual(t, sys.PathSymlink(ctx, "/file", 3, "a/symlink"), wasi.ENOTCAPABLE)
+}
+
+func testStrictPathsBackslash(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS:      tmp,
+		StrictPaths: true,
+	})
+
+	assertOK(t, os.Mkdir(filepath.Join(tmp, "a"), 0777))
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "b"), []byte("Hello, World!"), 0666))
+
+	// Only '/' is a separator, so the path is a single file name which does
+	// not escape the root, and does not refer to "b" either.
+	const path = `a\..\..\b`
+	_, errno := sys.PathFileStatGet(ctx, 3, 0, path)
+	assertEqual(t, errno, wasi.ENOENT)
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, path, wasi.OpenCreate|wasi.OpenExclusive, wasi.FileRights, 0, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+
+	stat, errno := sys.PathFileStatGet(ctx, 3, 0, path)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, stat.Size, 0)
+
+	entries, err := os.ReadDir(tmp)
+	assertOK(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	assertDeepEqual(t, names, []string{"a", path, "b"})
+}
+
+func testFDStatSetFlagsDSync(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	fd, errno := sys.PathOpen(ctx, 3, 0, "file", wasi.OpenCreate, wasi.AllRights, wasi.AllRights, wasi.Append)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	for i, flags := range []wasi.FDFlags{
+		wasi.Append | wasi.DSync,
+		wasi.Append,
+		wasi.Append | wasi.DSync,
+	} {
+		assertEqual(t, sys.FDStatSetFlags(ctx, fd, flags), wasi.ESUCCESS)
+		stat, errno := sys.FDStatGet(ctx, fd)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, stat.Flags, flags)
+
+		n, errno := sys.FDWrite(ctx, fd, []wasi.IOVec{[]byte{'1' + byte(i)}})
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, n, 1)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmp, "file"))
+	assertOK(t, err)
+	assertEqual(t, string(data), "123")
+	assertEqual(t, sys.FDClose(ctx, fd), wasi.ESUCCESS)
+}
+
+func testFDReadDirChanges(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-1"), []byte("1"), 0666))
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-2"), []byte("2"), 0666))
+
+	const rights = wasi.DirectoryRights
+	d, errno := sys.PathOpen(ctx, 3, 0, ".", wasi.OpenDirectory, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+
+	readDir := func() map[string]bool {
+		names := make(map[string]bool)
+		entries := make([]wasi.DirEntry, 1)
+		cookie := wasi.DirCookie(0)
+		for {
+			n, errno := sys.FDReadDir(ctx, d, entries, cookie, 1024)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			if n == 0 {
+				return names
+			}
+			names[string(entries[0].Name)] = true
+			cookie = entries[0].Next
+		}
+	}
+
+	// Start a pass without reaching the end of the directory so the entries
+	// remain buffered.
+	entries := make([]wasi.DirEntry, 1)
+	n, errno := sys.FDReadDir(ctx, d, entries, 0, 1024)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	assertEqual(t, n, 1)
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-3"), []byte("3"), 0666))
+	assertDeepEqual(t, readDir(), map[string]bool{
+		".": true, "..": true, "file-1": true, "file-2": true, "file-3": true,
+	})
+
+	assertOK(t, os.Remove(filepath.Join(tmp, "file-1")))
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-4"), []byte("4"), 0666))
+	assertDeepEqual(t, readDir(), map[string]bool{
+		".": true, "..": true, "file-2": true, "file-3": true, "file-4": true,
+	})
+	assertEqual(t, sys.FDClose(ctx, d), wasi.ESUCCESS)
+}
+
+func testFDReadDirDotEntries(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS: tmp,
+	})
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-1"), []byte("1"), 0666))
+	assertDeepEqual(t, readDirNames(t, ctx, sys), []string{".", "..", "file-1"})
+}
+
+func testFDReadDirOmitDotEntries(t *testing.T, ctx context.Context, newSystem newSystem) {
+	tmp := t.TempDir()
+	sys := newSystem(TestConfig{
+		RootFS:         tmp,
+		OmitDotEntries: true,
+	})
+	assertDeepEqual(t, readDirNames(t, ctx, sys), []string{})
+
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-1"), []byte("1"), 0666))
+	assertOK(t, os.WriteFile(filepath.Join(tmp, "file-2"), []byte("2"), 0666))
+	assertDeepEqual(t, readDirNames(t, ctx, sys), []string{"file-1", "file-2"})
+}
+
+// readDirNames reads the names of the entries of the root directory one by
+// one, so each entry is returned by a separate call to FDReadDir.
+func readDirNames(t *testing.T, ctx context.Context, sys wasi.System) []string {
+	const rights = wasi.DirectoryRights
+	d, errno := sys.PathOpen(ctx, 3, 0, ".", wasi.OpenDirectory, rights, rights, 0)
+	assertEqual(t, errno, wasi.ESUCCESS)
+	defer sys.FDClose(ctx, d)
+
+	names := []string{}
+	entries := make([]wasi.DirEntry, 1)
+	cookie := wasi.DirCookie(0)
+	for {
+		n, errno := sys.FDReadDir(ctx, d, entries, cookie, 1024)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		if n == 0 {
+			sort.Strings(names)
+			return names
+		}
+		names = append(names, string(entries[0].Name))
+		cookie = entries[0].Next
+	}
+}
@@ -0,0 +1,27 @@
+package wasitest
+
+import (
+	"flag"
+	"regexp"
+	"testing"
+)
+
+// Main runs the test suite of TestSystem against the systems created by
+// makeSystem outside of "go test", so programs can verify that a system
+// behaves correctly on the platform they run on.
+//
+// The result of each test is written to stdout, and Main exits the process
+// with a non-zero status code if any of the tests failed. The flags of the
+// testing package (e.g. -test.run) are parsed from os.Args.
+func Main(makeSystem MakeSystem) {
+	testing.Init()
+	flag.Set("test.v", "true")
+	testing.Main(matchString, []testing.InternalTest{{
+		Name: "TestSystem",
+		F:    func(t *testing.T) { TestSystem(t, makeSystem) },
+	}}, nil, nil)
+}
+
+func matchString(pattern, name string) (bool, error) {
+	return regexp.MatchString(pattern, name)
+}
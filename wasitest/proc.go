@@ -2,6 +2,7 @@ package wasitest
 
 import (
 	"context"
+	"syscall"
 	"testing"
 	"time"
 
@@ -37,7 +38,7 @@ var proc = testSuite{
 			case nil:
 				t.Error("proc_raise must not return")
 			case *sys.ExitError:
-				if exitCode := v.ExitCode(); exitCode != 127+42 {
+				if exitCode := v.ExitCode(); exitCode != 127+uint32(syscall.SIGTERM) {
 					t.Errorf("exit error contains the wrong exit code: %d", exitCode)
 				}
 			default:
@@ -45,7 +46,7 @@ var proc = testSuite{
 			}
 		}()
 
-		s.ProcRaise(ctx, 42)
+		s.ProcRaise(ctx, wasi.SIGTERM)
 	},
 
 	"SchedYield does nothing": func(t *testing.T, ctx context.Context, newSystem newSystem) {
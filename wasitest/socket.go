@@ -258,6 +258,22 @@ var socket = testSuite{
 		wasi.Inet6Family, wasi.StreamSocket, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"fd_filestat_get reports the type of ipv4 sockets": testSocketFileStat(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"fd_filestat_get reports the type of ipv6 sockets": testSocketFileStat(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
+	"cannot open or accept ipv4 sockets beyond the limit": testSocketMaxSockets(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"cannot open or accept ipv6 sockets beyond the limit": testSocketMaxSockets(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"can connect a ipv4 datagram socket": testSocketConnectOK(
 		wasi.InetFamily, wasi.DatagramSocket, &wasi.Inet4Address{Addr: localIPv4, Port: nextPort()},
 	),
@@ -486,6 +502,22 @@ var socket = testSuite{
 		wasi.Inet6Family, wasi.DatagramSocket,
 	),
 
+	"can set the type of service of ipv4 stream sockets": testSocketTrafficClass(
+		wasi.InetFamily, wasi.StreamSocket, wasi.IpTypeOfService,
+	),
+
+	"can set the traffic class of ipv6 stream sockets": testSocketTrafficClass(
+		wasi.Inet6Family, wasi.StreamSocket, wasi.Ipv6TrafficClass,
+	),
+
+	"can set the type of service of ipv4 datagram sockets": testSocketTrafficClass(
+		wasi.InetFamily, wasi.DatagramSocket, wasi.IpTypeOfService,
+	),
+
+	"can set the traffic class of ipv6 datagram sockets": testSocketTrafficClass(
+		wasi.Inet6Family, wasi.DatagramSocket, wasi.Ipv6TrafficClass,
+	),
+
 	"connected ipv4 stream sockets can send and receive data": testSocketSendAndReceiveStream(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -510,6 +542,14 @@ var socket = testSuite{
 		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"accepted ipv4 stream sockets send the buffered data when closed with linger": testSocketLingerStream(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"accepted ipv6 stream sockets send the buffered data when closed with linger": testSocketLingerStream(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"timeout unblocks ipv4 stream sockets waiting for data in blocking mode": testSocketTimeoutStreamBlocking(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -518,6 +558,14 @@ var socket = testSuite{
 		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
 	),
 
+	"timeout unblocks ipv4 stream sockets waiting to send data in blocking mode": testSocketSendTimeoutStreamBlocking(
+		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
+	),
+
+	"timeout unblocks ipv6 stream sockets waiting to send data in blocking mode": testSocketSendTimeoutStreamBlocking(
+		wasi.Inet6Family, &wasi.Inet6Address{Addr: localIPv6},
+	),
+
 	"timeout unblocks ipv4 datagram sockets waiting for data in blocking mode": testSocketTimeoutDatagramBlocking(
 		wasi.InetFamily, &wasi.Inet4Address{Addr: localIPv4},
 	),
@@ -1014,6 +1062,62 @@ func testSocketConnectAndAccept(family wasi.ProtocolFamily, typ wasi.SocketType,
 	}
 }
 
+func testSocketFileStat(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		client, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, client, serverAddr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+
+		sockPoll(t, ctx, sys, client, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		accept, _, _, errno := sys.SockAccept(ctx, server, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// Write to the socket so the host has data buffered which could be
+		// reported as the size.
+		_, errno = sys.FDWrite(ctx, client, []wasi.IOVec{[]byte("Hello, World!")})
+		assertEqual(t, errno, wasi.ESUCCESS)
+		sockPoll(t, ctx, sys, accept, wasi.FDReadEvent)
+
+		datagram, errno := sockOpen(t, ctx, sys, family, wasi.DatagramSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		for _, test := range []struct {
+			fd  wasi.FD
+			typ wasi.FileType
+		}{
+			{server, wasi.SocketStreamType},
+			{accept, wasi.SocketStreamType},
+			{datagram, wasi.SocketDGramType},
+		} {
+			stat, errno := sys.FDFileStatGet(ctx, test.fd)
+			assertEqual(t, errno, wasi.ESUCCESS)
+			assertEqual(t, stat.FileType, test.typ)
+			assertEqual(t, stat.Size, wasi.FileSize(0))
+			assertEqual(t, stat.AccessTime, wasi.Timestamp(0))
+			assertEqual(t, stat.ModifyTime, wasi.Timestamp(0))
+			assertEqual(t, stat.ChangeTime, wasi.Timestamp(0))
+		}
+
+		assertEqual(t, sys.FDClose(ctx, datagram), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, accept), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, client), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
 func testSocketConnectAndAcceptBlocking(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1058,6 +1162,58 @@ func testSocketConnectAndAcceptBlocking(family wasi.ProtocolFamily, typ wasi.Soc
 	}
 }
 
+func testSocketMaxSockets(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{MaxSockets: 3})
+
+		server, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		serverAddr, errno := sys.SockBind(ctx, server, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, server, 10), wasi.ESUCCESS)
+
+		client1, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		_, errno = sys.SockConnect(ctx, client1, serverAddr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+		sockPoll(t, ctx, sys, client1, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		accept1, _, _, errno := sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		_, errno = sys.SockOpen(ctx, family, wasi.StreamSocket, 0, wasi.AllRights, wasi.AllRights)
+		assertEqual(t, errno, wasi.EMFILE)
+
+		assertEqual(t, sys.FDClose(ctx, accept1), wasi.ESUCCESS)
+
+		client2, errno := sockOpen(t, ctx, sys, family, wasi.StreamSocket, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		_, errno = sys.SockConnect(ctx, client2, serverAddr)
+		assertEqual(t, errno, wasi.EINPROGRESS)
+		sockPoll(t, ctx, sys, client2, wasi.FDWriteEvent)
+		sockPoll(t, ctx, sys, server, wasi.FDReadEvent)
+
+		// The connection remains in the backlog when the limit is reached,
+		// so it can be accepted after closing a socket.
+		_, _, _, errno = sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.EMFILE)
+
+		assertEqual(t, sys.FDClose(ctx, client1), wasi.ESUCCESS)
+
+		accept2, remoteAddr, _, errno := sys.SockAccept(ctx, server, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		clientAddr, errno := sys.SockLocalAddress(ctx, client2)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertDeepEqual(t, remoteAddr, clientAddr)
+
+		assertEqual(t, sys.FDClose(ctx, accept2), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, client2), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, server), wasi.ESUCCESS)
+	}
+}
+
 func testSocketConnectAndShutdown(family wasi.ProtocolFamily, typ wasi.SocketType, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1093,12 +1249,12 @@ func testSocketConnectAndShutdown(family wasi.ProtocolFamily, typ wasi.SocketTyp
 		// Darwin and Linux disagree on when to return ENOTCONN on shutdown(2);
 		// on Darwin, the error is returned for read and write directions
 		// independently, while on Linux, the error is only returned after
-		// shutting down both read and write directions. We have not way of
-		// managing this so we only test the Linux behavior which is less strict
-		// than Darwin, and expect ENOTCONN only after both the read and write
-		// ends of the socket have been shut down.
-		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownRD), wasi.ENOTCONN)
-		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownWR), wasi.ENOTCONN)
+		// shutting down both read and write directions. Shutting down a
+		// socket repeatedly must succeed regardless, so cleanup code can be
+		// idempotent.
+		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownRD), wasi.ESUCCESS)
+		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownWR), wasi.ESUCCESS)
+		assertEqual(t, sys.SockShutdown(ctx, client, wasi.ShutdownRD|wasi.ShutdownWR), wasi.ESUCCESS)
 
 		sockPoll(t, ctx, sys, accept, wasi.FDReadEvent)
 
@@ -1426,6 +1582,8 @@ func testSocketShutdownInvalidArgument(family wasi.ProtocolFamily, typ wasi.Sock
 		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
 		assertEqual(t, errno, wasi.ESUCCESS)
 		assertEqual(t, sys.SockShutdown(ctx, sock, ^(wasi.ShutdownRD|wasi.ShutdownWR)), wasi.EINVAL)
+		assertEqual(t, sys.SockShutdown(ctx, sock, 0), wasi.EINVAL)
+		assertEqual(t, sys.SockShutdown(ctx, sock, wasi.ShutdownRD|4), wasi.EINVAL)
 		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
 	}
 }
@@ -1634,6 +1792,53 @@ func testSocketSendAndPeekStream(family wasi.ProtocolFamily, bind wasi.SocketAdd
 	}
 }
 
+func testSocketLingerStream(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		typ := wasi.StreamSocket
+
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, sock, false)
+
+		addr, errno := sys.SockBind(ctx, sock, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, sock, 10), wasi.ESUCCESS)
+
+		conn1, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, conn1, false)
+
+		_, errno = sys.SockConnect(ctx, conn1, addr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		conn2, _, _, errno := sys.SockAccept(ctx, sock, wasi.NonBlock)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		linger := wasi.LingerValue{Enabled: true, Timeout: 1}
+		assertEqual(t, sys.SockSetOpt(ctx, conn2, wasi.Linger, linger), wasi.ESUCCESS)
+		assertEqual(t, sockOption[wasi.LingerValue](t, ctx, sys, conn2, wasi.Linger), linger)
+
+		buffer1 := []byte("Hello, World!")
+		size1, errno := sys.FDWrite(ctx, conn2, []wasi.IOVec{buffer1})
+		assertEqual(t, size1, wasi.Size(len(buffer1)))
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, conn2), wasi.ESUCCESS)
+
+		// The data is received before the end of the stream.
+		buffer2 := make([]byte, 32)
+		size2, errno := sys.FDRead(ctx, conn1, []wasi.IOVec{buffer2})
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, string(buffer2[:size2]), string(buffer1))
+		size2, errno = sys.FDRead(ctx, conn1, []wasi.IOVec{buffer2})
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, size2, wasi.Size(0))
+
+		assertEqual(t, sys.FDClose(ctx, conn1), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketTimeoutStreamBlocking(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -1691,6 +1896,59 @@ func testSocketTimeoutStreamBlocking(family wasi.ProtocolFamily, bind wasi.Socke
 	}
 }
 
+func testSocketSendTimeoutStreamBlocking(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		typ := wasi.StreamSocket
+
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, sock, false)
+
+		addr, errno := sys.SockBind(ctx, sock, bind)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		assertEqual(t, sys.SockListen(ctx, sock, 10), wasi.ESUCCESS)
+
+		conn1, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+		setNonBlock(t, ctx, sys, conn1, false)
+		assertEqual(t, sys.SockSetOpt(ctx, conn1, wasi.SendBufferSize, wasi.IntValue(4096)), wasi.ESUCCESS)
+
+		_, errno = sys.SockConnect(ctx, conn1, addr)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		conn2, _, _, errno := sys.SockAccept(ctx, sock, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		const sendTimeout = 20 * time.Millisecond
+		errno = sys.SockSetOpt(ctx, conn1,
+			wasi.SendTimeout,
+			wasi.TimeValue(sendTimeout),
+		)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// The peer does not read, so the buffers eventually fill up and the
+		// send blocks until the timeout expires.
+		buffer := make([]byte, 64*1024)
+		for i := 0; ; i++ {
+			if i == 1000 {
+				t.Fatal("the socket buffers never filled up")
+			}
+			start := time.Now()
+			_, errno := sys.SockSend(ctx, conn1, []wasi.IOVec{buffer}, 0)
+			if errno == wasi.EAGAIN {
+				assertEqual(t, time.Since(start) >= sendTimeout, true)
+				break
+			}
+			assertEqual(t, errno, wasi.ESUCCESS)
+		}
+
+		assertEqual(t, sys.FDClose(ctx, conn2), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, conn1), wasi.ESUCCESS)
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func testSocketTimeoutDatagramBlocking(family wasi.ProtocolFamily, bind wasi.SocketAddress) testFunc {
 	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
 		sys := newSystem(TestConfig{})
@@ -2342,6 +2600,26 @@ func testSocketSetOptionInvalidArgument(family wasi.ProtocolFamily, typ wasi.Soc
 	}
 }
 
+func testSocketTrafficClass(family wasi.ProtocolFamily, typ wasi.SocketType, option wasi.SocketOption) testFunc {
+	return func(t *testing.T, ctx context.Context, newSystem newSystem) {
+		sys := newSystem(TestConfig{})
+		sock, errno := sockOpen(t, ctx, sys, family, typ, 0)
+		assertEqual(t, errno, wasi.ESUCCESS)
+
+		// The expedited forwarding DSCP, the ECN bits are left unset since
+		// hosts may manage them on stream sockets.
+		const ef = 0xb8
+		assertEqual(t, sys.SockSetOpt(ctx, sock, option, wasi.IntValue(ef)), wasi.ESUCCESS)
+		assertEqual(t, sockOption[wasi.IntValue](t, ctx, sys, sock, option), wasi.IntValue(ef))
+
+		for _, value := range []wasi.IntValue{-1, 256} {
+			assertEqual(t, sys.SockSetOpt(ctx, sock, option, value), wasi.EINVAL)
+		}
+		assertEqual(t, sockOption[wasi.IntValue](t, ctx, sys, sock, option), wasi.IntValue(ef))
+		assertEqual(t, sys.FDClose(ctx, sock), wasi.ESUCCESS)
+	}
+}
+
 func sockOpen(t *testing.T, ctx context.Context, sys wasi.System, family wasi.ProtocolFamily, typ wasi.SocketType, proto wasi.Protocol) (wasi.FD, wasi.Errno) {
 	t.Helper()
 	sock, errno := sys.SockOpen(ctx, family, typ, proto, wasi.AllRights, wasi.AllRights)
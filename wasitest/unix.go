@@ -0,0 +1,121 @@
+//go:build unix
+
+package wasitest
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/stealthrocket/wasi-go"
+	"github.com/stealthrocket/wasi-go/systems/unix"
+	"github.com/tetratelabs/wazero/sys"
+	sysunix "golang.org/x/sys/unix"
+)
+
+// MakeUnixSystem is a MakeSystem function which creates unix.System instances
+// configured by config, with the standard streams connected to pipes and the
+// root file system pre-opened at "/".
+func MakeUnixSystem(config TestConfig) (wasi.System, error) {
+	s := &unix.System{
+		Args:    config.Args,
+		Environ: config.Environ,
+		Rand:    config.Rand,
+		Yield: func(ctx context.Context) error {
+			return nil
+		},
+		Exit: func(ctx context.Context, code int) error {
+			panic(sys.NewExitError(uint32(code)))
+		},
+		Raise: func(ctx context.Context, code int) error {
+			panic(sys.NewExitError(127 + uint32(code)))
+		},
+	}
+	s.MaxOpenFiles = config.MaxOpenFiles
+	s.MaxOpenDirs = config.MaxOpenDirs
+	s.MaxSockets = config.MaxSockets
+	s.OmitDotEntries = config.OmitDotEntries
+	s.StrictPaths = config.StrictPaths
+	defer func() {
+		if s != nil {
+			s.Close(context.Background())
+		}
+	}()
+
+	if now := config.Now; now != nil {
+		clocks := wasi.MakeClocks(now)
+		s.Monotonic = clocks.Monotonic
+		s.MonotonicPrecision = time.Nanosecond
+		s.Realtime = clocks.Realtime
+		s.RealtimePrecision = time.Microsecond
+	}
+
+	stdio := []struct {
+		path string
+		r    io.ReadCloser
+		w    io.WriteCloser
+	}{
+		{path: "/dev/stdin", r: config.Stdin},
+		{path: "/dev/stdout", w: config.Stdout},
+		{path: "/dev/stderr", w: config.Stderr},
+	}
+	for i, f := range stdio {
+		fds, err := pipe()
+		if err != nil {
+			return nil, err
+		}
+		// The guest reads from stdin and writes to stdout and stderr, the other
+		// end of each pipe is connected to the test configuration, or closed.
+		guest, host := fds[1], fds[0]
+		if i == 0 {
+			guest, host = fds[0], fds[1]
+		}
+		switch {
+		case f.r != nil:
+			go copyAndClose(os.NewFile(uintptr(host), f.path), f.r)
+		case f.w != nil:
+			go copyAndClose(f.w, os.NewFile(uintptr(host), f.path))
+		default:
+			sysunix.Close(host)
+		}
+		s.Preopen(unix.FD(guest), f.path, wasi.FDStat{
+			FileType:   wasi.CharacterDeviceType,
+			RightsBase: wasi.AllRights,
+		})
+	}
+
+	if config.RootFS != "" {
+		rootFS, err := sysunix.Open(config.RootFS, sysunix.O_DIRECTORY, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.Preopen(unix.FD(rootFS), "/", wasi.FDStat{
+			FileType:         wasi.DirectoryType,
+			RightsBase:       wasi.AllRights,
+			RightsInheriting: wasi.AllRights,
+		})
+	}
+
+	ret := s
+	s = nil
+	return ret, nil
+}
+
+func copyAndClose(w io.WriteCloser, r io.ReadCloser) {
+	defer w.Close()
+	defer r.Close()
+	_, _ = io.Copy(w, r)
+}
+
+func pipe() (fds [2]int, err error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	if err := sysunix.Pipe(fds[:]); err != nil {
+		return fds, err
+	}
+	sysunix.CloseOnExec(fds[0])
+	sysunix.CloseOnExec(fds[1])
+	return fds, nil
+}
@@ -31,6 +31,11 @@ type TestConfig struct {
 	// Limits, zero means none.
 	MaxOpenFiles int
 	MaxOpenDirs  int
+	MaxSockets   int
+	// Omit the "." and ".." directory entries.
+	OmitDotEntries bool
+	// Reject the paths escaping the directories they are resolved from.
+	StrictPaths bool
 }
 
 // MakeSystem is a function used to create a system to run the test suites
@@ -0,0 +1,75 @@
+package wasi
+
+import "context"
+
+// WorkingDirectoryFD is the file descriptor that path functions are given to
+// resolve relative paths against the working directory of a System wrapped
+// by WorkingDirectory. It has the value of AT_FDCWD in wasi-libc.
+const WorkingDirectoryFD FD = -2
+
+// WorkingDirectory wraps a System to emulate a working directory, for guests
+// which were ported from POSIX and pass WorkingDirectoryFD to the path
+// functions, expecting relative paths to be resolved against the current
+// working directory. The path functions given WorkingDirectoryFD resolve paths
+// from cwd instead, which should be a pre-opened directory; the rights of cwd
+// apply to the operations.
+//
+// WASI has no concept of working directory, and capabilities must otherwise be
+// passed explicitly, so the wrapper is only installed for guests which need it.
+// Without it, WorkingDirectoryFD is not a valid file descriptor and the path
+// functions fail with EBADF.
+func WorkingDirectory(s System, cwd FD) System {
+	return &workingDirectory{System: s, cwd: cwd}
+}
+
+type workingDirectory struct {
+	System
+	cwd FD
+}
+
+func (w *workingDirectory) resolve(fd FD) FD {
+	if fd == WorkingDirectoryFD {
+		return w.cwd
+	}
+	return fd
+}
+
+func (w *workingDirectory) PathCreateDirectory(ctx context.Context, fd FD, path string) Errno {
+	return w.System.PathCreateDirectory(ctx, w.resolve(fd), path)
+}
+
+func (w *workingDirectory) PathFileStatGet(ctx context.Context, fd FD, lookupFlags LookupFlags, path string) (FileStat, Errno) {
+	return w.System.PathFileStatGet(ctx, w.resolve(fd), lookupFlags, path)
+}
+
+func (w *workingDirectory) PathFileStatSetTimes(ctx context.Context, fd FD, lookupFlags LookupFlags, path string, accessTime, modifyTime Timestamp, flags FSTFlags) Errno {
+	return w.System.PathFileStatSetTimes(ctx, w.resolve(fd), lookupFlags, path, accessTime, modifyTime, flags)
+}
+
+func (w *workingDirectory) PathLink(ctx context.Context, oldFD FD, oldFlags LookupFlags, oldPath string, newFD FD, newPath string) Errno {
+	return w.System.PathLink(ctx, w.resolve(oldFD), oldFlags, oldPath, w.resolve(newFD), newPath)
+}
+
+func (w *workingDirectory) PathOpen(ctx context.Context, fd FD, dirFlags LookupFlags, path string, openFlags OpenFlags, rightsBase, rightsInheriting Rights, fdFlags FDFlags) (FD, Errno) {
+	return w.System.PathOpen(ctx, w.resolve(fd), dirFlags, path, openFlags, rightsBase, rightsInheriting, fdFlags)
+}
+
+func (w *workingDirectory) PathReadLink(ctx context.Context, fd FD, path string, buffer []byte) (int, Errno) {
+	return w.System.PathReadLink(ctx, w.resolve(fd), path, buffer)
+}
+
+func (w *workingDirectory) PathRemoveDirectory(ctx context.Context, fd FD, path string) Errno {
+	return w.System.PathRemoveDirectory(ctx, w.resolve(fd), path)
+}
+
+func (w *workingDirectory) PathRename(ctx context.Context, fd FD, oldPath string, newFD FD, newPath string) Errno {
+	return w.System.PathRename(ctx, w.resolve(fd), oldPath, w.resolve(newFD), newPath)
+}
+
+func (w *workingDirectory) PathSymlink(ctx context.Context, oldPath string, fd FD, newPath string) Errno {
+	return w.System.PathSymlink(ctx, oldPath, w.resolve(fd), newPath)
+}
+
+func (w *workingDirectory) PathUnlinkFile(ctx context.Context, fd FD, path string) Errno {
+	return w.System.PathUnlinkFile(ctx, w.resolve(fd), path)
+}